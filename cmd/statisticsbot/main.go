@@ -24,6 +24,7 @@ import (
 	"github.com/stollenaar/statisticsbot/internal/commands"
 	"github.com/stollenaar/statisticsbot/internal/database"
 	"github.com/stollenaar/statisticsbot/internal/routes"
+	"github.com/stollenaar/statisticsbot/internal/scheduler"
 	"github.com/stollenaar/statisticsbot/internal/util"
 
 	"github.com/bwmarrin/discordgo"
@@ -58,7 +59,9 @@ func init() {
 			cache.WithCaches(
 				cache.FlagGuilds,
 				cache.FlagChannels,
+				cache.FlagRoles,
 				cache.FlagMessages,
+				cache.FlagMembers,
 			),
 		),
 		bot.WithEventListenerFunc(func(event *events.ApplicationCommandInteractionCreate) {
@@ -210,7 +213,14 @@ func main() {
 	gatewayReady.Store(true)
 
 	database.Init(client, GuildID)
+	database.ConvertEmbeddingStorage()
+	database.ResumeEmbeddingMigrations()
+	database.LoadVectorIndexes()
 	go routes.CreateRouter(client)
+	go scheduler.Start(client)
+	go database.StartConversationWindowRefresh()
+	go database.StartTopicRefresh(client)
+	go database.StartSessionCleanup()
 
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
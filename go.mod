@@ -19,7 +19,9 @@ require (
 	github.com/klauspost/compress v1.19.2
 	github.com/knights-analytics/hugot v0.7.7
 	github.com/marcboeker/go-duckdb/v2 v2.4.3
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/stollenaar/aws-rotating-credentials-provider/credentials v0.0.0-20250330204128-299effe6093c
+	golang.org/x/image v0.44.0
 	golang.org/x/text v0.41.0
 )
 
@@ -92,7 +94,6 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/crypto v0.54.0 // indirect
 	golang.org/x/exp v0.0.0-20260727155853-b88d891fe743 // indirect
-	golang.org/x/image v0.44.0 // indirect
 	golang.org/x/mod v0.38.0 // indirect
 	golang.org/x/sync v0.22.0 // indirect
 	golang.org/x/sys v0.47.0 // indirect
@@ -1,11 +1,13 @@
 package admincommand
 
 import (
+	"fmt"
 	"log/slog"
 	"strings"
 
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
+	"github.com/stollenaar/statisticsbot/internal/database"
 	"github.com/stollenaar/statisticsbot/internal/util"
 )
 
@@ -42,6 +44,10 @@ func (a AdminCommand) Handler(event *events.ApplicationCommandInteractionCreate)
 	switch *sub.SubCommandGroupName {
 	case "summary":
 		components = summaryHandler(sub)
+	case "embeddings":
+		components = embeddingsHandler(event, sub)
+	case "reposts":
+		components = repostsHandler(event, sub)
 	}
 	if len(components) != 0 {
 		util.UpdateInteractionResponse(event, components)
@@ -90,5 +96,71 @@ func (a AdminCommand) CreateCommandArguments() []discord.ApplicationCommandOptio
 				},
 			},
 		},
+		discord.ApplicationCommandOptionSubCommandGroup{
+			Name:        "embeddings",
+			Description: "Manage the semantic search embeddings",
+			Options: []discord.ApplicationCommandOptionSubCommand{
+				{
+					Name:        "migrate",
+					Description: "Re-embed this server's messages with another model",
+					Options: []discord.ApplicationCommandOption{
+						discord.ApplicationCommandOptionString{
+							Name:        "model",
+							Description: "HuggingFace model id, defaults to the configured model",
+							Required:    false,
+						},
+					},
+				},
+				{
+					Name:        "status",
+					Description: "Show the model searches use, the queue and the migration progress",
+				},
+				{
+					Name:        "quantization",
+					Description: "Compare the size and recall of quantized embedding storage",
+				},
+			},
+		},
+		discord.ApplicationCommandOptionSubCommandGroup{
+			Name:        "reposts",
+			Description: "Manage the repost detection",
+			Options: []discord.ApplicationCommandOptionSubCommand{
+				{
+					Name:        "enable",
+					Description: "Reply to messages that nearly duplicate a recent one",
+					Options: []discord.ApplicationCommandOption{
+						discord.ApplicationCommandOptionChannel{
+							Name:         "channel",
+							Description:  "Channel to watch, defaults to this one",
+							Required:     false,
+							ChannelTypes: []discord.ChannelType{discord.ChannelTypeGuildText},
+						},
+						discord.ApplicationCommandOptionFloat{
+							Name:        "threshold",
+							Description: fmt.Sprintf("Similarity from 0 to 1 a repost needs, defaults to %.2f", database.DefaultRepostThreshold),
+							Required:    false,
+							MinValue:    util.Pointer(0.5),
+							MaxValue:    util.Pointer(1.0),
+						},
+					},
+				},
+				{
+					Name:        "disable",
+					Description: "Stop looking for reposts in a channel",
+					Options: []discord.ApplicationCommandOption{
+						discord.ApplicationCommandOptionChannel{
+							Name:         "channel",
+							Description:  "Channel to stop watching, defaults to this one",
+							Required:     false,
+							ChannelTypes: []discord.ChannelType{discord.ChannelTypeGuildText},
+						},
+					},
+				},
+				{
+					Name:        "list",
+					Description: "List the channels repost detection is enabled in",
+				},
+			},
+		},
 	}
 }
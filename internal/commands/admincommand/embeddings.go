@@ -0,0 +1,173 @@
+package admincommand
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/embeddings"
+)
+
+const (
+	// embeddingMigrationsShown is how many past migrations the status lists.
+	embeddingMigrationsShown = 5
+	// quantizationReportSamples is how many searches the quantization report
+	// measures recall over.
+	quantizationReportSamples = 200
+)
+
+func embeddingsHandler(event *events.ApplicationCommandInteractionCreate, sub discord.SlashCommandInteractionData) []discord.LayoutComponent {
+	guildID := event.GuildID().String()
+
+	switch *sub.SubCommandName {
+	case "migrate":
+		model, ok := sub.OptString("model")
+		if !ok {
+			model = embeddings.ModelName()
+		}
+		return embeddingsMigrateComponents(guildID, model)
+	case "status":
+		return embeddingsStatusComponents(guildID)
+	case "quantization":
+		return embeddingsQuantizationComponents(guildID)
+	}
+	return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Unknown embeddings subcommand")}}
+}
+
+func embeddingsMigrateComponents(guildID, model string) []discord.LayoutComponent {
+	migration, err := database.StartEmbeddingMigration(guildID, model)
+	if err != nil {
+		if !errors.Is(err, database.ErrMigrationRunning) {
+			slog.Error("Failed to start embedding migration", slog.Any("err", err))
+		}
+		return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents(fmt.Sprintf("Failed to start the migration: %s", err))}}
+	}
+
+	return []discord.LayoutComponent{discord.ContainerComponent{Components: []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{
+			Content: fmt.Sprintf("Re-embedding this server with `%s`.\n/semantic keeps searching with `%s` until every message is embedded. Check progress with `/admin embeddings status`.",
+				migration.ToModel, migration.FromModel),
+		},
+	}}}
+}
+
+func embeddingsStatusComponents(guildID string) []discord.LayoutComponent {
+	serving, err := database.ServingEmbeddingModel(guildID)
+	if err != nil {
+		slog.Error("Failed to fetch the embedding model", slog.Any("err", err))
+		return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Failed to fetch the embedding model")}}
+	}
+	migrations, err := database.ListEmbeddingMigrations(guildID, embeddingMigrationsShown)
+	if err != nil {
+		slog.Error("Failed to fetch embedding migrations", slog.Any("err", err))
+		return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Failed to fetch embedding migrations")}}
+	}
+
+	lines := []string{
+		"# Embeddings",
+		fmt.Sprintf("Searching with `%s`", serving),
+		fmt.Sprintf("Configured model `%s`", embeddings.ModelName()),
+	}
+	stats := database.GetEmbeddingQueueStats()
+	lines = append(lines, "", "**Queue**",
+		fmt.Sprintf("%d/%d queued · %d waiting to retry · %d workers, batches of %d", stats.Depth, stats.Capacity, stats.Retrying, stats.Workers, stats.BatchSize),
+		fmt.Sprintf("%d embedded in the last minute · %d embedded, %d failed, %d retried, %d dropped since start", stats.PerMinute, stats.Embedded, stats.Failed, stats.Retried, stats.Dropped),
+	)
+
+	if len(migrations) > 0 {
+		lines = append(lines, "", "**Migrations**")
+	}
+	for _, m := range migrations {
+		line := fmt.Sprintf("%s `%s` → `%s` · %d/%d embedded", migrationStatusEmoji(m.Status), m.FromModel, m.ToModel, m.Done, m.Total)
+		if m.Failed > 0 {
+			line += fmt.Sprintf(" · %d failed", m.Failed)
+		}
+		line += fmt.Sprintf(" · started <t:%d:R>", m.StartedAt.Unix())
+		if m.FinishedAt.Valid {
+			line += fmt.Sprintf(", finished <t:%d:R>", m.FinishedAt.Time.Unix())
+		}
+		if m.Error != "" {
+			line += fmt.Sprintf("\n-# %s", m.Error)
+		}
+		lines = append(lines, line)
+	}
+
+	return []discord.LayoutComponent{discord.ContainerComponent{Components: []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{Content: strings.Join(lines, "\n")},
+	}}}
+}
+
+func embeddingsQuantizationComponents(guildID string) []discord.LayoutComponent {
+	model, err := database.ServingEmbeddingModel(guildID)
+	if err != nil {
+		slog.Error("Failed to fetch the embedding model", slog.Any("err", err))
+		return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Failed to fetch the embedding model")}}
+	}
+	report, err := database.GetQuantizationReport(model, quantizationReportSamples)
+	if err != nil {
+		slog.Error("Failed to build the quantization report", slog.Any("err", err))
+		return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Failed to build the quantization report")}}
+	}
+
+	lines := []string{
+		"# Embedding quantization",
+		fmt.Sprintf("`%s` · %d dimensions · storing `%s` · statsbot.db is %s", report.Model, report.Dimensions, report.Mode, formatBytes(report.DatabaseBytes)),
+		fmt.Sprintf("Stored vectors: %d float, %d int8, %d binary", report.Vectors[database.QuantizationNone], report.Vectors[database.QuantizationInt8], report.Vectors[database.QuantizationBinary]),
+		"",
+	}
+	full := report.Modes[0].Bytes
+	for _, m := range report.Modes {
+		line := fmt.Sprintf("**%s** · %s", m.Mode, formatBytes(m.Bytes))
+		if full > 0 && m.Mode != database.QuantizationNone {
+			line += fmt.Sprintf(" (%.0f%% smaller)", 100*(1-float64(m.Bytes)/float64(full)))
+		}
+		if m.Recall >= 0 {
+			line += fmt.Sprintf(" · recall@10 %.1f%%, %.1f%% after rescoring", 100*m.Recall, 100*m.RescoredRecall)
+		}
+		lines = append(lines, line)
+	}
+	switch {
+	case report.Samples > 0 && report.Kept > 0:
+		lines = append(lines, fmt.Sprintf("-# Recall measured over %d searches among sampled full-precision vectors, including the %d kept aside when the stored ones were converted.", report.Samples, report.Kept))
+	case report.Samples > 0:
+		lines = append(lines, fmt.Sprintf("-# Recall measured over %d searches among sampled stored vectors.", report.Samples))
+	default:
+		lines = append(lines, "-# Recall needs full-precision vectors, and none are stored.")
+	}
+	if report.Mode == database.QuantizationNone {
+		lines = append(lines, "-# Check this report before setting EMBEDDING_QUANTIZATION: the next start converts the stored vectors for good, keeping only a sample at full precision for this report.")
+	}
+
+	return []discord.LayoutComponent{discord.ContainerComponent{Components: []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{Content: strings.Join(lines, "\n")},
+	}}}
+}
+
+// formatBytes renders a byte count with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func migrationStatusEmoji(status string) string {
+	switch status {
+	case database.EmbeddingMigrationComplete:
+		return "✅"
+	case database.EmbeddingMigrationFailed:
+		return "❌"
+	default:
+		return "⏳"
+	}
+}
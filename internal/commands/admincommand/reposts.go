@@ -0,0 +1,71 @@
+package admincommand
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/stollenaar/statisticsbot/internal/database"
+)
+
+func repostsHandler(event *events.ApplicationCommandInteractionCreate, sub discord.SlashCommandInteractionData) []discord.LayoutComponent {
+	guildID := event.GuildID().String()
+
+	channelID := event.Channel().ID()
+	if channel, ok := sub.OptChannel("channel"); ok {
+		channelID = channel.ID
+	}
+
+	switch *sub.SubCommandName {
+	case "enable":
+		threshold := database.DefaultRepostThreshold
+		if t, ok := sub.OptFloat("threshold"); ok {
+			threshold = t
+		}
+		if err := database.EnableRepostDetection(guildID, channelID.String(), event.User().ID.String(), threshold); err != nil {
+			slog.Error("Failed to enable repost detection", slog.Any("err", err))
+			return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Failed to enable repost detection")}}
+		}
+		return []discord.LayoutComponent{discord.ContainerComponent{Components: []discord.ContainerSubComponent{
+			discord.TextDisplayComponent{
+				Content: fmt.Sprintf("Replying to reposts in %s: new messages at least %.0f%% similar to a message from the past week.", discord.ChannelMention(channelID), threshold*100),
+			},
+		}}}
+	case "disable":
+		found, err := database.DisableRepostDetection(channelID.String())
+		if err != nil {
+			slog.Error("Failed to disable repost detection", slog.Any("err", err))
+			return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Failed to disable repost detection")}}
+		}
+		if !found {
+			return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents(fmt.Sprintf("Repost detection is not enabled in %s", discord.ChannelMention(channelID)))}}
+		}
+		return []discord.LayoutComponent{discord.ContainerComponent{Components: []discord.ContainerSubComponent{
+			discord.TextDisplayComponent{Content: fmt.Sprintf("No longer looking for reposts in %s.", discord.ChannelMention(channelID))},
+		}}}
+	case "list":
+		return repostsListComponents(guildID)
+	}
+	return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Unknown reposts subcommand")}}
+}
+
+func repostsListComponents(guildID string) []discord.LayoutComponent {
+	channels, err := database.ListRepostChannels(guildID)
+	if err != nil {
+		slog.Error("Failed to fetch the repost channels", slog.Any("err", err))
+		return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Failed to fetch the repost channels")}}
+	}
+	if len(channels) == 0 {
+		return []discord.LayoutComponent{discord.ContainerComponent{Components: errorComponents("Repost detection is not enabled in any channel")}}
+	}
+
+	lines := []string{"# Repost detection"}
+	for _, c := range channels {
+		lines = append(lines, fmt.Sprintf("<#%s> · %.0f%% similar · enabled by <@%s> <t:%d:R>", c.ChannelID, c.Threshold*100, c.EnabledBy, c.CreatedAt.Unix()))
+	}
+	return []discord.LayoutComponent{discord.ContainerComponent{Components: []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{Content: strings.Join(lines, "\n")},
+	}}}
+}
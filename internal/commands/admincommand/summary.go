@@ -11,7 +11,6 @@ import (
 
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
-	"github.com/google/uuid"
 	"github.com/stollenaar/statisticsbot/internal/commands/summarizecommand"
 	"github.com/stollenaar/statisticsbot/internal/database"
 	"github.com/stollenaar/statisticsbot/internal/util"
@@ -291,21 +290,10 @@ func summaryRetryComponents(id string) []discord.LayoutComponent {
 			slog.Error("Failed to unmarshal messages JSON", slog.Any("err", err))
 			rows = errorComponents("Failed to parse stored messages")
 		} else {
-			summaries, rawResponse, err := summarizecommand.GetSummary(messages)
-
-			status := "success"
-			if err != nil {
-				status = "failed"
-			} else if len(summaries.Summaries) == 0 {
-				status = "empty"
-			}
-
-			// Preserve the previous try by storing the retry as a new row linked
-			// to the original attempt instead of overwriting it.
-			retryID := uuid.New().String()
-			if saveErr := database.SaveSummaryRetry(retryID, parentID, inv.GuildID, inv.ChannelID, inv.Unit, inv.MessagesJSON, rawResponse, status); saveErr != nil {
-				slog.Warn("Failed to save summary retry", slog.Any("err", saveErr))
-			}
+			// The inv is not pending, so every attempt, repairs included, is
+			// stored as a new retry linked to the original instead of
+			// overwriting a previous try.
+			summaries, err := summarizecommand.GetSummary(inv, messages)
 
 			if err != nil {
 				rows = errorComponents(fmt.Sprintf("Retry failed: %s", err.Error()))
@@ -428,7 +416,7 @@ func invocationRows(inv database.SummaryInvocation, label string) []discord.Cont
 			},
 		},
 	}
-	if inv.Status == "success" {
+	if inv.Status == "success" || inv.Status == "invalid" {
 		actionRow.Components = append(actionRow.Components, discord.ButtonComponent{
 			Style:    discord.ButtonStyleSecondary,
 			Label:    "View Response",
@@ -505,6 +493,8 @@ func statusEmoji(status string) string {
 		return "❌"
 	case "empty":
 		return "⭕"
+	case "invalid":
+		return "⚠️"
 	default:
 		return "⏳"
 	}
@@ -10,13 +10,22 @@ import (
 	"github.com/stollenaar/statisticsbot/internal/commands/helpcommand"
 	"github.com/stollenaar/statisticsbot/internal/commands/lastmessagecommand"
 	"github.com/stollenaar/statisticsbot/internal/commands/maxcommand"
+	"github.com/stollenaar/statisticsbot/internal/commands/moderationcommand"
 	"github.com/stollenaar/statisticsbot/internal/commands/moodcommand"
 	"github.com/stollenaar/statisticsbot/internal/commands/plotcommand"
+	"github.com/stollenaar/statisticsbot/internal/commands/schedulecommand"
 	"github.com/stollenaar/statisticsbot/internal/commands/semanticcommand"
 	"github.com/stollenaar/statisticsbot/internal/commands/summarizecommand"
+	"github.com/stollenaar/statisticsbot/internal/commands/topicscommand"
+	"github.com/stollenaar/statisticsbot/internal/commands/twinscommand"
 	"github.com/stollenaar/statisticsbot/internal/util"
 )
 
+// MessageCommandI is a command in a message's context menu.
+type MessageCommandI interface {
+	Handler(event *events.ApplicationCommandInteractionCreate)
+}
+
 type CommandI interface {
 	Handler(event *events.ApplicationCommandInteractionCreate)
 	CreateCommandArguments() []discord.ApplicationCommandOption
@@ -29,10 +38,17 @@ var (
 		helpcommand.HelpCmd,
 		lastmessagecommand.LastMessageCmd,
 		maxcommand.MaxCmd,
+		moderationcommand.ModerationCmd,
 		moodcommand.MoodCmd,
 		semanticcommand.SemanticCmd,
 		summarizecommand.SummarizeCmd,
 		plotcommand.PlotCmd,
+		schedulecommand.ScheduleCmd,
+		topicscommand.TopicsCmd,
+		twinscommand.TwinsCmd,
+	}
+	MessageCommands = []MessageCommandI{
+		semanticcommand.SimilarCmd,
 	}
 	ApplicationCommands    []discord.ApplicationCommandCreate
 	CommandHandlers        = make(map[string]func(e *events.ApplicationCommandInteractionCreate))
@@ -67,6 +83,13 @@ func init() {
 		}
 	}
 
+	for _, cmd := range MessageCommands {
+		ApplicationCommands = append(ApplicationCommands, &discord.MessageCommandCreate{
+			Name: reflect.ValueOf(cmd).FieldByName("Name").String(),
+		})
+		MessageCommandHandlers[reflect.ValueOf(cmd).FieldByName("Name").String()] = cmd.Handler
+	}
+
 	ApplicationCommands = append(ApplicationCommands,
 		&discord.SlashCommandCreate{
 			Name:        "ping",
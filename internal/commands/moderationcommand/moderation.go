@@ -0,0 +1,211 @@
+package moderationcommand
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/moderation"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+var (
+	ModerationCmd = ModerationCommand{
+		Name:        "modreport",
+		Description: "Report the flagged-message rates per channel and week (mods only)",
+	}
+)
+
+const (
+	defaultWeeks = 4
+	maxWeeks     = 12
+	// maxScoreOnDemand bounds how many unscored messages the report scores
+	// before answering. New messages are scored on arrival, so this only
+	// matters for history from before scoring was enabled.
+	maxScoreOnDemand = 300
+	maxChannels      = 10
+	maxFlagged       = 10
+	maxExcerptRunes  = 80
+)
+
+type ModerationCommand struct {
+	Name        string
+	Description string
+}
+
+// Handler shows how many of the scored messages in the last weeks were
+// flagged by the local moderation classifiers, per channel and per week,
+// followed by the strongest flagged messages with jump links. Only the admin
+// can run it and the report is always ephemeral.
+func (m ModerationCommand) Handler(event *events.ApplicationCommandInteractionCreate) {
+	if event.Member().User.ID.String() != util.ConfigFile.ADMIN_USER_ID {
+		event.CreateMessage(discord.MessageCreate{
+			Content: "You are not the boss of me",
+			Flags:   discord.MessageFlagEphemeral | discord.MessageFlagIsComponentsV2,
+		})
+		return
+	}
+	if !moderation.Enabled() {
+		event.CreateMessage(discord.MessageCreate{
+			Content: "Moderation scoring is disabled, set MODERATION_ENABLED=true to turn it on",
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+
+	err := event.DeferCreateMessage(true)
+	if err != nil {
+		slog.Error("Error deferring: ", slog.Any("err", err))
+		return
+	}
+	sub := event.SlashCommandInteractionData()
+
+	weeks := defaultWeeks
+	if opt, ok := sub.Options["weeks"]; ok {
+		if n := opt.Int(); n > 0 {
+			weeks = n
+		}
+	}
+	if weeks > maxWeeks {
+		weeks = maxWeeks
+	}
+
+	var channels []string
+	if channel, ok := sub.OptChannel("channel"); ok {
+		channels = []string{channel.ID.String()}
+	}
+	guildID := event.GuildID().String()
+	threshold := moderation.Threshold()
+
+	now := time.Now()
+	start := now.AddDate(0, 0, -7*weeks)
+
+	scoreMissing(guildID, channels, start, now)
+
+	byChannel, err := database.FlaggedRateByChannel(guildID, channels, start, now, threshold)
+	if err != nil {
+		slog.Error("moderation duckDB error", slog.Any("err", err))
+		util.EditError(event, "error happened while trying to fetch the flagged rates")
+		return
+	}
+	if len(byChannel) == 0 {
+		util.EditError(event, "no scored messages found in that period")
+		return
+	}
+	byWeek, err := database.FlaggedRateByWeek(guildID, channels, start, now, threshold)
+	if err != nil {
+		slog.Error("moderation duckDB error", slog.Any("err", err))
+		util.EditError(event, "error happened while trying to fetch the flagged rates")
+		return
+	}
+	flagged, err := database.GetFlaggedMessages(guildID, channels, start, now, threshold, maxFlagged)
+	if err != nil {
+		slog.Error("moderation duckDB error", slog.Any("err", err))
+		util.EditError(event, "error happened while trying to fetch the flagged messages")
+		return
+	}
+
+	components := reportComponents(*event.GuildID(), weeks, threshold, byChannel, byWeek, flagged)
+	util.UpdateInteractionResponse(event, components)
+}
+
+// scoreMissing scores the messages in the period that have not been scored
+// yet, newest first, up to maxScoreOnDemand.
+func scoreMissing(guildID string, channels []string, start, end time.Time) {
+	missing, err := database.GetPeriodMessagesWithoutModeration(guildID, channels, start, end, maxScoreOnDemand)
+	if err != nil {
+		slog.Warn("Failed to fetch unscored messages", slog.Any("err", err))
+		return
+	}
+	for _, message := range missing {
+		database.ScoreMessage(message.MessageID, message.Content)
+	}
+}
+
+func reportComponents(guildID snowflake.ID, weeks int, threshold float32, byChannel, byWeek []database.FlaggedRate, flagged []database.FlaggedMessage) []discord.LayoutComponent {
+	var total database.FlaggedRate
+	for _, c := range byChannel {
+		total.Flagged += c.Flagged
+		total.Total += c.Total
+	}
+
+	rows := []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{
+			Content: fmt.Sprintf("# Moderation report for the past %d weeks\n%s flagged · threshold %.2f",
+				weeks, formatRate(total), threshold),
+		},
+	}
+
+	lines := []string{"**Per channel**"}
+	for i, c := range byChannel {
+		if i == maxChannels {
+			lines = append(lines, fmt.Sprintf("-# …and %d more", len(byChannel)-maxChannels))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. <#%s> — %s", i+1, c.Key, formatRate(c)))
+	}
+	rows = append(rows, util.GetSeparator(), discord.TextDisplayComponent{Content: strings.Join(lines, "\n")})
+
+	lines = []string{"**Per week**"}
+	for _, w := range byWeek {
+		lines = append(lines, fmt.Sprintf("Week of %s — %s", w.Key, formatRate(w)))
+	}
+	rows = append(rows, util.GetSeparator(), discord.TextDisplayComponent{Content: strings.Join(lines, "\n")})
+
+	lines = []string{"**Strongest flagged messages**"}
+	if len(flagged) == 0 {
+		lines = append(lines, "_Nothing was flagged._")
+	}
+	for _, f := range flagged {
+		link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, f.ChannelID, f.ID)
+		lines = append(lines, fmt.Sprintf("[%s](%s) %s in <#%s> · %s\n> %s",
+			f.Date.Format("2006-01-02 15:04"), link, util.MemberMention(f.AuthorID), f.ChannelID, formatScores(f.Scores), excerpt(f.Content)))
+	}
+	rows = append(rows, util.GetSeparator(), discord.TextDisplayComponent{Content: strings.Join(lines, "\n")})
+
+	return []discord.LayoutComponent{discord.ContainerComponent{Components: rows}}
+}
+
+// formatRate renders a rate like "12/340 (3.5%)".
+func formatRate(f database.FlaggedRate) string {
+	return fmt.Sprintf("%d/%d (%.1f%%)", f.Flagged, f.Total, 100*f.Rate())
+}
+
+func formatScores(s moderation.Scores) string {
+	return fmt.Sprintf("toxicity %.2f · insult %.2f · spam %.2f", s.Toxicity, s.Insult, s.Spam)
+}
+
+// excerpt shortens a message to a single line quote.
+func excerpt(content string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if runes := []rune(content); len(runes) > maxExcerptRunes {
+		content = string(runes[:maxExcerptRunes]) + "…"
+	}
+	return content
+}
+
+func (m ModerationCommand) CreateCommandArguments() []discord.ApplicationCommandOption {
+	return []discord.ApplicationCommandOption{
+		discord.ApplicationCommandOptionInt{
+			Name:        "weeks",
+			Description: fmt.Sprintf("How many weeks back to report on (default %d, max %d)", defaultWeeks, maxWeeks),
+			Required:    false,
+			MinValue:    util.Pointer(1),
+			MaxValue:    util.Pointer(maxWeeks),
+		},
+		discord.ApplicationCommandOptionChannel{
+			Name:        "channel",
+			Description: "Only report on this channel",
+			Required:    false,
+			ChannelTypes: []discord.ChannelType{
+				discord.ChannelTypeGuildText,
+				discord.ChannelTypeGuildPublicThread,
+			},
+		},
+	}
+}
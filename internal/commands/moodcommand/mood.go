@@ -1,36 +1,61 @@
 package moodcommand
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
 	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/llm"
 	"github.com/stollenaar/statisticsbot/internal/util"
+	"github.com/stollenaar/statisticsbot/internal/util/charts"
 )
 
 var (
 	MoodCmd = MoodCommand{
 		Name:        "mood",
-		Description: "get the mood of messages from a period of time",
+		Description: "get the mood of a channel over a period of time",
+	}
+
+	moodSchema = &llm.Schema{
+		Type: "object",
+		Properties: map[string]*llm.Schema{
+			"messages": {
+				Type:     "array",
+				MinItems: util.Pointer(1),
+				MaxItems: util.Pointer(maxNarrationTopics),
+				Items: &llm.Schema{
+					Type: "object",
+					Properties: map[string]*llm.Schema{
+						"topic": {Type: "string", MinLength: util.Pointer(1)},
+						"mood":  {Type: "string", MinLength: util.Pointer(1)},
+					},
+					Required: []string{"topic", "mood"},
+				},
+			},
+		},
+		Required: []string{"messages"},
 	}
-	pastMessages = `
-		SELECT id, content
-		FROM messages
-		WHERE guild_id = ? 
-		AND channel_id = ?
-		AND date BETWEEN ? and ?;
-	`
-
-	milvusQuery = `
-		id in %s
-	`
+)
+
+const (
+	maxMoodDays = 90
+	// maxClassifyOnDemand bounds how many unlabelled messages /mood queues
+	// for labelling. New messages are labelled on arrival, so this only
+	// matters for history that has not been backfilled yet.
+	maxClassifyOnDemand = 300
+	maxMoodUsers        = 10
+	maxNarrationSamples = 150
+	maxNarrationTopics  = 5
 )
 
 type MoodCommand struct {
@@ -42,23 +67,20 @@ type CommandParsed struct {
 	Unit string
 }
 
+// MoodResponse is the LLM's narration of what the mood was about.
 type MoodResponse struct {
-	// The key can be a string (e.g., a topic title), and the value is the Mood of that topic.
-	Mood map[string]string `json:"mood"`
-}
-
-type MoodRequest struct {
-	MoodBodies []MoodBody `json:"messages"`
-	Eps        float32    `json:"eps"`
-	MinSamples int        `json:"minSamples"`
-	TopN       int        `json:"topN"`
+	Moods []MoodTopic `json:"messages"`
 }
 
-type MoodBody struct {
-	Vector  []float32 `json:"vector"`
-	Message string    `json:"message"`
+type MoodTopic struct {
+	Topic string `json:"topic"`
+	Mood  string `json:"mood"`
 }
 
+// Handler shows the mood of a channel as labelled by the local sentiment
+// classifier: the overall split, a line chart of the average sentiment per
+// day and a per-user breakdown. The LLM is only asked for the optional topic
+// narration.
 func (m MoodCommand) Handler(event *events.ApplicationCommandInteractionCreate) {
 	err := event.DeferCreateMessage(util.ConfigFile.SetEphemeral() == discord.MessageFlagEphemeral)
 
@@ -70,85 +92,218 @@ func (m MoodCommand) Handler(event *events.ApplicationCommandInteractionCreate)
 
 	unit, err := parseTimeArg(sub.Options["unit"].String())
 	if err != nil {
-		eString := err.Error()
-		_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
-			Content: &eString,
-		})
-		if err != nil {
-			slog.Error("Error editing the response:", slog.Any("err", err))
-		}
+		util.EditError(event, err.Error())
 		return
 	}
 
+	channelID := event.Channel().ID()
+	if channel, ok := sub.OptChannel("channel"); ok {
+		if !channel.Permissions.Has(discord.PermissionViewChannel) {
+			util.EditError(event, "you can't read that channel")
+			return
+		}
+		channelID = channel.ID
+	}
+	guildID := event.GuildID().String()
+	channels := []string{channelID.String()}
+
 	now := time.Now()
+	start := now.Add(-unit)
+
+	pending := queueMissing(guildID, channels, start, now)
 
-	// Get all messages in the time frame
-	rs, err := database.QueryDuckDB(pastMessages, []interface{}{event.GuildID().String(), event.Channel().String(), now.Add(-unit), now})
+	users, err := database.MoodByUser(guildID, channels, start, now)
 	if err != nil {
-		eString := "error happened while trying to fetch the messages"
 		slog.Error("mood duckDB error", slog.Any("err", err))
-		_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
-			Content: &eString,
-		})
-		if err != nil {
-			slog.Error("Error editing the response:", slog.Any("err", err))
+		util.EditError(event, "error happened while trying to fetch the mood")
+		return
+	}
+	if len(users) == 0 {
+		if pending > 0 {
+			util.EditError(event, "the messages in that period are still being labelled, try again in a minute")
+			return
 		}
+		util.EditError(event, "no messages found in that period")
 		return
 	}
 
-	var messages []MoodBody
-	messagMap := make(map[string]string)
-	var messageIds []string
+	var files []*discord.File
+	tracker := charts.ChartTracker{
+		GuildID:   guildID,
+		ChartType: charts.LineChart,
+		Metric:    charts.MetricType{Category: "sentiment", Metric: "avg"},
+		GroupBy:   charts.MetricType{Category: "single", Metric: "date"},
+		Channels:  channels,
+		DateRange: "custom",
+		CustomDateRange: charts.DateRange{
+			Start: &start,
+			End:   &now,
+		},
+	}
+	chart, err := tracker.GenerateChart(event.Client())
+	if err != nil {
+		slog.Warn("Failed to generate mood chart", slog.Any("err", err))
+		chart = nil
+	} else {
+		files = append(files, chart)
+	}
 
-	for rs.Next() {
-		var id, content string
-		err := rs.Scan(&id, &content)
+	var narration []MoodTopic
+	narrationFailed := false
+	if sub.Bool("narrate") {
+		editor := util.NewStreamEditor(event, util.PreviewTopics("Narrating the mood…", "mood"))
+		narration, err = narrate(guildID, channels, start, now, editor)
+		editor.Close()
 		if err != nil {
-			eString := "error happened while trying to build Mood body"
-			slog.Error("mood duckDB error", slog.Any("err", err))
-			_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
-				Content: &eString,
-			})
-			if err != nil {
-				slog.Error("Error editing the response:", slog.Any("err", err))
-			}
-			return
+			slog.Error("mood narration error", slog.Any("err", err))
+			narrationFailed = true
 		}
-		messagMap[id] = content
-		messageIds = append(messageIds, id)
 	}
 
-	// Get and create the Mood
-	mood, err := getMood(messages)
+	components := moodComponents(channelID, sub.Options["unit"].String(), users, pending, chart, narration, narrationFailed)
+	// Components v2 messages can't carry content, so a streamed narration
+	// preview is cleared.
+	_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
+		Content:         util.Pointer(""),
+		Flags:           util.Pointer(discord.MessageFlagIsComponentsV2),
+		Components:      &components,
+		Files:           files,
+		AllowedMentions: &discord.AllowedMentions{},
+	})
 	if err != nil {
-		eString := "error happened while trying to generate the mood"
-		slog.Error("mood error", slog.Any("err", err))
-		_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
-			Content: &eString,
-		})
-		if err != nil {
-			slog.Error("Error editing the response:", slog.Any("err", err))
-		}
-		return
+		slog.Error("Error editing the response:", slog.Any("err", err))
 	}
+}
 
-	embed := discord.Embed{
-		Title: fmt.Sprintf("Mood of the past %s", sub.Options["unit"].String()),
+// queueMissing queues the messages in the period that have no sentiment yet,
+// newest first, up to maxClassifyOnDemand, and returns how many were queued.
+// They are labelled in the background, so the mood shown covers only the
+// messages labelled already.
+func queueMissing(guildID string, channels []string, start, end time.Time) int {
+	missing, err := database.GetPeriodMessagesWithoutSentiment(guildID, channels, start, end, maxClassifyOnDemand)
+	if err != nil {
+		slog.Warn("Failed to fetch unclassified messages", slog.Any("err", err))
+		return 0
+	}
+	queued := 0
+	for _, message := range missing {
+		if database.QueueSentiment(message.MessageID, message.Content) {
+			queued++
+		}
 	}
+	return queued
+}
 
-	for topic, Mood := range mood.Mood {
-		embed.Fields = append(embed.Fields, discord.EmbedField{
-			Name:  topic,
-			Value: Mood,
-		})
+// narrate asks the LLM what the conversation was about and how it felt, based
+// on a sample of the already labelled messages. The reply is streamed into the
+// editor while it is generated.
+func narrate(guildID string, channels []string, start, end time.Time, editor *util.StreamEditor) ([]MoodTopic, error) {
+	samples, err := database.MoodSamples(guildID, channels, start, end, maxNarrationSamples)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
-		Embeds: &[]discord.Embed{embed},
+	prompt := fmt.Sprintf(
+		"You are an AI that outputs valid JSON only.\n"+
+			"The following Discord messages are each labelled with their sentiment.\n"+
+			"Group them by topic and describe in one sentence how the conversation felt for each topic.\n"+
+			"Return a JSON object with a \"messages\" array of at most %d elements, each with a \"topic\" and \"mood\" field.\n\n"+
+			"Input:\n%s",
+		maxNarrationTopics, string(data))
+
+	out, err := llm.Generate[MoodResponse](context.Background(), llm.Request{
+		Temperature:      0.2,
+		FrequencyPenalty: 1.8,
+		PresencePenalty:  1.2,
+		MaxTokens:        len(data) + 1000,
+		Messages:         []llm.Message{{Role: "user", Content: prompt}},
+		Schema:           moodSchema,
+	}, llm.Structured{
+		OnDelta: editor.Write,
+		OnAttempt: func(attempt llm.Attempt) {
+			if attempt.Err != nil {
+				editor.Reset()
+			}
+		},
 	})
 	if err != nil {
-		slog.Error("Error editing the response:", slog.Any("err", err))
+		return nil, err
+	}
+	for i, topic := range out.Moods {
+		out.Moods[i].Topic = util.MentionifyIDs(topic.Topic)
+		out.Moods[i].Mood = util.MentionifyIDs(topic.Mood)
+	}
+	return out.Moods, nil
+}
+
+func moodComponents(channelID snowflake.ID, unit string, users []database.UserMood, pending int, chart *discord.File, narration []MoodTopic, narrationFailed bool) []discord.LayoutComponent {
+	var total database.UserMood
+	weighted := 0.0
+	for _, u := range users {
+		total.Positive += u.Positive
+		total.Neutral += u.Neutral
+		total.Negative += u.Negative
+		weighted += u.Score * float64(u.Total())
+	}
+	count := total.Total()
+
+	rows := []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{
+			Content: fmt.Sprintf("# Mood of %s over the past %s\n%d messages · average sentiment %s\n%s",
+				discord.ChannelMention(channelID), unit, count, formatScore(weighted/float64(count)), formatSplit(total)),
+		},
 	}
+	if pending > 0 {
+		rows = append(rows, discord.TextDisplayComponent{
+			Content: fmt.Sprintf("-# %d more messages are still being labelled, run /mood again in a minute to include them.", pending),
+		})
+	}
+
+	if chart != nil {
+		rows = append(rows, discord.MediaGalleryComponent{
+			Items: []discord.MediaGalleryItem{{
+				Media: discord.UnfurledMediaItem{URL: fmt.Sprintf("attachment://%s", chart.Name)},
+			}},
+		})
+	}
+
+	lines := []string{"**Per user**"}
+	for i, u := range users {
+		if i == maxMoodUsers {
+			lines = append(lines, fmt.Sprintf("-# …and %d more", len(users)-maxMoodUsers))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s — %s · %s", i+1, util.MemberMention(u.AuthorID), formatScore(u.Score), formatSplit(u)))
+	}
+	rows = append(rows, util.GetSeparator(), discord.TextDisplayComponent{Content: strings.Join(lines, "\n")})
+
+	switch {
+	case narrationFailed:
+		rows = append(rows, util.GetSeparator(), discord.TextDisplayComponent{Content: "_Failed to narrate the topics._"})
+	case len(narration) > 0:
+		lines := []string{"**Topics**"}
+		for _, topic := range narration {
+			lines = append(lines, fmt.Sprintf("**%s** — %s", topic.Topic, topic.Mood))
+		}
+		rows = append(rows, util.GetSeparator(), discord.TextDisplayComponent{Content: strings.Join(lines, "\n")})
+	}
+
+	return []discord.LayoutComponent{discord.ContainerComponent{Components: rows}}
+}
+
+// formatSplit renders the share of each label, e.g. "😊 40% · 😐 45% · 😠 15%".
+func formatSplit(u database.UserMood) string {
+	total := float64(u.Total())
+	return fmt.Sprintf("😊 %.0f%% · 😐 %.0f%% · 😠 %.0f%%",
+		100*float64(u.Positive)/total, 100*float64(u.Neutral)/total, 100*float64(u.Negative)/total)
+}
+
+func formatScore(score float64) string {
+	return fmt.Sprintf("%+.2f", score)
 }
 
 func (m MoodCommand) ParseArguments(bot *discordgo.Session, interaction *discordgo.InteractionCreate) interface{} {
@@ -174,9 +329,23 @@ func (m MoodCommand) CreateCommandArguments() []discord.ApplicationCommandOption
 	return []discord.ApplicationCommandOption{
 		discord.ApplicationCommandOptionString{
 			Name:        "unit",
-			Description: "How far back to get the mood of a conversation",
+			Description: fmt.Sprintf("How far back to get the mood of a conversation (up to %dd)", maxMoodDays),
 			Required:    true,
 		},
+		discord.ApplicationCommandOptionChannel{
+			Name:        "channel",
+			Description: "Channel to get the mood of, defaults to this one",
+			Required:    false,
+			ChannelTypes: []discord.ChannelType{
+				discord.ChannelTypeGuildText,
+				discord.ChannelTypeGuildPublicThread,
+			},
+		},
+		discord.ApplicationCommandOptionBool{
+			Name:        "narrate",
+			Description: "Also have the LLM describe what the conversation was about",
+			Required:    false,
+		},
 	}
 }
 
@@ -210,60 +379,10 @@ func parseTimeArg(timeUnit string) (time.Duration, error) {
 		return 0, fmt.Errorf("unknown time unit: %s", unit)
 	}
 
-	// Enforce maximum time limit (1 day)
-	maxDuration := 24 * time.Hour
-	if duration > maxDuration {
-		return 0, fmt.Errorf("time cannot exceed 1 day (24h)")
+	// Enforce maximum time limit
+	if duration > maxMoodDays*24*time.Hour {
+		return 0, fmt.Errorf("time cannot exceed %d days", maxMoodDays)
 	}
 
 	return duration, nil
 }
-
-func getMood(messages []MoodBody) (out MoodResponse, err error) {
-	data, err := json.Marshal(messages)
-	if err != nil {
-		return MoodResponse{}, err
-	}
-
-	prompt := fmt.Sprintf("group the following messages together and analyze the mood. Make sure to return both the topic of the grouped messages, and mood analysis. Return it as a json string of this format {\"messages\":[{\"topic\", \"mood\"}]}: %s", string(data))
-
-	resp, err := util.CreateOllamaGeneration(util.OllamaGenerateRequest{
-		Model:            util.ConfigFile.OLLAMA_MODEL,
-		Temperature:      0.2,
-		FrequencePenalty: 1.8,
-		PresencePenalty:  1.2,
-		MaxTokens:        len(data) + 1000,
-		Messages:         []map[string]string{{"role": "user", "content": prompt}},
-		ResponseFormat: map[string]interface{}{
-			"type": "json_object",
-			"properties": map[string]interface{}{
-				"messages": map[string]interface{}{
-					"type": "array",
-					"items": map[string]interface{}{
-						"type": "json_object",
-						"properties": map[string]interface{}{
-							"topic": map[string]interface{}{
-								"type": "string",
-							},
-							"mood": map[string]interface{}{
-								"type": "string",
-							},
-						},
-					},
-				},
-			},
-			"required": []string{
-				"messages",
-				"topic",
-				"mood",
-			},
-		},
-		Stream: false,
-	})
-	if err != nil {
-		return MoodResponse{}, nil
-	}
-
-	err = json.Unmarshal([]byte(resp.Choices[0].Message.Content), &out)
-	return
-}
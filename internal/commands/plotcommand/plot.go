@@ -191,6 +191,8 @@ func (p PlotCommand) ComponentHandler(event *events.ComponentInteractionCreate)
 			chartTracker.DateRange = event.StringSelectMenuInteractionData().Values[0]
 		case "group_by":
 			chartTracker.GroupBy = charts.GetMetricType(event.StringSelectMenuInteractionData().Values[0])
+		case "line_mode":
+			chartTracker.SetLineMode(event.StringSelectMenuInteractionData().Values[0])
 		}
 
 		p.displayPlotSelection(event.GenericEvent, event.Token(), chartTracker, make(map[string][]discord.LayoutComponent))
@@ -205,7 +207,11 @@ func (p PlotCommand) ComponentHandler(event *events.ComponentInteractionCreate)
 		}
 	} else {
 		event.DeferUpdateMessage()
-		chart, err := chartTracker.GenerateChart(event.Client())
+		data, err := chartTracker.Data(event.Client())
+		var chart *discord.File
+		if err == nil {
+			chart, err = chartTracker.Render(data)
+		}
 		if err != nil {
 			slog.Error("plot error", slog.Any("err", err))
 			e := "Error happened while processing selection"
@@ -221,9 +227,25 @@ func (p PlotCommand) ComponentHandler(event *events.ComponentInteractionCreate)
 			if err != nil {
 				slog.Error("plot error", slog.Any("err", err))
 			}
+			var components []discord.LayoutComponent
+			link, err := chartTracker.InteractiveLink(data)
+			if err != nil {
+				slog.Error("plot link error", slog.Any("err", err))
+			} else if link != "" {
+				components = append(components, discord.ActionRowComponent{
+					Components: []discord.InteractiveComponent{
+						discord.ButtonComponent{
+							Style: discord.ButtonStyleLink,
+							Label: "Open interactive",
+							URL:   link,
+						},
+					},
+				})
+			}
 			_, err = event.Client().Rest.CreateFollowupMessage(event.Client().ApplicationID, event.Token(), discord.MessageCreate{
-				Files: []*discord.File{chart},
-				Flags: util.ConfigFile.SetEphemeral(),
+				Files:      []*discord.File{chart},
+				Components: components,
+				Flags:      util.ConfigFile.SetEphemeral(),
 			})
 			if err != nil {
 				slog.Error("plot error", slog.Any("err", err))
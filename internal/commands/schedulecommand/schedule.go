@@ -0,0 +1,366 @@
+package schedulecommand
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/google/uuid"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/scheduler"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+const (
+	// maxJobsPerGuild keeps a guild from flooding its channels (and the LLM)
+	// with reports.
+	maxJobsPerGuild = 10
+	recentRuns      = 10
+)
+
+var (
+	ScheduleCmd = ScheduleCommand{
+		Name:        "schedule",
+		Description: "Schedule recurring reports to be posted in a channel",
+	}
+)
+
+type ScheduleCommand struct {
+	Name        string
+	Description string
+}
+
+func (s ScheduleCommand) Handler(event *events.ApplicationCommandInteractionCreate) {
+	if !canManage(event.Member()) {
+		event.CreateMessage(discord.MessageCreate{
+			Content: "You need the Manage Server permission to schedule reports",
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+	err := event.DeferCreateMessage(true)
+	if err != nil {
+		slog.Error("Error deferring: ", slog.Any("err", err))
+		return
+	}
+
+	sub := event.SlashCommandInteractionData()
+	guildID := event.GuildID().String()
+
+	var rows []discord.ContainerSubComponent
+	switch *sub.SubCommandName {
+	case "add":
+		rows = addJob(guildID, event.User().ID.String(), sub)
+	case "list":
+		rows = listJobs(guildID)
+	case "remove":
+		rows = removeJob(guildID, sub.String("id"))
+	case "pause":
+		rows = toggleJob(guildID, sub.String("id"), false)
+	case "resume":
+		rows = toggleJob(guildID, sub.String("id"), true)
+	case "runs":
+		rows = listRuns(guildID, sub.String("id"))
+	default:
+		rows = errorComponents("Unknown schedule subcommand")
+	}
+	util.UpdateInteractionResponse(event, []discord.LayoutComponent{discord.ContainerComponent{Components: rows}})
+}
+
+// canManage allows the bot admin and any member who can manage the server.
+func canManage(member *discord.ResolvedMember) bool {
+	if member == nil {
+		return false
+	}
+	return member.User.ID.String() == util.ConfigFile.ADMIN_USER_ID ||
+		member.Permissions.Has(discord.PermissionManageGuild)
+}
+
+func addJob(guildID, userID string, sub discord.SlashCommandInteractionData) []discord.ContainerSubComponent {
+	report := sub.String("report")
+	spec := sub.String("cron")
+
+	config := scheduler.Config{
+		Period:    sub.String("period"),
+		ChartType: sub.String("chart_type"),
+		Metric:    sub.String("metric"),
+		GroupBy:   sub.String("group_by"),
+	}
+	if source, ok := sub.OptChannel("source"); ok {
+		config.SourceChannelID = source.ID.String()
+	}
+	if err := config.Validate(report); err != nil {
+		return errorComponents(err.Error())
+	}
+
+	next, err := scheduler.NextRun(spec, time.Now())
+	if err != nil {
+		return errorComponents(err.Error())
+	}
+
+	jobs, err := database.ListScheduledJobs(guildID)
+	if err != nil {
+		slog.Error("Failed to list scheduled jobs", slog.Any("err", err))
+		return errorComponents("Failed to fetch the existing schedules")
+	}
+	if len(jobs) >= maxJobsPerGuild {
+		return errorComponents(fmt.Sprintf("This server already has %d scheduled reports, remove one first", maxJobsPerGuild))
+	}
+
+	configJSON, _ := json.Marshal(config)
+	job := database.ScheduledJob{
+		ID:        uuid.New().String(),
+		GuildID:   guildID,
+		ChannelID: sub.Channel("channel").ID.String(),
+		CreatedBy: userID,
+		Report:    report,
+		Config:    string(configJSON),
+		Cron:      spec,
+		Enabled:   true,
+		NextRunAt: next,
+	}
+	if err := database.SaveScheduledJob(job); err != nil {
+		slog.Error("Failed to save scheduled job", slog.Any("err", err))
+		return errorComponents("Failed to save the schedule")
+	}
+
+	return []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{Content: "**Report scheduled**"},
+		discord.TextDisplayComponent{Content: jobLine(job)},
+	}
+}
+
+func listJobs(guildID string) []discord.ContainerSubComponent {
+	jobs, err := database.ListScheduledJobs(guildID)
+	if err != nil {
+		slog.Error("Failed to list scheduled jobs", slog.Any("err", err))
+		return errorComponents("Failed to fetch the schedules")
+	}
+
+	rows := []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{Content: fmt.Sprintf("**Scheduled Reports** — %d/%d", len(jobs), maxJobsPerGuild)},
+	}
+	if len(jobs) == 0 {
+		return append(rows, discord.TextDisplayComponent{Content: "No reports scheduled."})
+	}
+	for _, job := range jobs {
+		rows = append(rows, discord.SeparatorComponent{}, discord.TextDisplayComponent{Content: jobLine(job)})
+	}
+	return rows
+}
+
+func removeJob(guildID, id string) []discord.ContainerSubComponent {
+	removed, err := database.DeleteScheduledJob(id, guildID)
+	if err != nil {
+		slog.Error("Failed to delete scheduled job", slog.Any("err", err))
+		return errorComponents("Failed to remove the schedule")
+	}
+	if !removed {
+		return errorComponents(fmt.Sprintf("No scheduled report with id `%s`", id))
+	}
+	return []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{Content: fmt.Sprintf("Removed scheduled report `%s`", id)},
+	}
+}
+
+// toggleJob pauses or resumes a job. A resumed job starts from its next slot
+// rather than catching up on everything it missed while paused.
+func toggleJob(guildID, id string, enabled bool) []discord.ContainerSubComponent {
+	job, err := database.GetScheduledJob(id)
+	if err != nil || job.GuildID != guildID {
+		return errorComponents(fmt.Sprintf("No scheduled report with id `%s`", id))
+	}
+
+	next := job.NextRunAt
+	if enabled {
+		if next, err = scheduler.NextRun(job.Cron, time.Now()); err != nil {
+			return errorComponents(err.Error())
+		}
+	}
+	if _, err := database.SetScheduledJobEnabled(id, guildID, enabled, next); err != nil {
+		slog.Error("Failed to update scheduled job", slog.Any("err", err))
+		return errorComponents("Failed to update the schedule")
+	}
+	job.Enabled, job.NextRunAt = enabled, next
+	return []discord.ContainerSubComponent{discord.TextDisplayComponent{Content: jobLine(job)}}
+}
+
+func listRuns(guildID, id string) []discord.ContainerSubComponent {
+	job, err := database.GetScheduledJob(id)
+	if err != nil || job.GuildID != guildID {
+		return errorComponents(fmt.Sprintf("No scheduled report with id `%s`", id))
+	}
+	runs, err := database.ListScheduledJobRuns(id, recentRuns)
+	if err != nil {
+		slog.Error("Failed to list scheduled runs", slog.Any("err", err))
+		return errorComponents("Failed to fetch the runs")
+	}
+
+	rows := []discord.ContainerSubComponent{discord.TextDisplayComponent{Content: jobLine(job)}}
+	if len(runs) == 0 {
+		return append(rows, discord.TextDisplayComponent{Content: "This report has not run yet."})
+	}
+	rows = append(rows, discord.SeparatorComponent{})
+	for _, run := range runs {
+		line := fmt.Sprintf("%s **%s** | slot <t:%d:f> | ran <t:%d:R>", statusEmoji(run.Status), run.Status, run.ScheduledFor.Unix(), run.StartedAt.Unix())
+		if run.MessageID != "" {
+			line += fmt.Sprintf(" | [message](https://discord.com/channels/%s/%s/%s)", job.GuildID, job.ChannelID, run.MessageID)
+		}
+		if run.Error != "" {
+			line += fmt.Sprintf("\n↳ `%s`", run.Error)
+		}
+		rows = append(rows, discord.TextDisplayComponent{Content: line})
+	}
+	return rows
+}
+
+func jobLine(job database.ScheduledJob) string {
+	state := fmt.Sprintf("next <t:%d:R>", job.NextRunAt.Unix())
+	if !job.Enabled {
+		state = "paused"
+	}
+	line := fmt.Sprintf("`%s` **%s** in <#%s> | `%s` | %s", job.ID, job.Report, job.ChannelID, job.Cron, state)
+	if job.LastRunAt.Valid {
+		line += fmt.Sprintf("\n↳ last run <t:%d:R> %s %s", job.LastRunAt.Time.Unix(), statusEmoji(job.LastStatus), job.LastStatus)
+	}
+	return line
+}
+
+func statusEmoji(status string) string {
+	switch status {
+	case "success":
+		return "✅"
+	case "failed", "interrupted":
+		return "❌"
+	case "missed":
+		return "⭕"
+	default:
+		return "⏳"
+	}
+}
+
+func errorComponents(msg string) []discord.ContainerSubComponent {
+	return []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{Content: msg},
+	}
+}
+
+func (s ScheduleCommand) CreateCommandArguments() []discord.ApplicationCommandOption {
+	var reports []discord.ApplicationCommandOptionChoiceString
+	for _, r := range scheduler.Reports {
+		reports = append(reports, discord.ApplicationCommandOptionChoiceString{Name: r, Value: r})
+	}
+	jobID := discord.ApplicationCommandOptionString{
+		Name:        "id",
+		Description: "Id of the scheduled report, see /schedule list",
+		Required:    true,
+	}
+
+	return []discord.ApplicationCommandOption{
+		discord.ApplicationCommandOptionSubCommand{
+			Name:        "add",
+			Description: "Schedule a recurring report",
+			Options: []discord.ApplicationCommandOption{
+				discord.ApplicationCommandOptionString{
+					Name:        "report",
+					Description: "Which report to post",
+					Required:    true,
+					Choices:     reports,
+				},
+				discord.ApplicationCommandOptionString{
+					Name:        "cron",
+					Description: "When to post, e.g. \"0 9 * * 1\" (prefix CRON_TZ=Europe/Amsterdam for a timezone)",
+					Required:    true,
+				},
+				discord.ApplicationCommandOptionChannel{
+					Name:         "channel",
+					Description:  "Channel to post the report in",
+					Required:     true,
+					ChannelTypes: []discord.ChannelType{discord.ChannelTypeGuildText},
+				},
+				discord.ApplicationCommandOptionString{
+					Name:        "period",
+					Description: "How far back each report looks, e.g. 1d or 2w",
+					Required:    true,
+				},
+				discord.ApplicationCommandOptionChannel{
+					Name:         "source",
+					Description:  "Summary only: channel to summarize (defaults to the posting channel)",
+					ChannelTypes: []discord.ChannelType{discord.ChannelTypeGuildText},
+				},
+				discord.ApplicationCommandOptionString{
+					Name:        "chart_type",
+					Description: "Chart only: type of chart",
+					Choices: []discord.ApplicationCommandOptionChoiceString{
+						{Name: "Pie", Value: "pie"},
+						{Name: "Graph", Value: "graph"},
+						{Name: "Histogram", Value: "histogram"},
+						{Name: "Sunburst", Value: "sunburst"},
+						{Name: "Heatmap", Value: "heatmap"},
+						{Name: "Calendar", Value: "calendar"},
+						{Name: "Network", Value: "network"},
+					},
+				},
+				discord.ApplicationCommandOptionString{
+					Name:        "metric",
+					Description: "Chart only: what to chart",
+					Choices: []discord.ApplicationCommandOptionChoiceString{
+						{Name: "Reaction Count", Value: "reaction;count"},
+						{Name: "Message Count", Value: "message;count"},
+						{Name: "Avg. Message Length", Value: "message;avg_length"},
+						{Name: "Message Frequency", Value: "message;freq"},
+						{Name: "Bot interaction count", Value: "interaction;count"},
+						{Name: "Avg. Sentiment", Value: "sentiment;avg"},
+						{Name: "Positive Share", Value: "sentiment;positive_share"},
+					},
+				},
+				discord.ApplicationCommandOptionString{
+					Name:        "group_by",
+					Description: "Chart only: how to group the data",
+					Choices: []discord.ApplicationCommandOptionChoiceString{
+						{Name: "User", Value: "single;user"},
+						{Name: "Date", Value: "single;date"},
+						{Name: "Month", Value: "single;month"},
+						{Name: "Channel", Value: "single;channel"},
+						{Name: "Channel & User", Value: "channel;user;true"},
+						{Name: "Reaction & User", Value: "reaction;user;true"},
+						{Name: "Reaction & Channel", Value: "reaction;channel;true"},
+						{Name: "Date & User", Value: "date;user;true"},
+						{Name: "Date & Channel", Value: "date;channel;true"},
+						{Name: "Bot & User", Value: "interaction;user;true"},
+						{Name: "Hour & Weekday", Value: "weekday;hour;true"},
+						{Name: "Replies & Mentions", Value: "reply;user;true"},
+						{Name: "Replies & Mentions, by Community", Value: "reply;community;true"},
+					},
+				},
+			},
+		},
+		discord.ApplicationCommandOptionSubCommand{
+			Name:        "list",
+			Description: "List the scheduled reports of this server",
+		},
+		discord.ApplicationCommandOptionSubCommand{
+			Name:        "runs",
+			Description: "Show the recent runs of a scheduled report",
+			Options:     []discord.ApplicationCommandOption{jobID},
+		},
+		discord.ApplicationCommandOptionSubCommand{
+			Name:        "pause",
+			Description: "Pause a scheduled report",
+			Options:     []discord.ApplicationCommandOption{jobID},
+		},
+		discord.ApplicationCommandOptionSubCommand{
+			Name:        "resume",
+			Description: "Resume a paused scheduled report",
+			Options:     []discord.ApplicationCommandOption{jobID},
+		},
+		discord.ApplicationCommandOptionSubCommand{
+			Name:        "remove",
+			Description: "Remove a scheduled report",
+			Options:     []discord.ApplicationCommandOption{jobID},
+		},
+	}
+}
@@ -0,0 +1,139 @@
+package semanticcommand
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+const (
+	// maxContextMessageLength bounds each message of a context view.
+	maxContextMessageLength = 200
+	// maxContextLength keeps a context view within the text display limit,
+	// dropping the messages furthest from the hit first.
+	maxContextLength = 3500
+)
+
+// contextButtons numbers a button per result on the page, opening the
+// conversation around it.
+func contextButtons(results []database.SemanticSearchResult) discord.ActionRowComponent {
+	var buttons []discord.InteractiveComponent
+	for i, r := range results {
+		buttons = append(buttons, discord.ButtonComponent{
+			Style:    discord.ButtonStyleSecondary,
+			Label:    fmt.Sprintf("Context %d", i+1),
+			CustomID: "semantic_context_" + r.MessageID,
+		})
+	}
+	return discord.ActionRowComponent{Components: buttons}
+}
+
+// contextHandler answers a context button privately with the conversation
+// around the message, as GetMessageBlock finds it, with the message itself
+// highlighted.
+func (s SemanticCommand) contextHandler(event *events.ComponentInteractionCreate, messageID string) {
+	if err := event.DeferCreateMessage(true); err != nil {
+		slog.Error("Error deferring: ", slog.Any("err", err))
+		return
+	}
+
+	block, err := database.GetMessageBlock(messageID)
+	if err != nil {
+		slog.Error("semantic context error", slog.Any("err", err))
+		s.editContext(event, []discord.LayoutComponent{discord.TextDisplayComponent{Content: "error happened while loading the conversation"}})
+		return
+	}
+	hit := -1
+	for i, m := range block {
+		if m.MessageID == messageID {
+			hit = i
+			break
+		}
+	}
+	if hit < 0 {
+		s.editContext(event, []discord.LayoutComponent{discord.TextDisplayComponent{Content: "this message is no longer stored"}})
+		return
+	}
+
+	guildID := event.GuildID()
+	lines := make([]string, len(block))
+	for i, m := range block {
+		content := truncate(strings.TrimSpace(m.Content), maxContextMessageLength)
+		if content == "" {
+			content = "*no text*"
+		}
+		line := fmt.Sprintf("**%s** <t:%d:t>: %s", authorName(event.Client(), guildID, m.Author), m.Date.UTC().Unix(), content)
+		if i == hit {
+			line = "> " + strings.ReplaceAll(line, "\n", "\n> ")
+		}
+		lines[i] = line
+	}
+
+	// Grow the view outwards from the hit until it no longer fits.
+	first, last := hit, hit
+	length := len(lines[hit])
+	for {
+		grown := false
+		if first > 0 && length+len(lines[first-1])+1 <= maxContextLength {
+			first--
+			length += len(lines[first]) + 1
+			grown = true
+		}
+		if last < len(lines)-1 && length+len(lines[last+1])+1 <= maxContextLength {
+			last++
+			length += len(lines[last]) + 1
+			grown = true
+		}
+		if !grown {
+			break
+		}
+	}
+
+	target := block[hit]
+	header := fmt.Sprintf("# Context in <#%s>\n-# %d messages around the match, <t:%d:f>", target.ChannelID, last-first+1, target.Date.UTC().Unix())
+	link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", target.GuildID, target.ChannelID, target.MessageID)
+	s.editContext(event, []discord.LayoutComponent{
+		discord.ContainerComponent{Components: []discord.ContainerSubComponent{
+			discord.TextDisplayComponent{Content: header},
+			discord.SeparatorComponent{},
+			discord.TextDisplayComponent{Content: strings.Join(lines[first:last+1], "\n")},
+		}},
+		discord.ActionRowComponent{Components: []discord.InteractiveComponent{
+			discord.ButtonComponent{Style: discord.ButtonStyleLink, Label: "Jump to message", URL: link},
+		}},
+	})
+}
+
+// editContext fills the deferred context view.
+func (s SemanticCommand) editContext(event *events.ComponentInteractionCreate, components []discord.LayoutComponent) {
+	_, err := event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
+		Flags:           util.Pointer(discord.MessageFlagIsComponentsV2),
+		Components:      &components,
+		AllowedMentions: &discord.AllowedMentions{},
+	})
+	if err != nil {
+		slog.Error("Error editing the response:", slog.Any("err", err))
+	}
+}
+
+// authorName returns the member's display name from the member cache,
+// falling back to a mention, which Discord resolves without pinging.
+func authorName(client *bot.Client, guildID *snowflake.ID, authorID string) string {
+	id, err := snowflake.Parse(authorID)
+	if err != nil {
+		return authorID
+	}
+	if guildID != nil {
+		if member, ok := client.Caches.Member(*guildID, id); ok {
+			return member.EffectiveName()
+		}
+	}
+	return discord.UserMention(id)
+}
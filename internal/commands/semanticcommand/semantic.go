@@ -1,12 +1,13 @@
 package semanticcommand
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
@@ -26,6 +27,9 @@ const (
 	defaultPoolSize  = 20
 	maxPoolSize      = 50
 	maxContentLength = 300
+	// maxTranscriptLength bounds a conversation's transcript so a page of
+	// them stays within Discord's embed limits.
+	maxTranscriptLength = 800
 	// sessionTTL is how long a search's results stay navigable before its
 	// pagination buttons expire.
 	sessionTTL = 15 * time.Minute
@@ -36,12 +40,6 @@ var (
 		Name:        "semantic",
 		Description: "semantic search command",
 	}
-
-	// sessions caches each search's ranked results so pagination buttons can
-	// slice into them without re-running the search. Keyed by a token embedded
-	// in the button custom IDs.
-	sessions   = make(map[string]*searchSession)
-	sessionsMu sync.Mutex
 )
 
 type SemanticCommand struct {
@@ -49,11 +47,113 @@ type SemanticCommand struct {
 	Description string
 }
 
+// searchSession holds a search's query, the filters it ran with and its
+// ranked matches: results for a message search, conversations for a
+// conversation search. It is stored under a token embedded in the button
+// custom IDs, so pagination slices into it without re-running the search and
+// survives restarts. Matches are stored without their content, which is
+// loaded again for the page shown.
 type searchSession struct {
-	query   string
-	guildID string
-	results []database.SemanticSearchResult
-	created time.Time
+	Query         string                `json:"query"`
+	GuildID       string                `json:"guild_id"`
+	Scope         string                `json:"scope"`
+	Model         string                `json:"model"`
+	Limit         int                   `json:"limit"`
+	Results       []sessionResult       `json:"results,omitempty"`
+	Conversations []sessionConversation `json:"conversations,omitempty"`
+}
+
+// sessionResult is a ranked message match, by id.
+type sessionResult struct {
+	MessageID  string  `json:"message_id"`
+	Score      float64 `json:"score"`
+	MatchStart int     `json:"match_start,omitempty"`
+	MatchEnd   int     `json:"match_end,omitempty"`
+}
+
+// sessionConversation is a ranked conversation window, whose messages are
+// found again by its channel and dates.
+type sessionConversation struct {
+	ChannelID      string    `json:"channel_id"`
+	FirstMessageID string    `json:"first_message_id"`
+	LastMessageID  string    `json:"last_message_id"`
+	StartDate      time.Time `json:"start_date"`
+	EndDate        time.Time `json:"end_date"`
+	MessageCount   int       `json:"message_count"`
+	Score          float64   `json:"score"`
+}
+
+// len returns how many matches the session holds.
+func (sess *searchSession) len() int {
+	return len(sess.Results) + len(sess.Conversations)
+}
+
+// addResults appends ranked message matches to the session.
+func (sess *searchSession) addResults(results ...database.SemanticSearchResult) {
+	for _, r := range results {
+		sess.Results = append(sess.Results, sessionResult{
+			MessageID:  r.MessageID,
+			Score:      r.Score,
+			MatchStart: r.MatchStart,
+			MatchEnd:   r.MatchEnd,
+		})
+	}
+}
+
+// addConversations appends ranked conversation matches to the session.
+func (sess *searchSession) addConversations(conversations ...database.ConversationSearchResult) {
+	for _, c := range conversations {
+		sess.Conversations = append(sess.Conversations, sessionConversation{
+			ChannelID:      c.ChannelID,
+			FirstMessageID: c.FirstMessageID,
+			LastMessageID:  c.LastMessageID,
+			StartDate:      c.StartDate,
+			EndDate:        c.EndDate,
+			MessageCount:   c.MessageCount,
+			Score:          c.Score,
+		})
+	}
+}
+
+// loadResults returns the message matches from start to end with their
+// current content.
+func (sess *searchSession) loadResults(start, end int) ([]database.SemanticSearchResult, error) {
+	var results []database.SemanticSearchResult
+	for _, r := range sess.Results[start:end] {
+		results = append(results, database.SemanticSearchResult{
+			MessageID:  r.MessageID,
+			Score:      r.Score,
+			MatchStart: r.MatchStart,
+			MatchEnd:   r.MatchEnd,
+		})
+	}
+	return database.FillSearchResults(results)
+}
+
+// loadConversations returns the conversation matches from start to end with
+// their messages.
+func (sess *searchSession) loadConversations(start, end int) ([]database.ConversationSearchResult, error) {
+	var conversations []database.ConversationSearchResult
+	for _, c := range sess.Conversations[start:end] {
+		messages, err := database.GetConversationMessages(c.ChannelID, c.StartDate, c.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, database.ConversationSearchResult{
+			ConversationWindow: database.ConversationWindow{
+				GuildID:        sess.GuildID,
+				ChannelID:      c.ChannelID,
+				FirstMessageID: c.FirstMessageID,
+				LastMessageID:  c.LastMessageID,
+				StartDate:      c.StartDate,
+				EndDate:        c.EndDate,
+				MessageCount:   c.MessageCount,
+			},
+			Score:    c.Score,
+			Messages: messages,
+		})
+	}
+	return conversations, nil
 }
 
 func (s SemanticCommand) Handler(event *events.ApplicationCommandInteractionCreate) {
@@ -75,80 +175,119 @@ func (s SemanticCommand) Handler(event *events.ApplicationCommandInteractionCrea
 	if poolSize > maxPoolSize {
 		poolSize = maxPoolSize
 	}
+	scope := "messages"
+	if opt, ok := sub.Options["scope"]; ok {
+		scope = opt.String()
+	}
 
-	// Embed the query with the same model used for stored messages.
-	vec, err := embeddings.Embed(query)
+	// Embed the query with the model the guild's stored messages are searched
+	// with, which lags behind the configured one while a migration runs.
+	model, err := database.ServingEmbeddingModel(event.GuildID().String())
+	if err != nil {
+		slog.Error("semantic model lookup error", slog.Any("err", err))
+		s.editError(event, "error happened while embedding the query")
+		return
+	}
+	vec, err := embeddings.EmbedWith(model, query)
 	if err != nil {
 		slog.Error("semantic embedding error", slog.Any("err", err))
 		s.editError(event, "error happened while embedding the query")
 		return
 	}
 
-	results, err := database.SearchSimilarMessages(event.GuildID().String(), vec, embeddings.ModelName(), poolSize)
+	sess := &searchSession{
+		Query:   query,
+		GuildID: event.GuildID().String(),
+		Scope:   scope,
+		Model:   model,
+		Limit:   poolSize,
+	}
+	if scope == "conversations" {
+		var conversations []database.ConversationSearchResult
+		conversations, err = database.SearchSimilarConversations(sess.GuildID, vec, model, poolSize)
+		sess.addConversations(conversations...)
+	} else {
+		var results []database.SemanticSearchResult
+		results, err = database.SearchSimilarMessages(sess.GuildID, vec, model, poolSize)
+		sess.addResults(results...)
+	}
 	if err != nil {
 		slog.Error("semantic search error", slog.Any("err", err))
 		s.editError(event, "error happened while searching for messages")
 		return
 	}
 
-	if len(results) == 0 {
-		s.editError(event, "no matching messages found (has the history been embedded yet?)")
+	if sess.len() == 0 {
+		if scope == "conversations" {
+			s.editError(event, "no matching conversations found (have the conversation windows been built yet?)")
+		} else {
+			s.editError(event, "no matching messages found (has the history been embedded yet?)")
+		}
 		return
 	}
 
 	token := uuid.New().String()
-	sess := &searchSession{
-		query:   query,
-		guildID: event.GuildID().String(),
-		results: results,
-		created: time.Now(),
+	if err := database.SaveSession(token, s.Name, sess, sessionTTL); err != nil {
+		slog.Error("semantic session error", slog.Any("err", err))
+		s.editError(event, "error happened while saving the search")
+		return
 	}
-	storeSession(token, sess)
 
-	embed, components := renderResults(token, sess, 1)
+	embed, components, err := renderResults(token, sess, 1)
+	if err != nil {
+		slog.Error("semantic render error", slog.Any("err", err))
+		s.editError(event, "error happened while loading the matches")
+		return
+	}
 	updateResponse(event.Client().Rest, event.ApplicationID(), event.Token(), embed, components)
 }
 
-// ComponentHandler drives the pagination buttons. Custom ID format:
-// semantic_page_<token>_<page>
+// ComponentHandler drives the pagination and context buttons. Custom ID
+// formats: semantic_page_<token>_<page> and semantic_context_<message id>
 func (s SemanticCommand) ComponentHandler(event *events.ComponentInteractionCreate) {
-	if err := event.DeferUpdateMessage(); err != nil {
-		slog.Error("Error deferring: ", slog.Any("err", err))
+	parts := strings.SplitN(event.Data.CustomID(), "_", 4)
+	if len(parts) == 3 && parts[1] == "context" {
+		s.contextHandler(event, parts[2])
 		return
 	}
-
-	parts := strings.SplitN(event.Data.CustomID(), "_", 4)
 	if len(parts) < 4 || parts[1] != "page" {
 		return
 	}
+	if err := event.DeferUpdateMessage(); err != nil {
+		slog.Error("Error deferring: ", slog.Any("err", err))
+		return
+	}
 	token := parts[2]
 	page, err := strconv.Atoi(parts[3])
 	if err != nil {
 		return
 	}
 
-	sess, ok := getSession(token)
-	if !ok {
-		expired := "this search has expired, please run `/semantic` again"
-		_, err := event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
-			Content:    &expired,
-			Embeds:     &[]discord.Embed{},
-			Components: &[]discord.LayoutComponent{},
-		})
-		if err != nil {
-			slog.Error("Error editing the response:", slog.Any("err", err))
-		}
+	var sess searchSession
+	err = database.LoadSession(token, s.Name, &sess)
+	if errors.Is(err, database.ErrSessionExpired) {
+		s.replaceResults(event, "this search has expired, please run `/semantic` again")
+		return
+	}
+	if err != nil {
+		slog.Error("semantic session error", slog.Any("err", err))
+		s.replaceResults(event, "error happened while loading the search")
 		return
 	}
 
-	embed, components := renderResults(token, sess, page)
+	embed, components, err := renderResults(token, &sess, page)
+	if err != nil {
+		slog.Error("semantic render error", slog.Any("err", err))
+		s.replaceResults(event, "error happened while loading the matches")
+		return
+	}
 	updateResponse(event.Client().Rest, event.ApplicationID(), event.Token(), embed, components)
 }
 
 // renderResults builds the embed and pagination buttons for a single page of a
-// cached search. Pages are 1-indexed and clamped to the valid range.
-func renderResults(token string, sess *searchSession, page int) (discord.Embed, []discord.LayoutComponent) {
-	totalPages := (len(sess.results) + resultsPerPage - 1) / resultsPerPage
+// stored search. Pages are 1-indexed and clamped to the valid range.
+func renderResults(token string, sess *searchSession, page int) (discord.Embed, []discord.LayoutComponent, error) {
+	totalPages := (sess.len() + resultsPerPage - 1) / resultsPerPage
 	if totalPages == 0 {
 		totalPages = 1
 	}
@@ -161,32 +300,36 @@ func renderResults(token string, sess *searchSession, page int) (discord.Embed,
 
 	start := (page - 1) * resultsPerPage
 	end := start + resultsPerPage
-	if end > len(sess.results) {
-		end = len(sess.results)
+	if end > sess.len() {
+		end = sess.len()
 	}
 
+	var components []discord.LayoutComponent
 	embed := discord.Embed{
-		Title: fmt.Sprintf("Semantic search: %q", sess.query),
+		Title: fmt.Sprintf("Semantic search: %q", sess.Query),
 		Footer: &discord.EmbedFooter{
-			Text: fmt.Sprintf("Page %d/%d — %d results", page, totalPages, len(sess.results)),
+			Text: fmt.Sprintf("Page %d/%d — %d results", page, totalPages, sess.len()),
 		},
 	}
-	for _, r := range sess.results[start:end] {
-		link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", sess.guildID, r.ChannelID, r.MessageID)
-
-		author := r.AuthorID
-		if id, parseErr := snowflake.Parse(r.AuthorID); parseErr == nil {
-			author = discord.UserMention(id)
+	if len(sess.Conversations) > 0 {
+		conversations, err := sess.loadConversations(start, end)
+		if err != nil {
+			return discord.Embed{}, nil, err
+		}
+		embed.Fields = conversationFields(sess.GuildID, conversations)
+	} else {
+		results, err := sess.loadResults(start, end)
+		if err != nil {
+			return discord.Embed{}, nil, err
+		}
+		embed.Fields = messageFields(sess.GuildID, results)
+		// Without a token the results answer a message command, whose
+		// buttons would not be routed back here.
+		if token != "" && len(results) > 0 {
+			components = append(components, contextButtons(results))
 		}
-
-		embed.Fields = append(embed.Fields, discord.EmbedField{
-			Name: fmt.Sprintf("<t:%d>", r.Date.UTC().Unix()),
-			Value: fmt.Sprintf("%s\n%s — [jump](%s)",
-				truncate(r.Content, maxContentLength), author, link),
-		})
 	}
 
-	var components []discord.LayoutComponent
 	if totalPages > 1 {
 		components = append(components, discord.ActionRowComponent{
 			Components: []discord.InteractiveComponent{
@@ -205,7 +348,7 @@ func renderResults(token string, sess *searchSession, page int) (discord.Embed,
 			},
 		})
 	}
-	return embed, components
+	return embed, components, nil
 }
 
 // updateResponse edits the interaction's original response with the given embed
@@ -231,6 +374,19 @@ func (s SemanticCommand) editError(event *events.ApplicationCommandInteractionCr
 	}
 }
 
+// replaceResults replaces a page of results with a plain message, dropping
+// its pagination buttons.
+func (s SemanticCommand) replaceResults(event *events.ComponentInteractionCreate, msg string) {
+	_, err := event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
+		Content:    &msg,
+		Embeds:     &[]discord.Embed{},
+		Components: &[]discord.LayoutComponent{},
+	})
+	if err != nil {
+		slog.Error("Error editing the response:", slog.Any("err", err))
+	}
+}
+
 func (s SemanticCommand) CreateCommandArguments() []discord.ApplicationCommandOption {
 	return []discord.ApplicationCommandOption{
 		discord.ApplicationCommandOptionString{
@@ -243,41 +399,100 @@ func (s SemanticCommand) CreateCommandArguments() []discord.ApplicationCommandOp
 			Description: fmt.Sprintf("how many matches to fetch and page through (max %d)", maxPoolSize),
 			Required:    false,
 		},
+		discord.ApplicationCommandOptionString{
+			Name:        "scope",
+			Description: "search single messages or whole conversations",
+			Required:    false,
+			Choices: []discord.ApplicationCommandOptionChoiceString{
+				{Name: "messages", Value: "messages"},
+				{Name: "conversations", Value: "conversations"},
+			},
+		},
 	}
 }
 
-// storeSession caches a search's results and prunes any expired ones.
-func storeSession(token string, sess *searchSession) {
-	sessionsMu.Lock()
-	defer sessionsMu.Unlock()
-	pruneLocked()
-	sessions[token] = sess
+// truncate shortens s to at most n runes, appending an ellipsis when cut.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
 }
 
-// getSession returns a cached search by token, pruning expired ones first.
-func getSession(token string) (*searchSession, bool) {
-	sessionsMu.Lock()
-	defer sessionsMu.Unlock()
-	pruneLocked()
-	sess, ok := sessions[token]
-	return sess, ok
+// excerpt renders a result's content for the embed with the chunk that matched
+// in bold. A long message is cut down to that chunk, so the part that matched
+// is what is shown.
+func excerpt(r database.SemanticSearchResult) string {
+	content := strings.TrimSpace(r.Content)
+	start, end := r.MatchStart, r.MatchEnd
+	// Offsets outside the content mean it changed since it was embedded.
+	if end <= start || end > len(content) || !utf8.RuneStart(content[start]) || (end < len(content) && !utf8.RuneStart(content[end])) {
+		return truncate(content, maxContentLength)
+	}
+
+	before, match, after := content[:start], strings.TrimSpace(content[start:end]), content[end:]
+	if utf8.RuneCountInString(content) <= maxContentLength {
+		return before + "**" + match + "**" + after
+	}
+
+	out := "**" + truncate(match, maxContentLength) + "**"
+	if before != "" {
+		out = "…" + out
+	}
+	if after != "" && !strings.HasSuffix(out, "…**") {
+		out += "…"
+	}
+	return out
 }
 
-// pruneLocked drops sessions older than sessionTTL. Callers must hold sessionsMu.
-func pruneLocked() {
-	cutoff := time.Now().Add(-sessionTTL)
-	for k, v := range sessions {
-		if v.created.Before(cutoff) {
-			delete(sessions, k)
+// conversationFields renders each matched conversation as its transcript,
+// with the channel and a link to where the conversation starts.
+func conversationFields(guildID string, conversations []database.ConversationSearchResult) []discord.EmbedField {
+	var fields []discord.EmbedField
+	for _, c := range conversations {
+		link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, c.ChannelID, c.FirstMessageID)
+
+		var transcript strings.Builder
+		for _, m := range c.Messages {
+			author := m.Author
+			if id, parseErr := snowflake.Parse(m.Author); parseErr == nil {
+				author = discord.UserMention(id)
+			}
+			transcript.WriteString(fmt.Sprintf("%s: %s\n", author, truncate(strings.TrimSpace(m.Content), 120)))
 		}
+
+		channel := c.ChannelID
+		if id, parseErr := snowflake.Parse(c.ChannelID); parseErr == nil {
+			channel = discord.ChannelMention(id)
+		}
+
+		fields = append(fields, discord.EmbedField{
+			Name: fmt.Sprintf("<t:%d> – <t:%d:t> · %d messages", c.StartDate.UTC().Unix(), c.EndDate.UTC().Unix(), c.MessageCount),
+			Value: fmt.Sprintf("%s\n%s — [jump to start](%s)",
+				truncate(strings.TrimSpace(transcript.String()), maxTranscriptLength), channel, link),
+		})
 	}
+	return fields
 }
 
-// truncate shortens s to at most n runes, appending an ellipsis when cut.
-func truncate(s string, n int) string {
-	runes := []rune(s)
-	if len(runes) <= n {
-		return s
+// messageFields renders each matched message with its author and a link to
+// it, numbered like the context buttons.
+func messageFields(guildID string, results []database.SemanticSearchResult) []discord.EmbedField {
+	var fields []discord.EmbedField
+	for i, r := range results {
+		link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, r.ChannelID, r.MessageID)
+
+		author := r.AuthorID
+		if id, parseErr := snowflake.Parse(r.AuthorID); parseErr == nil {
+			author = discord.UserMention(id)
+		}
+
+		fields = append(fields, discord.EmbedField{
+			Name: fmt.Sprintf("%d. <t:%d>", i+1, r.Date.UTC().Unix()),
+			Value: fmt.Sprintf("%s\n%s — [jump](%s)",
+				excerpt(r), author, link),
+		})
 	}
-	return string(runes[:n]) + "…"
+	return fields
 }
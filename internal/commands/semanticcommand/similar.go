@@ -0,0 +1,94 @@
+package semanticcommand
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/disgoorg/disgo/events"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/embeddings"
+)
+
+var SimilarCmd = SimilarCommand{
+	Name: "Find similar messages",
+}
+
+// SimilarCommand is the message context menu command listing the messages
+// nearest to the one it was used on, e.g. to find where a question was
+// already answered.
+type SimilarCommand struct {
+	Name string
+}
+
+// Handler searches with the target message's stored embedding, or embeds it
+// on the spot when it has not been embedded yet, and answers privately with
+// a single page of matches.
+func (s SimilarCommand) Handler(event *events.ApplicationCommandInteractionCreate) {
+	if err := event.DeferCreateMessage(true); err != nil {
+		slog.Error("Error deferring: ", slog.Any("err", err))
+		return
+	}
+
+	target := event.MessageCommandInteractionData().TargetMessage()
+	guildID := event.GuildID().String()
+	content := strings.TrimSpace(target.Content)
+	if content == "" {
+		SemanticCmd.editError(event, "this message has no text to compare")
+		return
+	}
+
+	model, err := database.ServingEmbeddingModel(guildID)
+	if err != nil {
+		slog.Error("similar model lookup error", slog.Any("err", err))
+		SemanticCmd.editError(event, "error happened while embedding the message")
+		return
+	}
+	vec, err := database.GetMessageEmbedding(target.ID.String(), model)
+	if errors.Is(err, sql.ErrNoRows) {
+		vec, err = embeddings.EmbedWith(model, content)
+	}
+	if err != nil {
+		slog.Error("similar embedding error", slog.Any("err", err))
+		SemanticCmd.editError(event, "error happened while embedding the message")
+		return
+	}
+
+	// One extra match, since the message itself usually comes back first.
+	matches, err := database.SearchSimilarMessages(guildID, vec, model, resultsPerPage+1)
+	if err != nil {
+		slog.Error("similar search error", slog.Any("err", err))
+		SemanticCmd.editError(event, "error happened while searching for messages")
+		return
+	}
+
+	sess := &searchSession{
+		Query:   content,
+		GuildID: guildID,
+		Scope:   "messages",
+		Model:   model,
+		Limit:   resultsPerPage,
+	}
+	for _, m := range matches {
+		if m.MessageID != target.ID.String() && sess.len() < resultsPerPage {
+			sess.addResults(m)
+		}
+	}
+	if sess.len() == 0 {
+		SemanticCmd.editError(event, "no similar messages found (has the history been embedded yet?)")
+		return
+	}
+
+	// A single page has no buttons, so the session is not stored.
+	embed, components, err := renderResults("", sess, 1)
+	if err != nil {
+		slog.Error("similar render error", slog.Any("err", err))
+		SemanticCmd.editError(event, "error happened while loading the matches")
+		return
+	}
+	embed.Title = "Similar messages"
+	embed.URL = target.JumpURL()
+	embed.Description = "> " + truncate(content, maxContentLength)
+	updateResponse(event.Client().Rest, event.ApplicationID(), event.Token(), embed, components)
+}
@@ -0,0 +1,332 @@
+package summarizecommand
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/util"
+	"github.com/stollenaar/statisticsbot/internal/util/charts"
+)
+
+const (
+	// maxDigestChannels bounds how many channels get their own LLM summary, so a
+	// digest costs at most this many generations and stays inside Discord's
+	// per-message text limit. Quieter channels still count towards the stats.
+	maxDigestChannels = 5
+	// maxDigestChannelLength caps each channel's rendered topics; components v2
+	// messages share a 4000 character budget across every text display.
+	maxDigestChannelLength = 600
+	maxDigestParticipants  = 5
+)
+
+var (
+	digestMessages = `
+	SELECT m.channel_id, m.author_id, m.content
+	FROM messages m
+	JOIN (
+		SELECT id, MAX(version) AS latest_version
+		FROM messages
+		WHERE guild_id = ?
+		AND date BETWEEN ? AND ?
+		GROUP BY id
+	) sub ON m.id = sub.id AND m.version = sub.latest_version
+	%s
+	ORDER BY m.date;
+	`
+)
+
+// channelDigest is the summary of a single channel inside a digest.
+type channelDigest struct {
+	ChannelID string
+	Messages  int
+	Summaries []util.SummaryResponseBody
+	Err       error
+}
+
+// digestHandler summarizes every channel the invoker can read (or the ones
+// given in the channel options) over the requested period, grouping topics per
+// channel and adding the message-volume and participant stats from the chart
+// queries.
+func (s SummarizeCommand) digestHandler(event *events.ApplicationCommandInteractionCreate, sub discord.SlashCommandInteractionData) {
+	unit, err := parseTimeArg(sub.Options["unit"].String(), maxDigestDays)
+	if err != nil {
+		util.EditError(event, err.Error())
+		return
+	}
+
+	channelIDs := digestChannels(event, sub)
+	if len(channelIDs) == 0 {
+		util.EditError(event, "you can't read any of those channels")
+		return
+	}
+
+	now := time.Now()
+	start := now.Add(-unit)
+
+	byChannel, err := fetchDigestMessages(event.Client(), *event.GuildID(), channelIDs, start, now)
+	if err != nil {
+		slog.Error("digest duckDB error", slog.Any("err", err))
+		util.EditError(event, "error happened while trying to fetch the messages")
+		return
+	}
+	if len(byChannel) == 0 {
+		util.EditError(event, "no messages found in that period")
+		return
+	}
+
+	volume, err := digestStats(event.Client(), event.GuildID().String(), channelIDs, charts.MetricType{Category: "single", Metric: "channel"}, start, now)
+	if err != nil {
+		slog.Warn("Failed to fetch digest channel volume", slog.Any("err", err))
+	}
+	participants, err := digestStats(event.Client(), event.GuildID().String(), channelIDs, charts.MetricType{Category: "single", Metric: "user"}, start, now)
+	if err != nil {
+		slog.Warn("Failed to fetch digest participants", slog.Any("err", err))
+	}
+
+	// Most active channels first; only those get summarized.
+	ordered := make([]string, 0, len(byChannel))
+	for channelID := range byChannel {
+		ordered = append(ordered, channelID)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return len(byChannel[ordered[i]]) > len(byChannel[ordered[j]])
+	})
+	if len(ordered) > maxDigestChannels {
+		ordered = ordered[:maxDigestChannels]
+	}
+
+	var digests []channelDigest
+	for i, channelID := range ordered {
+		messages := byChannel[channelID]
+		digest := channelDigest{ChannelID: channelID, Messages: len(messages)}
+
+		// Every channel is saved as its own invocation so a failed one can be
+		// retried from the admin summary view like a regular /summarize.
+		inv := NewInvocation(event.GuildID().String(), channelID, sub.Options["unit"].String(), messages)
+		id, _ := snowflake.Parse(channelID)
+		editor := util.NewStreamEditor(event, func(partial string) string {
+			return fmt.Sprintf("-# Channel %d of %d: %s\n%s", i+1, len(ordered), discord.ChannelMention(id), previewSummary(partial))
+		})
+		summaries, err := GetSummaryStream(inv, messages, editor)
+		editor.Close()
+		if err != nil {
+			slog.Error("digest summarize error", slog.String("channel", channelID), slog.Any("err", err))
+			digest.Err = err
+		} else {
+			digest.Summaries = summaries.Summaries
+		}
+		digests = append(digests, digest)
+	}
+
+	components := digestComponents(sub.Options["unit"].String(), byChannel, volume, participants, digests)
+	// Components v2 messages can't carry content, so the streamed preview is
+	// cleared.
+	_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
+		Content:         util.Pointer(""),
+		Flags:           util.Pointer(discord.MessageFlagIsComponentsV2),
+		Components:      &components,
+		AllowedMentions: &discord.AllowedMentions{},
+	})
+	if err != nil {
+		slog.Error("Error editing the response:", slog.Any("err", err))
+	}
+}
+
+// digestChannels returns the channels to digest: the ones picked in the
+// channel options, or else every channel in the guild, keeping only those the
+// invoker can read so private channels never end up in the summaries.
+func digestChannels(event *events.ApplicationCommandInteractionCreate, sub discord.SlashCommandInteractionData) (ids []string) {
+	picked := false
+	for _, name := range digestChannelOptions() {
+		channel, ok := sub.OptChannel(name)
+		if !ok {
+			continue
+		}
+		picked = true
+		// The resolved permissions are the invoker's in that channel.
+		if channel.Permissions.Has(discord.PermissionViewChannel) && !util.Contains(ids, channel.ID.String()) {
+			ids = append(ids, channel.ID.String())
+		}
+	}
+	if picked {
+		return ids
+	}
+	return util.VisibleChannels(event.Client(), event.Member().Member)
+}
+
+// digestChannelOptions names the channel options, one per channel that can
+// get its own summary.
+func digestChannelOptions() []string {
+	names := []string{"channel"}
+	for i := 2; i <= maxDigestChannels; i++ {
+		names = append(names, fmt.Sprintf("channel%d", i))
+	}
+	return names
+}
+
+// fetchDigestMessages returns the latest version of every message in the
+// period, keyed by channel id. An empty channelIDs means the whole guild.
+func fetchDigestMessages(client *bot.Client, guildID snowflake.ID, channelIDs []string, start, end time.Time) (map[string][]util.SummaryBody, error) {
+	params := []interface{}{guildID.String(), start, end}
+	filter := ""
+	if len(channelIDs) > 0 {
+		placeholders := make([]string, len(channelIDs))
+		for i, id := range channelIDs {
+			placeholders[i] = "?"
+			params = append(params, id)
+		}
+		filter = fmt.Sprintf("WHERE m.channel_id IN (%s)", strings.Join(placeholders, ", "))
+	}
+
+	rs, err := database.QueryDuckDB(fmt.Sprintf(digestMessages, filter), params)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	byChannel := make(map[string][]util.SummaryBody)
+	for rs.Next() {
+		var channelID, authorID, content string
+		if err := rs.Scan(&channelID, &authorID, &content); err != nil {
+			return nil, err
+		}
+		byChannel[channelID] = append(byChannel[channelID], util.SummaryBody{
+			Author:  nickname(client, guildID, authorID),
+			Message: content,
+		})
+	}
+	return byChannel, rs.Err()
+}
+
+// digestStats runs the message-count chart query for the period with the given
+// grouping, so the digest numbers match what /plot would draw.
+func digestStats(client *bot.Client, guildID string, channelIDs []string, groupBy charts.MetricType, start, end time.Time) ([]*charts.ChartData, error) {
+	tracker := charts.ChartTracker{
+		GuildID:   guildID,
+		Metric:    charts.MetricType{Category: "message", Metric: "count"},
+		GroupBy:   groupBy,
+		Channels:  channelIDs,
+		DateRange: "custom",
+		CustomDateRange: charts.DateRange{
+			Start: &start,
+			End:   &end,
+		},
+	}
+	return tracker.Data(client)
+}
+
+func digestComponents(unit string, byChannel map[string][]util.SummaryBody, volume, participants []*charts.ChartData, digests []channelDigest) []discord.LayoutComponent {
+	total := 0
+	for _, messages := range byChannel {
+		total += len(messages)
+	}
+
+	header := []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{
+			Content: fmt.Sprintf("# Digest of the past %s\n%d messages across %d channel%s", unit, total, len(byChannel), plural(len(byChannel))),
+		},
+	}
+
+	if len(volume) > 0 {
+		lines := []string{"**Message volume**"}
+		for _, d := range volume {
+			lines = append(lines, fmt.Sprintf("- %s — %.0f", channelLabel(d), d.Value))
+		}
+		header = append(header, discord.TextDisplayComponent{Content: strings.Join(lines, "\n")})
+	}
+
+	if len(participants) > 0 {
+		lines := []string{"**Most active participants**"}
+		for i, d := range participants {
+			if i == maxDigestParticipants {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("%d. %s — %.0f messages", i+1, participantLabel(d), d.Value))
+		}
+		header = append(header, discord.TextDisplayComponent{Content: strings.Join(lines, "\n")})
+	}
+
+	body := []discord.ContainerSubComponent{}
+	for _, digest := range digests {
+		id, _ := snowflake.Parse(digest.ChannelID)
+		lines := []string{fmt.Sprintf("### %s (%d messages)", discord.ChannelMention(id), digest.Messages)}
+		switch {
+		case digest.Err != nil:
+			lines = append(lines, "_Failed to summarize this channel._")
+		case len(digest.Summaries) == 0:
+			lines = append(lines, "_Nothing worth summarizing._")
+		default:
+			for _, summary := range digest.Summaries {
+				lines = append(lines, fmt.Sprintf("**%s** — %s", summary.Topic, summary.Summary))
+			}
+		}
+		body = append(body, discord.SeparatorComponent{}, discord.TextDisplayComponent{
+			Content: util.Truncate(strings.Join(lines, "\n"), maxDigestChannelLength),
+		})
+	}
+	if skipped := len(byChannel) - len(digests); skipped > 0 {
+		body = append(body, discord.SeparatorComponent{}, discord.TextDisplayComponent{
+			Content: fmt.Sprintf("_…and %d quieter channel%s not summarized._", skipped, plural(skipped)),
+		})
+	}
+
+	return []discord.LayoutComponent{
+		discord.ContainerComponent{Components: header},
+		discord.ContainerComponent{Components: body},
+	}
+}
+
+// nickname returns the member's guild nickname, falling back to their id so
+// GetSummary can turn it back into a mention.
+func nickname(client *bot.Client, guildID snowflake.ID, authorID string) string {
+	id, err := snowflake.Parse(authorID)
+	if err != nil {
+		return authorID
+	}
+	member, ok := client.Caches.Member(guildID, id)
+	if !ok || member.Nick == nil {
+		return authorID
+	}
+	return *member.Nick
+}
+
+func channelLabel(d *charts.ChartData) string {
+	if id, err := snowflake.Parse(d.Xaxes); err == nil {
+		return discord.ChannelMention(id)
+	}
+	return d.XLabel
+}
+
+// participantLabel mentions the member a chart row counts, falling back to
+// the name the chart resolved.
+func participantLabel(d *charts.ChartData) string {
+	if id, err := snowflake.Parse(d.Xaxes); err == nil {
+		return discord.UserMention(id)
+	}
+	return d.XLabel
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// ChannelMessages returns the messages of a single channel in the period, with
+// authors resolved the same way /summarize does, ready for GetSummary.
+func ChannelMessages(client *bot.Client, guildID snowflake.ID, channelID string, start, end time.Time) ([]util.SummaryBody, error) {
+	byChannel, err := fetchDigestMessages(client, guildID, []string{channelID}, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return byChannel[channelID], nil
+}
@@ -0,0 +1,155 @@
+package summarizecommand
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/llm"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// summarySchema is both sent to the model and used to check its reply.
+var summarySchema = &llm.Schema{
+	Type: "object",
+	Properties: map[string]*llm.Schema{
+		"messages": {
+			Type:     "array",
+			MinItems: util.Pointer(1),
+			MaxItems: util.Pointer(20),
+			Items: &llm.Schema{
+				Type: "object",
+				Properties: map[string]*llm.Schema{
+					"topic":   {Type: "string", MinLength: util.Pointer(1)},
+					"summary": {Type: "string", MinLength: util.Pointer(1)},
+				},
+				Required: []string{"topic", "summary"},
+			},
+		},
+	},
+	Required: []string{"messages"},
+}
+
+// NewInvocation saves the messages as a pending summary invocation, so the
+// summary can be retried from the admin command if it fails.
+func NewInvocation(guildID, channelID, unit string, messages []util.SummaryBody) database.SummaryInvocation {
+	messagesJSON, _ := json.Marshal(messages)
+	inv := database.SummaryInvocation{
+		ID:           uuid.New().String(),
+		GuildID:      guildID,
+		ChannelID:    channelID,
+		Unit:         unit,
+		MessagesJSON: string(messagesJSON),
+		Status:       "pending",
+	}
+	if err := database.SaveSummaryInvocation(inv.ID, guildID, channelID, unit, inv.MessagesJSON); err != nil {
+		slog.Warn("Failed to save summary invocation", slog.Any("err", err))
+	}
+	return inv
+}
+
+// GetSummary summarizes the messages of an invocation. Replies that do not
+// match summarySchema are sent back to the model to be repaired, and every
+// attempt is recorded: the first completes a pending invocation, the others
+// are saved as retries beneath the original attempt.
+func GetSummary(inv database.SummaryInvocation, messages []util.SummaryBody) (util.SummaryResponse, error) {
+	return GetSummaryStream(inv, messages, nil)
+}
+
+// GetSummaryStream is GetSummary with progress: every attempt's reply is
+// streamed into the editor while it is generated.
+func GetSummaryStream(inv database.SummaryInvocation, messages []util.SummaryBody, editor *util.StreamEditor) (out util.SummaryResponse, err error) {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return util.SummaryResponse{}, err
+	}
+	if util.ConfigFile.DEBUG {
+		d, _ := json.MarshalIndent(messages, "", "    ")
+		os.WriteFile("summary.json", d, 0644)
+	}
+	prompt := fmt.Sprintf(
+		"You are an AI that outputs valid JSON only.\n"+
+			"Summarize and group the following Discord messages by topic.\n"+
+			"Use the exact author names provided in the input.\n"+
+			"Return a JSON object with a \"messages\" array where each element has a \"topic\" and \"summary\" field.\n\n"+
+			"Input:\n%s",
+		string(data))
+
+	opts := llm.Structured{
+		OnAttempt: func(attempt llm.Attempt) {
+			slog.Debug("Raw response for summarize", slog.Int("attempt", attempt.Number), slog.String("rawResponse", attempt.Raw))
+			recordAttempt(inv, attempt)
+		},
+	}
+	if editor != nil {
+		opts.OnDelta = editor.Write
+		onAttempt := opts.OnAttempt
+		opts.OnAttempt = func(attempt llm.Attempt) {
+			onAttempt(attempt)
+			if attempt.Err != nil {
+				editor.Reset()
+			}
+		}
+	}
+
+	out, err = llm.Generate[util.SummaryResponse](context.Background(), llm.Request{
+		Temperature:      0.2,
+		FrequencyPenalty: 1.8,
+		PresencePenalty:  1.2,
+		MaxTokens:        len(data) + 1000,
+		Messages:         []llm.Message{{Role: "user", Content: prompt}},
+		Schema:           summarySchema,
+	}, opts)
+	if err != nil {
+		return util.SummaryResponse{}, err
+	}
+
+	// Members without a nickname are passed to the model by ID, so any that come
+	// back have to be turned into mentions before they reach Discord.
+	for i, summary := range out.Summaries {
+		out.Summaries[i].Topic = util.MentionifyIDs(summary.Topic)
+		out.Summaries[i].Summary = util.MentionifyIDs(summary.Summary)
+	}
+	return out, nil
+}
+
+// recordAttempt stores an attempt in summary_invocations. Only the first
+// attempt of a fresh invocation goes on the invocation itself; repairs and
+// attempts for an invocation that already ran (an admin retry) become retries
+// grouped under the original.
+func recordAttempt(inv database.SummaryInvocation, attempt llm.Attempt) {
+	status := attemptStatus(attempt.Err)
+
+	if attempt.Number == 1 && inv.Status == "pending" {
+		if err := database.UpdateSummaryInvocation(inv.ID, attempt.Raw, status); err != nil {
+			slog.Warn("Failed to update summary invocation", slog.Any("err", err))
+		}
+		return
+	}
+
+	parentID := inv.ID
+	if inv.ParentID != "" {
+		parentID = inv.ParentID
+	}
+	if err := database.SaveSummaryRetry(uuid.New().String(), parentID, inv.GuildID, inv.ChannelID, inv.Unit, inv.MessagesJSON, attempt.Raw, status); err != nil {
+		slog.Warn("Failed to save summary retry", slog.Any("err", err))
+	}
+}
+
+func attemptStatus(err error) string {
+	var validationErr *llm.ValidationError
+	var decodeErr *llm.DecodeError
+	switch {
+	case err == nil:
+		return "success"
+	case errors.As(err, &validationErr), errors.As(err, &decodeErr):
+		return "invalid"
+	default:
+		return "failed"
+	}
+}
@@ -1,10 +1,8 @@
 package summarizecommand
 
 import (
-	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os"
 	"regexp"
 	"strconv"
 	"time"
@@ -12,8 +10,6 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
-	"github.com/disgoorg/snowflake/v2"
-	"github.com/google/uuid"
 	"github.com/stollenaar/statisticsbot/internal/database"
 	"github.com/stollenaar/statisticsbot/internal/util"
 )
@@ -35,6 +31,16 @@ var (
 		GROUP BY id
 	) sub ON m.id = sub.id AND m.version = sub.latest_version;
 	`
+	// previewSummary renders the topics generated so far while a summary is
+	// streaming in.
+	previewSummary = util.PreviewTopics("Summarizing…", "summary")
+)
+
+const (
+	// maxSummaryDays bounds a single-channel summary; maxDigestDays is larger
+	// because a digest is expected to cover a quieter, longer stretch.
+	maxSummaryDays = 1
+	maxDigestDays  = 7
 )
 
 type SummarizeCommand struct {
@@ -60,7 +66,12 @@ func (s SummarizeCommand) Handler(event *events.ApplicationCommandInteractionCre
 
 	sub := event.SlashCommandInteractionData()
 
-	unit, err := parseTimeArg(sub.Options["unit"].String())
+	if sub.Bool("digest") {
+		s.digestHandler(event, sub)
+		return
+	}
+
+	unit, err := parseTimeArg(sub.Options["unit"].String(), maxSummaryDays)
 	if err != nil {
 		eString := err.Error()
 		_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
@@ -104,31 +115,20 @@ func (s SummarizeCommand) Handler(event *events.ApplicationCommandInteractionCre
 			}
 			return
 		}
-		var nickname string
-
-		member, _ := event.Client().Caches.Member(*event.GuildID(), snowflake.MustParse(author_id))
-		if member.Nick == nil {
-			nickname = author_id
-		} else {
-			nickname = *member.Nick
-		}
 		messages = append(messages, util.SummaryBody{
-			Author:  nickname,
+			Author:  nickname(event.Client(), *event.GuildID(), author_id),
 			Message: content,
 		})
 	}
 
 	// Save the invocation so it can be retried if the summary fails
-	messagesJSON, _ := json.Marshal(messages)
-	invID := uuid.New().String()
-	if saveErr := database.SaveSummaryInvocation(invID, event.GuildID().String(), event.Channel().ID().String(), sub.Options["unit"].String(), string(messagesJSON)); saveErr != nil {
-		slog.Warn("Failed to save summary invocation", slog.Any("err", saveErr))
-	}
+	inv := NewInvocation(event.GuildID().String(), event.Channel().ID().String(), sub.Options["unit"].String(), messages)
 
-	// Get and create the summary
-	summaries, rawResponse, err := GetSummary(messages)
+	// Get and create the summary, showing the topics as they are generated
+	editor := util.NewStreamEditor(event, previewSummary)
+	summaries, err := GetSummaryStream(inv, messages, editor)
+	editor.Close()
 	if err != nil {
-		database.UpdateSummaryInvocation(invID, rawResponse, "failed")
 		eString := "error happened while trying to generate the summaries"
 		slog.Error("summarize error", slog.Any("err", err))
 		_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
@@ -139,18 +139,6 @@ func (s SummarizeCommand) Handler(event *events.ApplicationCommandInteractionCre
 		}
 		return
 	}
-	if len(summaries.Summaries) == 0 {
-		database.UpdateSummaryInvocation(invID, rawResponse, "empty")
-		eString := "summarize returned empty, clanker likely had an oopsie"
-		_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
-			Content: &eString,
-		})
-		if err != nil {
-			slog.Error("Error editing the response:", slog.Any("err", err))
-		}
-		return
-	}
-	database.UpdateSummaryInvocation(invID, rawResponse, "success")
 
 	embed := discord.Embed{
 		Title: fmt.Sprintf("Summary of the past %s", sub.Options["unit"].String()),
@@ -162,8 +150,10 @@ func (s SummarizeCommand) Handler(event *events.ApplicationCommandInteractionCre
 			Value: summary.Summary,
 		})
 	}
+	// Clear the streamed preview now that the embed holds the result
 	message, err := event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
-		Embeds: &[]discord.Embed{embed},
+		Content: util.Pointer(""),
+		Embeds:  &[]discord.Embed{embed},
 	})
 	if err != nil {
 		slog.Error("Error editing the response:", slog.Any("err", err))
@@ -171,7 +161,7 @@ func (s SummarizeCommand) Handler(event *events.ApplicationCommandInteractionCre
 	}
 
 	// Track where the summary landed so the admin command can link back to it
-	if saveErr := database.SetSummaryInvocationMessage(invID, message.ID.String()); saveErr != nil {
+	if saveErr := database.SetSummaryInvocationMessage(inv.ID, message.ID.String()); saveErr != nil {
 		slog.Warn("Failed to save summary message ID", slog.Any("err", saveErr))
 	}
 }
@@ -196,16 +186,40 @@ func (s SummarizeCommand) ParseArguments(bot *discordgo.Session, interaction *di
 }
 
 func (s SummarizeCommand) CreateCommandArguments() []discord.ApplicationCommandOption {
-	return []discord.ApplicationCommandOption{
+	options := []discord.ApplicationCommandOption{
 		discord.ApplicationCommandOptionString{
 			Name:        "unit",
 			Description: "How far back to summarize the messages",
 			Required:    true,
 		},
+		discord.ApplicationCommandOptionBool{
+			Name:        "digest",
+			Description: fmt.Sprintf("Summarize every channel you can read instead of just this one (up to %dd)", maxDigestDays),
+			Required:    false,
+		},
+	}
+	for i, name := range digestChannelOptions() {
+		description := "A channel to include in the digest (defaults to every channel you can read)"
+		if i > 0 {
+			description = "Another channel to include in the digest"
+		}
+		options = append(options, discord.ApplicationCommandOptionChannel{
+			Name:        name,
+			Description: description,
+			Required:    false,
+			ChannelTypes: []discord.ChannelType{
+				discord.ChannelTypeGuildText,
+				discord.ChannelTypeGuildNews,
+				discord.ChannelTypeGuildPublicThread,
+			},
+		})
 	}
+	return options
 }
 
-func parseTimeArg(timeUnit string) (time.Duration, error) {
+// parseTimeArg parses a duration such as "30m" or "2d", rejecting anything
+// longer than maxDays.
+func parseTimeArg(timeUnit string, maxDays int) (time.Duration, error) {
 	// Regular expression to match a number followed by a unit
 	re := regexp.MustCompile(`^(\d+)([smhd])$`)
 	matches := re.FindStringSubmatch(timeUnit)
@@ -235,78 +249,11 @@ func parseTimeArg(timeUnit string) (time.Duration, error) {
 		return 0, fmt.Errorf("unknown time unit: %s", unit)
 	}
 
-	// Enforce maximum time limit (1 day)
-	maxDuration := 24 * time.Hour
+	// Enforce maximum time limit
+	maxDuration := time.Duration(maxDays) * 24 * time.Hour
 	if duration > maxDuration {
-		return 0, fmt.Errorf("time cannot exceed 1 day (24h)")
+		return 0, fmt.Errorf("time cannot exceed %d day%s (%dh)", maxDays, plural(maxDays), maxDays*24)
 	}
 
 	return duration, nil
 }
-
-func GetSummary(messages []util.SummaryBody) (out util.SummaryResponse, rawResponse string, err error) {
-	data, err := json.Marshal(messages)
-	if err != nil {
-		return util.SummaryResponse{}, "", err
-	}
-	if util.ConfigFile.DEBUG {
-		d, _ := json.MarshalIndent(messages, "", "    ")
-		os.WriteFile("summary.json", d, 0644)
-	}
-	prompt := fmt.Sprintf(
-		"You are an AI that outputs valid JSON only.\n"+
-			"Summarize and group the following Discord messages by topic.\n"+
-			"Use the exact author names provided in the input.\n"+
-			"Return a JSON object with a \"messages\" array where each element has a \"topic\" and \"summary\" field.\n\n"+
-			"Input:\n%s",
-		string(data))
-	resp, err := util.CreateOllamaGeneration(util.OllamaGenerateRequest{
-		Model:            util.ConfigFile.OLLAMA_MODEL,
-		Temperature:      0.2,
-		FrequencePenalty: 1.8,
-		PresencePenalty:  1.2,
-		MaxTokens:        len(data) + 1000,
-		Messages:         []map[string]string{{"role": "user", "content": prompt}},
-		ResponseFormat: map[string]interface{}{
-			"type": "json_object",
-			"properties": map[string]interface{}{
-				"messages": map[string]interface{}{
-					"type":     "array",
-					"minItems": 1,
-					"maxItems": 20,
-					"items": map[string]interface{}{
-						"type": "json_object",
-						"properties": map[string]interface{}{
-							"topic": map[string]interface{}{
-								"type": "string",
-							},
-							"summary": map[string]interface{}{
-								"type": "string",
-							},
-						},
-						"required": []string{"topic", "summary"},
-					},
-				},
-			},
-			"required": []string{"messages"},
-		},
-		Stream: false,
-	})
-	if err != nil {
-		return util.SummaryResponse{}, "", err
-	}
-
-	rawResponse = resp.Choices[0].Message.Content
-	slog.Debug("Raw response for summarize", slog.String("rawResponse", rawResponse))
-	if err = json.Unmarshal([]byte(rawResponse), &out); err != nil {
-		return
-	}
-
-	// Members without a nickname are passed to the model by ID, so any that come
-	// back have to be turned into mentions before they reach Discord.
-	for i, summary := range out.Summaries {
-		out.Summaries[i].Topic = util.MentionifyIDs(summary.Topic)
-		out.Summaries[i].Summary = util.MentionifyIDs(summary.Summary)
-	}
-	return
-}
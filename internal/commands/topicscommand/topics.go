@@ -0,0 +1,220 @@
+package topicscommand
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/util"
+	"github.com/stollenaar/statisticsbot/internal/util/charts"
+)
+
+var (
+	TopicsCmd = TopicsCommand{
+		Name:        "topics",
+		Description: "show what this server has been talking about",
+	}
+)
+
+const (
+	maxTopics = 10
+	// defaultWeeks and maxWeeks bound how far back the share chart goes. The
+	// topic job keeps 12 past weeks besides the current one.
+	defaultWeeks = 8
+	maxWeeks     = 13
+	// maxChartSeries is how many topic labels get their own area in the chart;
+	// the rest are summed up as "Other".
+	maxChartSeries   = 8
+	maxExampleLength = 120
+	// maxExampleCandidates is how many of a topic's most representative
+	// messages are tried for one the invoker can read.
+	maxExampleCandidates = 5
+)
+
+type TopicsCommand struct {
+	Name        string
+	Description string
+}
+
+// Handler shows the largest topics of the most recent clustered week, each
+// with its most representative message, and a chart of how the share of each
+// topic moved over the past weeks.
+func (t TopicsCommand) Handler(event *events.ApplicationCommandInteractionCreate) {
+	err := event.DeferCreateMessage(util.ConfigFile.SetEphemeral() == discord.MessageFlagEphemeral)
+	if err != nil {
+		slog.Error("Error deferring: ", slog.Any("err", err))
+		return
+	}
+	sub := event.SlashCommandInteractionData()
+	guildID := event.GuildID().String()
+
+	weeks := defaultWeeks
+	if w, ok := sub.OptInt("weeks"); ok {
+		weeks = w
+	}
+
+	topics, err := database.GetLatestTopics(guildID, maxTopics)
+	if err != nil {
+		slog.Error("topics duckDB error", slog.Any("err", err))
+		util.EditError(event, "error happened while trying to fetch the topics")
+		return
+	}
+	if len(topics) == 0 {
+		util.EditError(event, "no topics found yet, they are clustered from the message embeddings every few hours")
+		return
+	}
+
+	// Topics are only clustered from channels every member can read, but a
+	// channel may have been locked since, so an example is only shown from a
+	// channel the invoker can still read.
+	examples := make(map[string]util.MessageObject)
+	for _, topic := range topics {
+		messages, err := database.GetTopicExamples(topic.ID, maxExampleCandidates)
+		if err != nil {
+			slog.Warn("Failed to fetch topic examples", slog.String("topic_id", topic.ID), slog.Any("err", err))
+			continue
+		}
+		for _, m := range messages {
+			if channelID, err := snowflake.Parse(m.ChannelID); err == nil && util.CanViewChannel(event.Client(), event.Member().Member, channelID) {
+				examples[topic.ID] = m
+				break
+			}
+		}
+	}
+
+	var files []*discord.File
+	shares, err := database.GetTopicShares(guildID, time.Now().AddDate(0, 0, -7*(weeks-1)))
+	if err != nil {
+		slog.Warn("Failed to fetch topic shares", slog.Any("err", err))
+	} else if xAxis, series := shareSeries(shares); len(xAxis) > 1 {
+		chart, err := charts.GenerateStackedAreaChart("Topic Share per Week", xAxis, series)
+		if err != nil {
+			slog.Warn("Failed to generate topics chart", slog.Any("err", err))
+		} else {
+			files = append(files, chart)
+		}
+	}
+
+	components := topicsComponents(guildID, topics, examples, files)
+	_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
+		Flags:           util.Pointer(discord.MessageFlagIsComponentsV2),
+		Components:      &components,
+		Files:           files,
+		AllowedMentions: &discord.AllowedMentions{},
+	})
+	if err != nil {
+		slog.Error("Error editing the response:", slog.Any("err", err))
+	}
+}
+
+// shareSeries turns the topic sizes per week into the percentage each label
+// took up of its week. The largest labels overall get their own series.
+func shareSeries(shares []database.TopicShare) ([]string, []charts.Series) {
+	var periods []time.Time
+	totals := make(map[time.Time]int)
+	sizes := make(map[string]int)
+	for _, s := range shares {
+		if _, ok := totals[s.PeriodStart]; !ok {
+			periods = append(periods, s.PeriodStart)
+		}
+		totals[s.PeriodStart] += s.Size
+		sizes[s.Label] += s.Size
+	}
+
+	labels := make([]string, 0, len(sizes))
+	for label := range sizes {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if sizes[labels[i]] != sizes[labels[j]] {
+			return sizes[labels[i]] > sizes[labels[j]]
+		}
+		return labels[i] < labels[j]
+	})
+
+	index := make(map[string]int)
+	var series []charts.Series
+	for i, label := range labels {
+		if i == maxChartSeries {
+			series = append(series, charts.Series{Name: "Other", Values: make([]float64, len(periods))})
+			break
+		}
+		index[label] = i
+		series = append(series, charts.Series{Name: label, Values: make([]float64, len(periods))})
+	}
+
+	xAxis := make([]string, len(periods))
+	column := make(map[time.Time]int)
+	for i, p := range periods {
+		xAxis[i] = p.Format("Jan 02")
+		column[p] = i
+	}
+
+	for _, s := range shares {
+		i, ok := index[s.Label]
+		if !ok {
+			i = maxChartSeries
+		}
+		share := 100 * float64(s.Size) / float64(totals[s.PeriodStart])
+		series[i].Values[column[s.PeriodStart]] += share
+	}
+	for _, s := range series {
+		for i, v := range s.Values {
+			s.Values[i] = math.Round(v*10) / 10
+		}
+	}
+	return xAxis, series
+}
+
+func topicsComponents(guildID string, topics []database.Topic, examples map[string]util.MessageObject, files []*discord.File) []discord.LayoutComponent {
+	total := 0
+	for _, topic := range topics {
+		total += topic.Size
+	}
+
+	period := topics[0].PeriodStart
+	rows := []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{
+			Content: fmt.Sprintf("# Top topics\nWeek of <t:%d:D> · %d messages in the %d largest topics", period.Unix(), total, len(topics)),
+		},
+	}
+
+	var lines []string
+	for i, topic := range topics {
+		lines = append(lines, fmt.Sprintf("%d. **%s** — %d messages", i+1, topic.Label, topic.Size))
+		if m, ok := examples[topic.ID]; ok {
+			link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, m.ChannelID, m.MessageID)
+			lines = append(lines, fmt.Sprintf("-# > %s [jump](%s)", util.Truncate(strings.Join(strings.Fields(m.Content), " "), maxExampleLength), link))
+		}
+	}
+	rows = append(rows, util.GetSeparator(), discord.TextDisplayComponent{Content: strings.Join(lines, "\n")})
+
+	for _, file := range files {
+		rows = append(rows, util.GetSeparator(), discord.MediaGalleryComponent{
+			Items: []discord.MediaGalleryItem{{
+				Media: discord.UnfurledMediaItem{URL: fmt.Sprintf("attachment://%s", file.Name)},
+			}},
+		})
+	}
+
+	return []discord.LayoutComponent{discord.ContainerComponent{Components: rows}}
+}
+
+func (t TopicsCommand) CreateCommandArguments() []discord.ApplicationCommandOption {
+	return []discord.ApplicationCommandOption{
+		discord.ApplicationCommandOptionInt{
+			Name:        "weeks",
+			Description: fmt.Sprintf("How many weeks the topic share chart covers (default %d)", defaultWeeks),
+			Required:    false,
+			MinValue:    util.Pointer(2),
+			MaxValue:    util.Pointer(maxWeeks),
+		},
+	}
+}
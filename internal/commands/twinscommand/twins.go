@@ -0,0 +1,106 @@
+package twinscommand
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+var (
+	TwinsCmd = TwinsCommand{
+		Name:        "twins",
+		Description: "find the members who write most like you",
+	}
+)
+
+const (
+	maxTwins        = 5
+	maxSharedTopics = 3
+)
+
+type TwinsCommand struct {
+	Name        string
+	Description string
+}
+
+// Handler ranks the members whose profile vector, the time-decayed average
+// of their message embeddings, is closest to the user's, with the topics
+// both of them wrote in.
+func (t TwinsCommand) Handler(event *events.ApplicationCommandInteractionCreate) {
+	err := event.DeferCreateMessage(util.ConfigFile.SetEphemeral() == discord.MessageFlagEphemeral)
+	if err != nil {
+		slog.Error("Error deferring: ", slog.Any("err", err))
+		return
+	}
+	sub := event.SlashCommandInteractionData()
+	guildID := event.GuildID().String()
+
+	user := event.User()
+	if u, ok := sub.OptUser("user"); ok {
+		user = u
+	}
+
+	twins, messages, err := database.GetUserTwins(guildID, user.ID.String(), maxTwins)
+	if errors.Is(err, database.ErrProfilesBuilding) {
+		util.EditError(event, "the member profiles are being built, try again in a few minutes")
+		return
+	}
+	if err != nil {
+		slog.Error("twins duckDB error", slog.Any("err", err))
+		util.EditError(event, "error happened while trying to compare the profiles")
+		return
+	}
+	if messages == 0 {
+		util.EditError(event, fmt.Sprintf("%s has no embedded messages yet", discord.UserMention(user.ID)))
+		return
+	}
+	if len(twins) == 0 {
+		util.EditError(event, "no other members have written enough to compare with")
+		return
+	}
+
+	lines := []string{
+		fmt.Sprintf("# Who writes like %s", user.EffectiveName()),
+		fmt.Sprintf("-# Based on %d messages, recent ones weighing more", messages),
+	}
+	for i, twin := range twins {
+		lines = append(lines, fmt.Sprintf("%d. <@%s> — %.0f%% similar · %d messages", i+1, twin.AuthorID, twin.Score*100, twin.Messages))
+
+		topics, err := database.GetSharedTopics(guildID, user.ID.String(), twin.AuthorID, maxSharedTopics)
+		if err != nil {
+			slog.Warn("Failed to fetch shared topics", slog.Any("err", err))
+			continue
+		}
+		if len(topics) > 0 {
+			lines = append(lines, fmt.Sprintf("-# Both talk about %s", strings.Join(topics, ", ")))
+		}
+	}
+
+	components := []discord.LayoutComponent{discord.ContainerComponent{Components: []discord.ContainerSubComponent{
+		discord.TextDisplayComponent{Content: strings.Join(lines, "\n")},
+	}}}
+	_, err = event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
+		Flags:           util.Pointer(discord.MessageFlagIsComponentsV2),
+		Components:      &components,
+		AllowedMentions: &discord.AllowedMentions{},
+	})
+	if err != nil {
+		slog.Error("Error editing the response:", slog.Any("err", err))
+	}
+}
+
+func (t TwinsCommand) CreateCommandArguments() []discord.ApplicationCommandOption {
+	return []discord.ApplicationCommandOption{
+		discord.ApplicationCommandOptionUser{
+			Name:        "user",
+			Description: "Member to find the twins of, defaults to you",
+			Required:    false,
+		},
+	}
+}
@@ -0,0 +1,32 @@
+-- scheduled_jobs holds the recurring reports guild admins set up through
+-- /schedule. config is a JSON blob whose shape depends on report.
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    id VARCHAR PRIMARY KEY,
+    guild_id VARCHAR NOT NULL,
+    channel_id VARCHAR NOT NULL,
+    created_by VARCHAR NOT NULL,
+    report VARCHAR NOT NULL,
+    config VARCHAR NOT NULL,
+    cron VARCHAR NOT NULL,
+    enabled BOOLEAN DEFAULT TRUE,
+    next_run_at TIMESTAMP NOT NULL,
+    last_run_at TIMESTAMP,
+    last_status VARCHAR,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- scheduled_job_runs records every execution of a job. scheduled_for is the
+-- slot the run was meant for, which differs from started_at when a run was
+-- missed while the bot was down and caught up after a restart.
+CREATE TABLE IF NOT EXISTS scheduled_job_runs (
+    id VARCHAR PRIMARY KEY,
+    job_id VARCHAR NOT NULL,
+    scheduled_for TIMESTAMP NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP,
+    status VARCHAR DEFAULT 'running',
+    error VARCHAR,
+    message_id VARCHAR
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_job_runs_job ON scheduled_job_runs (job_id);
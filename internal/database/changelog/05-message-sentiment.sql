@@ -0,0 +1,13 @@
+-- message_sentiment stores the local classifier's verdict for the latest
+-- version of a message. Like message_embeddings, id is a logical foreign key
+-- to messages.id and everything else is joined back from there.
+-- score is P(positive) - P(negative), so it ranges from -1 to 1 and can be
+-- averaged; label is the most likely class and confidence its probability.
+CREATE TABLE IF NOT EXISTS message_sentiment (
+    id VARCHAR PRIMARY KEY,
+    model VARCHAR NOT NULL,
+    label VARCHAR NOT NULL,
+    score FLOAT NOT NULL,
+    confidence FLOAT NOT NULL,
+    classified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,13 @@
+-- message_moderation stores the local moderation classifiers' scores for the
+-- latest version of a message. Like message_sentiment, id is a logical
+-- foreign key to messages.id and everything else is joined back from there.
+-- Every score is an independent probability from 0 to 1; whether a message
+-- counts as flagged depends on the configured threshold, so it is not stored.
+CREATE TABLE IF NOT EXISTS message_moderation (
+    id VARCHAR PRIMARY KEY,
+    model VARCHAR NOT NULL,
+    toxicity FLOAT NOT NULL,
+    insult FLOAT NOT NULL,
+    spam FLOAT NOT NULL,
+    scored_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,41 @@
+-- message_embeddings is re-keyed on (id, model) so vectors from several
+-- embedding models can coexist: a guild keeps searching with its old model
+-- while its history is re-embedded with a new one. Rows from before the model
+-- was always set are attributed to the original default model.
+CREATE TABLE message_embeddings_by_model (
+    id VARCHAR NOT NULL,
+    model VARCHAR NOT NULL,
+    embedding FLOAT[] NOT NULL,
+    PRIMARY KEY (id, model)
+);
+
+INSERT INTO message_embeddings_by_model (id, model, embedding)
+SELECT id, COALESCE(model, 'sentence-transformers/all-MiniLM-L6-v2'), embedding
+FROM message_embeddings;
+
+DROP TABLE message_embeddings;
+ALTER TABLE message_embeddings_by_model RENAME TO message_embeddings;
+
+-- guild_embedding_models pins the model a guild's semantic searches use. It
+-- only moves once a migration to a new model has embedded the whole history.
+CREATE TABLE IF NOT EXISTS guild_embedding_models (
+    guild_id VARCHAR PRIMARY KEY,
+    model VARCHAR NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- embedding_migrations records every re-embedding job. A job still marked
+-- running when the bot starts was interrupted and is picked up again.
+CREATE TABLE IF NOT EXISTS embedding_migrations (
+    id VARCHAR PRIMARY KEY,
+    guild_id VARCHAR NOT NULL,
+    from_model VARCHAR NOT NULL,
+    to_model VARCHAR NOT NULL,
+    status VARCHAR DEFAULT 'running',
+    total INTEGER DEFAULT 0,
+    done INTEGER DEFAULT 0,
+    failed INTEGER DEFAULT 0,
+    error VARCHAR,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
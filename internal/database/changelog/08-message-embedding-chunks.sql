@@ -0,0 +1,15 @@
+-- message_embedding_chunks stores the vectors of the overlapping chunks a long
+-- message is split into, so its tail is searchable too. Messages that fit in a
+-- single chunk have no rows here; their vector in message_embeddings covers
+-- them. For split messages message_embeddings holds the first chunk's vector.
+-- start_offset and end_offset are byte offsets into the trimmed content the
+-- chunks were cut from, used to highlight the matching part.
+CREATE TABLE IF NOT EXISTS message_embedding_chunks (
+    id VARCHAR NOT NULL,
+    model VARCHAR NOT NULL,
+    chunk_index INTEGER NOT NULL,
+    start_offset INTEGER NOT NULL,
+    end_offset INTEGER NOT NULL,
+    embedding FLOAT[] NOT NULL,
+    PRIMARY KEY (id, model, chunk_index)
+);
@@ -0,0 +1,19 @@
+-- conversation_windows stores embeddings of runs of consecutive messages in a
+-- channel, a coarser search target than single messages, which are often too
+-- short to mean much on their own. Windows are cut at long pauses unless a
+-- message replies into the current window, and long conversations are covered
+-- by overlapping windows. A window is identified by its first message.
+CREATE TABLE IF NOT EXISTS conversation_windows (
+    first_message_id VARCHAR NOT NULL,
+    model VARCHAR NOT NULL,
+    guild_id VARCHAR NOT NULL,
+    channel_id VARCHAR NOT NULL,
+    last_message_id VARCHAR NOT NULL,
+    start_date TIMESTAMP NOT NULL,
+    end_date TIMESTAMP NOT NULL,
+    message_count INTEGER NOT NULL,
+    embedding FLOAT[] NOT NULL,
+    PRIMARY KEY (first_message_id, model)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_windows_channel ON conversation_windows (channel_id, model);
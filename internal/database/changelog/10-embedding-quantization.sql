@@ -0,0 +1,12 @@
+-- Embeddings can be stored quantized to save space: as one signed byte per
+-- dimension (embedding_int8) or one bit per dimension (embedding_bits), next
+-- to the full-precision embedding column. A row holds its vector in exactly
+-- one of the three, picked by EMBEDDING_QUANTIZATION when it was written or
+-- converted on startup.
+ALTER TABLE message_embeddings ALTER COLUMN embedding DROP NOT NULL;
+ALTER TABLE message_embeddings ADD COLUMN IF NOT EXISTS embedding_int8 TINYINT[];
+ALTER TABLE message_embeddings ADD COLUMN IF NOT EXISTS embedding_bits BIT;
+
+ALTER TABLE message_embedding_chunks ALTER COLUMN embedding DROP NOT NULL;
+ALTER TABLE message_embedding_chunks ADD COLUMN IF NOT EXISTS embedding_int8 TINYINT[];
+ALTER TABLE message_embedding_chunks ADD COLUMN IF NOT EXISTS embedding_bits BIT;
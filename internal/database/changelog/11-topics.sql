@@ -0,0 +1,27 @@
+-- topics holds the clusters the topic job found among a guild's message
+-- embeddings in one period (a week starting on period_start). label is
+-- written by the LLM from the messages nearest the centroid; centroid is kept
+-- so a topic that carries on into the next period can keep its label.
+CREATE TABLE IF NOT EXISTS topics (
+    id VARCHAR PRIMARY KEY,
+    guild_id VARCHAR NOT NULL,
+    model VARCHAR NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    period_end TIMESTAMP NOT NULL,
+    label VARCHAR NOT NULL,
+    size INTEGER NOT NULL,
+    centroid FLOAT[] NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_topics_guild_period ON topics (guild_id, model, period_start);
+
+-- topic_messages lists the messages of every topic. score is the message's
+-- cosine similarity to the topic's centroid, so the highest scoring ones are
+-- the most representative.
+CREATE TABLE IF NOT EXISTS topic_messages (
+    topic_id VARCHAR NOT NULL,
+    message_id VARCHAR NOT NULL,
+    score FLOAT NOT NULL,
+    PRIMARY KEY (topic_id, message_id)
+);
@@ -0,0 +1,10 @@
+-- repost_channels lists the channels that opted in to repost detection: a new
+-- message there whose embedding is at least threshold similar to a recent
+-- message in the guild gets a reply linking to the earlier one.
+CREATE TABLE IF NOT EXISTS repost_channels (
+    channel_id VARCHAR PRIMARY KEY,
+    guild_id VARCHAR NOT NULL,
+    threshold FLOAT NOT NULL,
+    enabled_by VARCHAR NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
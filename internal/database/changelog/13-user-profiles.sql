@@ -0,0 +1,15 @@
+-- user_profiles caches a vector per member describing how they write: the sum
+-- of their normalised message embeddings, each decayed by its age as of
+-- updated_at. Only its direction matters, so the sum is never divided by
+-- weight; weight is the decayed message count and message_count the plain
+-- one. New messages are folded in as they are embedded.
+CREATE TABLE IF NOT EXISTS user_profiles (
+    guild_id VARCHAR NOT NULL,
+    author_id VARCHAR NOT NULL,
+    model VARCHAR NOT NULL,
+    embedding FLOAT[] NOT NULL,
+    weight DOUBLE NOT NULL,
+    message_count INTEGER NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (guild_id, author_id, model)
+);
@@ -0,0 +1,14 @@
+-- interaction_sessions keeps the state paginated commands need to render
+-- their next page, e.g. the query and ranked result ids of a /semantic
+-- search, so their buttons keep working across restarts. state is a JSON
+-- blob whose shape depends on command. Expired sessions are cleaned up in the
+-- background.
+CREATE TABLE IF NOT EXISTS interaction_sessions (
+    token VARCHAR PRIMARY KEY,
+    command VARCHAR NOT NULL,
+    state VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_interaction_sessions_expires ON interaction_sessions (expires_at);
@@ -0,0 +1,17 @@
+-- topic_periods records the finished periods the topic job has clustered, so
+-- a period that had too few messages or no cluster large enough to keep is
+-- not clustered again on every run. Periods that already have topics count as
+-- done.
+CREATE TABLE IF NOT EXISTS topic_periods (
+    guild_id VARCHAR NOT NULL,
+    model VARCHAR NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    clustered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (guild_id, model, period_start)
+);
+
+INSERT INTO topic_periods (guild_id, model, period_start, clustered_at)
+SELECT guild_id, model, period_start, MAX(created_at)
+FROM topics
+GROUP BY guild_id, model, period_start
+ON CONFLICT DO NOTHING;
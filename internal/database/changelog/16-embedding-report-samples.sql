@@ -0,0 +1,10 @@
+-- embedding_report_samples keeps full-precision copies of a sample of the
+-- message vectors that are converted to a quantized storage mode, so the
+-- quantization report can still measure recall once the embedding column has
+-- been emptied.
+CREATE TABLE IF NOT EXISTS embedding_report_samples (
+    id VARCHAR NOT NULL,
+    model VARCHAR NOT NULL,
+    embedding FLOAT[] NOT NULL,
+    PRIMARY KEY (id, model)
+);
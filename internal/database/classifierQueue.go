@@ -0,0 +1,60 @@
+package database
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// classifierQueueSize is how many messages can wait for a classifier before
+// new ones are dropped.
+const classifierQueueSize = 1000
+
+// classifierQueue runs a classifier over new messages on a single worker, the
+// classifiers only running one input at a time anyway. Like the embedding
+// queue it drops messages when full instead of blocking the gateway; dropped
+// messages are left for the backfill routes.
+type classifierQueue struct {
+	name     string
+	classify func(id, content string)
+
+	startOnce sync.Once
+	jobs      chan classifierJob
+}
+
+type classifierJob struct {
+	id, content string
+}
+
+var (
+	sentimentQueue  = &classifierQueue{name: "sentiment", classify: ClassifyMessage}
+	moderationQueue = &classifierQueue{name: "moderation", classify: ScoreMessage}
+)
+
+func (q *classifierQueue) start() {
+	q.startOnce.Do(func() {
+		q.jobs = make(chan classifierJob, classifierQueueSize)
+		go func() {
+			for job := range q.jobs {
+				q.classify(job.id, job.content)
+			}
+		}()
+	})
+}
+
+// QueueSentiment queues a message for the sentiment classifier, for messages
+// that missed it on arrival. It reports false when the queue is full.
+func QueueSentiment(id, content string) bool {
+	return sentimentQueue.TryEnqueue(id, content)
+}
+
+// TryEnqueue queues a message unless the queue is full.
+func (q *classifierQueue) TryEnqueue(id, content string) bool {
+	q.start()
+	select {
+	case q.jobs <- classifierJob{id: id, content: content}:
+		return true
+	default:
+		slog.Warn("classifier queue full, dropping message", slog.String("queue", q.name), slog.String("id", id))
+		return false
+	}
+}
@@ -0,0 +1,359 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/embeddings"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+const (
+	// windowGap is the pause after which a new conversation starts, unless
+	// the next message replies into the current one.
+	windowGap = 10 * time.Minute
+	// windowMessages and windowStride size the sliding windows long
+	// conversations are cut into; consecutive windows share half their
+	// messages.
+	windowMessages = 20
+	windowStride   = 10
+	// windowMaxRunes closes a window early once its text would no longer fit
+	// in the embedding model's context window.
+	windowMaxRunes = 1200
+	// windowEmbedBatch is how many windows go through the pipeline at once.
+	windowEmbedBatch = 16
+	// windowRefreshInterval is how often new messages are folded into
+	// windows in the background.
+	windowRefreshInterval = 15 * time.Minute
+)
+
+// windowRefreshMu keeps the background refresh and /fixConversationWindows
+// from rebuilding the same channel at once.
+var windowRefreshMu sync.Mutex
+
+// ConversationWindow is a run of consecutive messages in a channel, embedded
+// as a whole.
+type ConversationWindow struct {
+	GuildID        string
+	ChannelID      string
+	FirstMessageID string
+	LastMessageID  string
+	StartDate      time.Time
+	EndDate        time.Time
+	MessageCount   int
+	Text           string
+}
+
+// ConversationSearchResult is a conversation window returned from a
+// similarity search, with its messages.
+type ConversationSearchResult struct {
+	ConversationWindow
+	Score    float64
+	Messages []util.MessageObject
+}
+
+// buildConversationWindows cuts a channel's messages, oldest first, into
+// conversations at pauses longer than windowGap, keeping replies with the
+// conversation they answer, and slides windows over each conversation.
+func buildConversationWindows(messages []util.MessageObject) []ConversationWindow {
+	var windows []ConversationWindow
+	var conversation []util.MessageObject
+	inConversation := make(map[string]bool)
+
+	flush := func() {
+		windows = append(windows, slideWindows(conversation)...)
+		conversation = nil
+		clear(inConversation)
+	}
+
+	for _, m := range messages {
+		if len(conversation) > 0 {
+			gap := m.Date.Sub(conversation[len(conversation)-1].Date)
+			repliesIn := m.ReplyMessageID.Valid && inConversation[m.ReplyMessageID.String]
+			if gap > windowGap && !repliesIn {
+				flush()
+			}
+		}
+		conversation = append(conversation, m)
+		inConversation[m.MessageID] = true
+	}
+	if len(conversation) > 0 {
+		flush()
+	}
+	return windows
+}
+
+// slideWindows covers a conversation with windows of at most windowMessages
+// messages and windowMaxRunes runes, starting every windowStride messages or
+// where the previous window ended, whichever comes first.
+func slideWindows(conversation []util.MessageObject) []ConversationWindow {
+	var windows []ConversationWindow
+	for first := 0; first < len(conversation); {
+		var lines []string
+		runes := 0
+		last := first
+		for ; last < len(conversation) && last-first < windowMessages; last++ {
+			line := strings.TrimSpace(conversation[last].Content)
+			if runes+len([]rune(line)) > windowMaxRunes && last > first {
+				break
+			}
+			lines = append(lines, line)
+			runes += len([]rune(line)) + 1
+		}
+
+		windows = append(windows, ConversationWindow{
+			GuildID:        conversation[first].GuildID,
+			ChannelID:      conversation[first].ChannelID,
+			FirstMessageID: conversation[first].MessageID,
+			LastMessageID:  conversation[last-1].MessageID,
+			StartDate:      conversation[first].Date,
+			EndDate:        conversation[last-1].Date,
+			MessageCount:   last - first,
+			Text:           strings.Join(lines, "\n"),
+		})
+		if last == len(conversation) {
+			break
+		}
+		// A window closed early by windowMaxRunes may end before the next
+		// stride; start the next one where it ended so no message is left out.
+		first = min(first+windowStride, last)
+	}
+	return windows
+}
+
+// StartConversationWindowRefresh refreshes the conversation windows until the
+// process exits.
+func StartConversationWindowRefresh() {
+	ticker := time.NewTicker(windowRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := RefreshConversationWindows(); err != nil {
+			slog.Error("Failed to refresh conversation windows", slog.Any("err", err))
+		}
+		<-ticker.C
+	}
+}
+
+// RefreshConversationWindows brings the conversation windows of every channel
+// up to date for the configured model and each guild's serving model. The
+// newest window of a channel may still have been growing, so every channel is
+// rebuilt from the start of its newest window on.
+func RefreshConversationWindows() error {
+	windowRefreshMu.Lock()
+	defer windowRefreshMu.Unlock()
+
+	rows, err := duckdbClient.Query(`SELECT DISTINCT guild_id, channel_id FROM messages`)
+	if err != nil {
+		return err
+	}
+	type channel struct{ guildID, channelID string }
+	var channels []channel
+	for rows.Next() {
+		var c channel
+		if err := rows.Scan(&c.guildID, &c.channelID); err != nil {
+			rows.Close()
+			return err
+		}
+		channels = append(channels, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, c := range channels {
+		models := []string{embeddings.ModelName()}
+		if serving, err := ServingEmbeddingModel(c.guildID); err != nil {
+			errs = append(errs, err)
+		} else if serving != models[0] {
+			models = append(models, serving)
+		}
+
+		for _, model := range models {
+			if err := refreshChannelWindows(c.channelID, model); err != nil {
+				errs = append(errs, fmt.Errorf("channel %s: %w", c.channelID, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func refreshChannelWindows(channelID, model string) error {
+	var since sql.NullTime
+	err := duckdbClient.QueryRow(`SELECT MAX(start_date) FROM conversation_windows WHERE channel_id = ? AND model = ?`, channelID, model).Scan(&since)
+	if err != nil {
+		return err
+	}
+	from := time.Time{}
+	if since.Valid {
+		from = since.Time
+	}
+
+	messages, err := getChannelMessagesSince(channelID, from)
+	if err != nil {
+		return err
+	}
+	windows := buildConversationWindows(messages)
+	if len(windows) == 0 {
+		return nil
+	}
+
+	var vecs [][]float32
+	for start := 0; start < len(windows); start += windowEmbedBatch {
+		batch := windows[start:min(start+windowEmbedBatch, len(windows))]
+		texts := make([]string, len(batch))
+		for i, w := range batch {
+			texts[i] = w.Text
+		}
+		batchVecs, err := embeddings.EmbedBatch(model, texts)
+		if err != nil {
+			return err
+		}
+		vecs = append(vecs, batchVecs...)
+	}
+
+	tx, err := duckdbClient.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM conversation_windows WHERE channel_id = ? AND model = ? AND start_date >= ?`, channelID, model, from); err != nil {
+		return err
+	}
+	for i, w := range windows {
+		if vecs[i] == nil {
+			slog.Warn("failed to embed conversation window", slog.String("first_message_id", w.FirstMessageID))
+			continue
+		}
+		_, err := tx.Exec(fmt.Sprintf(`
+			INSERT INTO conversation_windows (first_message_id, model, guild_id, channel_id, last_message_id, start_date, end_date, message_count, embedding)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, %s)
+			ON CONFLICT (first_message_id, model) DO UPDATE SET
+				last_message_id = EXCLUDED.last_message_id,
+				end_date = EXCLUDED.end_date,
+				message_count = EXCLUDED.message_count,
+				embedding = EXCLUDED.embedding`, floatSliceToList(vecs[i])),
+			w.FirstMessageID, model, w.GuildID, w.ChannelID, w.LastMessageID, w.StartDate, w.EndDate, w.MessageCount,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// getChannelMessagesSince returns the latest version of a channel's messages
+// from the given time on, oldest first.
+func getChannelMessagesSince(channelID string, since time.Time) ([]util.MessageObject, error) {
+	return getChannelMessages(channelID, since, time.Time{})
+}
+
+// GetConversationMessages returns the latest version of a channel's messages
+// between two times, oldest first.
+func GetConversationMessages(channelID string, start, end time.Time) ([]util.MessageObject, error) {
+	return getChannelMessages(channelID, start, end)
+}
+
+// getChannelMessages returns the latest version of a channel's non-empty
+// messages from start on and, unless end is zero, up to end, oldest first.
+func getChannelMessages(channelID string, start, end time.Time) ([]util.MessageObject, error) {
+	params := []any{channelID, start}
+	query := `
+		SELECT m.id, m.guild_id, m.channel_id, m.author_id, m.reply_message_id, m.content, m.date
+		FROM messages m
+		JOIN (
+			SELECT id, MAX(version) AS latest_version
+			FROM messages
+			WHERE channel_id = ?
+			AND date >= ?
+			GROUP BY id
+		) latest ON m.id = latest.id AND m.version = latest.latest_version
+		WHERE m.content <> ''`
+	if !end.IsZero() {
+		query += "\n\t\tAND m.date <= ?"
+		params = append(params, end)
+	}
+	query += "\n\t\tORDER BY m.date ASC"
+
+	rows, err := duckdbClient.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []util.MessageObject
+	for rows.Next() {
+		var m util.MessageObject
+		if err := rows.Scan(&m.MessageID, &m.GuildID, &m.ChannelID, &m.Author, &m.ReplyMessageID, &m.Content, &m.Date); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// SearchSimilarConversations returns the conversation windows in a guild most
+// similar to the query vector, ordered by descending cosine similarity, each
+// with its messages. Overlapping windows of the same conversation can both
+// match; only the best of two windows sharing messages is kept.
+func SearchSimilarConversations(guildID string, vec []float32, model string, limit int) ([]ConversationSearchResult, error) {
+	// Windows overlap by at most half, so fetching twice the limit leaves
+	// enough after dropping overlapping ones.
+	query := fmt.Sprintf(`
+		SELECT guild_id, channel_id, first_message_id, last_message_id, start_date, end_date, message_count,
+		       list_cosine_similarity(embedding, %s::FLOAT[]) AS score
+		FROM conversation_windows
+		WHERE guild_id = ? AND model = ?
+		ORDER BY score DESC
+		LIMIT ?`, floatSliceToList(vec))
+
+	rows, err := duckdbClient.Query(query, guildID, model, limit*2)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []ConversationSearchResult
+	for rows.Next() {
+		var r ConversationSearchResult
+		if err := rows.Scan(&r.GuildID, &r.ChannelID, &r.FirstMessageID, &r.LastMessageID, &r.StartDate, &r.EndDate, &r.MessageCount, &r.Score); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var results []ConversationSearchResult
+	for _, c := range candidates {
+		if len(results) == limit {
+			break
+		}
+		overlaps := false
+		for _, r := range results {
+			if r.ChannelID == c.ChannelID && !c.StartDate.After(r.EndDate) && !r.StartDate.After(c.EndDate) {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			continue
+		}
+
+		c.Messages, err = GetConversationMessages(c.ChannelID, c.StartDate, c.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, c)
+	}
+	return results, nil
+}
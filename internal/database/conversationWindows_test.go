@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// conversation returns n messages a minute apart, starting at start, with ids
+// m<offset>, m<offset+1>, ...
+func conversation(start time.Time, offset, n int, content string) []util.MessageObject {
+	messages := make([]util.MessageObject, n)
+	for i := range messages {
+		messages[i] = util.MessageObject{
+			GuildID:   "guild",
+			ChannelID: "channel",
+			MessageID: fmt.Sprintf("m%d", offset+i),
+			Content:   content,
+			Date:      start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return messages
+}
+
+func TestBuildConversationWindows(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	later := start.Add(time.Hour)
+
+	replyIn := conversation(later, 3, 1, "a late answer")
+	replyIn[0].ReplyMessageID = sql.NullString{String: "m1", Valid: true}
+	replyOut := conversation(later, 3, 1, "an answer from elsewhere")
+	replyOut[0].ReplyMessageID = sql.NullString{String: "elsewhere", Valid: true}
+
+	tests := []struct {
+		name     string
+		messages []util.MessageObject
+		// want is the first and last message id of every window.
+		want [][2]string
+	}{
+		{
+			name:     "empty",
+			messages: nil,
+			want:     nil,
+		},
+		{
+			name:     "single window",
+			messages: conversation(start, 0, 5, "hi"),
+			want:     [][2]string{{"m0", "m4"}},
+		},
+		{
+			name:     "windows overlap by the stride",
+			messages: conversation(start, 0, 25, "hi"),
+			want:     [][2]string{{"m0", "m19"}, {"m10", "m24"}},
+		},
+		{
+			name:     "exactly one full window",
+			messages: conversation(start, 0, windowMessages, "hi"),
+			want:     [][2]string{{"m0", "m19"}},
+		},
+		{
+			name:     "a gap starts a new conversation",
+			messages: append(conversation(start, 0, 3, "hi"), conversation(later, 3, 2, "hi")...),
+			want:     [][2]string{{"m0", "m2"}, {"m3", "m4"}},
+		},
+		{
+			name:     "a reply keeps the conversation going across a gap",
+			messages: append(conversation(start, 0, 3, "hi"), replyIn...),
+			want:     [][2]string{{"m0", "m3"}},
+		},
+		{
+			name:     "a reply to another conversation does not",
+			messages: append(conversation(start, 0, 3, "hi"), replyOut...),
+			want:     [][2]string{{"m0", "m2"}, {"m3", "m3"}},
+		},
+		{
+			name:     "the rune limit closes windows early without skipping messages",
+			messages: conversation(start, 0, 5, strings.Repeat("x", 500)),
+			want:     [][2]string{{"m0", "m1"}, {"m2", "m3"}, {"m4", "m4"}},
+		},
+		{
+			name:     "a message longer than the limit still gets a window",
+			messages: conversation(start, 0, 2, strings.Repeat("x", windowMaxRunes+1)),
+			want:     [][2]string{{"m0", "m0"}, {"m1", "m1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows := buildConversationWindows(tt.messages)
+
+			var got [][2]string
+			for _, w := range windows {
+				got = append(got, [2]string{w.FirstMessageID, w.LastMessageID})
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("windows = %v, want %v", got, tt.want)
+			}
+
+			// Every message must be part of a window.
+			index := make(map[string]int)
+			for i, m := range tt.messages {
+				index[m.MessageID] = i
+			}
+			covered := make([]bool, len(tt.messages))
+			for _, w := range windows {
+				for i := index[w.FirstMessageID]; i <= index[w.LastMessageID]; i++ {
+					covered[i] = true
+				}
+			}
+			for i, ok := range covered {
+				if !ok {
+					t.Errorf("message %s is in no window", tt.messages[i].MessageID)
+				}
+			}
+		})
+	}
+}
+
+func TestSlideWindowsCounts(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	windows := slideWindows(conversation(start, 0, 45, "hi"))
+
+	wantStarts := []string{"m0", "m10", "m20", "m30"}
+	if len(windows) != len(wantStarts) {
+		t.Fatalf("got %d windows, want %d", len(windows), len(wantStarts))
+	}
+	for i, w := range windows {
+		if w.FirstMessageID != wantStarts[i] {
+			t.Errorf("window %d starts at %s, want %s", i, w.FirstMessageID, wantStarts[i])
+		}
+		if w.MessageCount != strings.Count(w.Text, "\n")+1 {
+			t.Errorf("window %d counts %d messages but has %d lines", i, w.MessageCount, strings.Count(w.Text, "\n")+1)
+		}
+		if !w.EndDate.After(w.StartDate) {
+			t.Errorf("window %d ends %v before it starts %v", i, w.EndDate, w.StartDate)
+		}
+	}
+	if last := windows[len(windows)-1]; last.LastMessageID != "m44" {
+		t.Errorf("last window ends at %s, want m44", last.LastMessageID)
+	}
+}
@@ -62,6 +62,7 @@ func init() {
 // once; only the first call closes the database.
 func Exit() {
 	exitOnce.Do(func() {
+		saveVectorIndexes()
 		slog.Info("Closing DB")
 		if err := duckdbClient.Close(); err != nil {
 			slog.Error("error closing DB", slog.Any("err", err))
@@ -214,10 +215,15 @@ func Init(client *bot.Client, GuildID *string) {
 
 		// Async checking the channels of guild for new messages
 		waitGroup.Add(1)
-		go func(client *bot.Client, channels []discord.GuildChannel, waitGroup *sync.WaitGroup) {
+		go func(client *bot.Client, guildID string, channels []discord.GuildChannel, waitGroup *sync.WaitGroup) {
 			defer waitGroup.Done()
+			// Warm the model cache so EmbedMessage does not have to ask the
+			// database on the gateway path.
+			if _, err := ServingEmbeddingModel(guildID); err != nil {
+				slog.Warn("failed to fetch the guild's embedding model", slog.String("guild_id", guildID), slog.Any("err", err))
+			}
 			initChannels(client, channels, waitGroup)
-		}(client, channels, &waitGroup)
+		}(client, guild.ID.String(), channels, &waitGroup)
 	}
 
 	// Waiting for all async calls to complete
@@ -603,6 +609,59 @@ func CountFilterOccurences(filter, word string, params []interface{}) (messageOb
 	return
 }
 
+// TrendingWord is a word's usage in a period compared to the period before it.
+type TrendingWord struct {
+	Word     string
+	Count    int
+	Previous int
+}
+
+// TrendingWords ranks the words used in a guild between start and end by how
+// much more they were used than in the equally long period before start.
+// Short words are skipped as a cheap stand-in for a stopword list.
+func TrendingWords(guildID string, start, end time.Time, limit int) ([]TrendingWord, error) {
+	query := `
+		WITH latest AS (
+			SELECT content, date
+			FROM messages
+			WHERE guild_id = ?
+			AND date BETWEEN ? AND ?
+			QUALIFY ROW_NUMBER() OVER (PARTITION BY id ORDER BY version DESC) = 1
+		),
+		words AS (
+			SELECT
+				date >= ? AS is_current,
+				LOWER(unnest(string_split(regexp_replace(content, '[^a-zA-Z0-9'' ]', '', 'g'), ' '))) AS word
+			FROM latest
+		)
+		SELECT word,
+			COUNT(*) FILTER (WHERE is_current) AS word_count,
+			COUNT(*) FILTER (WHERE NOT is_current) AS previous_count
+		FROM words
+		WHERE LENGTH(word) >= 4
+		GROUP BY word
+		HAVING COUNT(*) FILTER (WHERE is_current) >= 3
+		ORDER BY word_count - previous_count DESC, word_count DESC
+		LIMIT ?;
+	`
+	previousStart := start.Add(-end.Sub(start))
+	rows, err := QueryDuckDB(query, []interface{}{guildID, previousStart, end, start, limit})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []TrendingWord
+	for rows.Next() {
+		var w TrendingWord
+		if err := rows.Scan(&w.Word, &w.Count, &w.Previous); err != nil {
+			return nil, err
+		}
+		result = append(result, w)
+	}
+	return result, rows.Err()
+}
+
 func GetMessageBlock(messageID string) ([]util.MessageObject, error) {
 	query := `
 		WITH RECURSIVE latest AS (
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// insertMessage stores a message in the test database, removing it again when
+// the test ends.
+func insertMessage(t *testing.T, guildID, id, content string, date time.Time) {
+	t.Helper()
+	_, err := duckdbClient.Exec(`INSERT INTO messages (id, guild_id, channel_id, author_id, content, date) VALUES (?, ?, 'channel', 'author', ?, ?)`, id, guildID, content, date)
+	if err != nil {
+		t.Fatalf("insert message: %v", err)
+	}
+	t.Cleanup(func() {
+		duckdbClient.Exec(`DELETE FROM messages WHERE id = ?`, id)
+	})
+}
+
+func TestTrendingWords(t *testing.T) {
+	end := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	start := end.Add(-7 * 24 * time.Hour)
+
+	// "Deploy" is new this week; "coffee" was just as common the week before.
+	for i := range 4 {
+		day := start.Add(time.Duration(i+1) * 24 * time.Hour)
+		insertMessage(t, "trending", fmt.Sprintf("now%d", i), "Deploy the coffee, it's deploy day!", day)
+		insertMessage(t, "trending", fmt.Sprintf("before%d", i), "coffee please", day.Add(-7*24*time.Hour))
+	}
+	// Outside both periods, and in another guild.
+	insertMessage(t, "trending", "old", "deploy deploy deploy", start.Add(-30*24*time.Hour))
+	insertMessage(t, "elsewhere", "other", "deploy deploy deploy", start.Add(24*time.Hour))
+
+	words, err := TrendingWords("trending", start, end, 5)
+	if err != nil {
+		t.Fatalf("TrendingWords: %v", err)
+	}
+	if len(words) == 0 || words[0].Word != "deploy" {
+		t.Fatalf("got %+v, want deploy first", words)
+	}
+	if words[0].Count != 8 || words[0].Previous != 0 {
+		t.Errorf("deploy counted %d now and %d before, want 8 and 0", words[0].Count, words[0].Previous)
+	}
+	for _, w := range words {
+		switch w.Word {
+		case "coffee":
+			if w.Count != 4 || w.Previous != 4 {
+				t.Errorf("coffee counted %d now and %d before, want 4 and 4", w.Count, w.Previous)
+			}
+		case "the", "day":
+			t.Errorf("short word %q was ranked", w.Word)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stollenaar/statisticsbot/internal/embeddings"
+)
+
+const (
+	EmbeddingMigrationRunning  = "running"
+	EmbeddingMigrationComplete = "complete"
+	EmbeddingMigrationFailed   = "failed"
+
+	// migrationProgressEvery is how many messages are embedded between
+	// progress updates of a migration's row.
+	migrationProgressEvery = 100
+)
+
+// ErrMigrationRunning is returned when a guild already has a migration in
+// progress.
+var ErrMigrationRunning = errors.New("an embedding migration is already running for this guild")
+
+// guildModels caches the model every guild searches with and the model a
+// running migration embeds with, so queueing a new message does not have to
+// ask the database for them.
+var guildModels = struct {
+	sync.RWMutex
+	serving   map[string]string
+	migrating map[string]string
+}{
+	serving:   make(map[string]string),
+	migrating: make(map[string]string),
+}
+
+// migratingEmbeddingModel returns the model a running migration of the guild
+// embeds with, or an empty string without one.
+func migratingEmbeddingModel(guildID string) string {
+	guildModels.RLock()
+	defer guildModels.RUnlock()
+	return guildModels.migrating[guildID]
+}
+
+func setMigratingEmbeddingModel(guildID, model string) {
+	guildModels.Lock()
+	defer guildModels.Unlock()
+	if model == "" {
+		delete(guildModels.migrating, guildID)
+	} else {
+		guildModels.migrating[guildID] = model
+	}
+}
+
+// EmbeddingMigration is a job re-embedding a guild's history with a new
+// model. The guild's searches keep using FromModel until it completes.
+type EmbeddingMigration struct {
+	ID         string
+	GuildID    string
+	FromModel  string
+	ToModel    string
+	Status     string
+	Total      int
+	Done       int
+	Failed     int
+	Error      string
+	StartedAt  time.Time
+	FinishedAt sql.NullTime
+}
+
+const embeddingMigrationColumns = `id, guild_id, from_model, to_model, status, total, done, failed, COALESCE(error, ''), started_at, finished_at`
+
+func scanEmbeddingMigration(s rowScanner) (EmbeddingMigration, error) {
+	var m EmbeddingMigration
+	err := s.Scan(&m.ID, &m.GuildID, &m.FromModel, &m.ToModel, &m.Status, &m.Total, &m.Done, &m.Failed, &m.Error, &m.StartedAt, &m.FinishedAt)
+	return m, err
+}
+
+// ServingEmbeddingModel returns the model a guild's semantic searches use. A
+// guild seen for the first time is pinned to the model most of its stored
+// vectors come from, so changing EMBEDDING_MODEL does not switch searches to
+// a model that has not embedded anything yet. Guilds without any vectors are
+// pinned to the configured model.
+func ServingEmbeddingModel(guildID string) (string, error) {
+	guildModels.RLock()
+	model, ok := guildModels.serving[guildID]
+	guildModels.RUnlock()
+	if ok {
+		return model, nil
+	}
+
+	err := duckdbClient.QueryRow(`SELECT model FROM guild_embedding_models WHERE guild_id = ?`, guildID).Scan(&model)
+	if err == nil {
+		cacheServingEmbeddingModel(guildID, model)
+		return model, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	err = duckdbClient.QueryRow(`
+		SELECT e.model
+		FROM message_embeddings e
+		JOIN (
+			SELECT DISTINCT id
+			FROM messages
+			WHERE guild_id = ?
+		) m ON m.id = e.id
+		GROUP BY e.model
+		ORDER BY COUNT(*) DESC
+		LIMIT 1`,
+		guildID,
+	).Scan(&model)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		model = embeddings.ModelName()
+	case err != nil:
+		return "", err
+	}
+
+	_, err = duckdbClient.Exec(`
+		INSERT INTO guild_embedding_models (guild_id, model, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (guild_id) DO NOTHING`,
+		guildID, model, time.Now(),
+	)
+	if err != nil {
+		return "", err
+	}
+	// Read back in case a concurrent call pinned the guild first.
+	return ServingEmbeddingModel(guildID)
+}
+
+func cacheServingEmbeddingModel(guildID, model string) {
+	guildModels.Lock()
+	defer guildModels.Unlock()
+	guildModels.serving[guildID] = model
+}
+
+func setServingEmbeddingModel(guildID, model string) error {
+	_, err := duckdbClient.Exec(`
+		INSERT INTO guild_embedding_models (guild_id, model, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (guild_id) DO UPDATE SET
+			model = EXCLUDED.model,
+			updated_at = EXCLUDED.updated_at`,
+		guildID, model, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	cacheServingEmbeddingModel(guildID, model)
+	return nil
+}
+
+// ListEmbeddingMigrations returns the most recent migrations of a guild,
+// newest first.
+func ListEmbeddingMigrations(guildID string, limit int) ([]EmbeddingMigration, error) {
+	rows, err := duckdbClient.Query(`
+		SELECT `+embeddingMigrationColumns+`
+		FROM embedding_migrations
+		WHERE guild_id = ?
+		ORDER BY started_at DESC
+		LIMIT ?`,
+		guildID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []EmbeddingMigration
+	for rows.Next() {
+		m, err := scanEmbeddingMigration(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// StartEmbeddingMigration starts re-embedding a guild's history with the given
+// model in the background. The model is loaded up front so a typo or a model
+// missing in offline mode fails here instead of in the job.
+func StartEmbeddingMigration(guildID, model string) (EmbeddingMigration, error) {
+	from, err := ServingEmbeddingModel(guildID)
+	if err != nil {
+		return EmbeddingMigration{}, err
+	}
+	if from == model {
+		return EmbeddingMigration{}, fmt.Errorf("the guild already searches with %s", model)
+	}
+
+	var running int
+	err = duckdbClient.QueryRow(`SELECT COUNT(*) FROM embedding_migrations WHERE guild_id = ? AND status = ?`, guildID, EmbeddingMigrationRunning).Scan(&running)
+	if err != nil {
+		return EmbeddingMigration{}, err
+	}
+	if running > 0 {
+		return EmbeddingMigration{}, ErrMigrationRunning
+	}
+
+	if _, err := embeddings.EmbedWith(model, "model check"); err != nil {
+		return EmbeddingMigration{}, fmt.Errorf("failed to load %s: %w", model, err)
+	}
+
+	migration := EmbeddingMigration{
+		ID:        uuid.New().String(),
+		GuildID:   guildID,
+		FromModel: from,
+		ToModel:   model,
+		Status:    EmbeddingMigrationRunning,
+		StartedAt: time.Now(),
+	}
+	_, err = duckdbClient.Exec(`
+		INSERT INTO embedding_migrations (id, guild_id, from_model, to_model, status, started_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		migration.ID, migration.GuildID, migration.FromModel, migration.ToModel, migration.Status, migration.StartedAt,
+	)
+	if err != nil {
+		return EmbeddingMigration{}, err
+	}
+
+	go runEmbeddingMigration(migration)
+	return migration, nil
+}
+
+// ResumeEmbeddingMigrations restarts the migrations that were still running
+// when the bot stopped. Messages embedded before the restart are skipped.
+func ResumeEmbeddingMigrations() {
+	rows, err := duckdbClient.Query(`
+		SELECT `+embeddingMigrationColumns+`
+		FROM embedding_migrations
+		WHERE status = ?`,
+		EmbeddingMigrationRunning,
+	)
+	if err != nil {
+		slog.Error("failed to fetch running embedding migrations", slog.Any("err", err))
+		return
+	}
+	defer rows.Close()
+
+	var migrations []EmbeddingMigration
+	for rows.Next() {
+		m, err := scanEmbeddingMigration(rows)
+		if err != nil {
+			slog.Error("failed to scan embedding migration", slog.Any("err", err))
+			return
+		}
+		migrations = append(migrations, m)
+	}
+
+	for _, m := range migrations {
+		slog.Info("Resuming embedding migration", slog.String("guild_id", m.GuildID), slog.String("model", m.ToModel))
+		go runEmbeddingMigration(m)
+	}
+}
+
+// runEmbeddingMigration embeds every message of the guild the new model has
+// not embedded yet. New messages are embedded with the new model as well
+// while it runs, and a last pass picks up any that were dropped from the
+// queue. Only when none are left does the guild switch over; a migration with
+// failures keeps the old model and can be started again to retry the
+// messages that are still missing.
+func runEmbeddingMigration(m EmbeddingMigration) {
+	setMigratingEmbeddingModel(m.GuildID, m.ToModel)
+	defer setMigratingEmbeddingModel(m.GuildID, "")
+
+	// Messages that failed before a restart are retried, so they count again.
+	m.Failed = 0
+	// The first pass covers the history, the second what came in meanwhile.
+	for range 2 {
+		messages, err := getMessagesWithoutEmbeddings(m.GuildID, m.ToModel, 0)
+		if err != nil {
+			finishEmbeddingMigration(m, err)
+			return
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		m.Total = m.Done + len(messages)
+		updateEmbeddingMigrationProgress(m)
+
+		processed := 0
+		EmbedMessages(m.ToModel, messages, func(ok bool) {
+			if ok {
+				m.Done++
+			} else {
+				m.Failed++
+			}
+			if processed++; processed%migrationProgressEvery == 0 {
+				updateEmbeddingMigrationProgress(m)
+			}
+		})
+		if m.Failed > 0 {
+			break
+		}
+	}
+
+	if m.Failed > 0 {
+		finishEmbeddingMigration(m, fmt.Errorf("%d messages failed to embed", m.Failed))
+		return
+	}
+	if err := setServingEmbeddingModel(m.GuildID, m.ToModel); err != nil {
+		finishEmbeddingMigration(m, err)
+		return
+	}
+	switchVectorIndex(m.FromModel, m.ToModel)
+	finishEmbeddingMigration(m, nil)
+}
+
+func updateEmbeddingMigrationProgress(m EmbeddingMigration) {
+	_, err := duckdbClient.Exec(`UPDATE embedding_migrations SET total = ?, done = ?, failed = ? WHERE id = ?`,
+		m.Total, m.Done, m.Failed, m.ID)
+	if err != nil {
+		slog.Error("failed to update embedding migration", slog.String("id", m.ID), slog.Any("err", err))
+	}
+}
+
+func finishEmbeddingMigration(m EmbeddingMigration, err error) {
+	status, errMsg := EmbeddingMigrationComplete, ""
+	if err != nil {
+		status, errMsg = EmbeddingMigrationFailed, err.Error()
+		slog.Error("embedding migration failed", slog.String("guild_id", m.GuildID), slog.String("model", m.ToModel), slog.Any("err", err))
+	} else {
+		slog.Info("Embedding migration complete", slog.String("guild_id", m.GuildID), slog.String("model", m.ToModel), slog.Int("embedded", m.Done))
+	}
+
+	_, err = duckdbClient.Exec(`
+		UPDATE embedding_migrations
+		SET status = ?, total = ?, done = ?, failed = ?, error = ?, finished_at = ?
+		WHERE id = ?`,
+		status, m.Total, m.Done, m.Failed, errMsg, time.Now(), m.ID,
+	)
+	if err != nil {
+		slog.Error("failed to finish embedding migration", slog.String("id", m.ID), slog.Any("err", err))
+	}
+}
@@ -0,0 +1,540 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/embeddings"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// Storage modes for embedding vectors, selected by EMBEDDING_QUANTIZATION.
+const (
+	QuantizationNone   = "none"
+	QuantizationInt8   = "int8"
+	QuantizationBinary = "binary"
+)
+
+const (
+	// quantizedRescoreFactor is how many matches per requested result are
+	// taken from the quantized scores and re-ranked exactly.
+	quantizedRescoreFactor = 3
+	// quantizedMaxRescore bounds how many matched texts a search embeds again
+	// to rescore them, as that is a pipeline run on the search's path. Matches
+	// past it keep their estimates.
+	quantizedMaxRescore = 30
+	// quantizationReportCorpus bounds how many stored vectors the report
+	// measures recall on.
+	quantizationReportCorpus = 5000
+	// quantizationReportTopK is the k of the recall@k in the report.
+	quantizationReportTopK = 10
+)
+
+// similarityTemplate scores a stored row against a query vector, given as a
+// float list literal (%[1]s) and as a bit string literal (%[2]s), using
+// whichever column holds the row's vector. Cosine similarity ignores the int8
+// scale, so quantized bytes are compared as they are. Bits are compared by
+// Hamming distance, mapped onto the same -1 to 1 range.
+const similarityTemplate = `COALESCE(
+	list_cosine_similarity(embedding, %[1]s::FLOAT[]),
+	list_cosine_similarity(embedding_int8::FLOAT[], %[1]s::FLOAT[]),
+	1 - 2 * bit_count(xor(embedding_bits, %[2]s::BIT)) / bit_length(embedding_bits)
+)`
+
+// dequantizedColumn reads a row's vector back as floats from whichever column
+// holds it. Bits come back as -1 and 1, which keeps their angles.
+const dequantizedColumn = `COALESCE(
+	embedding,
+	embedding_int8::FLOAT[],
+	list_transform(string_split(embedding_bits::VARCHAR, ''), b -> CASE b WHEN '1' THEN 1.0 ELSE -1.0 END)::FLOAT[]
+)`
+
+// EmbeddingQuantization returns the configured storage mode for embeddings.
+func EmbeddingQuantization() string {
+	switch mode := strings.ToLower(util.ConfigFile.EMBEDDING_QUANTIZATION); mode {
+	case QuantizationInt8, QuantizationBinary:
+		return mode
+	default:
+		return QuantizationNone
+	}
+}
+
+// embeddingStorageColumn is the column vectors are written to in a mode.
+func embeddingStorageColumn(mode string) string {
+	switch mode {
+	case QuantizationInt8:
+		return "embedding_int8"
+	case QuantizationBinary:
+		return "embedding_bits"
+	default:
+		return "embedding"
+	}
+}
+
+// embeddingValues renders a vector as the SQL values of the embedding,
+// embedding_int8 and embedding_bits columns, in that order, for the
+// configured mode. The values are numeric or bit strings formatted by us, so
+// they are safe to inline.
+func embeddingValues(vec []float32) string {
+	switch EmbeddingQuantization() {
+	case QuantizationInt8:
+		return fmt.Sprintf("NULL, %s::TINYINT[], NULL", int8SliceToList(quantizeInt8(vec)))
+	case QuantizationBinary:
+		return fmt.Sprintf("NULL, NULL, '%s'::BIT", quantizeBinary(vec))
+	default:
+		return fmt.Sprintf("%s, NULL, NULL", floatSliceToList(vec))
+	}
+}
+
+// similarityExpr scores stored rows against a query vector; see
+// similarityTemplate.
+func similarityExpr(vec []float32) string {
+	return fmt.Sprintf(similarityTemplate, floatSliceToList(vec), quantizeBinary(vec))
+}
+
+// quantizeInt8 scales a vector so its largest component is ±127 and rounds it.
+// It matches the conversion ConvertEmbeddingStorage does in SQL.
+func quantizeInt8(vec []float32) []int8 {
+	var scale float64
+	for _, v := range vec {
+		scale = math.Max(scale, math.Abs(float64(v)))
+	}
+	out := make([]int8, len(vec))
+	if scale == 0 {
+		return out
+	}
+	for i, v := range vec {
+		out[i] = int8(math.Round(float64(v) / scale * 127))
+	}
+	return out
+}
+
+// quantizeBinary keeps the sign of every component, as a DuckDB bit string.
+func quantizeBinary(vec []float32) string {
+	var b strings.Builder
+	b.Grow(len(vec))
+	for _, v := range vec {
+		if v > 0 {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func int8SliceToList(vec []int8) string {
+	var b strings.Builder
+	b.Grow(len(vec) * 4)
+	b.WriteByte('[')
+	for i, v := range vec {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// ConvertEmbeddingStorage converts the stored vectors to the configured mode:
+// floats to int8 or bits, and int8 to bits. Going the other way cannot bring
+// back the precision that is gone, so rows stored more coarsely than
+// configured count as missing instead and are embedded again by
+// /fixEmbeddings. A sample of the full-precision vectors is kept aside first
+// for the quantization report; without it nothing is converted.
+func ConvertEmbeddingStorage() {
+	mode := EmbeddingQuantization()
+	if mode == QuantizationNone {
+		return
+	}
+	if err := keepReportSamples(); err != nil {
+		slog.Error("Failed to keep a sample of the embeddings for the quantization report, not converting them", slog.Any("err", err))
+		return
+	}
+
+	for _, table := range []string{"message_embeddings", "message_embedding_chunks"} {
+		var query string
+		switch mode {
+		case QuantizationInt8:
+			// The scale is computed once per row in a subquery; a lambda that
+			// took the maximum itself would do so once per component.
+			query = fmt.Sprintf(`
+				UPDATE %[1]s
+				SET embedding_int8 = list_transform(%[1]s.embedding, x -> COALESCE(round(x / NULLIF(s.scale, 0) * 127), 0)::TINYINT),
+				    embedding = NULL
+				FROM (
+					SELECT rowid AS row_id, list_max(list_transform(embedding, x -> abs(x))) AS scale
+					FROM %[1]s
+					WHERE embedding IS NOT NULL
+				) s
+				WHERE %[1]s.rowid = s.row_id`, table)
+		case QuantizationBinary:
+			query = fmt.Sprintf(`
+				UPDATE %s
+				SET embedding_bits = array_to_string(list_transform(COALESCE(embedding, embedding_int8::FLOAT[]), x -> CASE WHEN x > 0 THEN '1' ELSE '0' END), '')::BIT,
+				    embedding = NULL,
+				    embedding_int8 = NULL
+				WHERE embedding IS NOT NULL OR embedding_int8 IS NOT NULL`, table)
+		}
+
+		res, err := duckdbClient.Exec(query)
+		if err != nil {
+			slog.Error("Failed to convert embeddings", slog.String("table", table), slog.String("mode", mode), slog.Any("err", err))
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			slog.Info("Converted embeddings", slog.String("table", table), slog.String("mode", mode), slog.Int64("rows", n))
+		}
+	}
+
+	// Freed blocks are only handed back to the file on a checkpoint.
+	if _, err := duckdbClient.Exec(`CHECKPOINT`); err != nil {
+		slog.Warn("Failed to checkpoint after converting embeddings", slog.Any("err", err))
+	}
+}
+
+// keepReportSamples copies full-precision message vectors into
+// embedding_report_samples, topping every model up to
+// quantizationReportCorpus, before ConvertEmbeddingStorage empties the
+// embedding column.
+func keepReportSamples() error {
+	rows, err := duckdbClient.Query(`SELECT DISTINCT model FROM message_embeddings WHERE embedding IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	var models []string
+	for rows.Next() {
+		var model string
+		if err := rows.Scan(&model); err != nil {
+			rows.Close()
+			return err
+		}
+		models = append(models, model)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, model := range models {
+		var kept int
+		if err := duckdbClient.QueryRow(`SELECT COUNT(*) FROM embedding_report_samples WHERE model = ?`, model).Scan(&kept); err != nil {
+			return err
+		}
+		if kept >= quantizationReportCorpus {
+			continue
+		}
+		// The sample size cannot be bound as a parameter.
+		_, err := duckdbClient.Exec(fmt.Sprintf(`
+			INSERT INTO embedding_report_samples (id, model, embedding)
+			SELECT id, model, embedding FROM (
+				SELECT id, model, embedding FROM message_embeddings
+				WHERE model = ? AND embedding IS NOT NULL
+				AND id NOT IN (SELECT id FROM embedding_report_samples WHERE model = ?)
+			) USING SAMPLE reservoir(%d ROWS)
+			ON CONFLICT DO NOTHING`, quantizationReportCorpus-kept),
+			model, model,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rescoreQuantized replaces the estimated scores of matches read from
+// quantized vectors with exact ones and re-ranks them. The full-precision
+// vectors are not stored, so the matched text is embedded again, which costs
+// a search one pipeline run over at most quantizedMaxRescore texts; a match
+// past that, or whose text fails to embed, keeps its estimate.
+func rescoreQuantized(query []float32, model string, results []SemanticSearchResult) []SemanticSearchResult {
+	var texts []string
+	var indexes []int
+	for i, r := range results {
+		if !r.quantized {
+			continue
+		}
+		if len(texts) == quantizedMaxRescore {
+			break
+		}
+		text := strings.TrimSpace(r.Content)
+		if r.MatchEnd > r.MatchStart && r.MatchEnd <= len(text) {
+			text = text[r.MatchStart:r.MatchEnd]
+		}
+		texts = append(texts, text)
+		indexes = append(indexes, i)
+	}
+	if len(texts) == 0 {
+		return results
+	}
+
+	started := time.Now()
+	vecs, err := embeddings.EmbedBatch(model, texts)
+	if err != nil {
+		slog.Warn("Failed to rescore quantized matches", slog.String("model", model), slog.Any("err", err))
+		return results
+	}
+	slog.Debug("Rescored quantized matches", slog.String("model", model), slog.Int("matches", len(texts)), slog.Duration("took", time.Since(started)))
+	for j, vec := range vecs {
+		if vec != nil {
+			results[indexes[j]].Score = cosineSimilarity(query, vec)
+		}
+	}
+
+	slices.SortStableFunc(results, func(a, b SemanticSearchResult) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		default:
+			return 0
+		}
+	})
+	return results
+}
+
+// QuantizationStats describes how one storage mode would do on a model's
+// embeddings.
+type QuantizationStats struct {
+	Mode string
+	// Bytes is the estimated size of the vectors in this mode.
+	Bytes int64
+	// Recall is the share of the exact top 10 found in the top 10 by this
+	// mode's scores, and RescoredRecall the share found in the top
+	// quantizedRescoreFactor*10, which are re-ranked exactly. Both are -1
+	// when they could not be measured.
+	Recall         float64
+	RescoredRecall float64
+}
+
+// QuantizationReport compares the storage modes on a model's embeddings.
+type QuantizationReport struct {
+	Model string
+	Mode  string
+	// Vectors counts the stored message and chunk vectors per storage mode.
+	Vectors    map[string]int
+	Dimensions int
+	// DatabaseBytes is the size of statsbot.db on disk.
+	DatabaseBytes int64
+	// Samples is how many vectors recall was measured on. Recall needs
+	// full-precision vectors: the ones still stored, and the sample kept
+	// aside when they were converted, which Kept counts.
+	Samples int
+	Kept    int
+	Modes   []QuantizationStats
+}
+
+// GetQuantizationReport estimates what each storage mode saves on a model's
+// embeddings and what it costs in recall. Recall is measured on a sample of
+// the full-precision vectors still stored or kept aside by
+// ConvertEmbeddingStorage: each sampled vector is searched for among the
+// others by exact cosine similarity and by each mode's quantized scores.
+func GetQuantizationReport(model string, samples int) (QuantizationReport, error) {
+	report := QuantizationReport{
+		Model:   model,
+		Mode:    EmbeddingQuantization(),
+		Vectors: make(map[string]int),
+	}
+
+	rows, err := duckdbClient.Query(`
+		SELECT CASE
+		         WHEN embedding IS NOT NULL THEN 'none'
+		         WHEN embedding_int8 IS NOT NULL THEN 'int8'
+		         ELSE 'binary'
+		       END AS storage,
+		       COUNT(*),
+		       MAX(COALESCE(len(embedding), len(embedding_int8), bit_length(embedding_bits)))
+		FROM (
+			SELECT embedding, embedding_int8, embedding_bits FROM message_embeddings WHERE model = ?
+			UNION ALL
+			SELECT embedding, embedding_int8, embedding_bits FROM message_embedding_chunks WHERE model = ?
+		)
+		GROUP BY storage`,
+		model, model,
+	)
+	if err != nil {
+		return report, err
+	}
+	total := 0
+	for rows.Next() {
+		var storage string
+		var count, dims int
+		if err := rows.Scan(&storage, &count, &dims); err != nil {
+			rows.Close()
+			return report, err
+		}
+		report.Vectors[storage] = count
+		report.Dimensions = max(report.Dimensions, dims)
+		total += count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	if err := duckdbClient.QueryRow(`SELECT COUNT(*) FROM embedding_report_samples WHERE model = ?`, model).Scan(&report.Kept); err != nil {
+		return report, err
+	}
+
+	if info, err := os.Stat(filepath.Join(util.ConfigFile.DUCKDB_PATH, "statsbot.db")); err == nil {
+		report.DatabaseBytes = info.Size()
+	}
+
+	corpus, err := sampleFloatEmbeddings(model, quantizationReportCorpus)
+	if err != nil {
+		return report, err
+	}
+	queries := min(samples, len(corpus))
+	if len(corpus) <= quantizationReportTopK {
+		queries = 0
+	}
+	report.Samples = queries
+
+	// Per-vector sizes: 4 bytes per float, 1 per int8, 1 bit per bit string
+	// plus the byte DuckDB pads it with.
+	sizes := map[string]int64{
+		QuantizationNone:   int64(report.Dimensions) * 4,
+		QuantizationInt8:   int64(report.Dimensions),
+		QuantizationBinary: int64(report.Dimensions+7)/8 + 1,
+	}
+	for _, mode := range []string{QuantizationNone, QuantizationInt8, QuantizationBinary} {
+		stats := QuantizationStats{Mode: mode, Bytes: int64(total) * sizes[mode], Recall: -1, RescoredRecall: -1}
+		if queries > 0 {
+			stats.Recall, stats.RescoredRecall = measureRecall(corpus, queries, mode)
+		}
+		report.Modes = append(report.Modes, stats)
+	}
+	return report, nil
+}
+
+// sampleFloatEmbeddings samples the model's full-precision message vectors,
+// from the stored ones and the ones kept aside for the report.
+func sampleFloatEmbeddings(model string, limit int) ([][]float32, error) {
+	// The sample size cannot be bound as a parameter.
+	rows, err := duckdbClient.Query(fmt.Sprintf(`
+		SELECT embedding FROM (
+			SELECT id, embedding FROM message_embeddings
+			WHERE model = ? AND embedding IS NOT NULL
+			UNION ALL
+			SELECT id, embedding FROM embedding_report_samples
+			WHERE model = ?
+			AND id NOT IN (SELECT id FROM message_embeddings WHERE model = ? AND embedding IS NOT NULL)
+		)
+		USING SAMPLE reservoir(%d ROWS)`, limit),
+		model, model, model,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result [][]float32
+	for rows.Next() {
+		var list []any
+		if err := rows.Scan(&list); err != nil {
+			return nil, err
+		}
+		vec := make([]float32, len(list))
+		for i, v := range list {
+			vec[i], _ = v.(float32)
+		}
+		result = append(result, vec)
+	}
+	return result, rows.Err()
+}
+
+// measureRecall searches the corpus for the first queries of its vectors,
+// leaving out the query itself, and compares a mode's top results with the
+// exact ones.
+func measureRecall(corpus [][]float32, queries int, mode string) (recall, rescored float64) {
+	quantized := make([][]float32, len(corpus))
+	for i, vec := range corpus {
+		quantized[i] = dequantize(vec, mode)
+	}
+
+	order := rand.Perm(len(corpus))[:queries]
+	var hits, rescoredHits int
+	for _, q := range order {
+		exact := topK(corpus[q], corpus, q, quantizationReportTopK)
+		approx := topK(dequantize(corpus[q], mode), quantized, q, quantizationReportTopK*quantizedRescoreFactor)
+		for _, id := range exact {
+			if i := slices.Index(approx, id); i >= 0 {
+				rescoredHits++
+				if i < quantizationReportTopK {
+					hits++
+				}
+			}
+		}
+	}
+	n := float64(queries * quantizationReportTopK)
+	return float64(hits) / n, float64(rescoredHits) / n
+}
+
+// dequantize is what the similarity of a mode sees of a vector.
+func dequantize(vec []float32, mode string) []float32 {
+	out := make([]float32, len(vec))
+	switch mode {
+	case QuantizationInt8:
+		for i, v := range quantizeInt8(vec) {
+			out[i] = float32(v)
+		}
+	case QuantizationBinary:
+		for i, b := range quantizeBinary(vec) {
+			out[i] = -1
+			if b == '1' {
+				out[i] = 1
+			}
+		}
+	default:
+		copy(out, vec)
+	}
+	return out
+}
+
+// topK returns the indexes of the k vectors most similar to the query,
+// skipping the query's own index.
+func topK(query []float32, vecs [][]float32, skip, k int) []int {
+	scores := make([]float64, len(vecs))
+	ids := make([]int, 0, len(vecs)-1)
+	for i, vec := range vecs {
+		if i == skip {
+			continue
+		}
+		scores[i] = cosineSimilarity(query, vec)
+		ids = append(ids, i)
+	}
+	slices.SortFunc(ids, func(a, b int) int {
+		switch {
+		case scores[a] > scores[b]:
+			return -1
+		case scores[a] < scores[b]:
+			return 1
+		default:
+			return 0
+		}
+	})
+	return ids[:min(k, len(ids))]
+}
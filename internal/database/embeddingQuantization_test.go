@@ -0,0 +1,69 @@
+package database
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestQuantizeInt8(t *testing.T) {
+	got := quantizeInt8([]float32{0.5, -0.25, 0.1, 0, -0.5})
+	want := []int8{127, -64, 25, 0, -127}
+	if !slices.Equal(got, want) {
+		t.Errorf("quantizeInt8 = %v, want %v", got, want)
+	}
+
+	if got := quantizeInt8([]float32{0, 0, 0}); !slices.Equal(got, []int8{0, 0, 0}) {
+		t.Errorf("zero vector quantized to %v", got)
+	}
+}
+
+// quantizeInt8 has to agree with the SQL ConvertEmbeddingStorage converts
+// stored rows with, or a converted row and a newly stored one would differ.
+func TestQuantizeInt8MatchesSQL(t *testing.T) {
+	vec := []float32{0.31, -0.82, 0.07, 0.55, -0.12}
+	var list []any
+	err := duckdbClient.QueryRow(`
+		SELECT list_transform(embedding, x -> COALESCE(round(x / NULLIF(scale, 0) * 127), 0)::TINYINT)
+		FROM (SELECT embedding, list_max(list_transform(embedding, x -> abs(x))) AS scale
+		      FROM (SELECT ` + floatSliceToList(vec) + `::FLOAT[] AS embedding))`).Scan(&list)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	got := quantizeInt8(vec)
+	if len(list) != len(got) {
+		t.Fatalf("SQL returned %d components, want %d", len(list), len(got))
+	}
+	for i, v := range list {
+		if v.(int8) != got[i] {
+			t.Errorf("component %d: SQL %v, Go %v", i, v, got[i])
+		}
+	}
+}
+
+func TestMeasureRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	corpus := make([][]float32, 200)
+	for i := range corpus {
+		corpus[i] = make([]float32, 32)
+		for d := range corpus[i] {
+			corpus[i][d] = float32(rng.NormFloat64())
+		}
+	}
+
+	recall, rescored := measureRecall(corpus, 20, QuantizationNone)
+	if recall != 1 || rescored != 1 {
+		t.Errorf("full precision recall = %v / %v, want 1 / 1", recall, rescored)
+	}
+
+	for _, mode := range []string{QuantizationInt8, QuantizationBinary} {
+		recall, rescored := measureRecall(corpus, 20, mode)
+		if recall <= 0 || recall > 1 || rescored < recall || rescored > 1 {
+			t.Errorf("%s recall = %v, rescored %v: want 0 < recall <= rescored <= 1", mode, recall, rescored)
+		}
+	}
+	// int8 keeps nearly all of a vector; its neighbours should barely move.
+	if recall, _ := measureRecall(corpus, 20, QuantizationInt8); recall < 0.9 {
+		t.Errorf("int8 recall = %v, want at least 0.9", recall)
+	}
+}
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/embeddings"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+const (
+	defaultEmbeddingBatchSize = 16
+	defaultEmbeddingQueueSize = 1000
+
+	// embeddingBatchWait is how long a worker waits for a batch to fill up
+	// before embedding what it has.
+	embeddingBatchWait = 50 * time.Millisecond
+	// embeddingMaxAttempts bounds how often a message is tried before it is
+	// given up on; /fixEmbeddings picks it up again later.
+	embeddingMaxAttempts = 3
+	embeddingRetryDelay  = 2 * time.Second
+)
+
+// embeddingJob is a single message waiting to be embedded with a model. done,
+// when set, is called once with the outcome.
+type embeddingJob struct {
+	model   string
+	id      string
+	content string
+	attempt int
+	done    func(ok bool)
+}
+
+// EmbeddingQueueStats is a snapshot of the embedding queue's counters since
+// the bot started.
+type EmbeddingQueueStats struct {
+	Workers   int   `json:"workers"`
+	BatchSize int   `json:"batch_size"`
+	Capacity  int   `json:"capacity"`
+	Depth     int   `json:"depth"`
+	Retrying  int64 `json:"retrying"`
+	Enqueued  int64 `json:"enqueued"`
+	Embedded  int64 `json:"embedded"`
+	Failed    int64 `json:"failed"`
+	Retried   int64 `json:"retried"`
+	Dropped   int64 `json:"dropped"`
+	// PerMinute is how many messages were embedded in the last minute.
+	PerMinute int64 `json:"per_minute"`
+}
+
+// embeddingQueue batches messages into single pipeline runs on a fixed number
+// of workers. The channel is the backpressure: Enqueue blocks while it is
+// full, TryEnqueue drops instead.
+type embeddingQueue struct {
+	startOnce sync.Once
+	jobs      chan embeddingJob
+	workers   int
+	batchSize int
+
+	retrying atomic.Int64
+	enqueued atomic.Int64
+	embedded atomic.Int64
+	failed   atomic.Int64
+	retried  atomic.Int64
+	dropped  atomic.Int64
+
+	// perSecond counts embedded messages per second over the last minute,
+	// indexed by unix second modulo its length.
+	mu        sync.Mutex
+	perSecond [60]int64
+	seconds   [60]int64
+}
+
+var embedQueue embeddingQueue
+
+func configuredInt(value string, fallback int) int {
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// start sizes the queue from the config and launches the workers the first
+// time a message is queued.
+func (q *embeddingQueue) start() {
+	q.startOnce.Do(func() {
+		q.workers = embeddings.Workers()
+		q.batchSize = configuredInt(util.ConfigFile.EMBEDDING_BATCH_SIZE, defaultEmbeddingBatchSize)
+		q.jobs = make(chan embeddingJob, configuredInt(util.ConfigFile.EMBEDDING_QUEUE_SIZE, defaultEmbeddingQueueSize))
+
+		for range q.workers {
+			go q.work()
+		}
+	})
+}
+
+// Enqueue adds a job, waiting for room when the queue is full.
+func (q *embeddingQueue) Enqueue(job embeddingJob) {
+	q.start()
+	q.enqueued.Add(1)
+	q.jobs <- job
+}
+
+// TryEnqueue adds a job unless the queue is full. Used on the gateway path,
+// which must never block; a dropped message is left for /fixEmbeddings.
+func (q *embeddingQueue) TryEnqueue(job embeddingJob) bool {
+	q.start()
+	select {
+	case q.jobs <- job:
+		q.enqueued.Add(1)
+		return true
+	default:
+		q.dropped.Add(1)
+		slog.Warn("embedding queue full, dropping message", slog.String("id", job.id))
+		return false
+	}
+}
+
+func (q *embeddingQueue) work() {
+	for job := range q.jobs {
+		batch := []embeddingJob{job}
+
+		timer := time.NewTimer(embeddingBatchWait)
+	collect:
+		for len(batch) < q.batchSize {
+			select {
+			case job := <-q.jobs:
+				batch = append(batch, job)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		q.process(batch)
+	}
+}
+
+// process embeds a batch, one pipeline run per model in it, and stores the
+// vectors. Long messages are split into chunks first, and all chunks of the
+// batch go through the same run.
+func (q *embeddingQueue) process(batch []embeddingJob) {
+	byModel := make(map[string][]embeddingJob)
+	for _, job := range batch {
+		byModel[job.model] = append(byModel[job.model], job)
+	}
+
+	for model, jobs := range byModel {
+		var texts []string
+		chunks := make([][]embeddings.Chunk, len(jobs))
+		for i, job := range jobs {
+			split, err := embeddings.Split(model, job.content)
+			if err != nil {
+				slog.Error("failed to split message", slog.String("id", job.id), slog.String("model", model), slog.Any("err", err))
+				continue
+			}
+			chunks[i] = split
+			for _, chunk := range split {
+				texts = append(texts, chunk.Text)
+			}
+		}
+
+		var vecs [][]float32
+		if len(texts) > 0 {
+			var err error
+			vecs, err = embeddings.EmbedBatch(model, texts)
+			if err != nil {
+				slog.Error("failed to embed batch", slog.String("model", model), slog.Int("size", len(texts)), slog.Any("err", err))
+				for _, job := range jobs {
+					q.retry(job)
+				}
+				continue
+			}
+		}
+
+		offset := 0
+		for i, job := range jobs {
+			if chunks[i] == nil {
+				q.retry(job)
+				continue
+			}
+			jobVecs := vecs[offset : offset+len(chunks[i])]
+			offset += len(chunks[i])
+
+			if slices.ContainsFunc(jobVecs, func(vec []float32) bool { return vec == nil }) {
+				slog.Error("failed to embed message", slog.String("id", job.id), slog.String("model", model))
+				q.retry(job)
+				continue
+			}
+			if err := saveEmbeddings(job.id, model, chunks[i], jobVecs); err != nil {
+				slog.Error("failed to store message embedding", slog.String("id", job.id), slog.String("model", model), slog.Any("err", err))
+				q.retry(job)
+				continue
+			}
+			q.succeed(job)
+		}
+	}
+}
+
+// saveEmbeddings stores the first chunk's vector as the message's vector and
+// the rest as its chunks, then brings the model's vector index up to date. A
+// message embedded for the first time is also folded into its author's
+// profile; edits are not, so a profile may lag behind edited messages until
+// it is rebuilt.
+func saveEmbeddings(id, model string, chunks []embeddings.Chunk, vecs [][]float32) error {
+	var existed bool
+	err := duckdbClient.QueryRow(`SELECT EXISTS (SELECT 1 FROM message_embeddings WHERE id = ? AND model = ?)`, id, model).Scan(&existed)
+	if err != nil {
+		return err
+	}
+
+	if err := SaveMessageEmbeddings(id, model, chunks, vecs); err != nil {
+		return err
+	}
+	indexMessageEmbeddings(id, model, vecs)
+	if !existed {
+		updateUserProfile(id, model, vecs[0])
+	}
+	return nil
+}
+
+// retry puts a failed job back on the queue after a growing delay, or gives
+// up on it after embeddingMaxAttempts.
+func (q *embeddingQueue) retry(job embeddingJob) {
+	job.attempt++
+	if job.attempt >= embeddingMaxAttempts {
+		q.failed.Add(1)
+		if job.done != nil {
+			job.done(false)
+		}
+		return
+	}
+
+	q.retried.Add(1)
+	q.retrying.Add(1)
+	time.AfterFunc(embeddingRetryDelay*time.Duration(1<<(job.attempt-1)), func() {
+		q.retrying.Add(-1)
+		q.jobs <- job
+	})
+}
+
+func (q *embeddingQueue) succeed(job embeddingJob) {
+	q.embedded.Add(1)
+
+	now := time.Now().Unix()
+	q.mu.Lock()
+	slot := now % int64(len(q.perSecond))
+	if q.seconds[slot] != now {
+		q.seconds[slot], q.perSecond[slot] = now, 0
+	}
+	q.perSecond[slot]++
+	q.mu.Unlock()
+
+	if job.done != nil {
+		job.done(true)
+	}
+}
+
+// GetEmbeddingQueueStats returns the current state of the embedding queue.
+func GetEmbeddingQueueStats() EmbeddingQueueStats {
+	q := &embedQueue
+	q.start()
+
+	stats := EmbeddingQueueStats{
+		Workers:   q.workers,
+		BatchSize: q.batchSize,
+		Capacity:  cap(q.jobs),
+		Depth:     len(q.jobs),
+		Retrying:  q.retrying.Load(),
+		Enqueued:  q.enqueued.Load(),
+		Embedded:  q.embedded.Load(),
+		Failed:    q.failed.Load(),
+		Retried:   q.retried.Load(),
+		Dropped:   q.dropped.Load(),
+	}
+
+	now := time.Now().Unix()
+	q.mu.Lock()
+	for i, second := range q.seconds {
+		if now-second < int64(len(q.seconds)) {
+			stats.PerMinute += q.perSecond[i]
+		}
+	}
+	q.mu.Unlock()
+	return stats
+}
@@ -3,8 +3,10 @@ package database
 import (
 	"fmt"
 	"log/slog"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/stollenaar/statisticsbot/internal/embeddings"
@@ -20,6 +22,13 @@ type SemanticSearchResult struct {
 	Content   string
 	Date      time.Time
 	Score     float64
+	// MatchStart and MatchEnd are the byte offsets into the trimmed Content of
+	// the chunk that scored best. Both are 0 when the message was embedded
+	// whole.
+	MatchStart int
+	MatchEnd   int
+	// quantized is set when Score was estimated from a quantized vector.
+	quantized bool
 }
 
 // floatSliceToList renders a vector as a DuckDB list literal (e.g. "[0.1,0.2]").
@@ -38,32 +47,133 @@ func floatSliceToList(vec []float32) string {
 	return b.String()
 }
 
-// SaveMessageEmbedding upserts the embedding vector for a message. Only the
-// vector is stored; content and metadata live in the messages table and are
-// joined back via the message id.
-func SaveMessageEmbedding(id, model string, vec []float32) error {
+// SaveMessageEmbeddings upserts the embedding vector a model produced for a
+// message, and replaces its chunk vectors, in one transaction so a search
+// never sees the message vector of one version with the chunks of another.
+// vecs[0] is the message vector. A message that fits in a single chunk only
+// has its old chunks removed, in case an edit made it shorter. Vectors are
+// quantized when EMBEDDING_QUANTIZATION asks for it, and only the vectors are
+// stored; content and metadata live in the messages table and are joined back
+// via the message id.
+func SaveMessageEmbeddings(id, model string, chunks []embeddings.Chunk, vecs [][]float32) error {
+	tx, err := duckdbClient.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// The embedding is inlined as a numeric list literal; the driver does not
 	// bind Go slices as DuckDB lists.
-	query := fmt.Sprintf(`
-		INSERT INTO message_embeddings (id, model, embedding)
+	_, err = tx.Exec(fmt.Sprintf(`
+		INSERT INTO message_embeddings (id, model, embedding, embedding_int8, embedding_bits)
 		VALUES (?, ?, %s)
-		ON CONFLICT (id) DO UPDATE SET
+		ON CONFLICT (id, model) DO UPDATE SET
 			embedding = EXCLUDED.embedding,
-			model = EXCLUDED.model`, floatSliceToList(vec))
-	_, err := duckdbClient.Exec(query, id, model)
-	return err
+			embedding_int8 = EXCLUDED.embedding_int8,
+			embedding_bits = EXCLUDED.embedding_bits`, embeddingValues(vecs[0])),
+		id, model,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_embedding_chunks WHERE id = ? AND model = ?`, id, model); err != nil {
+		return err
+	}
+	if len(chunks) > 1 {
+		for i, chunk := range chunks {
+			_, err := tx.Exec(fmt.Sprintf(`
+				INSERT INTO message_embedding_chunks (id, model, chunk_index, start_offset, end_offset, embedding, embedding_int8, embedding_bits)
+				VALUES (?, ?, ?, ?, ?, %s)`, embeddingValues(vecs[i])),
+				id, model, chunk.Index, chunk.Start, chunk.End,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
 }
 
 // SearchSimilarMessages returns the messages in a guild most similar to the
-// given query vector, ordered by descending cosine similarity. Content and
-// metadata are read from the latest version of each message. Only embeddings
-// produced by the given model are compared, so a model change (with a different
-// vector dimension) does not break the similarity function.
+// given query vector, ordered by descending cosine similarity. A message split
+// into chunks scores as its best chunk, which is returned as the match.
+// Content and metadata are read from the latest version of each message. Only
+// embeddings produced by the given model are compared, so a model change (with
+// a different vector dimension) does not break the similarity function.
+//
+// When the model's vector index is up to date, only the candidates it returns
+// are scored; otherwise, or when too few candidates belong to the guild, every
+// embedding is. Scores of quantized vectors are estimates, so with
+// quantization on a larger pool is scored and its matches are re-ranked
+// exactly.
 func SearchSimilarMessages(guildID string, vec []float32, model string, limit int) ([]SemanticSearchResult, error) {
+	pool := limit
+	if EmbeddingQuantization() != QuantizationNone {
+		pool = limit * quantizedRescoreFactor
+	}
+
+	results, err := searchCandidateMessages(guildID, vec, model, pool)
+	if err != nil {
+		return nil, err
+	}
+	results = rescoreQuantized(vec, model, results)
+	return results[:min(limit, len(results))], nil
+}
+
+func searchCandidateMessages(guildID string, vec []float32, model string, limit int) ([]SemanticSearchResult, error) {
+	k := max(limit*vectorIndexOversample, vectorIndexMinCandidates)
+	candidates, ok := getVectorIndex(model).candidates(vec, k)
+	if !ok || len(candidates) == 0 {
+		return searchSimilarMessages(guildID, vec, model, limit, nil)
+	}
+
+	results, err := searchSimilarMessages(guildID, vec, model, limit, candidates)
+	if err != nil || len(results) == limit || len(candidates) < k {
+		return results, err
+	}
+	return searchSimilarMessages(guildID, vec, model, limit, nil)
+}
+
+// searchSimilarMessages scores the given candidate messages, or every message
+// when candidates is nil.
+func searchSimilarMessages(guildID string, vec []float32, model string, limit int, candidates []string) ([]SemanticSearchResult, error) {
+	candidateFilter := ""
+	var candidateParams []any
+	if candidates != nil {
+		candidateFilter = "AND id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(candidates)), ", ") + ")"
+		for _, id := range candidates {
+			candidateParams = append(candidateParams, id)
+		}
+	}
+
+	params := []any{model}
+	params = append(params, candidateParams...)
+	params = append(params, model)
+	params = append(params, candidateParams...)
+	params = append(params, guildID, limit)
+
 	query := fmt.Sprintf(`
+		WITH scored AS (
+			SELECT id, 0 AS start_offset, 0 AS end_offset,
+			       %[1]s AS score, embedding IS NULL AS quantized
+			FROM message_embeddings
+			WHERE model = ? %[2]s
+			UNION ALL
+			SELECT id, start_offset, end_offset,
+			       %[1]s AS score, embedding IS NULL AS quantized
+			FROM message_embedding_chunks
+			WHERE model = ? %[2]s
+		), best AS (
+			SELECT id, start_offset, end_offset, score, quantized
+			FROM scored
+			-- The first chunk is embedded from the same text as the whole
+			-- message, so on a tie the chunk wins and its offsets are kept.
+			QUALIFY ROW_NUMBER() OVER (PARTITION BY id ORDER BY score DESC, end_offset > 0 DESC) = 1
+		)
 		SELECT m.id, m.channel_id, COALESCE(m.author_id, ''), m.content, m.date,
-		       list_cosine_similarity(e.embedding, %s::FLOAT[]) AS score
-		FROM message_embeddings e
+		       b.score, b.start_offset, b.end_offset, b.quantized
+		FROM best b
 		JOIN (
 			SELECT m.id, m.guild_id, m.channel_id, m.author_id, m.content, m.date
 			FROM messages m
@@ -73,12 +183,11 @@ func SearchSimilarMessages(guildID string, vec []float32, model string, limit in
 				GROUP BY id
 			) latest ON m.id = latest.id AND m.version = latest.latest_version
 			WHERE m.guild_id = ?
-		) m ON m.id = e.id
-		WHERE e.model = ?
-		ORDER BY score DESC
-		LIMIT ?`, floatSliceToList(vec))
+		) m ON m.id = b.id
+		ORDER BY b.score DESC
+		LIMIT ?`, similarityExpr(vec), candidateFilter)
 
-	rows, err := duckdbClient.Query(query, guildID, model, limit)
+	rows, err := duckdbClient.Query(query, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +196,7 @@ func SearchSimilarMessages(guildID string, vec []float32, model string, limit in
 	var results []SemanticSearchResult
 	for rows.Next() {
 		var r SemanticSearchResult
-		if err := rows.Scan(&r.MessageID, &r.ChannelID, &r.AuthorID, &r.Content, &r.Date, &r.Score); err != nil {
+		if err := rows.Scan(&r.MessageID, &r.ChannelID, &r.AuthorID, &r.Content, &r.Date, &r.Score, &r.MatchStart, &r.MatchEnd, &r.quantized); err != nil {
 			return nil, err
 		}
 		results = append(results, r)
@@ -95,10 +204,68 @@ func SearchSimilarMessages(guildID string, vec []float32, model string, limit in
 	return results, rows.Err()
 }
 
+// FillSearchResults fills in the channel, author, content and date of results
+// that only carry a message id and score, such as the ones a paginated search
+// stored in its session, from the latest version of each message. The order
+// is kept; messages no longer stored are dropped.
+func FillSearchResults(results []SemanticSearchResult) ([]SemanticSearchResult, error) {
+	if len(results) == 0 {
+		return nil, nil
+	}
+	var params []any
+	for _, r := range results {
+		params = append(params, r.MessageID)
+	}
+
+	rows, err := duckdbClient.Query(`
+		SELECT id, channel_id, COALESCE(author_id, ''), content, date
+		FROM messages
+		WHERE id IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(params)), ", ")+`)
+		QUALIFY ROW_NUMBER() OVER (PARTITION BY id ORDER BY version DESC) = 1`,
+		params...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := make(map[string]SemanticSearchResult, len(results))
+	for rows.Next() {
+		var r SemanticSearchResult
+		if err := rows.Scan(&r.MessageID, &r.ChannelID, &r.AuthorID, &r.Content, &r.Date); err != nil {
+			return nil, err
+		}
+		stored[r.MessageID] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var filled []SemanticSearchResult
+	for _, r := range results {
+		m, ok := stored[r.MessageID]
+		if !ok {
+			continue
+		}
+		r.ChannelID, r.AuthorID, r.Content, r.Date = m.ChannelID, m.AuthorID, m.Content, m.Date
+		filled = append(filled, r)
+	}
+	return filled, nil
+}
+
 // GetMessagesWithoutEmbeddings returns the latest version of every stored
-// message that does not yet have an embedding. A limit <= 0 means no limit.
-func GetMessagesWithoutEmbeddings(limit int) ([]util.MessageObject, error) {
-	query := `
+// message that does not yet have an embedding from the given model, or only
+// one stored more coarsely than EMBEDDING_QUANTIZATION asks for. A limit <= 0
+// means no limit.
+func GetMessagesWithoutEmbeddings(model string, limit int) ([]util.MessageObject, error) {
+	return getMessagesWithoutEmbeddings("", model, limit)
+}
+
+// getMessagesWithoutEmbeddings is GetMessagesWithoutEmbeddings, optionally
+// limited to a single guild.
+func getMessagesWithoutEmbeddings(guildID, model string, limit int) ([]util.MessageObject, error) {
+	params := []any{model}
+	query := fmt.Sprintf(`
 		SELECT m.id, m.guild_id, m.channel_id, m.author_id, m.content, m.date
 		FROM messages m
 		JOIN (
@@ -106,13 +273,17 @@ func GetMessagesWithoutEmbeddings(limit int) ([]util.MessageObject, error) {
 			FROM messages
 			GROUP BY id
 		) latest ON m.id = latest.id AND m.version = latest.latest_version
-		LEFT JOIN message_embeddings e ON e.id = m.id
-		WHERE e.id IS NULL AND m.content <> ''`
+		LEFT JOIN message_embeddings e ON e.id = m.id AND e.model = ?
+		WHERE e.%s IS NULL AND m.content <> ''`, embeddingStorageColumn(EmbeddingQuantization()))
+	if guildID != "" {
+		query += "\n\t\tAND m.guild_id = ?"
+		params = append(params, guildID)
+	}
 	if limit > 0 {
 		query += fmt.Sprintf("\n\t\tLIMIT %d", limit)
 	}
 
-	rows, err := duckdbClient.Query(query)
+	rows, err := duckdbClient.Query(query, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -129,22 +300,92 @@ func GetMessagesWithoutEmbeddings(limit int) ([]util.MessageObject, error) {
 	return result, rows.Err()
 }
 
-// EmbedMessage generates and stores an embedding for a single message. It is
-// best-effort: empty content is skipped and failures are logged, not returned,
-// so it can be fired off from ingestion and backfill paths.
-func EmbedMessage(id, content string) {
+// EmbedMessage queues a single message for embedding with the configured
+// model, with the model the guild's searches use when that is another one, and
+// with the model a running migration moves the guild to. It does not wait for
+// room in the queue, so it can be called from the gateway listeners: empty
+// content is skipped, and a message that does not fit in the queue is dropped
+// and left for /fixEmbeddings. The guild's models come from the cache Init
+// warms, so only the first message of a guild the bot joined since asks the
+// database for them.
+//
+// embedded, when set, is called from an embedding worker once the vector of
+// the model the guild searches with is stored.
+func EmbedMessage(guildID, id, content string, embedded func(model string)) {
 	content = strings.TrimSpace(content)
 	if content == "" {
 		return
 	}
 
-	vec, err := embeddings.Embed(content)
+	models := []string{embeddings.ModelName()}
+	serving, err := ServingEmbeddingModel(guildID)
 	if err != nil {
-		slog.Error("failed to embed message", slog.String("id", id), slog.Any("err", err))
-		return
+		slog.Warn("failed to fetch the guild's embedding model", slog.String("guild_id", guildID), slog.Any("err", err))
+	} else if serving != models[0] {
+		models = append(models, serving)
+	}
+	if migrating := migratingEmbeddingModel(guildID); migrating != "" && !slices.Contains(models, migrating) {
+		models = append(models, migrating)
+	}
+
+	for _, model := range models {
+		job := embeddingJob{model: model, id: id, content: content}
+		if embedded != nil && model == serving {
+			job.done = func(ok bool) {
+				if ok {
+					embedded(model)
+				}
+			}
+		}
+		embedQueue.TryEnqueue(job)
+	}
+}
+
+// GetMessageEmbedding returns the vector a model stored for a whole message,
+// or sql.ErrNoRows when it has not been embedded with that model.
+func GetMessageEmbedding(id, model string) ([]float32, error) {
+	var list []any
+	err := duckdbClient.QueryRow(`SELECT `+dequantizedColumn+` FROM message_embeddings WHERE id = ? AND model = ?`, id, model).Scan(&list)
+	if err != nil {
+		return nil, err
 	}
+	vec := make([]float32, len(list))
+	for i, v := range list {
+		vec[i], _ = v.(float32)
+	}
+	return vec, nil
+}
+
+// EmbedMessages queues messages for embedding with the given model and waits
+// until each is stored or given up on. Queueing waits for room, so a large
+// backfill moves at the pace of the workers. progress, when set, is called
+// once per message with its outcome; calls never overlap.
+func EmbedMessages(model string, messages []util.MessageObject, progress func(ok bool)) (embedded, failed int) {
+	var waitGroup sync.WaitGroup
+	var mu sync.Mutex
+
+	for _, m := range messages {
+		content := strings.TrimSpace(m.Content)
+		if content == "" {
+			continue
+		}
+
+		waitGroup.Add(1)
+		embedQueue.Enqueue(embeddingJob{model: model, id: m.MessageID, content: content, done: func(ok bool) {
+			defer waitGroup.Done()
 
-	if err := SaveMessageEmbedding(id, embeddings.ModelName(), vec); err != nil {
-		slog.Error("failed to store message embedding", slog.String("id", id), slog.Any("err", err))
+			mu.Lock()
+			defer mu.Unlock()
+			if ok {
+				embedded++
+			} else {
+				failed++
+			}
+			if progress != nil {
+				progress(ok)
+			}
+		}})
 	}
+	waitGroup.Wait()
+	return embedded, failed
 }
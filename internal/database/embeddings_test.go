@@ -0,0 +1,56 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/embeddings"
+)
+
+func TestSaveMessageEmbeddingsBestChunk(t *testing.T) {
+	const model = "test-chunks"
+	insertMessage(t, "chunks", "long", "first part of a long message, then the second part", time.Now())
+	t.Cleanup(func() {
+		duckdbClient.Exec(`DELETE FROM message_embeddings WHERE model = ?`, model)
+		duckdbClient.Exec(`DELETE FROM message_embedding_chunks WHERE model = ?`, model)
+	})
+
+	chunks := []embeddings.Chunk{{Index: 0, Start: 0, End: 28}, {Index: 1, Start: 20, End: 51}}
+	vecs := [][]float32{{1, 0, 0}, {0, 1, 0}}
+	if err := SaveMessageEmbeddings("long", model, chunks, vecs); err != nil {
+		t.Fatalf("SaveMessageEmbeddings: %v", err)
+	}
+
+	// The message vector and the first chunk score the same; the chunk's
+	// offsets must win so the match can be highlighted.
+	results, err := searchSimilarMessages("chunks", []float32{1, 0, 0}, model, 1, nil)
+	if err != nil {
+		t.Fatalf("searchSimilarMessages: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if r := results[0]; r.MatchStart != 0 || r.MatchEnd != 28 {
+		t.Errorf("matched %d-%d, want the first chunk 0-28", r.MatchStart, r.MatchEnd)
+	}
+
+	results, err = searchSimilarMessages("chunks", []float32{0, 1, 0}, model, 1, nil)
+	if err != nil {
+		t.Fatalf("searchSimilarMessages: %v", err)
+	}
+	if len(results) != 1 || results[0].MatchStart != 20 || results[0].MatchEnd != 51 {
+		t.Errorf("got %+v, want the second chunk 20-51", results)
+	}
+
+	// Saving a shorter version drops the old chunks with the new vector.
+	if err := SaveMessageEmbeddings("long", model, chunks[:1], vecs[:1]); err != nil {
+		t.Fatalf("SaveMessageEmbeddings: %v", err)
+	}
+	var stored int
+	if err := duckdbClient.QueryRow(`SELECT COUNT(*) FROM message_embedding_chunks WHERE id = 'long' AND model = ?`, model).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Errorf("%d chunks left after saving a single-chunk message, want 0", stored)
+	}
+}
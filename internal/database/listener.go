@@ -29,9 +29,14 @@ func MessageCreateListener(event *events.GuildMessageCreate) {
 		}
 		ConstructCreateMessageObject(message, message.GuildID.String(), message.Author.Bot)
 
-		// Embed newly ingested messages so they become searchable. Best-effort
-		// and off the hot path; history is backfilled via the /fixEmbeddings route.
-		go EmbedMessage(message.ID.String(), message.Content)
+		// Queue newly ingested messages for embedding so they become searchable.
+		// Best-effort and non-blocking; history is backfilled via the
+		// /fixEmbeddings route.
+		// Channels that opted in to repost detection check the message
+		// against recent ones once it is embedded.
+		EmbedMessage(message.GuildID.String(), message.ID.String(), message.Content, repostDetector(event.Client(), message))
+		sentimentQueue.TryEnqueue(message.ID.String(), message.Content)
+		moderationQueue.TryEnqueue(message.ID.String(), message.Content)
 	}
 }
 
@@ -67,8 +72,11 @@ func MessageUpdateListener(event *events.GuildMessageUpdate) {
 		constructUpdateMessageObject(message, message.GuildID.String(), message.Author.Bot)
 
 		// Re-embed the edited message so semantic search reflects the new
-		// content. SaveMessageEmbedding upserts, overwriting the old vector.
-		go EmbedMessage(message.ID.String(), message.Content)
+		// content. SaveMessageEmbeddings replaces the message's vector and its
+		// chunks in one transaction.
+		EmbedMessage(message.GuildID.String(), message.ID.String(), message.Content, nil)
+		sentimentQueue.TryEnqueue(message.ID.String(), message.Content)
+		moderationQueue.TryEnqueue(message.ID.String(), message.Content)
 	}
 }
 
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/moderation"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// FlaggedRate is the share of scored messages that were flagged in a channel
+// or week.
+type FlaggedRate struct {
+	Key     string
+	Flagged int
+	Total   int
+}
+
+func (f FlaggedRate) Rate() float64 {
+	if f.Total == 0 {
+		return 0
+	}
+	return float64(f.Flagged) / float64(f.Total)
+}
+
+// FlaggedMessage is a message whose strongest score passed the threshold.
+type FlaggedMessage struct {
+	ID        string
+	ChannelID string
+	AuthorID  string
+	Content   string
+	Date      time.Time
+	Scores    moderation.Scores
+}
+
+// latestModeratedMessages selects the latest version of the messages in a
+// guild and period, joined with their moderation scores and whether the
+// strongest one reaches the threshold. The %s is an optional channel filter.
+const latestModeratedMessages = `
+	SELECT m.id, m.channel_id, m.author_id, m.content, m.date,
+		mo.toxicity, mo.insult, mo.spam,
+		GREATEST(mo.toxicity, mo.insult, mo.spam) >= ? AS flagged
+	FROM messages m
+	JOIN (
+		SELECT id, MAX(version) AS latest_version
+		FROM messages
+		WHERE guild_id = ?
+		AND date BETWEEN ? AND ?
+		GROUP BY id
+	) latest ON m.id = latest.id AND m.version = latest.latest_version
+	JOIN message_moderation mo ON mo.id = m.id
+	WHERE m.content <> ''
+	%s`
+
+// SaveMessageModeration upserts the moderation scores of a message.
+func SaveMessageModeration(id, model string, scores moderation.Scores) error {
+	_, err := duckdbClient.Exec(`
+		INSERT INTO message_moderation (id, model, toxicity, insult, spam, scored_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			model = EXCLUDED.model,
+			toxicity = EXCLUDED.toxicity,
+			insult = EXCLUDED.insult,
+			spam = EXCLUDED.spam,
+			scored_at = EXCLUDED.scored_at`,
+		id, model, scores.Toxicity, scores.Insult, scores.Spam, time.Now(),
+	)
+	return err
+}
+
+// ScoreMessage scores and stores a single message when moderation scoring is
+// enabled. Like ClassifyMessage it is best-effort: empty content is skipped
+// and failures are logged, not returned.
+func ScoreMessage(id, content string) {
+	if !moderation.Enabled() {
+		return
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return
+	}
+
+	scores, err := moderation.Score(content)
+	if err != nil {
+		slog.Error("failed to score message", slog.String("id", id), slog.Any("err", err))
+		return
+	}
+
+	if err := SaveMessageModeration(id, moderation.ModelName(), scores); err != nil {
+		slog.Error("failed to store message moderation scores", slog.String("id", id), slog.Any("err", err))
+	}
+}
+
+// GetPeriodMessagesWithoutModeration returns the latest version of the
+// messages in a guild and period that have not been scored yet, newest first.
+// A limit <= 0 means no limit.
+func GetPeriodMessagesWithoutModeration(guildID string, channelIDs []string, start, end time.Time, limit int) ([]util.MessageObject, error) {
+	filter, params := channelFilter(channelIDs, []any{guildID, start, end})
+	query := fmt.Sprintf(`
+		SELECT m.id, m.guild_id, m.channel_id, m.author_id, m.content, m.date
+		FROM messages m
+		JOIN (
+			SELECT id, MAX(version) AS latest_version
+			FROM messages
+			WHERE guild_id = ?
+			AND date BETWEEN ? AND ?
+			GROUP BY id
+		) latest ON m.id = latest.id AND m.version = latest.latest_version
+		LEFT JOIN message_moderation mo ON mo.id = m.id
+		WHERE mo.id IS NULL AND m.content <> ''
+		%s
+		ORDER BY m.date DESC`, filter)
+	if limit > 0 {
+		query += fmt.Sprintf("\n\t\tLIMIT %d", limit)
+	}
+
+	rows, err := duckdbClient.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []util.MessageObject
+	for rows.Next() {
+		var m util.MessageObject
+		if err := rows.Scan(&m.MessageID, &m.GuildID, &m.ChannelID, &m.Author, &m.Content, &m.Date); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// FlaggedRateByChannel returns the flagged share per channel in a guild and
+// period, highest rate first. Unscored messages are left out.
+func FlaggedRateByChannel(guildID string, channelIDs []string, start, end time.Time, threshold float32) ([]FlaggedRate, error) {
+	return flaggedRates("channel_id", "flagged_count * 1.0 / total DESC, total DESC", guildID, channelIDs, start, end, threshold)
+}
+
+// FlaggedRateByWeek returns the flagged share per week in a guild and period,
+// oldest week first. Keys are the Monday starting the week, as YYYY-MM-DD.
+func FlaggedRateByWeek(guildID string, channelIDs []string, start, end time.Time, threshold float32) ([]FlaggedRate, error) {
+	return flaggedRates("strftime(date_trunc('week', date), '%Y-%m-%d')", "key ASC", guildID, channelIDs, start, end, threshold)
+}
+
+func flaggedRates(keyExpr, orderBy, guildID string, channelIDs []string, start, end time.Time, threshold float32) ([]FlaggedRate, error) {
+	filter, params := channelFilter(channelIDs, []any{threshold, guildID, start, end})
+	query := fmt.Sprintf(`
+		SELECT %s AS key, COUNT(*) FILTER (WHERE flagged) AS flagged_count, COUNT(*) AS total
+		FROM (%s)
+		GROUP BY key
+		ORDER BY %s`,
+		keyExpr, fmt.Sprintf(latestModeratedMessages, filter), orderBy,
+	)
+
+	rows, err := duckdbClient.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []FlaggedRate
+	for rows.Next() {
+		var f FlaggedRate
+		if err := rows.Scan(&f.Key, &f.Flagged, &f.Total); err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+	return result, rows.Err()
+}
+
+// GetFlaggedMessages returns up to limit flagged messages in a guild and
+// period, strongest score first.
+func GetFlaggedMessages(guildID string, channelIDs []string, start, end time.Time, threshold float32, limit int) ([]FlaggedMessage, error) {
+	filter, params := channelFilter(channelIDs, []any{threshold, guildID, start, end})
+	query := fmt.Sprintf(`
+		SELECT id, channel_id, author_id, content, date, toxicity, insult, spam
+		FROM (%s)
+		WHERE flagged
+		ORDER BY GREATEST(toxicity, insult, spam) DESC, date DESC
+		LIMIT %d`,
+		fmt.Sprintf(latestModeratedMessages, filter), limit,
+	)
+
+	rows, err := duckdbClient.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []FlaggedMessage
+	for rows.Next() {
+		var f FlaggedMessage
+		if err := rows.Scan(&f.ID, &f.ChannelID, &f.AuthorID, &f.Content, &f.Date, &f.Scores.Toxicity, &f.Scores.Insult, &f.Scores.Spam); err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+const (
+	// DefaultRepostThreshold is the similarity above which a message counts
+	// as a repost when a channel opts in without picking one.
+	DefaultRepostThreshold = 0.95
+	// repostWindow is how far back an earlier message can be to count as
+	// what was reposted.
+	repostWindow = 7 * 24 * time.Hour
+	// repostMinRunes skips short messages; "lol" and "thanks" are repeated
+	// all the time without being reposts.
+	repostMinRunes = 30
+	// repostCandidates is how many of the most similar messages are checked
+	// for a recent one in a channel that may be linked to.
+	repostCandidates = 50
+)
+
+var (
+	// repostChannels caches the threshold of every opted-in channel, so the
+	// gateway listener does not have to query for every message.
+	repostChannels     map[string]float64
+	repostChannelsOnce sync.Once
+	repostChannelsMu   sync.RWMutex
+)
+
+// RepostChannel is a channel that opted in to repost detection.
+type RepostChannel struct {
+	ChannelID string
+	GuildID   string
+	Threshold float64
+	EnabledBy string
+	CreatedAt time.Time
+}
+
+// EnableRepostDetection opts a channel in to repost detection, or changes its
+// threshold when it already is.
+func EnableRepostDetection(guildID, channelID, enabledBy string, threshold float64) error {
+	_, err := duckdbClient.Exec(`
+		INSERT INTO repost_channels (channel_id, guild_id, threshold, enabled_by, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (channel_id) DO UPDATE SET
+			threshold = EXCLUDED.threshold,
+			enabled_by = EXCLUDED.enabled_by`,
+		channelID, guildID, threshold, enabledBy, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	loadRepostChannels()
+	repostChannelsMu.Lock()
+	repostChannels[channelID] = threshold
+	repostChannelsMu.Unlock()
+	return nil
+}
+
+// DisableRepostDetection opts a channel out again, reporting whether it was
+// opted in.
+func DisableRepostDetection(channelID string) (bool, error) {
+	res, err := duckdbClient.Exec(`DELETE FROM repost_channels WHERE channel_id = ?`, channelID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	loadRepostChannels()
+	repostChannelsMu.Lock()
+	delete(repostChannels, channelID)
+	repostChannelsMu.Unlock()
+	return n > 0, nil
+}
+
+// ListRepostChannels returns the channels of a guild that opted in to repost
+// detection, oldest first.
+func ListRepostChannels(guildID string) ([]RepostChannel, error) {
+	rows, err := duckdbClient.Query(`
+		SELECT channel_id, guild_id, threshold, enabled_by, created_at
+		FROM repost_channels
+		WHERE guild_id = ?
+		ORDER BY created_at ASC`,
+		guildID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []RepostChannel
+	for rows.Next() {
+		var c RepostChannel
+		if err := rows.Scan(&c.ChannelID, &c.GuildID, &c.Threshold, &c.EnabledBy, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+// loadRepostChannels fills the cache of opted-in channels the first time it
+// is needed.
+func loadRepostChannels() {
+	repostChannelsOnce.Do(func() {
+		channels := make(map[string]float64)
+		rows, err := duckdbClient.Query(`SELECT channel_id, threshold FROM repost_channels`)
+		if err != nil {
+			slog.Error("failed to load the repost channels", slog.Any("err", err))
+		} else {
+			defer rows.Close()
+			for rows.Next() {
+				var channelID string
+				var threshold float64
+				if err := rows.Scan(&channelID, &threshold); err != nil {
+					slog.Error("failed to scan repost channel", slog.Any("err", err))
+					continue
+				}
+				channels[channelID] = threshold
+			}
+		}
+
+		repostChannelsMu.Lock()
+		repostChannels = channels
+		repostChannelsMu.Unlock()
+	})
+}
+
+// repostThreshold returns the threshold of a channel, if it opted in.
+func repostThreshold(channelID string) (float64, bool) {
+	loadRepostChannels()
+	repostChannelsMu.RLock()
+	defer repostChannelsMu.RUnlock()
+	threshold, ok := repostChannels[channelID]
+	return threshold, ok
+}
+
+// repostDetector returns the callback EmbedMessage runs once a new message is
+// embedded, or nil when its channel did not opt in or the message is too
+// short to judge.
+func repostDetector(client *bot.Client, message discord.Message) func(model string) {
+	threshold, ok := repostThreshold(message.ChannelID.String())
+	if !ok || message.Author.Bot || len([]rune(strings.TrimSpace(message.Content))) < repostMinRunes {
+		return nil
+	}
+	return func(model string) {
+		go detectRepost(client, message, model, threshold)
+	}
+}
+
+// detectRepost replies to a message with a link to the recent message it
+// nearly duplicates, if there is one. Only the same channel and the channels
+// @everyone can read are searched, so the reply never links to a private
+// channel the readers of this one may not see.
+func detectRepost(client *bot.Client, message discord.Message, model string, threshold float64) {
+	channelIDs := append(util.PublicChannels(client, *message.GuildID), message.ChannelID.String())
+	original, ok, err := FindRepost(message.GuildID.String(), message.ID.String(), model, channelIDs, message.CreatedAt, threshold)
+	if err != nil {
+		slog.Error("failed to check for a repost", slog.String("id", message.ID.String()), slog.Any("err", err))
+		return
+	}
+	if !ok {
+		return
+	}
+
+	link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", message.GuildID.String(), original.ChannelID, original.MessageID)
+	_, err = client.Rest.CreateMessage(message.ChannelID, discord.MessageCreate{
+		Content:          fmt.Sprintf("This looks like a repost of [an earlier message](%s) from <t:%d:R> (%.0f%% similar).", link, original.Date.UTC().Unix(), original.Score*100),
+		MessageReference: &discord.MessageReference{MessageID: &message.ID},
+		AllowedMentions:  &discord.AllowedMentions{},
+	})
+	if err != nil {
+		slog.Error("failed to reply to a repost", slog.String("id", message.ID.String()), slog.Any("err", err))
+	}
+}
+
+// FindRepost returns the most similar message in one of the given channels
+// sent within repostWindow before the given one, if it is at least threshold
+// similar.
+func FindRepost(guildID, id, model string, channelIDs []string, sent time.Time, threshold float64) (SemanticSearchResult, bool, error) {
+	vec, err := GetMessageEmbedding(id, model)
+	if err != nil {
+		return SemanticSearchResult{}, false, err
+	}
+	results, err := SearchSimilarMessages(guildID, vec, model, repostCandidates)
+	if err != nil {
+		return SemanticSearchResult{}, false, err
+	}
+
+	since := sent.Add(-repostWindow)
+	for _, r := range results {
+		if r.Score < threshold {
+			break
+		}
+		if r.MessageID != id && r.Date.Before(sent) && r.Date.After(since) && util.Contains(channelIDs, r.ChannelID) {
+			return r, true, nil
+		}
+	}
+	return SemanticSearchResult{}, false, nil
+}
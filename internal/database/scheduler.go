@@ -0,0 +1,194 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+type ScheduledJob struct {
+	ID         string
+	GuildID    string
+	ChannelID  string
+	CreatedBy  string
+	Report     string
+	Config     string
+	Cron       string
+	Enabled    bool
+	NextRunAt  time.Time
+	LastRunAt  sql.NullTime
+	LastStatus string
+	CreatedAt  time.Time
+}
+
+type ScheduledJobRun struct {
+	ID           string
+	JobID        string
+	ScheduledFor time.Time
+	StartedAt    time.Time
+	FinishedAt   sql.NullTime
+	Status       string
+	Error        string
+	MessageID    string
+}
+
+const scheduledJobColumns = `id, guild_id, channel_id, created_by, report, config, cron, enabled, next_run_at, last_run_at, COALESCE(last_status, ''), created_at`
+
+func scanScheduledJob(s rowScanner) (ScheduledJob, error) {
+	var job ScheduledJob
+	err := s.Scan(&job.ID, &job.GuildID, &job.ChannelID, &job.CreatedBy, &job.Report, &job.Config, &job.Cron, &job.Enabled, &job.NextRunAt, &job.LastRunAt, &job.LastStatus, &job.CreatedAt)
+	return job, err
+}
+
+func scanScheduledJobs(rows *sql.Rows) ([]ScheduledJob, error) {
+	defer rows.Close()
+
+	var result []ScheduledJob
+	for rows.Next() {
+		job, err := scanScheduledJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, job)
+	}
+	return result, rows.Err()
+}
+
+func SaveScheduledJob(job ScheduledJob) error {
+	_, err := duckdbClient.Exec(
+		`INSERT INTO scheduled_jobs (id, guild_id, channel_id, created_by, report, config, cron, enabled, next_run_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.GuildID, job.ChannelID, job.CreatedBy, job.Report, job.Config, job.Cron, job.Enabled, job.NextRunAt, time.Now(),
+	)
+	return err
+}
+
+func GetScheduledJob(id string) (ScheduledJob, error) {
+	return scanScheduledJob(duckdbClient.QueryRow(`
+		SELECT `+scheduledJobColumns+`
+		FROM scheduled_jobs WHERE id = ?`,
+		id,
+	))
+}
+
+// ListScheduledJobs returns every job of a guild, soonest first.
+func ListScheduledJobs(guildID string) ([]ScheduledJob, error) {
+	rows, err := duckdbClient.Query(`
+		SELECT `+scheduledJobColumns+`
+		FROM scheduled_jobs
+		WHERE guild_id = ?
+		ORDER BY next_run_at ASC`,
+		guildID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanScheduledJobs(rows)
+}
+
+// DueScheduledJobs returns the enabled jobs whose next run is at or before now,
+// including any that came due while the bot was down.
+func DueScheduledJobs(now time.Time) ([]ScheduledJob, error) {
+	rows, err := duckdbClient.Query(`
+		SELECT `+scheduledJobColumns+`
+		FROM scheduled_jobs
+		WHERE enabled AND next_run_at <= ?
+		ORDER BY next_run_at ASC`,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanScheduledJobs(rows)
+}
+
+// SetScheduledJobEnabled pauses or resumes a job. The guild id is part of the
+// filter so one guild's admins cannot touch another guild's jobs.
+func SetScheduledJobEnabled(id, guildID string, enabled bool, nextRunAt time.Time) (bool, error) {
+	res, err := duckdbClient.Exec(
+		`UPDATE scheduled_jobs SET enabled = ?, next_run_at = ? WHERE id = ? AND guild_id = ?`,
+		enabled, nextRunAt, id, guildID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UpdateScheduledJobRun records the outcome of a run on the job itself and
+// moves it on to its next slot.
+func UpdateScheduledJobRun(id string, lastRunAt time.Time, status string, nextRunAt time.Time) error {
+	_, err := duckdbClient.Exec(
+		`UPDATE scheduled_jobs SET last_run_at = ?, last_status = ?, next_run_at = ? WHERE id = ?`,
+		lastRunAt, status, nextRunAt, id,
+	)
+	return err
+}
+
+// DeleteScheduledJob removes a job and its run history.
+func DeleteScheduledJob(id, guildID string) (bool, error) {
+	res, err := duckdbClient.Exec(`DELETE FROM scheduled_jobs WHERE id = ? AND guild_id = ?`, id, guildID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = duckdbClient.Exec(`DELETE FROM scheduled_job_runs WHERE job_id = ?`, id)
+	return true, err
+}
+
+func SaveScheduledJobRun(run ScheduledJobRun) error {
+	_, err := duckdbClient.Exec(
+		`INSERT INTO scheduled_job_runs (id, job_id, scheduled_for, started_at, status)
+		 VALUES (?, ?, ?, ?, ?)`,
+		run.ID, run.JobID, run.ScheduledFor, run.StartedAt, run.Status,
+	)
+	return err
+}
+
+func FinishScheduledJobRun(id, status, errMsg, messageID string) error {
+	_, err := duckdbClient.Exec(
+		`UPDATE scheduled_job_runs SET finished_at = ?, status = ?, error = NULLIF(?, ''), message_id = NULLIF(?, '') WHERE id = ?`,
+		time.Now(), status, errMsg, messageID, id,
+	)
+	return err
+}
+
+// FailInterruptedScheduledJobRuns marks runs that were still "running" when
+// the bot went down. Their jobs were already moved to the next slot, so the
+// interrupted slot is not retried.
+func FailInterruptedScheduledJobRuns() error {
+	_, err := duckdbClient.Exec(
+		`UPDATE scheduled_job_runs SET status = 'interrupted', finished_at = ? WHERE status = 'running'`,
+		time.Now(),
+	)
+	return err
+}
+
+// ListScheduledJobRuns returns the most recent runs of a job, newest first.
+func ListScheduledJobRuns(jobID string, limit int) ([]ScheduledJobRun, error) {
+	rows, err := duckdbClient.Query(`
+		SELECT id, job_id, scheduled_for, started_at, finished_at, status, COALESCE(error, ''), COALESCE(message_id, '')
+		FROM scheduled_job_runs
+		WHERE job_id = ?
+		ORDER BY started_at DESC
+		LIMIT ?`,
+		jobID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []ScheduledJobRun
+	for rows.Next() {
+		var run ScheduledJobRun
+		if err := rows.Scan(&run.ID, &run.JobID, &run.ScheduledFor, &run.StartedAt, &run.FinishedAt, &run.Status, &run.Error, &run.MessageID); err != nil {
+			return nil, err
+		}
+		result = append(result, run)
+	}
+	return result, rows.Err()
+}
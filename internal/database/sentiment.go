@@ -0,0 +1,230 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/sentiment"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// UserMood is the sentiment breakdown of a single author's messages.
+type UserMood struct {
+	AuthorID string
+	Positive int
+	Neutral  int
+	Negative int
+	Score    float64
+}
+
+func (u UserMood) Total() int {
+	return u.Positive + u.Neutral + u.Negative
+}
+
+// MoodSample is a classified message, as handed to the LLM for narration.
+type MoodSample struct {
+	Message string `json:"message"`
+	Mood    string `json:"mood"`
+}
+
+// latestSentimentMessages selects the latest version of the messages in a
+// guild and period, joined with their sentiment. The %s is an optional
+// channel filter.
+const latestSentimentMessages = `
+	SELECT m.id, m.author_id, m.content, s.label, s.score
+	FROM messages m
+	JOIN (
+		SELECT id, MAX(version) AS latest_version
+		FROM messages
+		WHERE guild_id = ?
+		AND date BETWEEN ? AND ?
+		GROUP BY id
+	) latest ON m.id = latest.id AND m.version = latest.latest_version
+	JOIN message_sentiment s ON s.id = m.id
+	WHERE m.content <> ''
+	%s`
+
+// SaveMessageSentiment upserts the sentiment of a message.
+func SaveMessageSentiment(id, model string, result sentiment.Result) error {
+	_, err := duckdbClient.Exec(`
+		INSERT INTO message_sentiment (id, model, label, score, confidence, classified_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			model = EXCLUDED.model,
+			label = EXCLUDED.label,
+			score = EXCLUDED.score,
+			confidence = EXCLUDED.confidence,
+			classified_at = EXCLUDED.classified_at`,
+		id, model, result.Label, result.Score, result.Confidence, time.Now(),
+	)
+	return err
+}
+
+// ClassifyMessage labels and stores the sentiment of a single message. Like
+// EmbedMessage it is best-effort: empty content is skipped and failures are
+// logged, not returned.
+func ClassifyMessage(id, content string) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return
+	}
+
+	result, err := sentiment.Classify(content)
+	if err != nil {
+		slog.Error("failed to classify message", slog.String("id", id), slog.Any("err", err))
+		return
+	}
+
+	if err := SaveMessageSentiment(id, sentiment.ModelName(), result); err != nil {
+		slog.Error("failed to store message sentiment", slog.String("id", id), slog.Any("err", err))
+	}
+}
+
+// channelFilter renders an "AND m.channel_id IN (...)" clause for the given
+// channels, appending them to params. No channels means no filter.
+func channelFilter(channelIDs []string, params []any) (string, []any) {
+	if len(channelIDs) == 0 {
+		return "", params
+	}
+	placeholders := make([]string, len(channelIDs))
+	for i, id := range channelIDs {
+		placeholders[i] = "?"
+		params = append(params, id)
+	}
+	return fmt.Sprintf("AND m.channel_id IN (%s)", strings.Join(placeholders, ", ")), params
+}
+
+// GetMessagesWithoutSentiment returns the latest version of every stored
+// message that has not been classified yet. A limit <= 0 means no limit.
+func GetMessagesWithoutSentiment(limit int) ([]util.MessageObject, error) {
+	query := `
+		SELECT m.id, m.guild_id, m.channel_id, m.author_id, m.content, m.date
+		FROM messages m
+		JOIN (
+			SELECT id, MAX(version) AS latest_version
+			FROM messages
+			GROUP BY id
+		) latest ON m.id = latest.id AND m.version = latest.latest_version
+		LEFT JOIN message_sentiment s ON s.id = m.id
+		WHERE s.id IS NULL AND m.content <> ''`
+	if limit > 0 {
+		query += fmt.Sprintf("\n\t\tLIMIT %d", limit)
+	}
+
+	rows, err := duckdbClient.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []util.MessageObject
+	for rows.Next() {
+		var m util.MessageObject
+		if err := rows.Scan(&m.MessageID, &m.GuildID, &m.ChannelID, &m.Author, &m.Content, &m.Date); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// GetPeriodMessagesWithoutSentiment returns the latest version of the messages
+// in a guild and period that have not been classified yet, newest first. A
+// limit <= 0 means no limit.
+func GetPeriodMessagesWithoutSentiment(guildID string, channelIDs []string, start, end time.Time, limit int) ([]util.MessageObject, error) {
+	filter, params := channelFilter(channelIDs, []any{guildID, start, end})
+	query := fmt.Sprintf(`
+		SELECT m.id, m.guild_id, m.channel_id, m.author_id, m.content, m.date
+		FROM messages m
+		JOIN (
+			SELECT id, MAX(version) AS latest_version
+			FROM messages
+			WHERE guild_id = ?
+			AND date BETWEEN ? AND ?
+			GROUP BY id
+		) latest ON m.id = latest.id AND m.version = latest.latest_version
+		LEFT JOIN message_sentiment s ON s.id = m.id
+		WHERE s.id IS NULL AND m.content <> ''
+		%s
+		ORDER BY m.date DESC`, filter)
+	if limit > 0 {
+		query += fmt.Sprintf("\n\t\tLIMIT %d", limit)
+	}
+
+	rows, err := duckdbClient.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []util.MessageObject
+	for rows.Next() {
+		var m util.MessageObject
+		if err := rows.Scan(&m.MessageID, &m.GuildID, &m.ChannelID, &m.Author, &m.Content, &m.Date); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// MoodByUser returns the sentiment breakdown of every author in a guild and
+// period, most active first. Unclassified messages are left out.
+func MoodByUser(guildID string, channelIDs []string, start, end time.Time) ([]UserMood, error) {
+	filter, params := channelFilter(channelIDs, []any{guildID, start, end})
+	query := fmt.Sprintf(`
+		SELECT author_id,
+			COUNT(*) FILTER (WHERE label = '%s'),
+			COUNT(*) FILTER (WHERE label = '%s'),
+			COUNT(*) FILTER (WHERE label = '%s'),
+			AVG(score)
+		FROM (%s)
+		GROUP BY author_id
+		ORDER BY COUNT(*) DESC`,
+		sentiment.Positive, sentiment.Neutral, sentiment.Negative,
+		fmt.Sprintf(latestSentimentMessages, filter),
+	)
+
+	rows, err := duckdbClient.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []UserMood
+	for rows.Next() {
+		var u UserMood
+		if err := rows.Scan(&u.AuthorID, &u.Positive, &u.Neutral, &u.Negative, &u.Score); err != nil {
+			return nil, err
+		}
+		result = append(result, u)
+	}
+	return result, rows.Err()
+}
+
+// MoodSamples returns up to limit classified messages from a guild and period
+// with their label, for narrating what the mood was about.
+func MoodSamples(guildID string, channelIDs []string, start, end time.Time, limit int) ([]MoodSample, error) {
+	filter, params := channelFilter(channelIDs, []any{guildID, start, end})
+	query := fmt.Sprintf(latestSentimentMessages, filter) + fmt.Sprintf("\n\tORDER BY m.date DESC\n\tLIMIT %d", limit)
+
+	rows, err := duckdbClient.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []MoodSample
+	for rows.Next() {
+		var id, authorID string
+		var score float64
+		var s MoodSample
+		if err := rows.Scan(&id, &authorID, &s.Message, &s.Mood, &score); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// sessionCleanupInterval is how often expired interaction sessions are
+// deleted.
+const sessionCleanupInterval = 10 * time.Minute
+
+// ErrSessionExpired is returned for a session that does not exist, or no
+// longer does because its TTL passed.
+var ErrSessionExpired = errors.New("session expired")
+
+// SaveSession stores the state of a paginated command under a token, encoded
+// as JSON, until ttl has passed.
+func SaveSession(token, command string, state any, ttl time.Duration) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = duckdbClient.Exec(`
+		INSERT INTO interaction_sessions (token, command, state, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (token) DO UPDATE SET
+			state = EXCLUDED.state,
+			expires_at = EXCLUDED.expires_at`,
+		token, command, string(data), now, now.Add(ttl),
+	)
+	return err
+}
+
+// LoadSession decodes the state a command stored under a token into state,
+// or returns ErrSessionExpired.
+func LoadSession(token, command string, state any) error {
+	var data string
+	err := duckdbClient.QueryRow(`
+		SELECT state FROM interaction_sessions
+		WHERE token = ? AND command = ? AND expires_at > ?`,
+		token, command, time.Now(),
+	).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionExpired
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), state)
+}
+
+// DeleteExpiredSessions removes the sessions whose TTL has passed, returning
+// how many there were.
+func DeleteExpiredSessions() (int64, error) {
+	res, err := duckdbClient.Exec(`DELETE FROM interaction_sessions WHERE expires_at <= ?`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// StartSessionCleanup deletes expired sessions until the process exits.
+func StartSessionCleanup() {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := DeleteExpiredSessions(); err != nil {
+			slog.Error("Failed to delete expired sessions", slog.Any("err", err))
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,691 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/google/uuid"
+	"github.com/stollenaar/statisticsbot/internal/llm"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+const (
+	// topicPeriod is the length of the periods messages are clustered in.
+	// Periods start on Monday 00:00 UTC.
+	topicPeriod = 7 * 24 * time.Hour
+	// topicHistoryPeriods is how many past periods the job fills in besides
+	// the current one. A finished period is only clustered once, whether or
+	// not any topics came out of it.
+	topicHistoryPeriods = 12
+	// topicMinMessages skips periods with too few embedded messages to find
+	// anything meaningful in.
+	topicMinMessages = 30
+	// topicMinClusterSize drops clusters too small to call a topic.
+	topicMinClusterSize = 5
+	// topicMaxClusters caps k; a period gets about sqrt(n/2) clusters.
+	topicMaxClusters = 12
+	// topicSampleSize bounds how many vectors k-means runs on. Every message
+	// of the period is still assigned to its nearest centroid afterwards.
+	topicSampleSize       = 5000
+	topicKMeansIterations = 30
+	// topicRepresentatives is how many messages nearest the centroid the LLM
+	// gets to label a topic from.
+	topicRepresentatives = 10
+	topicMaxSampleRunes  = 300
+	topicMaxLabelRunes   = 60
+	// topicLabelMatch is the centroid similarity above which a topic takes
+	// over the label of a topic in the previous period, or of the previous
+	// run over the same period, instead of asking the LLM again.
+	topicLabelMatch = 0.85
+	// topicRefreshInterval is how often the current period is re-clustered
+	// in the background.
+	topicRefreshInterval = 6 * time.Hour
+)
+
+var (
+	// topicRefreshMu keeps the background job and /fixTopics from clustering
+	// the same period at once.
+	topicRefreshMu sync.Mutex
+
+	topicLabelSchema = &llm.Schema{
+		Type: "object",
+		Properties: map[string]*llm.Schema{
+			"label": {Type: "string", MinLength: util.Pointer(1)},
+		},
+		Required: []string{"label"},
+	}
+)
+
+// Topic is a cluster of a guild's messages in one period.
+type Topic struct {
+	ID          string
+	GuildID     string
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	Label       string
+	Size        int
+}
+
+// TopicShare is the number of messages a topic label covered in one period.
+// Topics in a period that got the same label are counted together.
+type TopicShare struct {
+	PeriodStart time.Time
+	Label       string
+	Size        int
+}
+
+type topicLabel struct {
+	Label string `json:"label"`
+}
+
+// topicVector is a message in a period with its normalised embedding.
+type topicVector struct {
+	id      string
+	content string
+	vec     []float32
+}
+
+// topicCluster is a cluster found by k-means, with its members sorted by
+// descending similarity to the centroid.
+type topicCluster struct {
+	centroid []float32
+	members  []int
+	scores   []float32
+}
+
+// topicPeriodStart returns the start of the period t falls in. Go's zero time
+// is a Monday, so truncating to whole weeks lands on Monday 00:00 UTC.
+func topicPeriodStart(t time.Time) time.Time {
+	return t.UTC().Truncate(topicPeriod)
+}
+
+// StartTopicRefresh clusters the messages of every guild into topics until
+// the process exits.
+func StartTopicRefresh(client *bot.Client) {
+	ticker := time.NewTicker(topicRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := RefreshTopics(client); err != nil {
+			slog.Error("Failed to refresh topics", slog.Any("err", err))
+		}
+		<-ticker.C
+	}
+}
+
+// RefreshTopics clusters the current period of every guild, and each of the
+// past topicHistoryPeriods periods that was not clustered yet, with the
+// guild's serving embedding model. Any member can see the topics, their
+// labels and example messages, so only the channels every member can read are
+// clustered.
+func RefreshTopics(client *bot.Client) error {
+	topicRefreshMu.Lock()
+	defer topicRefreshMu.Unlock()
+
+	rows, err := duckdbClient.Query(`SELECT DISTINCT guild_id FROM messages`)
+	if err != nil {
+		return err
+	}
+	var guilds []string
+	for rows.Next() {
+		var guildID string
+		if err := rows.Scan(&guildID); err != nil {
+			rows.Close()
+			return err
+		}
+		guilds = append(guilds, guildID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	current := topicPeriodStart(time.Now())
+	var errs []error
+	for _, guildID := range guilds {
+		model, err := ServingEmbeddingModel(guildID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		id, err := snowflake.Parse(guildID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		channels := util.PublicChannels(client, id)
+		if len(channels) == 0 {
+			continue
+		}
+
+		for i := topicHistoryPeriods; i >= 0; i-- {
+			start := current.Add(-time.Duration(i) * topicPeriod)
+			finished := i > 0
+			if finished {
+				var done bool
+				err := duckdbClient.QueryRow(`SELECT EXISTS (SELECT 1 FROM topic_periods WHERE guild_id = ? AND model = ? AND period_start = ?)`, guildID, model, start).Scan(&done)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				if done {
+					continue
+				}
+			}
+			if err := clusterTopics(guildID, model, channels, start, start.Add(topicPeriod)); err != nil {
+				errs = append(errs, fmt.Errorf("guild %s, period %s: %w", guildID, start.Format(time.DateOnly), err))
+				continue
+			}
+			if finished {
+				_, err := duckdbClient.Exec(`INSERT INTO topic_periods (guild_id, model, period_start, clustered_at) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`, guildID, model, start, time.Now())
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// clusterTopics replaces the topics of a guild's channels in one period.
+// Nothing is replaced when the period has too few messages or a label fails.
+func clusterTopics(guildID, model string, channelIDs []string, start, end time.Time) error {
+	vectors, err := getTopicVectors(guildID, model, channelIDs, start, end)
+	if err != nil {
+		return err
+	}
+	if len(vectors) < topicMinMessages {
+		return nil
+	}
+
+	k := min(max(int(math.Round(math.Sqrt(float64(len(vectors))/2))), 2), topicMaxClusters)
+	clusters := kMeans(vectors, k)
+
+	previous, err := getTopicCentroids(guildID, model, start.Add(-topicPeriod), start)
+	if err != nil {
+		return err
+	}
+
+	topics := make([]Topic, len(clusters))
+	for i, cluster := range clusters {
+		topics[i] = Topic{
+			ID:          uuid.New().String(),
+			GuildID:     guildID,
+			PeriodStart: start,
+			PeriodEnd:   end,
+			Size:        len(cluster.members),
+		}
+		if label, ok := matchTopicLabel(cluster.centroid, previous); ok {
+			topics[i].Label = label
+			continue
+		}
+		if topics[i].Label, err = labelTopic(vectors, cluster); err != nil {
+			return fmt.Errorf("failed to label topic: %w", err)
+		}
+	}
+
+	return saveTopics(guildID, model, start, topics, clusters, vectors)
+}
+
+// getTopicVectors returns the latest version of every message sent in the
+// guild's channels in the period, with the model's embedding.
+func getTopicVectors(guildID, model string, channelIDs []string, start, end time.Time) ([]topicVector, error) {
+	filter, params := channelFilter(channelIDs, []any{guildID, start, end, model})
+	rows, err := duckdbClient.Query(`
+		SELECT m.id, m.content, `+dequantizedColumn+`
+		FROM messages m
+		JOIN (
+			SELECT id, MAX(version) AS latest_version
+			FROM messages
+			WHERE guild_id = ?
+			AND date >= ? AND date < ?
+			GROUP BY id
+		) latest ON m.id = latest.id AND m.version = latest.latest_version
+		JOIN message_embeddings e ON e.id = m.id AND e.model = ?
+		WHERE m.content <> '' `+filter+`
+		ORDER BY m.date ASC`,
+		params...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []topicVector
+	for rows.Next() {
+		var v topicVector
+		var list []any
+		if err := rows.Scan(&v.id, &v.content, &list); err != nil {
+			return nil, err
+		}
+		vec := make([]float32, len(list))
+		for i, x := range list {
+			vec[i], _ = x.(float32)
+		}
+		v.vec = normalizeVector(vec)
+		result = append(result, v)
+	}
+	return result, rows.Err()
+}
+
+// kMeans clusters normalised vectors by cosine similarity, seeded with
+// k-means++ on a sample of at most topicSampleSize vectors. Near-duplicate
+// centroids are merged and every vector is then assigned to its nearest
+// centroid. Clusters smaller than topicMinClusterSize are dropped and the rest
+// are returned largest first.
+func kMeans(vectors []topicVector, k int) []topicCluster {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	sample := vectors
+	if len(sample) > topicSampleSize {
+		sample = make([]topicVector, topicSampleSize)
+		for i, j := range rng.Perm(len(vectors))[:topicSampleSize] {
+			sample[i] = vectors[j]
+		}
+	}
+	k = min(k, len(sample))
+	dims := len(sample[0].vec)
+
+	// k-means++: every next seed is picked with a probability proportional
+	// to its squared distance from the nearest seed so far.
+	centroids := [][]float32{sample[rng.Intn(len(sample))].vec}
+	dist := make([]float64, len(sample))
+	for len(centroids) < k {
+		total := 0.0
+		for i, v := range sample {
+			d := 1 - float64(dotVector(v.vec, centroids[len(centroids)-1]))
+			if len(centroids) == 1 || d*d < dist[i] {
+				dist[i] = d * d
+			}
+			total += dist[i]
+		}
+		if total == 0 {
+			break
+		}
+		r := rng.Float64() * total
+		next := len(sample) - 1
+		for i, d := range dist {
+			if r -= d; r <= 0 {
+				next = i
+				break
+			}
+		}
+		centroids = append(centroids, sample[next].vec)
+	}
+
+	assign := make([]int, len(sample))
+	for iteration := 0; iteration < topicKMeansIterations; iteration++ {
+		changed := iteration == 0
+		for i, v := range sample {
+			if c, _ := nearestCentroid(v.vec, centroids); c != assign[i] {
+				assign[i], changed = c, true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([][]float32, len(centroids))
+		for c := range sums {
+			sums[c] = make([]float32, dims)
+		}
+		for i, v := range sample {
+			for d, x := range v.vec {
+				sums[assign[i]][d] += x
+			}
+		}
+		for c, sum := range sums {
+			// An emptied cluster keeps its old centroid.
+			if norm := normalizeVector(sum); !isZeroVector(norm) {
+				centroids[c] = norm
+			}
+		}
+	}
+	centroids = mergeCentroids(centroids, assign)
+
+	clusters := make([]topicCluster, len(centroids))
+	for c := range clusters {
+		clusters[c].centroid = centroids[c]
+	}
+	for i, v := range vectors {
+		c, score := nearestCentroid(v.vec, centroids)
+		clusters[c].members = append(clusters[c].members, i)
+		clusters[c].scores = append(clusters[c].scores, score)
+	}
+
+	var result []topicCluster
+	for _, cluster := range clusters {
+		if len(cluster.members) < topicMinClusterSize {
+			continue
+		}
+		sort.Sort(byScore(cluster))
+		result = append(result, cluster)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return len(result[i].members) > len(result[j].members)
+	})
+	return result
+}
+
+// mergeCentroids joins centroids at least topicLabelMatch similar, which
+// k-means leaves behind when k overestimates the number of topics. Merged
+// centroids are averaged by the number of sample vectors assigned to them.
+func mergeCentroids(centroids [][]float32, assign []int) [][]float32 {
+	weights := make([]float32, len(centroids))
+	for _, c := range assign {
+		weights[c]++
+	}
+
+	var merged [][]float32
+	var mergedWeights []float32
+	for c, centroid := range centroids {
+		target := -1
+		for m := range merged {
+			if dotVector(centroid, merged[m]) >= topicLabelMatch {
+				target = m
+				break
+			}
+		}
+		if target < 0 {
+			merged = append(merged, centroid)
+			mergedWeights = append(mergedWeights, weights[c])
+			continue
+		}
+
+		sum := make([]float32, len(centroid))
+		for d := range sum {
+			sum[d] = merged[target][d]*mergedWeights[target] + centroid[d]*weights[c]
+		}
+		if norm := normalizeVector(sum); !isZeroVector(norm) {
+			merged[target] = norm
+		}
+		mergedWeights[target] += weights[c]
+	}
+	return merged
+}
+
+// byScore sorts a cluster's members by descending similarity.
+type byScore topicCluster
+
+func (c byScore) Len() int           { return len(c.members) }
+func (c byScore) Less(i, j int) bool { return c.scores[i] > c.scores[j] }
+func (c byScore) Swap(i, j int) {
+	c.members[i], c.members[j] = c.members[j], c.members[i]
+	c.scores[i], c.scores[j] = c.scores[j], c.scores[i]
+}
+
+func nearestCentroid(vec []float32, centroids [][]float32) (int, float32) {
+	best, bestScore := 0, float32(math.Inf(-1))
+	for c, centroid := range centroids {
+		if s := dotVector(vec, centroid); s > bestScore {
+			best, bestScore = c, s
+		}
+	}
+	return best, bestScore
+}
+
+func normalizeVector(vec []float32) []float32 {
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vec
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	out := make([]float32, len(vec))
+	for i, v := range vec {
+		out[i] = v * scale
+	}
+	return out
+}
+
+func isZeroVector(vec []float32) bool {
+	for _, v := range vec {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func dotVector(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+type topicCentroid struct {
+	label    string
+	centroid []float32
+}
+
+// getTopicCentroids returns the labels and centroids of a guild's topics in
+// the periods starting between from and to, both inclusive.
+func getTopicCentroids(guildID, model string, from, to time.Time) ([]topicCentroid, error) {
+	rows, err := duckdbClient.Query(`
+		SELECT label, centroid FROM topics
+		WHERE guild_id = ? AND model = ? AND period_start BETWEEN ? AND ?`,
+		guildID, model, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []topicCentroid
+	for rows.Next() {
+		var t topicCentroid
+		var list []any
+		if err := rows.Scan(&t.label, &list); err != nil {
+			return nil, err
+		}
+		t.centroid = make([]float32, len(list))
+		for i, x := range list {
+			t.centroid[i], _ = x.(float32)
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+// matchTopicLabel returns the label of the known topic closest to a centroid,
+// if it is at least topicLabelMatch similar.
+func matchTopicLabel(centroid []float32, known []topicCentroid) (string, bool) {
+	best, bestScore := "", float32(topicLabelMatch)
+	for _, t := range known {
+		if len(t.centroid) != len(centroid) {
+			continue
+		}
+		if s := dotVector(centroid, t.centroid); s >= bestScore {
+			best, bestScore = t.label, s
+		}
+	}
+	return best, best != ""
+}
+
+// labelTopic asks the LLM to name what the messages nearest a cluster's
+// centroid have in common.
+func labelTopic(vectors []topicVector, cluster topicCluster) (string, error) {
+	var samples []string
+	for _, i := range cluster.members[:min(topicRepresentatives, len(cluster.members))] {
+		content := []rune(strings.TrimSpace(vectors[i].content))
+		samples = append(samples, string(content[:min(topicMaxSampleRunes, len(content))]))
+	}
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return "", err
+	}
+
+	prompt := fmt.Sprintf(
+		"You are an AI that outputs valid JSON only.\n"+
+			"The following Discord messages were grouped together because they talk about the same thing.\n"+
+			"Name the topic they share in at most five words, without quotes or trailing punctuation.\n"+
+			"Return a JSON object with a \"label\" field.\n\n"+
+			"Input:\n%s",
+		string(data))
+
+	out, err := llm.Generate[topicLabel](context.Background(), llm.Request{
+		Temperature: 0.2,
+		MaxTokens:   100,
+		Messages:    []llm.Message{{Role: "user", Content: prompt}},
+		Schema:      topicLabelSchema,
+	}, llm.Structured{})
+	if err != nil {
+		return "", err
+	}
+
+	label := []rune(strings.TrimSpace(out.Label))
+	return string(label[:min(topicMaxLabelRunes, len(label))]), nil
+}
+
+// saveTopics replaces a guild's topics in a period with the given ones and
+// their member messages.
+func saveTopics(guildID, model string, start time.Time, topics []Topic, clusters []topicCluster, vectors []topicVector) error {
+	tx, err := duckdbClient.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM topic_messages WHERE topic_id IN (
+			SELECT id FROM topics WHERE guild_id = ? AND model = ? AND period_start = ?
+		)`, guildID, model, start)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM topics WHERE guild_id = ? AND model = ? AND period_start = ?`, guildID, model, start); err != nil {
+		return err
+	}
+
+	for i, topic := range topics {
+		_, err := tx.Exec(fmt.Sprintf(`
+			INSERT INTO topics (id, guild_id, model, period_start, period_end, label, size, centroid, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, %s, ?)`, floatSliceToList(clusters[i].centroid)),
+			topic.ID, topic.GuildID, model, topic.PeriodStart, topic.PeriodEnd, topic.Label, topic.Size, time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+		for j, member := range clusters[i].members {
+			_, err := tx.Exec(`INSERT INTO topic_messages (topic_id, message_id, score) VALUES (?, ?, ?)`,
+				topic.ID, vectors[member].id, clusters[i].scores[j])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// GetLatestTopics returns the topics of the most recent period a guild has
+// topics for, largest first.
+func GetLatestTopics(guildID string, limit int) ([]Topic, error) {
+	model, err := ServingEmbeddingModel(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := duckdbClient.Query(`
+		SELECT id, guild_id, period_start, period_end, label, size
+		FROM topics
+		WHERE guild_id = ? AND model = ?
+		AND period_start = (SELECT MAX(period_start) FROM topics WHERE guild_id = ? AND model = ?)
+		ORDER BY size DESC
+		LIMIT ?`,
+		guildID, model, guildID, model, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Topic
+	for rows.Next() {
+		var t Topic
+		if err := rows.Scan(&t.ID, &t.GuildID, &t.PeriodStart, &t.PeriodEnd, &t.Label, &t.Size); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+// GetTopicExamples returns the latest version of a topic's most
+// representative messages, nearest the centroid first.
+func GetTopicExamples(topicID string, limit int) ([]util.MessageObject, error) {
+	rows, err := duckdbClient.Query(`
+		SELECT m.id, m.guild_id, m.channel_id, m.author_id, m.content, m.date
+		FROM topic_messages t
+		JOIN messages m ON m.id = t.message_id
+		JOIN (
+			SELECT id, MAX(version) AS latest_version
+			FROM messages
+			WHERE id IN (SELECT message_id FROM topic_messages WHERE topic_id = ?)
+			GROUP BY id
+		) latest ON m.id = latest.id AND m.version = latest.latest_version
+		WHERE t.topic_id = ?
+		ORDER BY t.score DESC
+		LIMIT ?`,
+		topicID, topicID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []util.MessageObject
+	for rows.Next() {
+		var m util.MessageObject
+		if err := rows.Scan(&m.MessageID, &m.GuildID, &m.ChannelID, &m.Author, &m.Content, &m.Date); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// GetTopicShares returns how many messages each topic label covered per
+// period, for the periods of a guild starting at or after since.
+func GetTopicShares(guildID string, since time.Time) ([]TopicShare, error) {
+	model, err := ServingEmbeddingModel(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := duckdbClient.Query(`
+		SELECT period_start, label, SUM(size)::INTEGER
+		FROM topics
+		WHERE guild_id = ? AND model = ? AND period_start >= ?
+		GROUP BY period_start, label
+		ORDER BY period_start ASC`,
+		guildID, model, topicPeriodStart(since),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []TopicShare
+	for rows.Next() {
+		var s TopicShare
+		if err := rows.Scan(&s.PeriodStart, &s.Label, &s.Size); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
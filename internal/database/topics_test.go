@@ -0,0 +1,78 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// topicGroups returns n vectors around each axis of a 3-dimensional space,
+// with ids naming their axis, plus two stray vectors too few to make a topic.
+func topicGroups(n int) []topicVector {
+	rng := rand.New(rand.NewSource(1))
+	var vectors []topicVector
+	for axis := range 3 {
+		for i := range n {
+			vec := make([]float32, 3)
+			for d := range vec {
+				vec[d] = rng.Float32() * 0.1
+			}
+			vec[axis] = 1
+			vectors = append(vectors, topicVector{id: fmt.Sprintf("%d-%d", axis, i), vec: normalizeVector(vec)})
+		}
+	}
+	stray := normalizeVector([]float32{1, 1, 1})
+	vectors = append(vectors, topicVector{id: "stray-0", vec: stray}, topicVector{id: "stray-1", vec: stray})
+	return vectors
+}
+
+func TestKMeans(t *testing.T) {
+	vectors := topicGroups(20)
+
+	// An overestimated k leaves near-duplicate centroids, which are merged
+	// back into one topic per axis.
+	for _, k := range []int{3, 8} {
+		clusters := kMeans(vectors, k)
+		if len(clusters) != 3 {
+			t.Fatalf("k=%d: got %d clusters, want 3", k, len(clusters))
+		}
+		for _, cluster := range clusters {
+			// Members are sorted by score, so the first is never a stray.
+			axis := vectors[cluster.members[0]].id[0]
+			for i, member := range cluster.members {
+				if id := vectors[member].id; id[0] != axis && id[0] != 's' {
+					t.Errorf("k=%d: cluster of axis %c has member %s", k, axis, id)
+				}
+				if i > 0 && cluster.scores[i] > cluster.scores[i-1] {
+					t.Errorf("k=%d: members are not sorted by score", k)
+				}
+			}
+			// The strays are assigned somewhere but never make up a topic.
+			if n := len(cluster.members); n < 20 || n > 22 {
+				t.Errorf("k=%d: cluster has %d members, want 20 to 22", k, n)
+			}
+		}
+	}
+}
+
+func TestMergeCentroids(t *testing.T) {
+	a := normalizeVector([]float32{1, 0.1, 0})
+	b := normalizeVector([]float32{1, -0.1, 0})
+	c := []float32{0, 0, 1}
+
+	// a has three vectors assigned, b one and c two.
+	merged := mergeCentroids([][]float32{a, b, c}, []int{0, 0, 0, 1, 2, 2})
+	if len(merged) != 2 {
+		t.Fatalf("got %d centroids, want a and b merged and c kept", len(merged))
+	}
+	// The merge is weighted towards a.
+	if merged[0][1] <= 0 {
+		t.Errorf("merged centroid %v is not weighted towards a", merged[0])
+	}
+	if s := dotVector(merged[0], merged[0]); s < 0.999 || s > 1.001 {
+		t.Errorf("merged centroid is not normalised: |v|² = %v", s)
+	}
+	if dotVector(merged[1], c) < 0.999 {
+		t.Errorf("unmerged centroid changed: %v", merged[1])
+	}
+}
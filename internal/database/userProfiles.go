@@ -0,0 +1,391 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// profileHalfLife is the age at which a message counts half as much
+	// towards its author's profile, so profiles follow how people write now.
+	profileHalfLife = 90 * 24 * time.Hour
+	// profileMinMessages leaves members with too few embedded messages out of
+	// the ranking; a handful of messages says little about someone's style.
+	profileMinMessages = 20
+)
+
+// profileMu keeps a rebuild's write from interleaving with the incremental
+// updates of the embedding workers.
+var profileMu sync.Mutex
+
+// ErrProfilesBuilding is returned while a guild's profiles are first being
+// built in the background.
+var ErrProfilesBuilding = errors.New("the user profiles of this guild are being built")
+
+// profileBuilds tracks the guilds whose profiles are being built in the
+// background, or were built since startup, keyed by guild and model. A build
+// only runs once at a time, and a guild whose build came up empty is not
+// built again on every command.
+var profileBuilds = struct {
+	sync.Mutex
+	running map[string]bool
+	built   map[string]bool
+}{running: make(map[string]bool), built: make(map[string]bool)}
+
+// UserTwin is a member whose profile is similar to another one's.
+type UserTwin struct {
+	AuthorID string
+	Score    float64
+	Messages int
+}
+
+// profileDecay is the weight of a message of the given age.
+func profileDecay(age time.Duration) float64 {
+	if age <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(age) / float64(profileHalfLife))
+}
+
+// updateUserProfile folds a newly embedded message into its author's profile.
+// Like ClassifyMessage it is best-effort: failures are logged, not returned.
+func updateUserProfile(id, model string, vec []float32) {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+
+	var guildID, authorID string
+	var date time.Time
+	err := duckdbClient.QueryRow(`
+		SELECT guild_id, author_id, date FROM messages
+		WHERE id = ? AND author_id IS NOT NULL
+		ORDER BY version DESC
+		LIMIT 1`, id).Scan(&guildID, &authorID, &date)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		slog.Error("failed to fetch the message for its author's profile", slog.String("id", id), slog.Any("err", err))
+		return
+	}
+
+	// Until the guild's profiles are first built, there is nothing to keep
+	// up to date; the build will include this message.
+	var built bool
+	err = duckdbClient.QueryRow(`SELECT EXISTS (SELECT 1 FROM user_profiles WHERE guild_id = ? AND model = ?)`, guildID, model).Scan(&built)
+	if err != nil || !built {
+		if err != nil {
+			slog.Error("failed to check for user profiles", slog.String("guild_id", guildID), slog.Any("err", err))
+		}
+		return
+	}
+
+	var list []any
+	var weight float64
+	var count int
+	var updatedAt time.Time
+	err = duckdbClient.QueryRow(`
+		SELECT embedding, weight, message_count, updated_at FROM user_profiles
+		WHERE guild_id = ? AND author_id = ? AND model = ?`,
+		guildID, authorID, model,
+	).Scan(&list, &weight, &count, &updatedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("failed to fetch user profile", slog.String("author_id", authorID), slog.Any("err", err))
+		return
+	}
+
+	sum := make([]float32, len(vec))
+	if len(list) == len(vec) {
+		for i, v := range list {
+			sum[i], _ = v.(float32)
+		}
+	} else {
+		// A new profile, or one of another dimension after a model mix-up.
+		weight, count, updatedAt = 0, 0, date
+	}
+
+	// Both the profile and the message are decayed to the later of their
+	// dates, so backfilled history does not count as new.
+	ref := updatedAt
+	if date.After(ref) {
+		ref = date
+	}
+	profile, message := float32(profileDecay(ref.Sub(updatedAt))), float32(profileDecay(ref.Sub(date)))
+	for i, v := range normalizeVector(vec) {
+		sum[i] = sum[i]*profile + v*message
+	}
+	weight = weight*float64(profile) + float64(message)
+
+	_, err = duckdbClient.Exec(fmt.Sprintf(`
+		INSERT INTO user_profiles (guild_id, author_id, model, embedding, weight, message_count, updated_at)
+		VALUES (?, ?, ?, %s, ?, ?, ?)
+		ON CONFLICT (guild_id, author_id, model) DO UPDATE SET
+			embedding = EXCLUDED.embedding,
+			weight = EXCLUDED.weight,
+			message_count = EXCLUDED.message_count,
+			updated_at = EXCLUDED.updated_at`, floatSliceToList(sum)),
+		guildID, authorID, model, weight, count+1, ref,
+	)
+	if err != nil {
+		slog.Error("failed to store user profile", slog.String("author_id", authorID), slog.Any("err", err))
+	}
+}
+
+// RebuildUserProfiles recomputes the profiles of every member of a guild from
+// their stored message embeddings. The embeddings are read and summed without
+// the profile lock, which is only held to swap the profiles in; a message
+// embedded in between is left out until the next rebuild.
+func RebuildUserProfiles(guildID, model string) error {
+	rows, err := duckdbClient.Query(`
+		SELECT m.author_id, m.date, `+dequantizedColumn+`
+		FROM messages m
+		JOIN (
+			SELECT id, MAX(version) AS latest_version
+			FROM messages
+			WHERE guild_id = ?
+			GROUP BY id
+		) latest ON m.id = latest.id AND m.version = latest.latest_version
+		JOIN message_embeddings e ON e.id = m.id AND e.model = ?
+		WHERE m.author_id IS NOT NULL`,
+		guildID, model,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type profile struct {
+		sum    []float32
+		weight float64
+		count  int
+	}
+	now := time.Now().UTC()
+	profiles := make(map[string]*profile)
+	for rows.Next() {
+		var authorID string
+		var date time.Time
+		var list []any
+		if err := rows.Scan(&authorID, &date, &list); err != nil {
+			return err
+		}
+		vec := make([]float32, len(list))
+		for i, v := range list {
+			vec[i], _ = v.(float32)
+		}
+
+		p, ok := profiles[authorID]
+		if !ok {
+			p = &profile{sum: make([]float32, len(vec))}
+			profiles[authorID] = p
+		}
+		if len(vec) != len(p.sum) {
+			continue
+		}
+		decay := profileDecay(now.Sub(date))
+		for i, v := range normalizeVector(vec) {
+			p.sum[i] += v * float32(decay)
+		}
+		p.weight += decay
+		p.count++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	profileMu.Lock()
+	defer profileMu.Unlock()
+
+	tx, err := duckdbClient.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_profiles WHERE guild_id = ? AND model = ?`, guildID, model); err != nil {
+		return err
+	}
+	for authorID, p := range profiles {
+		_, err := tx.Exec(fmt.Sprintf(`
+			INSERT INTO user_profiles (guild_id, author_id, model, embedding, weight, message_count, updated_at)
+			VALUES (?, ?, ?, %s, ?, ?, ?)`, floatSliceToList(p.sum)),
+			guildID, authorID, model, p.weight, p.count, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RebuildAllUserProfiles recomputes the profiles of every guild for the model
+// its searches use.
+func RebuildAllUserProfiles() error {
+	rows, err := duckdbClient.Query(`SELECT DISTINCT guild_id FROM messages`)
+	if err != nil {
+		return err
+	}
+	var guilds []string
+	for rows.Next() {
+		var guildID string
+		if err := rows.Scan(&guildID); err != nil {
+			rows.Close()
+			return err
+		}
+		guilds = append(guilds, guildID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, guildID := range guilds {
+		model, err := ServingEmbeddingModel(guildID)
+		if err == nil {
+			err = RebuildUserProfiles(guildID, model)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("guild %s: %w", guildID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetUserTwins returns the members of a guild whose profiles are most similar
+// to the given member's, most similar first, and how many messages that
+// member's profile is built from. A guild without profiles for its model has
+// them built in the background, and gets ErrProfilesBuilding until they are.
+func GetUserTwins(guildID, authorID string, limit int) ([]UserTwin, int, error) {
+	model, err := ServingEmbeddingModel(guildID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var profiles int
+	if err := duckdbClient.QueryRow(`SELECT COUNT(*) FROM user_profiles WHERE guild_id = ? AND model = ?`, guildID, model).Scan(&profiles); err != nil {
+		return nil, 0, err
+	}
+	if profiles == 0 && buildUserProfiles(guildID, model) {
+		return nil, 0, ErrProfilesBuilding
+	}
+
+	var count int
+	err = duckdbClient.QueryRow(`SELECT message_count FROM user_profiles WHERE guild_id = ? AND author_id = ? AND model = ?`, guildID, authorID, model).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := duckdbClient.Query(`
+		SELECT p.author_id, list_cosine_similarity(p.embedding, me.embedding) AS score, p.message_count
+		FROM user_profiles p
+		JOIN user_profiles me ON me.guild_id = p.guild_id AND me.model = p.model AND me.author_id = ?
+		WHERE p.guild_id = ? AND p.model = ?
+		AND p.author_id <> me.author_id
+		AND p.message_count >= ?
+		AND len(p.embedding) = len(me.embedding)
+		ORDER BY score DESC
+		LIMIT ?`,
+		authorID, guildID, model, profileMinMessages, limit,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var result []UserTwin
+	for rows.Next() {
+		var t UserTwin
+		if err := rows.Scan(&t.AuthorID, &t.Score, &t.Messages); err != nil {
+			return nil, 0, err
+		}
+		result = append(result, t)
+	}
+	return result, count, rows.Err()
+}
+
+// buildUserProfiles starts building a guild's profiles in the background,
+// unless a build for the guild and model is already running. It reports
+// false when the profiles were already built, so there is nothing to wait
+// for.
+func buildUserProfiles(guildID, model string) bool {
+	key := guildID + "/" + model
+	profileBuilds.Lock()
+	defer profileBuilds.Unlock()
+	if profileBuilds.built[key] {
+		return false
+	}
+	if profileBuilds.running[key] {
+		return true
+	}
+	profileBuilds.running[key] = true
+
+	go func() {
+		start := time.Now()
+		err := RebuildUserProfiles(guildID, model)
+
+		profileBuilds.Lock()
+		delete(profileBuilds.running, key)
+		profileBuilds.built[key] = err == nil
+		profileBuilds.Unlock()
+
+		if err != nil {
+			slog.Error("failed to build user profiles", slog.String("guild_id", guildID), slog.Any("err", err))
+			return
+		}
+		slog.Info("built user profiles", slog.String("guild_id", guildID), slog.Duration("took", time.Since(start)))
+	}()
+	return true
+}
+
+// GetSharedTopics returns the labels of the topics both members wrote in,
+// those where the quieter of the two wrote the most first.
+func GetSharedTopics(guildID, authorA, authorB string, limit int) ([]string, error) {
+	model, err := ServingEmbeddingModel(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := duckdbClient.Query(`
+		WITH authored AS (
+			SELECT t.label, m.author_id, COUNT(*) AS messages
+			FROM topics t
+			JOIN topic_messages tm ON tm.topic_id = t.id
+			JOIN (
+				SELECT id, author_id
+				FROM messages
+				WHERE guild_id = ? AND author_id IN (?, ?)
+				QUALIFY ROW_NUMBER() OVER (PARTITION BY id ORDER BY version DESC) = 1
+			) m ON m.id = tm.message_id
+			WHERE t.guild_id = ? AND t.model = ?
+			GROUP BY t.label, m.author_id
+		)
+		SELECT label
+		FROM authored
+		GROUP BY label
+		HAVING COUNT(*) = 2
+		ORDER BY MIN(messages) DESC, label ASC
+		LIMIT ?`,
+		guildID, authorA, authorB, guildID, model, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return nil, err
+		}
+		result = append(result, label)
+	}
+	return result, rows.Err()
+}
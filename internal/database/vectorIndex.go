@@ -0,0 +1,464 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/embeddings"
+	"github.com/stollenaar/statisticsbot/internal/util"
+	"github.com/stollenaar/statisticsbot/internal/vectorindex"
+)
+
+const (
+	// vectorIndexOversample is how many candidates the index returns per
+	// requested result. Candidates are scored exactly afterwards and filtered
+	// to the searching guild, so the index only has to get the right ones
+	// into the pool.
+	vectorIndexOversample = 4
+	// vectorIndexMinCandidates keeps small searches from depending on the
+	// graph getting the first handful exactly right.
+	vectorIndexMinCandidates = 200
+	// vectorIndexSaveInterval is how often indexes with unsaved changes are
+	// written to disk and checked against the table. An index that lags
+	// behind the table after a crash is noticed when it is loaded and rebuilt.
+	vectorIndexSaveInterval = time.Minute
+	// vectorIndexMaxMismatches is how many checks in a row may find the index
+	// holding a different number of vectors than the table before it is
+	// rebuilt. A single mismatch is usually a write landing between the two
+	// counts.
+	vectorIndexMaxMismatches = 2
+)
+
+// vectorIndex is the HNSW graph over every message and chunk embedding of one
+// model. It narrows a semantic search down to candidates, which are then
+// scored exactly in DuckDB, so it never decides the final ranking. Message
+// vectors are keyed by message id, chunk vectors by "<id>/<chunk index>".
+type vectorIndex struct {
+	model string
+	path  string
+
+	mu    sync.Mutex
+	graph *vectorindex.Graph
+	// ready is set once the graph holds every embedding of the model, after a
+	// load that matched the table or a rebuild.
+	ready bool
+	// building is set while the graph is (re)built in the background; writes
+	// that happen meanwhile are kept in pending and replayed on the new graph.
+	building bool
+	pending  []vectorIndexChange
+	dirty    bool
+	// mismatches counts the checks in a row that found the graph out of sync
+	// with the table.
+	mismatches int
+}
+
+// vectorIndexChange is a write to replay on a graph that was being built while
+// it happened. A nil vec removes the key.
+type vectorIndexChange struct {
+	key string
+	vec []float32
+}
+
+var (
+	vectorIndexes   = make(map[string]*vectorIndex)
+	vectorIndexesMu sync.Mutex
+	vectorSaverOnce sync.Once
+)
+
+// LoadVectorIndexes loads or builds, in the background, the index of every
+// model a guild searches with, so the embedding writer keeps them current from
+// startup on.
+func LoadVectorIndexes() {
+	models := map[string]bool{embeddings.ModelName(): true}
+	rows, err := duckdbClient.Query(`SELECT DISTINCT model FROM guild_embedding_models`)
+	if err != nil {
+		slog.Error("Failed to list the serving embedding models", slog.Any("err", err))
+	} else {
+		for rows.Next() {
+			var model string
+			if err := rows.Scan(&model); err == nil {
+				models[model] = true
+			}
+		}
+		rows.Close()
+	}
+
+	for model := range models {
+		getVectorIndex(model)
+	}
+}
+
+// getVectorIndex returns the index of a model, creating it and starting its
+// load in the background when it does not exist yet.
+func getVectorIndex(model string) *vectorIndex {
+	vectorIndexesMu.Lock()
+	defer vectorIndexesMu.Unlock()
+
+	if idx, ok := vectorIndexes[model]; ok {
+		return idx
+	}
+	idx := &vectorIndex{
+		model:    model,
+		path:     vectorIndexPath(model),
+		building: true,
+	}
+	vectorIndexes[model] = idx
+	go idx.load()
+
+	vectorSaverOnce.Do(func() { go saveVectorIndexesPeriodically() })
+	return idx
+}
+
+// loadedVectorIndex returns the index of a model if one is kept, without
+// creating it.
+func loadedVectorIndex(model string) (*vectorIndex, bool) {
+	vectorIndexesMu.Lock()
+	defer vectorIndexesMu.Unlock()
+	idx, ok := vectorIndexes[model]
+	return idx, ok
+}
+
+// vectorIndexPath places a model's index next to statsbot.db.
+func vectorIndexPath(model string) string {
+	name := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(model)
+	return filepath.Join(util.ConfigFile.DUCKDB_PATH, fmt.Sprintf("statsbot.%s.hnsw", name))
+}
+
+// load reads the index from disk and, when the file is missing, unreadable or
+// does not hold as many vectors as the table, rebuilds it from the table.
+func (idx *vectorIndex) load() {
+	graph, err := vectorindex.Load(idx.path)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info("No vector index on disk, building it", slog.String("model", idx.model))
+		idx.rebuild()
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to load the vector index, rebuilding it", slog.String("model", idx.model), slog.Any("err", err))
+		idx.rebuild()
+		return
+	}
+
+	count, err := countEmbeddings(idx.model)
+	if err != nil {
+		slog.Error("Failed to count embeddings", slog.String("model", idx.model), slog.Any("err", err))
+		idx.rebuild()
+		return
+	}
+	if graph.Len() != count {
+		slog.Info("Vector index is stale, rebuilding it", slog.String("model", idx.model), slog.Int("indexed", graph.Len()), slog.Int("stored", count))
+		idx.rebuild()
+		return
+	}
+
+	idx.finish(graph, false)
+	slog.Info("Loaded vector index", slog.String("model", idx.model), slog.Int("vectors", count))
+}
+
+// startRebuild rebuilds the index in the background unless a load or
+// rebuild is already running.
+func (idx *vectorIndex) startRebuild() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.building {
+		return
+	}
+	idx.building = true
+	go idx.rebuild()
+}
+
+// rebuild builds the graph from every embedding of the model in the table.
+// The caller sets building first.
+func (idx *vectorIndex) rebuild() {
+	start := time.Now()
+	graph, err := buildVectorGraph(idx.model)
+	if err != nil {
+		slog.Error("Failed to build the vector index", slog.String("model", idx.model), slog.Any("err", err))
+		idx.mu.Lock()
+		idx.building, idx.pending = false, nil
+		idx.mu.Unlock()
+		return
+	}
+
+	idx.finish(graph, true)
+	slog.Info("Built vector index", slog.String("model", idx.model), slog.Int("vectors", graph.Len()), slog.Duration("took", time.Since(start).Round(time.Millisecond)))
+}
+
+// finish swaps in a freshly loaded or built graph, replaying the writes that
+// happened meanwhile, and saves it when it changed.
+func (idx *vectorIndex) finish(graph *vectorindex.Graph, save bool) {
+	idx.mu.Lock()
+	for _, change := range idx.pending {
+		applyVectorChange(graph, change)
+	}
+	save = save || len(idx.pending) > 0
+	idx.graph, idx.pending = graph, nil
+	idx.ready, idx.building, idx.dirty = true, false, save
+	idx.mu.Unlock()
+
+	if save {
+		idx.save()
+	}
+}
+
+func buildVectorGraph(model string) (*vectorindex.Graph, error) {
+	rows, err := duckdbClient.Query(`
+		SELECT id, `+dequantizedColumn+` FROM message_embeddings WHERE model = ?
+		UNION ALL
+		SELECT id || '/' || chunk_index, `+dequantizedColumn+` FROM message_embedding_chunks WHERE model = ?`,
+		model, model,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	graph := vectorindex.New(0, 0)
+	for rows.Next() {
+		var key string
+		var list []any
+		if err := rows.Scan(&key, &list); err != nil {
+			return nil, err
+		}
+		vec := make([]float32, len(list))
+		for i, v := range list {
+			vec[i], _ = v.(float32)
+		}
+		if err := graph.Add(key, vec); err != nil {
+			return nil, fmt.Errorf("embedding %s: %w", key, err)
+		}
+	}
+	return graph, rows.Err()
+}
+
+func countEmbeddings(model string) (int, error) {
+	var count int
+	err := duckdbClient.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM message_embeddings WHERE model = ?)
+		     + (SELECT COUNT(*) FROM message_embedding_chunks WHERE model = ?)`,
+		model, model,
+	).Scan(&count)
+	return count, err
+}
+
+func applyVectorChange(graph *vectorindex.Graph, change vectorIndexChange) {
+	if change.vec == nil {
+		graph.Delete(change.key)
+		return
+	}
+	// A vector of the wrong size can only come from a model that changed its
+	// output under the same name; it leaves the index short, and so stale.
+	_ = graph.Add(change.key, change.vec)
+}
+
+// applyLocked records writes to the index: on the graph when it is ready, or
+// for replay once the running build finishes. Callers must hold idx.mu.
+func (idx *vectorIndex) applyLocked(changes ...vectorIndexChange) {
+	if idx.building {
+		idx.pending = append(idx.pending, changes...)
+		return
+	}
+	if !idx.ready {
+		return
+	}
+	for _, change := range changes {
+		applyVectorChange(idx.graph, change)
+	}
+	idx.dirty = true
+}
+
+// indexMessageEmbeddings updates the model's index, if one is kept, after a
+// message's vectors were saved. Chunks the message no longer has are removed.
+func indexMessageEmbeddings(id, model string, vecs [][]float32) {
+	idx, ok := loadedVectorIndex(model)
+	if !ok {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	changes := []vectorIndexChange{{key: id, vec: vecs[0]}}
+	if len(vecs) > 1 {
+		for i, vec := range vecs {
+			changes = append(changes, vectorIndexChange{key: fmt.Sprintf("%s/%d", id, i), vec: vec})
+		}
+	}
+	if idx.graph != nil {
+		for i := len(changes) - 1; ; i++ {
+			key := fmt.Sprintf("%s/%d", id, i)
+			if !idx.graph.Has(key) {
+				break
+			}
+			changes = append(changes, vectorIndexChange{key: key})
+		}
+	}
+	idx.applyLocked(changes...)
+}
+
+// candidates returns the ids of the messages nearest to the query vector, at
+// most k of them, or false when the index cannot be used: still building,
+// empty, or of another dimension. Whether it kept up with the table is left to
+// checkVectorIndexes, which rebuilds it when it did not.
+func (idx *vectorIndex) candidates(vec []float32, k int) (ids []string, ok bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.ready || idx.building || idx.graph.Len() == 0 || len(vec) != idx.graph.Dims() {
+		return nil, false
+	}
+
+	seen := make(map[string]bool)
+	for _, result := range idx.graph.Search(vec, k, max(k, vectorIndexMinCandidates)) {
+		id, _, _ := strings.Cut(result.Key, "/")
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, true
+}
+
+// save writes the index to disk if it changed since it was last saved. The
+// graph is snapshotted under the lock and written outside it, so searches and
+// writes do not wait on the disk.
+func (idx *vectorIndex) save() {
+	idx.mu.Lock()
+	if !idx.ready || !idx.dirty {
+		idx.mu.Unlock()
+		return
+	}
+	snapshot := idx.graph.Snapshot()
+	idx.dirty = false
+	idx.mu.Unlock()
+
+	if err := snapshot.Save(idx.path); err != nil {
+		slog.Error("Failed to save the vector index", slog.String("model", idx.model), slog.Any("err", err))
+		idx.mu.Lock()
+		idx.dirty = true
+		idx.mu.Unlock()
+	}
+}
+
+// check compares the number of vectors in the graph with the table and
+// rebuilds the index once they differed vectorIndexMaxMismatches checks in a
+// row.
+func (idx *vectorIndex) check() {
+	idx.mu.Lock()
+	usable := idx.ready && !idx.building
+	idx.mu.Unlock()
+	if !usable {
+		return
+	}
+
+	count, err := countEmbeddings(idx.model)
+	if err != nil {
+		slog.Error("Failed to count embeddings", slog.String("model", idx.model), slog.Any("err", err))
+		return
+	}
+
+	idx.mu.Lock()
+	indexed := idx.graph.Len()
+	if indexed == count {
+		idx.mismatches = 0
+	} else {
+		idx.mismatches++
+	}
+	rebuild := idx.mismatches >= vectorIndexMaxMismatches
+	if rebuild {
+		idx.mismatches = 0
+	}
+	idx.mu.Unlock()
+
+	if rebuild {
+		slog.Info("Vector index is out of sync, rebuilding it", slog.String("model", idx.model), slog.Int("indexed", indexed), slog.Int("stored", count))
+		idx.startRebuild()
+	}
+}
+
+func saveVectorIndexesPeriodically() {
+	ticker := time.NewTicker(vectorIndexSaveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		checkVectorIndexes()
+		compactVectorIndexes()
+		saveVectorIndexes()
+	}
+}
+
+// checkVectorIndexes checks every index against the table.
+func checkVectorIndexes() {
+	vectorIndexesMu.Lock()
+	indexes := make([]*vectorIndex, 0, len(vectorIndexes))
+	for _, idx := range vectorIndexes {
+		indexes = append(indexes, idx)
+	}
+	vectorIndexesMu.Unlock()
+
+	for _, idx := range indexes {
+		idx.check()
+	}
+}
+
+// saveVectorIndexes writes every index with unsaved changes to disk.
+func saveVectorIndexes() {
+	vectorIndexesMu.Lock()
+	indexes := make([]*vectorIndex, 0, len(vectorIndexes))
+	for _, idx := range vectorIndexes {
+		indexes = append(indexes, idx)
+	}
+	vectorIndexesMu.Unlock()
+
+	for _, idx := range indexes {
+		idx.save()
+	}
+}
+
+// compactVectorIndexes rebuilds the indexes in which replaced and removed
+// vectors, which deleting only marks, make up more than a quarter of the
+// graph.
+func compactVectorIndexes() {
+	vectorIndexesMu.Lock()
+	indexes := make([]*vectorIndex, 0, len(vectorIndexes))
+	for _, idx := range vectorIndexes {
+		indexes = append(indexes, idx)
+	}
+	vectorIndexesMu.Unlock()
+
+	for _, idx := range indexes {
+		idx.mu.Lock()
+		compact := idx.ready && idx.graph.Deleted() > idx.graph.Len()/3
+		idx.mu.Unlock()
+		if compact {
+			idx.startRebuild()
+		}
+	}
+}
+
+// switchVectorIndex is called when a guild's searches move to another model.
+// The new model's index is rebuilt from the freshly migrated embeddings, and
+// the index of a model no guild searches with anymore is dropped.
+func switchVectorIndex(from, to string) {
+	getVectorIndex(to).startRebuild()
+
+	if from == "" || from == to || from == embeddings.ModelName() {
+		return
+	}
+	var inUse bool
+	if err := duckdbClient.QueryRow(`SELECT COUNT(*) > 0 FROM guild_embedding_models WHERE model = ?`, from).Scan(&inUse); err != nil || inUse {
+		return
+	}
+
+	vectorIndexesMu.Lock()
+	delete(vectorIndexes, from)
+	vectorIndexesMu.Unlock()
+	if err := os.Remove(vectorIndexPath(from)); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove the vector index", slog.String("model", from), slog.Any("err", err))
+	}
+}
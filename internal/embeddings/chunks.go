@@ -0,0 +1,106 @@
+package embeddings
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/knights-analytics/hugot/backends"
+)
+
+const (
+	// chunkTokens keeps a chunk within the 256 tokens sentence-transformers
+	// models are trained on, leaving room for the special tokens.
+	chunkTokens = 200
+	// chunkOverlap is how many tokens consecutive chunks share, so a phrase
+	// cut at a chunk border is still whole in one of them.
+	chunkOverlap = 40
+)
+
+// Chunk is a piece of a message that is embedded on its own. Start and End are
+// byte offsets into the trimmed text it was cut from.
+type Chunk struct {
+	Index int
+	Start int
+	End   int
+	Text  string
+}
+
+// Split cuts text into overlapping chunks of at most chunkTokens tokens of the
+// named model's tokenizer. Text that fits in one chunk comes back as a single
+// chunk covering all of it. When the tokenizer gives no offsets the text is
+// split on runes instead, at the size EmbedWith would cap it to.
+func Split(name, text string) ([]Chunk, error) {
+	text = strings.TrimSpace(text)
+
+	pipeline, err := loadModel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var spans [][2]uint
+	if pipeline.Model != nil && pipeline.Model.Tokenizer != nil {
+		batch := backends.PipelineBatch{}
+		backends.TokenizeInputs(&batch, pipeline.Model.Tokenizer, []string{text})
+		if len(batch.Input) == 1 {
+			input := batch.Input[0]
+			for i, span := range input.Offsets {
+				if i < len(input.SpecialTokensMask) && input.SpecialTokensMask[i] == 1 {
+					continue
+				}
+				if span[1] > span[0] && int(span[1]) <= len(text) {
+					spans = append(spans, span)
+				}
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return splitRunes(text), nil
+	}
+	return splitSpans(text, spans), nil
+}
+
+// splitSpans cuts text into overlapping chunks of chunkTokens of the given
+// token spans.
+func splitSpans(text string, spans [][2]uint) []Chunk {
+	if len(spans) <= chunkTokens {
+		return []Chunk{{Index: 0, Start: 0, End: len(text), Text: text}}
+	}
+
+	var chunks []Chunk
+	for first := 0; ; first += chunkTokens - chunkOverlap {
+		last := min(first+chunkTokens, len(spans)) - 1
+		start, end := int(spans[first][0]), int(spans[last][1])
+		// Offsets of byte-level tokenizers can fall inside a multi-byte rune;
+		// widen the chunk to whole runes.
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+		chunks = append(chunks, Chunk{Index: len(chunks), Start: start, End: end, Text: text[start:end]})
+		if last == len(spans)-1 {
+			return chunks
+		}
+	}
+}
+
+// splitRunes is the fallback for Split, cutting on rune counts.
+func splitRunes(text string) []Chunk {
+	runes := []rune(text)
+	if len(runes) <= firstAttemptRunes {
+		return []Chunk{{Index: 0, Start: 0, End: len(text), Text: text}}
+	}
+
+	overlap := firstAttemptRunes * chunkOverlap / chunkTokens
+	var chunks []Chunk
+	for first := 0; ; first += firstAttemptRunes - overlap {
+		last := min(first+firstAttemptRunes, len(runes))
+		start := len(string(runes[:first]))
+		end := start + len(string(runes[first:last]))
+		chunks = append(chunks, Chunk{Index: len(chunks), Start: start, End: end, Text: text[start:end]})
+		if last == len(runes) {
+			return chunks
+		}
+	}
+}
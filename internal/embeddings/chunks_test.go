@@ -0,0 +1,105 @@
+package embeddings
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// wordSpans returns the byte span of every space-separated word, the way a
+// tokenizer's offsets would.
+func wordSpans(text string) [][2]uint {
+	var spans [][2]uint
+	start := 0
+	for i, r := range text + " " {
+		if r == ' ' {
+			if i > start {
+				spans = append(spans, [2]uint{uint(start), uint(i)})
+			}
+			start = i + 1
+		}
+	}
+	return spans
+}
+
+func TestSplitSpans(t *testing.T) {
+	short := "a message that fits in one chunk"
+	if chunks := splitSpans(short, wordSpans(short)); len(chunks) != 1 || chunks[0].Text != short {
+		t.Fatalf("short text split into %+v, want it whole", chunks)
+	}
+
+	words := make([]string, 500)
+	for i := range words {
+		words[i] = "word"
+	}
+	text := strings.Join(words, " ")
+	spans := wordSpans(text)
+	chunks := splitSpans(text, spans)
+
+	// 500 tokens with a stride of 160: chunks start at tokens 0, 160 and 320.
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(chunks))
+	}
+	for i, c := range chunks {
+		if c.Index != i {
+			t.Errorf("chunk %d has index %d", i, c.Index)
+		}
+		if c.Text != text[c.Start:c.End] {
+			t.Errorf("chunk %d text does not match its offsets", i)
+		}
+		if n := len(strings.Fields(c.Text)); n > chunkTokens {
+			t.Errorf("chunk %d has %d tokens, want at most %d", i, n, chunkTokens)
+		}
+		if i > 0 {
+			overlap := chunks[i-1].End - c.Start
+			if want := chunkOverlap*len("word ") - 1; overlap != want {
+				t.Errorf("chunks %d and %d overlap %d bytes, want %d", i-1, i, overlap, want)
+			}
+		}
+	}
+	if chunks[0].Start != 0 || chunks[len(chunks)-1].End != len(text) {
+		t.Errorf("chunks cover %d-%d, want 0-%d", chunks[0].Start, chunks[len(chunks)-1].End, len(text))
+	}
+}
+
+// A byte-level tokenizer can put a token border inside a multi-byte rune; the
+// chunk is widened to whole runes.
+func TestSplitSpansWholeRunes(t *testing.T) {
+	text := strings.Repeat("é", 300)
+	var spans [][2]uint
+	for i := 0; i < len(text); i++ {
+		spans = append(spans, [2]uint{uint(i), uint(i + 1)})
+	}
+	for _, c := range splitSpans(text, spans) {
+		if !utf8.ValidString(c.Text) {
+			t.Errorf("chunk %d cuts a rune: %q", c.Index, c.Text)
+		}
+	}
+}
+
+func TestSplitRunes(t *testing.T) {
+	short := strings.Repeat("é", firstAttemptRunes)
+	if chunks := splitRunes(short); len(chunks) != 1 {
+		t.Fatalf("text of firstAttemptRunes split into %d chunks, want 1", len(chunks))
+	}
+
+	text := strings.Repeat("é", 2*firstAttemptRunes)
+	chunks := splitRunes(text)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the text split", len(chunks))
+	}
+	for i, c := range chunks {
+		if c.Text != text[c.Start:c.End] || !utf8.ValidString(c.Text) {
+			t.Errorf("chunk %d does not match its offsets on rune borders", i)
+		}
+		if n := utf8.RuneCountInString(c.Text); n > firstAttemptRunes {
+			t.Errorf("chunk %d has %d runes, want at most %d", i, n, firstAttemptRunes)
+		}
+		if i > 0 && c.Start >= chunks[i-1].End {
+			t.Errorf("chunks %d and %d do not overlap", i-1, i)
+		}
+	}
+	if last := chunks[len(chunks)-1]; last.End != len(text) {
+		t.Errorf("last chunk ends at %d, want %d", last.End, len(text))
+	}
+}
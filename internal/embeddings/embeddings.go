@@ -1,7 +1,16 @@
 // Package embeddings produces sentence embeddings locally using a hugot
 // feature-extraction pipeline (default: sentence-transformers/all-MiniLM-L6-v2)
 // running on hugot's pure-Go backend, so no ONNX runtime system library is
-// required. The model is downloaded from HuggingFace on first use.
+// required. Models are found, and downloaded from HuggingFace on first use,
+// by the localmodels package, which honours EMBEDDING_MODELS_DIR and
+// EMBEDDING_OFFLINE.
+//
+// The model is configurable through EMBEDDING_MODEL, and more than one model
+// can be loaded at a time: while a guild is migrated to a new model, its
+// searches keep embedding queries with the old one.
+//
+// Stored messages are cut into overlapping chunks with Split so the tail of a
+// long message is searchable; queries are short and embedded whole.
 package embeddings
 
 import (
@@ -9,16 +18,23 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/knights-analytics/hugot"
 	"github.com/knights-analytics/hugot/pipelines"
+	"github.com/stollenaar/statisticsbot/internal/localmodels"
+	"github.com/stollenaar/statisticsbot/internal/util"
 )
 
 const (
+	// MODEL_NAME is the model used when EMBEDDING_MODEL is not set.
 	MODEL_NAME = "sentence-transformers/all-MiniLM-L6-v2"
 
+	defaultOnnxFilePath = "onnx/model.onnx"
+
 	// all-MiniLM-L6-v2 has a hard 512-token context window (position embeddings),
 	// and sentence-transformers truncates to 256 by default. The Go-backend
 	// tokenizer does not reliably truncate, so we cap input length ourselves.
@@ -26,72 +42,121 @@ const (
 	// token-dense input that still overflows we shrink and retry.
 	firstAttemptRunes = 1200
 	minAttemptRunes   = 128
+
+	// defaultWorkers is how many pipeline runs may happen at once when
+	// EMBEDDING_WORKERS is not set.
+	defaultWorkers = 2
 )
 
 var (
-	initOnce sync.Once
-	initErr  error
+	// models holds a pipeline per model name, loaded on first use.
+	models   = make(map[string]*model)
+	modelsMu sync.Mutex
+
+	// runSlots bounds the pipeline runs in flight to Workers. Besides the
+	// embedding queue, searches, /similar and the migrations embed on the
+	// request path, and would otherwise run as many at once as there are
+	// requests.
+	runSlots     chan struct{}
+	runSlotsOnce sync.Once
+)
 
-	session  *hugot.Session
+type model struct {
+	once     sync.Once
+	err      error
 	pipeline *pipelines.FeatureExtractionPipeline
+}
 
-	runMu sync.Mutex
-)
+// Workers returns how many texts may be embedded at once, from
+// EMBEDDING_WORKERS. The embedding queue starts this many workers.
+func Workers() int {
+	if n, err := strconv.Atoi(util.ConfigFile.EMBEDDING_WORKERS); err == nil && n > 0 {
+		return n
+	}
+	return defaultWorkers
+}
 
 // ModelName returns the configured embedding model identifier. Stored alongside
 // each vector so search only compares vectors produced by the same model.
 func ModelName() string {
+	if name := util.ConfigFile.EMBEDDING_MODEL; name != "" {
+		return name
+	}
 	return MODEL_NAME
 }
 
-func initPipeline() {
-	ctx := context.Background()
-
-	session, initErr = hugot.NewGoSession(ctx)
-	if initErr != nil {
-		return
+func onnxFilePath() string {
+	if p := util.ConfigFile.EMBEDDING_ONNX_PATH; p != "" {
+		return p
 	}
+	return defaultOnnxFilePath
+}
 
-	modelPath, err := ensureModel(ctx, "sentence-transformers/all-MiniLM-L6-v2")
-
+// loadModel returns the pipeline for the named model, creating it the first
+// time it is needed.
+func loadModel(name string) (*pipelines.FeatureExtractionPipeline, error) {
+	session, err := localmodels.Session()
 	if err != nil {
-		initErr = err
-		return
+		return nil, err
 	}
 
-	config := hugot.FeatureExtractionConfig{
-		ModelPath: modelPath,
-		Name:      "semantic-embeddings",
+	modelsMu.Lock()
+	m, ok := models[name]
+	if !ok {
+		m = &model{}
+		models[name] = m
 	}
+	modelsMu.Unlock()
 
-	pipeline, initErr = hugot.NewPipeline(session, config)
-}
+	m.once.Do(func() {
+		ctx := context.Background()
 
-// ensureModel returns the local path to the model, downloading it from
-// HuggingFace into MODELS_PATH the first time.
-func ensureModel(ctx context.Context, name string) (string, error) {
+		modelPath, err := ensureModel(ctx, name)
+		if err != nil {
+			m.err = err
+			return
+		}
 
-	opts := hugot.NewDownloadOptions()
-	opts.OnnxFilePath = "onnx/model.onnx"
+		config := hugot.FeatureExtractionConfig{
+			ModelPath:    modelPath,
+			Name:         "semantic-embeddings-" + name,
+			OnnxFilename: filepath.Base(onnxFilePath()),
+		}
+		m.pipeline, m.err = hugot.NewPipeline(session, config)
+	})
+	return m.pipeline, m.err
+}
 
-	modelsDir := "./models/"
-	if err := os.MkdirAll(modelsDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create models directory: %w", err)
+// ensureModel returns the local path to the model. The configured model can
+// be pinned to a directory with EMBEDDING_MODEL_PATH; any other is left to
+// localmodels.Path.
+func ensureModel(ctx context.Context, name string) (string, error) {
+	if local := util.ConfigFile.EMBEDDING_MODEL_PATH; local != "" && name == ModelName() {
+		if _, err := os.Stat(local); err != nil {
+			return "", fmt.Errorf("embedding model path %s: %w", local, err)
+		}
+		return local, nil
 	}
+	return localmodels.Path(ctx, name, onnxFilePath())
+}
 
-	return hugot.DownloadModel(ctx, name, modelsDir, opts)
+// Embed returns the embedding vector for a single input string using the
+// configured model.
+func Embed(text string) ([]float32, error) {
+	return EmbedWith(ModelName(), text)
 }
 
-// Embed returns the embedding vector for a single input string. The pipeline is
-// initialized (and the model downloaded) on first call.
+// EmbedWith returns the embedding vector for a single input string using the
+// named model. The model's pipeline is initialized (and the model downloaded)
+// on first call.
 //
 // Inputs longer than the model's 512-token context window would crash the
 // pipeline during graph construction, so the text is capped up front and, if it
 // still overflows, halved and retried until it fits.
-func Embed(text string) ([]float32, error) {
-	initOnce.Do(initPipeline)
-	if initErr != nil {
-		return nil, initErr
+func EmbedWith(name, text string) ([]float32, error) {
+	pipeline, err := loadModel(name)
+	if err != nil {
+		return nil, err
 	}
 
 	runes := []rune(strings.TrimSpace(text))
@@ -106,7 +171,7 @@ func Embed(text string) ([]float32, error) {
 
 	var lastErr error
 	for {
-		vec, err := runEmbed(string(runes[:limit]))
+		vec, err := runEmbed(pipeline, string(runes[:limit]))
 		if err == nil {
 			return vec, nil
 		}
@@ -120,12 +185,48 @@ func Embed(text string) ([]float32, error) {
 	}
 }
 
-// runEmbed runs a single input through the pipeline under the run lock.
-func runEmbed(text string) ([]float32, error) {
-	runMu.Lock()
-	defer runMu.Unlock()
+// EmbedBatch embeds several texts with the named model in a single pipeline
+// run. Texts are capped like in EmbedWith; if the batch as a whole fails, for
+// instance because one text still overflows the context window, every text is
+// retried on its own so one bad input does not fail the rest. vecs[i] is nil
+// for a text that could not be embedded, and err is only set when the model
+// itself could not be loaded.
+func EmbedBatch(name string, texts []string) (vecs [][]float32, err error) {
+	pipeline, err := loadModel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]string, len(texts))
+	for i, text := range texts {
+		runes := []rune(strings.TrimSpace(text))
+		inputs[i] = string(runes[:min(len(runes), firstAttemptRunes)])
+	}
+
+	out, err := runPipeline(pipeline, inputs)
+	if err == nil && len(out.Embeddings) == len(inputs) {
+		return out.Embeddings, nil
+	}
+
+	vecs = make([][]float32, len(texts))
+	for i, text := range texts {
+		vecs[i], _ = EmbedWith(name, text)
+	}
+	return vecs, nil
+}
+
+// runPipeline runs the inputs through the pipeline once a run slot is free.
+func runPipeline(pipeline *pipelines.FeatureExtractionPipeline, inputs []string) (*pipelines.FeatureExtractionOutput, error) {
+	runSlotsOnce.Do(func() { runSlots = make(chan struct{}, Workers()) })
+	runSlots <- struct{}{}
+	defer func() { <-runSlots }()
+
+	return pipeline.RunPipeline(context.Background(), inputs)
+}
 
-	out, err := pipeline.RunPipeline(context.Background(), []string{text})
+// runEmbed runs a single input through the pipeline.
+func runEmbed(pipeline *pipelines.FeatureExtractionPipeline, text string) ([]float32, error) {
+	out, err := runPipeline(pipeline, []string{text})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrEmptyResponse is returned when the backend answered without any content.
+var ErrEmptyResponse = errors.New("llm returned an empty response")
+
+// StatusError is returned when the backend answers with a non-2xx status.
+type StatusError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the request is worth retrying: rate limits and
+// server-side failures are, bad requests and auth failures are not.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// DecodeError is returned when the backend's reply cannot be parsed.
+type DecodeError struct {
+	Provider string
+	Body     string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s response: %s", e.Provider, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TransportError wraps failures to reach the backend at all.
+type TransportError struct {
+	Provider string
+	Err      error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("%s request failed: %s", e.Provider, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// retryable reports whether err is a transient failure. Context cancellation
+// and deadlines are never retried, since the caller has given up.
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return errors.Is(err, ErrEmptyResponse)
+}
@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// Fake is a deterministic provider for running without a model. It replays
+// Responses in order, wrapping around at the end, and records every request
+// it received in Calls.
+type Fake struct {
+	// Responses are returned in order. With none set, a request with a schema
+	// gets a placeholder reply that follows it, and any other request gets
+	// its last message echoed back.
+	Responses []string
+	// Err, when set, is returned by every call instead of a response.
+	Err error
+
+	mu    sync.Mutex
+	Calls []Request
+}
+
+// NewFake returns a Fake replaying the given responses.
+func NewFake(responses ...string) *Fake {
+	return &Fake{Responses: responses}
+}
+
+func (f *Fake) Name() string {
+	return ProviderFake
+}
+
+func (f *Fake) Chat(ctx context.Context, req Request) (Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	call := len(f.Calls)
+	f.Calls = append(f.Calls, req)
+
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+	if f.Err != nil {
+		return Response{}, f.Err
+	}
+	if len(f.Responses) == 0 {
+		return Response{Model: req.Model, Content: placeholder(req)}, nil
+	}
+	return Response{
+		Model:   req.Model,
+		Content: f.Responses[call%len(f.Responses)],
+	}, nil
+}
+
+// placeholder is the reply of a Fake without responses.
+func placeholder(req Request) string {
+	if req.Schema != nil {
+		data, err := json.Marshal(req.Schema.example())
+		if err == nil {
+			return string(data)
+		}
+	}
+	if len(req.Messages) == 0 {
+		return ProviderFake
+	}
+	return req.Messages[len(req.Messages)-1].Content
+}
+
+// ChatStream hands out the reply word by word, so streaming consumers can be
+// exercised without a model.
+func (f *Fake) ChatStream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	resp, err := f.Chat(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	for _, word := range strings.SplitAfter(resp.Content, " ") {
+		onDelta(word)
+	}
+	return resp, nil
+}
@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// httpClient has no timeout of its own; every request carries the caller's
+// context deadline instead.
+var httpClient = &http.Client{}
+
+// maxStreamLine bounds a single streamed chunk. Chunks are normally a few
+// tokens, the final one carries the usage counters.
+const maxStreamLine = 1024 * 1024
+
+// normalizeURL keeps the historical OLLAMA_URL format working: it used to be
+// given without a scheme and was always reached over https.
+func normalizeURL(raw string) string {
+	raw = strings.TrimRight(raw, "/")
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	return raw
+}
+
+// postJSON sends payload to url and decodes a 200 reply into out.
+func postJSON(ctx context.Context, provider, url string, payload, out any) error {
+	resp, err := post(ctx, provider, url, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &TransportError{Provider: provider, Err: err}
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return &DecodeError{Provider: provider, Body: string(body), Err: err}
+	}
+	return nil
+}
+
+// postStream sends payload to url and calls onLine with every non-empty line
+// of the reply as it arrives. Both Ollama's NDJSON and server-sent events are
+// line based.
+func postStream(ctx context.Context, provider, url string, payload any, onLine func(line []byte) error) error {
+	resp, err := post(ctx, provider, url, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := onLine(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return &TransportError{Provider: provider, Err: err}
+	}
+	return nil
+}
+
+// post sends payload to url, returning the response only when it is a 200.
+// The caller closes the body.
+func post(ctx context.Context, provider, url string, payload any) (*http.Response, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	if err := setAuth(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, &TransportError{Provider: provider, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Provider: provider, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// setAuth applies the configured auth scheme to a request.
+func setAuth(req *http.Request) error {
+	switch util.ConfigFile.OLLAMA_AUTH_TYPE {
+	case "basic":
+		username, err := util.GetOllamaUsername()
+		if err != nil {
+			return err
+		}
+		password, err := util.GetOllamaPassword()
+		if err != nil {
+			return err
+		}
+		token := b64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password)))
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", token))
+	case "api_key":
+		token, err := util.GetOllamaAPIKey()
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	return nil
+}
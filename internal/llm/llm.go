@@ -0,0 +1,157 @@
+// Package llm talks to the language model used for summaries and narration.
+// Providers hide the wire format of the backend (native Ollama or any
+// OpenAI-compatible chat completions endpoint), so callers only deal with
+// Request and Response and can swap in the Fake provider when offline.
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+const (
+	ProviderOllama = "ollama"
+	ProviderOpenAI = "openai"
+	ProviderFake   = "fake"
+
+	defaultTimeout    = 5 * time.Minute
+	defaultMaxRetries = 2
+	defaultBackoff    = 2 * time.Second
+)
+
+var (
+	// defaultMu guards the shared provider, which SetDefault may replace
+	// while it is in use.
+	defaultMu       sync.Mutex
+	defaultBuilt    bool
+	defaultProvider Provider
+	defaultErr      error
+)
+
+// Message is a single chat message.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request is a provider-agnostic chat request.
+type Request struct {
+	Model            string
+	Messages         []Message
+	Temperature      float32
+	MaxTokens        int
+	FrequencyPenalty float32
+	PresencePenalty  float32
+	// Schema is the JSON schema the reply has to follow. Nil asks for free text.
+	Schema *Schema
+}
+
+// Response is the model's reply.
+type Response struct {
+	Model            string
+	Content          string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Provider is a chat backend. Implementations must honour the context
+// deadline and return the typed errors from this package where they apply.
+type Provider interface {
+	Name() string
+	Chat(ctx context.Context, req Request) (Response, error)
+}
+
+// Default returns the provider selected by LLM_PROVIDER, wrapped with the
+// configured retries. It is built once and shared.
+func Default() (Provider, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if !defaultBuilt {
+		defaultProvider, defaultErr = FromConfig(util.ConfigFile)
+		defaultBuilt = true
+	}
+	return defaultProvider, defaultErr
+}
+
+// SetDefault replaces the shared provider, e.g. with a Fake when running
+// without a model. Requests already sent keep the provider they got.
+func SetDefault(p Provider) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultProvider, defaultErr, defaultBuilt = p, nil, true
+}
+
+// FromConfig builds the provider described by the config.
+func FromConfig(c *util.Config) (Provider, error) {
+	var p Provider
+	switch strings.ToLower(c.LLM_PROVIDER) {
+	case "", ProviderOpenAI:
+		p = NewOpenAI(c.OLLAMA_URL)
+	case ProviderOllama:
+		p = NewOllama(c.OLLAMA_URL)
+	case ProviderFake:
+		return fakeFromFile(c.LLM_FAKE_RESPONSES)
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q", c.LLM_PROVIDER)
+	}
+
+	retries := defaultMaxRetries
+	if c.LLM_MAX_RETRIES != "" {
+		n, err := strconv.Atoi(c.LLM_MAX_RETRIES)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LLM_MAX_RETRIES: %w", err)
+		}
+		retries = n
+	}
+	return WithRetry(p, retries, defaultBackoff), nil
+}
+
+// fakeFromFile returns a Fake replaying the replies in path, a JSON array of
+// strings. Without a path it answers every request with a placeholder; see
+// Fake.
+func fakeFromFile(path string) (*Fake, error) {
+	if path == "" {
+		return NewFake(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LLM_FAKE_RESPONSES: %w", err)
+	}
+	var responses []string
+	if err := json.Unmarshal(data, &responses); err != nil {
+		return nil, fmt.Errorf("LLM_FAKE_RESPONSES is not a JSON array of strings: %w", err)
+	}
+	return NewFake(responses...), nil
+}
+
+// Timeout is the deadline callers should put on a single generation.
+func Timeout() time.Duration {
+	if d, err := time.ParseDuration(util.ConfigFile.LLM_TIMEOUT); err == nil && d > 0 {
+		return d
+	}
+	return defaultTimeout
+}
+
+// Chat sends the request to the default provider under the configured
+// timeout. The model falls back to OLLAMA_MODEL when the request has none.
+func Chat(ctx context.Context, req Request) (Response, error) {
+	p, err := Default()
+	if err != nil {
+		return Response{}, err
+	}
+	if req.Model == "" {
+		req.Model = util.ConfigFile.OLLAMA_MODEL
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout())
+	defer cancel()
+	return p.Chat(ctx, req)
+}
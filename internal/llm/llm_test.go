@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+func TestFromConfigFake(t *testing.T) {
+	dir := t.TempDir()
+	canned := filepath.Join(dir, "responses.json")
+	if err := os.WriteFile(canned, []byte(`["first", "second"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(broken, []byte(`{"not": "a list"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := FromConfig(&util.Config{LLM_PROVIDER: "fake", LLM_FAKE_RESPONSES: canned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"first", "second", "first"} {
+		if resp, err := p.Chat(context.Background(), Request{}); err != nil || resp.Content != want {
+			t.Errorf("Chat = %q, %v, want %q", resp.Content, err, want)
+		}
+	}
+
+	for _, c := range []*util.Config{
+		{LLM_PROVIDER: "fake", LLM_FAKE_RESPONSES: filepath.Join(dir, "missing.json")},
+		{LLM_PROVIDER: "fake", LLM_FAKE_RESPONSES: broken},
+		{LLM_PROVIDER: "nope"},
+		{LLM_PROVIDER: "ollama", LLM_MAX_RETRIES: "many"},
+	} {
+		if _, err := FromConfig(c); err == nil {
+			t.Errorf("FromConfig(%+v) succeeded", c)
+		}
+	}
+}
+
+// TestSetDefaultConcurrent is meant for -race: replacing the provider while
+// requests pick it up must not race.
+func TestSetDefaultConcurrent(t *testing.T) {
+	useFake(t, NewFake("a"))
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SetDefault(NewFake("b"))
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := Chat(context.Background(), Request{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,115 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Ollama talks to Ollama's native /api/chat endpoint.
+type Ollama struct {
+	url string
+}
+
+type ollamaRequest struct {
+	Model    string         `json:"model"`
+	Messages []Message      `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Format   any            `json:"format,omitempty"`
+	Options  map[string]any `json:"options,omitempty"`
+}
+
+type ollamaResponse struct {
+	Model           string  `json:"model"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+	// Error is set on a streamed chunk when generation fails halfway.
+	Error string `json:"error"`
+}
+
+// NewOllama returns a provider for the Ollama server at baseURL, e.g.
+// "http://ollama:11434".
+func NewOllama(baseURL string) *Ollama {
+	return &Ollama{url: normalizeURL(baseURL) + "/api/chat"}
+}
+
+func (o *Ollama) Name() string {
+	return ProviderOllama
+}
+
+func (o *Ollama) Chat(ctx context.Context, req Request) (Response, error) {
+	var out ollamaResponse
+	if err := postJSON(ctx, o.Name(), o.url, o.payload(req, false), &out); err != nil {
+		return Response{}, err
+	}
+	if out.Message.Content == "" {
+		return Response{}, ErrEmptyResponse
+	}
+	return Response{
+		Model:            out.Model,
+		Content:          out.Message.Content,
+		PromptTokens:     out.PromptEvalCount,
+		CompletionTokens: out.EvalCount,
+	}, nil
+}
+
+// ChatStream reads Ollama's newline-delimited chunks; the last one has done
+// set and carries the token counts.
+func (o *Ollama) ChatStream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	var (
+		result  Response
+		content strings.Builder
+	)
+	err := postStream(ctx, o.Name(), o.url, o.payload(req, true), func(line []byte) error {
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return &DecodeError{Provider: o.Name(), Body: string(line), Err: err}
+		}
+		if chunk.Error != "" {
+			return &StatusError{Provider: o.Name(), StatusCode: http.StatusInternalServerError, Body: chunk.Error}
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			onDelta(chunk.Message.Content)
+		}
+		if chunk.Done {
+			result.Model = chunk.Model
+			result.PromptTokens = chunk.PromptEvalCount
+			result.CompletionTokens = chunk.EvalCount
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	if content.Len() == 0 {
+		return Response{}, ErrEmptyResponse
+	}
+	result.Content = content.String()
+	return result, nil
+}
+
+func (o *Ollama) payload(req Request, stream bool) ollamaRequest {
+	payload := ollamaRequest{
+		Model:    req.Model,
+		Messages: req.Messages,
+		Stream:   stream,
+		Options: map[string]any{
+			"temperature":       req.Temperature,
+			"frequency_penalty": req.FrequencyPenalty,
+			"presence_penalty":  req.PresencePenalty,
+		},
+	}
+	if req.MaxTokens > 0 {
+		payload.Options["num_predict"] = req.MaxTokens
+	}
+	// Ollama accepts the schema itself as the format.
+	if req.Schema != nil {
+		payload.Format = req.Schema
+	}
+
+	return payload
+}
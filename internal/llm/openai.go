@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// OpenAI talks to any OpenAI-compatible chat completions endpoint, including
+// Ollama's /v1/chat/completions.
+type OpenAI struct {
+	url string
+}
+
+type openAIRequest struct {
+	Model            string    `json:"model"`
+	Messages         []Message `json:"messages"`
+	Temperature      float32   `json:"temperature"`
+	MaxTokens        int       `json:"max_tokens,omitempty"`
+	FrequencyPenalty float32   `json:"frequency_penalty"`
+	PresencePenalty  float32   `json:"presence_penalty"`
+	ResponseFormat   any       `json:"response_format,omitempty"`
+	Stream           bool      `json:"stream"`
+	StreamOptions    any       `json:"stream_options,omitempty"`
+}
+
+type openAIResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message Message `json:"message"`
+		// Delta replaces Message on streamed chunks.
+		Delta Message `json:"delta"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// NewOpenAI returns a provider posting to the full chat completions url.
+func NewOpenAI(url string) *OpenAI {
+	return &OpenAI{url: normalizeURL(url)}
+}
+
+func (o *OpenAI) Name() string {
+	return ProviderOpenAI
+}
+
+func (o *OpenAI) Chat(ctx context.Context, req Request) (Response, error) {
+	var out openAIResponse
+	if err := postJSON(ctx, o.Name(), o.url, o.payload(req, false), &out); err != nil {
+		return Response{}, err
+	}
+	if len(out.Choices) == 0 || out.Choices[0].Message.Content == "" {
+		return Response{}, ErrEmptyResponse
+	}
+	return Response{
+		Model:            out.Model,
+		Content:          out.Choices[0].Message.Content,
+		PromptTokens:     out.Usage.PromptTokens,
+		CompletionTokens: out.Usage.CompletionTokens,
+	}, nil
+}
+
+// ChatStream reads the server-sent events of a streamed completion. Usage is
+// only reported in the last event, which has no choices.
+func (o *OpenAI) ChatStream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	var (
+		result  Response
+		content strings.Builder
+	)
+	err := postStream(ctx, o.Name(), o.url, o.payload(req, true), func(line []byte) error {
+		data, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			// Comments and other event fields carry nothing we use.
+			return nil
+		}
+		data = bytes.TrimSpace(data)
+		if string(data) == "[DONE]" {
+			return nil
+		}
+
+		var chunk openAIResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return &DecodeError{Provider: o.Name(), Body: string(data), Err: err}
+		}
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if chunk.Usage.CompletionTokens > 0 {
+			result.PromptTokens = chunk.Usage.PromptTokens
+			result.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			content.WriteString(chunk.Choices[0].Delta.Content)
+			onDelta(chunk.Choices[0].Delta.Content)
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	if content.Len() == 0 {
+		return Response{}, ErrEmptyResponse
+	}
+	result.Content = content.String()
+	return result, nil
+}
+
+func (o *OpenAI) payload(req Request, stream bool) openAIRequest {
+	payload := openAIRequest{
+		Model:            req.Model,
+		Messages:         req.Messages,
+		Temperature:      req.Temperature,
+		MaxTokens:        req.MaxTokens,
+		FrequencyPenalty: req.FrequencyPenalty,
+		PresencePenalty:  req.PresencePenalty,
+		Stream:           stream,
+	}
+	if stream {
+		payload.StreamOptions = map[string]any{"include_usage": true}
+	}
+	if req.Schema != nil {
+		payload.ResponseFormat = map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":   "response",
+				"schema": req.Schema,
+			},
+		}
+	}
+
+	return payload
+}
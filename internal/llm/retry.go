@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type retryProvider struct {
+	Provider
+	retries int
+	backoff time.Duration
+}
+
+// WithRetry retries transient failures up to retries extra times, doubling
+// the wait between attempts. The wait is cut short when ctx is done.
+func WithRetry(p Provider, retries int, backoff time.Duration) Provider {
+	if retries <= 0 {
+		return p
+	}
+	return &retryProvider{Provider: p, retries: retries, backoff: backoff}
+}
+
+func (r *retryProvider) Chat(ctx context.Context, req Request) (Response, error) {
+	wait := r.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := r.Provider.Chat(ctx, req)
+		if err == nil || attempt == r.retries || !retryable(err) {
+			return resp, err
+		}
+
+		slog.Warn("Retrying LLM request", slog.String("provider", r.Name()), slog.Int("attempt", attempt+1), slog.Duration("wait", wait), slog.Any("err", err))
+		select {
+		case <-ctx.Done():
+			return Response{}, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// ChatStream retries like Chat, but only while nothing has been streamed yet:
+// once part of a reply has been handed out, starting over would repeat it.
+func (r *retryProvider) ChatStream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	wait := r.backoff
+	for attempt := 0; ; attempt++ {
+		streamed := false
+		resp, err := stream(ctx, r.Provider, req, func(delta string) {
+			streamed = true
+			onDelta(delta)
+		})
+		if err == nil || streamed || attempt == r.retries || !retryable(err) {
+			return resp, err
+		}
+
+		slog.Warn("Retrying LLM request", slog.String("provider", r.Name()), slog.Int("attempt", attempt+1), slog.Duration("wait", wait), slog.Any("err", err))
+		select {
+		case <-ctx.Done():
+			return Response{}, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
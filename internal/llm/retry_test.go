@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// flaky fails its first calls with err, then answers.
+type flaky struct {
+	failures int
+	err      error
+	calls    int
+	// streamed is handed to onDelta before a failing stream returns.
+	streamed string
+}
+
+func (f *flaky) Name() string { return "flaky" }
+
+func (f *flaky) Chat(ctx context.Context, req Request) (Response, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return Response{}, f.err
+	}
+	return Response{Content: "ok"}, nil
+}
+
+func (f *flaky) ChatStream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	if f.calls < f.failures && f.streamed != "" {
+		onDelta(f.streamed)
+	}
+	resp, err := f.Chat(ctx, req)
+	if err == nil {
+		onDelta(resp.Content)
+	}
+	return resp, err
+}
+
+func TestRetry(t *testing.T) {
+	unavailable := &StatusError{Provider: "flaky", StatusCode: http.StatusServiceUnavailable}
+	badRequest := &StatusError{Provider: "flaky", StatusCode: http.StatusBadRequest}
+
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		retries   int
+		wantCalls int
+		wantErr   error
+	}{
+		{"succeeds first time", 0, nil, 2, 1, nil},
+		{"recovers from server errors", 2, unavailable, 2, 3, nil},
+		{"gives up after the retries", 5, unavailable, 2, 3, unavailable},
+		{"retries empty replies", 1, ErrEmptyResponse, 1, 2, nil},
+		{"does not retry bad requests", 5, badRequest, 2, 1, badRequest},
+		{"does not retry a cancelled request", 5, &TransportError{Provider: "flaky", Err: context.Canceled}, 2, 1, context.Canceled},
+		{"no retries configured", 1, unavailable, 0, 1, unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &flaky{failures: tt.failures, err: tt.err}
+			resp, err := WithRetry(p, tt.retries, time.Millisecond).Chat(context.Background(), Request{})
+			if p.calls != tt.wantCalls {
+				t.Errorf("provider called %d times, want %d", p.calls, tt.wantCalls)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || resp.Content != "ok" {
+				t.Errorf("Chat = %q, %v, want ok", resp.Content, err)
+			}
+		})
+	}
+}
+
+func TestRetryBackoffDoubles(t *testing.T) {
+	p := &flaky{failures: 2, err: ErrEmptyResponse}
+	start := time.Now()
+	if _, err := WithRetry(p, 2, 20*time.Millisecond).Chat(context.Background(), Request{}); err != nil {
+		t.Fatal(err)
+	}
+	// 20ms before the first retry, 40ms before the second.
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("two retries took %v, want at least 60ms of backoff", elapsed)
+	}
+}
+
+func TestRetryStopsWaitingWhenCancelled(t *testing.T) {
+	p := &flaky{failures: 5, err: ErrEmptyResponse}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := WithRetry(p, 3, time.Hour).Chat(ctx, Request{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled retry took %v", elapsed)
+	}
+}
+
+func TestRetryStream(t *testing.T) {
+	t.Run("retries before anything streamed", func(t *testing.T) {
+		p := &flaky{failures: 1, err: ErrEmptyResponse}
+		var got string
+		resp, err := WithRetry(p, 2, time.Millisecond).(Streamer).ChatStream(context.Background(), Request{}, func(delta string) { got += delta })
+		if err != nil || resp.Content != "ok" || got != "ok" {
+			t.Errorf("ChatStream = %q, %v with deltas %q, want ok", resp.Content, err, got)
+		}
+	})
+	t.Run("does not repeat a partial reply", func(t *testing.T) {
+		p := &flaky{failures: 1, err: ErrEmptyResponse, streamed: "par"}
+		var got string
+		_, err := WithRetry(p, 2, time.Millisecond).(Streamer).ChatStream(context.Background(), Request{}, func(delta string) { got += delta })
+		if !errors.Is(err, ErrEmptyResponse) {
+			t.Errorf("err = %v, want the failure after the partial reply", err)
+		}
+		if p.calls != 1 || got != "par" {
+			t.Errorf("provider called %d times with deltas %q, want one call streaming par", p.calls, got)
+		}
+	})
+}
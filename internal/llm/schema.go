@@ -0,0 +1,176 @@
+package llm
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Schema is the subset of JSON schema the bot uses for structured output. It
+// marshals to the schema sent to the model and validates the decoded reply,
+// so both sides are described by the same Go value.
+type Schema struct {
+	Type        string             `json:"type"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	MinItems    *int               `json:"minItems,omitempty"`
+	MaxItems    *int               `json:"maxItems,omitempty"`
+	MinLength   *int               `json:"minLength,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+}
+
+// ValidationError describes where a reply deviates from its schema. Path is
+// a JSON path like $.messages[2].topic.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Validate checks a value decoded with encoding/json into an any against the
+// schema and returns the first violation.
+func (s *Schema) Validate(v any) error {
+	return s.validate("$", v)
+}
+
+func (s *Schema) validate(path string, v any) error {
+	invalid := func(format string, args ...any) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return invalid("expected an object, got %s", typeName(v))
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return invalid("missing required field %q", name)
+			}
+		}
+		// Properties are checked in a fixed order so the same reply always
+		// reports the same error.
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if field, ok := obj[name]; ok {
+				if err := s.Properties[name].validate(path+"."+name, field); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return invalid("expected an array, got %s", typeName(v))
+		}
+		if s.MinItems != nil && len(arr) < *s.MinItems {
+			return invalid("expected at least %d items, got %d", *s.MinItems, len(arr))
+		}
+		if s.MaxItems != nil && len(arr) > *s.MaxItems {
+			return invalid("expected at most %d items, got %d", *s.MaxItems, len(arr))
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return invalid("expected a string, got %s", typeName(v))
+		}
+		if s.MinLength != nil && len([]rune(strings.TrimSpace(str))) < *s.MinLength {
+			return invalid("expected at least %d characters", *s.MinLength)
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			return invalid("expected one of %s, got %q", strings.Join(s.Enum, ", "), str)
+		}
+	case "number", "integer":
+		num, ok := v.(float64)
+		if !ok {
+			return invalid("expected a %s, got %s", s.Type, typeName(v))
+		}
+		if s.Type == "integer" && num != math.Trunc(num) {
+			return invalid("expected an integer, got %v", num)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return invalid("expected a boolean, got %s", typeName(v))
+		}
+	}
+	return nil
+}
+
+// example returns the smallest value that passes the schema: the first enum
+// value or a placeholder string long enough, the fewest items allowed (but at
+// least one), and every property.
+func (s *Schema) example() any {
+	switch s.Type {
+	case "object":
+		obj := make(map[string]any, len(s.Properties))
+		for name, property := range s.Properties {
+			obj[name] = property.example()
+		}
+		return obj
+	case "array":
+		n := 1
+		if s.MinItems != nil {
+			n = max(n, *s.MinItems)
+		}
+		if s.MaxItems != nil {
+			n = min(n, *s.MaxItems)
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			if s.Items != nil {
+				arr[i] = s.Items.example()
+			}
+		}
+		return arr
+	case "string":
+		if len(s.Enum) > 0 {
+			return s.Enum[0]
+		}
+		str := ProviderFake
+		if s.MinLength != nil && *s.MinLength > len(str) {
+			str += strings.Repeat(".", *s.MinLength-len(str))
+		}
+		return str
+	case "number", "integer":
+		return 0
+	case "boolean":
+		return false
+	}
+	return nil
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+var testSchema = &Schema{
+	Type: "object",
+	Properties: map[string]*Schema{
+		"messages": {
+			Type:     "array",
+			MinItems: pointer(1),
+			MaxItems: pointer(2),
+			Items: &Schema{
+				Type: "object",
+				Properties: map[string]*Schema{
+					"topic": {Type: "string", MinLength: pointer(1)},
+					"mood":  {Type: "string", Enum: []string{"good", "bad"}},
+					"count": {Type: "integer"},
+				},
+				Required: []string{"topic", "mood"},
+			},
+		},
+	},
+	Required: []string{"messages"},
+}
+
+func pointer(n int) *int { return &n }
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		wantPath string
+	}{
+		{"valid", `{"messages":[{"topic":"cats","mood":"good","count":3}]}`, ""},
+		{"not an object", `[]`, "$"},
+		{"missing field", `{}`, "$"},
+		{"too few items", `{"messages":[]}`, "$.messages"},
+		{"too many items", `{"messages":[{"topic":"a","mood":"good"},{"topic":"b","mood":"good"},{"topic":"c","mood":"good"}]}`, "$.messages"},
+		{"blank string", `{"messages":[{"topic":"  ","mood":"good"}]}`, "$.messages[0].topic"},
+		{"not in enum", `{"messages":[{"topic":"cats","mood":"meh"}]}`, "$.messages[0].mood"},
+		{"fraction for integer", `{"messages":[{"topic":"cats","mood":"bad","count":1.5}]}`, "$.messages[0].count"},
+		{"wrong type", `{"messages":[{"topic":1,"mood":"bad"}]}`, "$.messages[0].topic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(tt.reply), &value); err != nil {
+				t.Fatal(err)
+			}
+			err := testSchema.Validate(value)
+			if tt.wantPath == "" {
+				if err != nil {
+					t.Errorf("Validate = %v, want valid", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Path != tt.wantPath {
+				t.Errorf("Validate = %v, want an error at %s", err, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestSchemaExample(t *testing.T) {
+	if err := testSchema.Validate(roundTrip(t, testSchema.example())); err != nil {
+		t.Errorf("example does not pass its own schema: %v", err)
+	}
+}
+
+// roundTrip turns a value into what encoding/json decodes it to.
+func roundTrip(t *testing.T, v any) any {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
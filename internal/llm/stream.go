@@ -0,0 +1,42 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// Streamer is implemented by providers that can hand out the reply while it
+// is being generated. onDelta is called with every new piece of content, in
+// order; the returned Response holds the full reply as Chat would.
+type Streamer interface {
+	ChatStream(ctx context.Context, req Request, onDelta func(delta string)) (Response, error)
+}
+
+// ChatStream is Chat with progress: onDelta receives the reply as it is
+// generated. Providers that cannot stream deliver the whole reply in a single
+// call once it is done.
+func ChatStream(ctx context.Context, req Request, onDelta func(delta string)) (Response, error) {
+	p, err := Default()
+	if err != nil {
+		return Response{}, err
+	}
+	if req.Model == "" {
+		req.Model = util.ConfigFile.OLLAMA_MODEL
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout())
+	defer cancel()
+	return stream(ctx, p, req, onDelta)
+}
+
+func stream(ctx context.Context, p Provider, req Request, onDelta func(string)) (Response, error) {
+	if s, ok := p.(Streamer); ok {
+		return s.ChatStream(ctx, req, onDelta)
+	}
+	resp, err := p.Chat(ctx, req)
+	if err == nil {
+		onDelta(resp.Content)
+	}
+	return resp, err
+}
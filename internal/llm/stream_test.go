@@ -0,0 +1,136 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serve returns a server answering every request with body, line by line.
+func serve(t *testing.T, status int, lines ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func collect(t *testing.T, s Streamer) (Response, []string, error) {
+	t.Helper()
+	var deltas []string
+	resp, err := s.ChatStream(context.Background(), Request{Model: "test"}, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	return resp, deltas, err
+}
+
+func TestOllamaStream(t *testing.T) {
+	server := serve(t, http.StatusOK,
+		`{"model":"llama","message":{"role":"assistant","content":"Hel"},"done":false}`,
+		``,
+		`{"model":"llama","message":{"role":"assistant","content":"lo"},"done":false}`,
+		`{"model":"llama","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":7,"eval_count":2}`,
+	)
+	resp, deltas, err := collect(t, NewOllama(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" {
+		t.Errorf("deltas = %q, want Hel and lo", deltas)
+	}
+	want := Response{Model: "llama", Content: "Hello", PromptTokens: 7, CompletionTokens: 2}
+	if resp != want {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestOllamaStreamErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		lines  []string
+		check  func(error) bool
+	}{
+		{"error chunk", http.StatusOK, []string{
+			`{"message":{"content":"Hel"}}`,
+			`{"error":"model crashed"}`,
+		}, func(err error) bool {
+			var statusErr *StatusError
+			return errors.As(err, &statusErr) && statusErr.Body == "model crashed"
+		}},
+		{"garbage chunk", http.StatusOK, []string{`not json`}, func(err error) bool {
+			var decodeErr *DecodeError
+			return errors.As(err, &decodeErr)
+		}},
+		{"no content", http.StatusOK, []string{`{"done":true}`}, func(err error) bool {
+			return errors.Is(err, ErrEmptyResponse)
+		}},
+		{"bad status", http.StatusTooManyRequests, []string{`slow down`}, func(err error) bool {
+			var statusErr *StatusError
+			return errors.As(err, &statusErr) && statusErr.Temporary()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := serve(t, tt.status, tt.lines...)
+			if _, _, err := collect(t, NewOllama(server.URL)); !tt.check(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	server := serve(t, http.StatusOK,
+		`: keep-alive`,
+		`data: {"model":"gpt","choices":[{"delta":{"role":"assistant","content":""}}]}`,
+		``,
+		`data: {"model":"gpt","choices":[{"delta":{"content":"Hel"}}]}`,
+		`data:{"model":"gpt","choices":[{"delta":{"content":"lo"}}]}`,
+		`data: {"model":"gpt","choices":[],"usage":{"prompt_tokens":7,"completion_tokens":2}}`,
+		`data: [DONE]`,
+	)
+	resp, deltas, err := collect(t, NewOpenAI(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" {
+		t.Errorf("deltas = %q, want Hel and lo", deltas)
+	}
+	want := Response{Model: "gpt", Content: "Hello", PromptTokens: 7, CompletionTokens: 2}
+	if resp != want {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestOpenAIStreamErrors(t *testing.T) {
+	t.Run("garbage event", func(t *testing.T) {
+		server := serve(t, http.StatusOK, `data: {"choices":`)
+		var decodeErr *DecodeError
+		if _, _, err := collect(t, NewOpenAI(server.URL)); !errors.As(err, &decodeErr) {
+			t.Errorf("err = %v, want a DecodeError", err)
+		}
+	})
+	t.Run("no content", func(t *testing.T) {
+		server := serve(t, http.StatusOK, `data: [DONE]`)
+		if _, _, err := collect(t, NewOpenAI(server.URL)); !errors.Is(err, ErrEmptyResponse) {
+			t.Errorf("err = %v, want ErrEmptyResponse", err)
+		}
+	})
+	t.Run("bad status", func(t *testing.T) {
+		server := serve(t, http.StatusUnauthorized, `no key`)
+		var statusErr *StatusError
+		if _, _, err := collect(t, NewOpenAI(server.URL)); !errors.As(err, &statusErr) || statusErr.Temporary() {
+			t.Errorf("err = %v, want a permanent StatusError", err)
+		}
+	})
+}
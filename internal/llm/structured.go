@@ -0,0 +1,122 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// defaultAttempts is how many replies Generate asks for before giving up: the
+// first one and two repairs.
+const defaultAttempts = 3
+
+// Attempt is a single reply produced while generating structured output.
+type Attempt struct {
+	// Number counts from 1.
+	Number int
+	Raw    string
+	// Err is nil for the accepted reply, a *ValidationError or *DecodeError
+	// for a rejected one and any provider error when no reply came back.
+	Err error
+}
+
+// Structured configures Generate.
+type Structured struct {
+	// MaxAttempts bounds the replies asked for, repairs included. Zero means
+	// defaultAttempts.
+	MaxAttempts int
+	// OnDelta streams every attempt's reply when set.
+	OnDelta func(delta string)
+	// OnAttempt is called after every attempt, successful or not.
+	OnAttempt func(attempt Attempt)
+}
+
+// InvalidOutputError is returned when no attempt produced a valid reply.
+type InvalidOutputError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *InvalidOutputError) Error() string {
+	return fmt.Sprintf("no valid reply after %d attempts: %s", e.Attempts, e.Err)
+}
+
+func (e *InvalidOutputError) Unwrap() error {
+	return e.Err
+}
+
+// Generate asks for a reply following req.Schema and decodes it into T. A
+// reply that is not valid JSON or does not match the schema is sent back to
+// the model together with the validation error, until a reply passes or the
+// attempts run out. Provider errors are returned as is; they are retried by
+// the provider itself.
+func Generate[T any](ctx context.Context, req Request, opts Structured) (out T, err error) {
+	if req.Schema == nil {
+		return out, errors.New("structured generation needs a schema")
+	}
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultAttempts
+	}
+
+	for n := 1; ; n++ {
+		var resp Response
+		if opts.OnDelta != nil {
+			resp, err = ChatStream(ctx, req, opts.OnDelta)
+		} else {
+			resp, err = Chat(ctx, req)
+		}
+		if err != nil {
+			record(opts, Attempt{Number: n, Err: err})
+			return out, err
+		}
+
+		err = decode(resp.Content, req.Schema, &out)
+		record(opts, Attempt{Number: n, Raw: resp.Content, Err: err})
+		if err == nil {
+			return out, nil
+		}
+		if n == attempts {
+			return out, &InvalidOutputError{Attempts: n, Err: err}
+		}
+
+		// The rejected reply stays in the conversation so the model can see
+		// what it is asked to fix.
+		req.Messages = append(req.Messages,
+			Message{Role: "assistant", Content: resp.Content},
+			Message{Role: "user", Content: repairPrompt(err)},
+		)
+	}
+}
+
+// decode validates raw against the schema before decoding it into out, so a
+// reply missing fields is rejected instead of silently zero-filled.
+func decode(raw string, schema *Schema, out any) error {
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return &DecodeError{Provider: "structured output", Body: raw, Err: err}
+	}
+	if err := schema.Validate(value); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(raw), out)
+}
+
+func repairPrompt(err error) string {
+	reason := err.Error()
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		reason = "it is not valid JSON (" + decodeErr.Err.Error() + ")"
+	}
+	return fmt.Sprintf(
+		"Your previous reply was rejected: %s.\n"+
+			"Reply again with only the corrected JSON, following the same schema. Do not add any explanation.",
+		reason)
+}
+
+func record(opts Structured, attempt Attempt) {
+	if opts.OnAttempt != nil {
+		opts.OnAttempt(attempt)
+	}
+}
@@ -0,0 +1,110 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testReply struct {
+	Messages []struct {
+		Topic string `json:"topic"`
+		Mood  string `json:"mood"`
+	} `json:"messages"`
+}
+
+// useFake makes f the default provider for a test.
+func useFake(t *testing.T, f *Fake) {
+	t.Helper()
+	SetDefault(f)
+	t.Cleanup(func() {
+		defaultMu.Lock()
+		defer defaultMu.Unlock()
+		defaultBuilt, defaultProvider, defaultErr = false, nil, nil
+	})
+}
+
+func TestGenerateRepairs(t *testing.T) {
+	fake := NewFake(
+		`not json`,
+		`{"messages":[{"topic":"cats","mood":"meh"}]}`,
+		`{"messages":[{"topic":"cats","mood":"good"}]}`,
+	)
+	useFake(t, fake)
+
+	var attempts []Attempt
+	out, err := Generate[testReply](context.Background(), Request{
+		Messages: []Message{{Role: "user", Content: "go"}},
+		Schema:   testSchema,
+	}, Structured{OnAttempt: func(a Attempt) { attempts = append(attempts, a) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 || out.Messages[0].Mood != "good" {
+		t.Errorf("out = %+v, want the third reply", out)
+	}
+
+	if len(attempts) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(attempts))
+	}
+	var decodeErr *DecodeError
+	var validationErr *ValidationError
+	if !errors.As(attempts[0].Err, &decodeErr) || !errors.As(attempts[1].Err, &validationErr) || attempts[2].Err != nil {
+		t.Errorf("attempt errors = %v, %v, %v, want a decode error, a validation error and none", attempts[0].Err, attempts[1].Err, attempts[2].Err)
+	}
+
+	// Every repair sees the rejected reply and why it was rejected.
+	last := fake.Calls[2].Messages
+	if len(last) != 5 {
+		t.Fatalf("third request has %d messages, want 5", len(last))
+	}
+	if last[3].Role != "assistant" || last[3].Content != fake.Responses[1] {
+		t.Errorf("rejected reply not sent back: %+v", last[3])
+	}
+	if !strings.Contains(last[4].Content, "$.messages[0].mood") {
+		t.Errorf("repair prompt does not name the violation: %q", last[4].Content)
+	}
+}
+
+func TestGenerateGivesUp(t *testing.T) {
+	useFake(t, NewFake(`{}`))
+	_, err := Generate[testReply](context.Background(), Request{Schema: testSchema}, Structured{MaxAttempts: 2})
+	var invalid *InvalidOutputError
+	if !errors.As(err, &invalid) || invalid.Attempts != 2 {
+		t.Errorf("err = %v, want an InvalidOutputError after 2 attempts", err)
+	}
+}
+
+func TestGenerateProviderError(t *testing.T) {
+	fake := NewFake()
+	fake.Err = errors.New("down")
+	useFake(t, fake)
+	_, err := Generate[testReply](context.Background(), Request{Schema: testSchema}, Structured{})
+	if err != fake.Err || len(fake.Calls) != 1 {
+		t.Errorf("err = %v after %d calls, want the provider error after one", err, len(fake.Calls))
+	}
+}
+
+func TestGenerateStreams(t *testing.T) {
+	reply := `{"messages":[{"topic":"cats","mood":"good"}]}`
+	useFake(t, NewFake(reply))
+	var streamed strings.Builder
+	if _, err := Generate[testReply](context.Background(), Request{Schema: testSchema}, Structured{OnDelta: func(d string) { streamed.WriteString(d) }}); err != nil {
+		t.Fatal(err)
+	}
+	if streamed.String() != reply {
+		t.Errorf("streamed %q, want %q", streamed.String(), reply)
+	}
+}
+
+func TestFakePlaceholder(t *testing.T) {
+	useFake(t, NewFake())
+	if _, err := Generate[testReply](context.Background(), Request{Schema: testSchema}, Structured{MaxAttempts: 1}); err != nil {
+		t.Errorf("placeholder does not follow the schema: %v", err)
+	}
+	resp, err := Chat(context.Background(), Request{Messages: []Message{{Role: "user", Content: "echo me"}}})
+	if err != nil || resp.Content != "echo me" {
+		t.Errorf("Chat = %q, %v, want the message echoed", resp.Content, err)
+	}
+}
@@ -0,0 +1,74 @@
+// Package localmodels finds the HuggingFace models the embeddings, sentiment
+// and moderation packages run locally, and holds the one hugot session on
+// hugot's pure-Go backend their pipelines share. Models live in
+// EMBEDDING_MODELS_DIR and are downloaded there on first use, unless
+// EMBEDDING_OFFLINE is set, in which case they are only ever loaded from disk.
+package localmodels
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/knights-analytics/hugot"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// defaultDir is the models directory when EMBEDDING_MODELS_DIR is not set.
+const defaultDir = "./models/"
+
+var (
+	sessionOnce sync.Once
+	sessionErr  error
+	session     *hugot.Session
+)
+
+// Session returns the session every local pipeline is created on, creating
+// it the first time. Pipeline names must be unique within it.
+func Session() (*hugot.Session, error) {
+	sessionOnce.Do(func() {
+		session, sessionErr = hugot.NewGoSession(context.Background())
+	})
+	return session, sessionErr
+}
+
+// Dir returns the directory models are downloaded to and loaded from.
+func Dir() string {
+	if dir := util.ConfigFile.EMBEDDING_MODELS_DIR; dir != "" {
+		return dir
+	}
+	return defaultDir
+}
+
+// Path returns the local directory of the named model, downloading it from
+// HuggingFace into Dir unless offline mode is on. onnxFile is the path of the
+// ONNX export inside the model's repository; when empty, the repository must
+// hold exactly one .onnx file, which is taken whatever its path.
+func Path(ctx context.Context, name, onnxFile string) (string, error) {
+	dir := Dir()
+	if util.ConfigFile.EMBEDDING_OFFLINE {
+		// hugot stores a downloaded model in a directory named after it, with
+		// the slashes replaced.
+		local := filepath.Join(dir, strings.ReplaceAll(name, "/", "_"))
+		if _, err := os.Stat(local); err != nil {
+			return "", fmt.Errorf("model %s is not in %s and offline mode is on: %w", name, dir, err)
+		}
+		return local, nil
+	}
+
+	opts := hugot.NewDownloadOptions()
+	opts.OnnxFilePath = onnxFile
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create models directory: %w", err)
+	}
+
+	path, err := hugot.DownloadModel(ctx, name, dir, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to download model %s: %w", name, err)
+	}
+	return path, nil
+}
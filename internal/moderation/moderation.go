@@ -0,0 +1,223 @@
+// Package moderation scores messages for toxicity, insults and spam locally,
+// so mods can see which channels are heating up without reading everything.
+// It runs two hugot text-classification pipelines on the pure-Go backend the
+// same way the embeddings and sentiment packages run theirs: the ONNX export
+// of unitary/toxic-bert for toxicity and insults, and a RoBERTa spam
+// classifier. The models are found, or downloaded from HuggingFace on first
+// use, by the localmodels package. Each classifier is loaded on its own, so a
+// model that fails to load only leaves its own signals out.
+//
+// Scoring is optional and off unless MODERATION_ENABLED is set.
+package moderation
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/knights-analytics/hugot"
+	"github.com/knights-analytics/hugot/pipelines"
+	"github.com/stollenaar/statisticsbot/internal/localmodels"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+const (
+	TOXICITY_MODEL_NAME = "Xenova/toxic-bert"
+	SPAM_MODEL_NAME     = "mshenoda/roberta-spam"
+
+	// defaultThreshold is the score at or above which a message counts as
+	// flagged when MODERATION_THRESHOLD is not set.
+	defaultThreshold = 0.8
+
+	// Same 512-token window and truncation strategy as the sentiment
+	// classifier; abuse and spam show in the first sentences.
+	firstAttemptRunes = 1000
+	minAttemptRunes   = 128
+)
+
+var (
+	// toxic-bert is multi-label: every class has its own sigmoid, a message
+	// can be both toxic and an insult. It is a Xenova export, with the model
+	// under onnx/.
+	toxicity = &classifier{
+		model:    TOXICITY_MODEL_NAME,
+		name:     "message-toxicity",
+		onnxFile: "onnx/model.onnx",
+		options:  []hugot.TextClassificationOption{pipelines.WithSigmoid(), pipelines.WithMultiLabel()},
+	}
+	// The spam model is not a Xenova export, so its ONNX file is not looked
+	// for under onnx/: the one .onnx file in the repository is used wherever
+	// it is, and a repository without one only leaves spam out.
+	spam = &classifier{
+		model:   SPAM_MODEL_NAME,
+		name:    "message-spam",
+		options: []hugot.TextClassificationOption{pipelines.WithSoftmax(), pipelines.WithMultiLabel()},
+	}
+
+	runMu sync.Mutex
+)
+
+// classifier is one of the text-classification pipelines, loaded the first
+// time it is needed.
+type classifier struct {
+	model, name, onnxFile string
+	options               []hugot.TextClassificationOption
+
+	once     sync.Once
+	err      error
+	pipeline *pipelines.TextClassificationPipeline
+}
+
+// Scores are the independent probabilities of each signal, from 0 to 1.
+type Scores struct {
+	Toxicity float32
+	Insult   float32
+	Spam     float32
+}
+
+// Max returns the strongest of the signals.
+func (s Scores) Max() float32 {
+	return max(s.Toxicity, s.Insult, s.Spam)
+}
+
+// Enabled reports whether messages should be scored at all.
+func Enabled() bool {
+	return util.ConfigFile.MODERATION_ENABLED
+}
+
+// Threshold returns the score at or above which a message is flagged.
+func Threshold() float32 {
+	if t, err := strconv.ParseFloat(util.ConfigFile.MODERATION_THRESHOLD, 32); err == nil && t > 0 && t <= 1 {
+		return float32(t)
+	}
+	return defaultThreshold
+}
+
+// ModelName returns the identifier of the classifiers that loaded, stored
+// with every score so a later model change, or a classifier that loads after
+// a restart, can tell which rows to redo.
+func ModelName() string {
+	var names []string
+	for _, c := range []*classifier{toxicity, spam} {
+		if _, err := c.load(); err == nil {
+			names = append(names, c.model)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// load returns the classifier's pipeline, creating it the first time. A
+// failure is logged once and returned from every call after.
+func (c *classifier) load() (*pipelines.TextClassificationPipeline, error) {
+	c.once.Do(func() {
+		session, err := localmodels.Session()
+		if err != nil {
+			c.err = err
+			return
+		}
+		modelPath, err := localmodels.Path(context.Background(), c.model, c.onnxFile)
+		if err != nil {
+			c.err = err
+		} else {
+			c.pipeline, c.err = hugot.NewPipeline(session, hugot.TextClassificationConfig{
+				ModelPath: modelPath,
+				Name:      c.name,
+				Options:   c.options,
+			})
+		}
+		if c.err != nil {
+			slog.Error("Failed to load moderation classifier, its scores are left out", slog.String("model", c.model), slog.Any("err", c.err))
+		}
+	})
+	return c.pipeline, c.err
+}
+
+// Score runs a single text through the classifiers. The pipelines are
+// initialized (and the models downloaded) on first call; a classifier that
+// failed to load scores 0, and only when neither loaded is an error
+// returned. Text that overflows the context window is shortened and retried,
+// like sentiment.Classify does.
+func Score(text string) (Scores, error) {
+	_, toxicityErr := toxicity.load()
+	_, spamErr := spam.load()
+	if toxicityErr != nil && spamErr != nil {
+		return Scores{}, errors.Join(toxicityErr, spamErr)
+	}
+
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) == 0 {
+		return Scores{}, errors.New("cannot score empty text")
+	}
+
+	limit := min(len(runes), firstAttemptRunes)
+
+	var lastErr error
+	for {
+		scores, err := runScore(string(runes[:limit]))
+		if err == nil {
+			return scores, nil
+		}
+		lastErr = err
+		if limit <= minAttemptRunes {
+			return Scores{}, lastErr
+		}
+		if limit /= 2; limit < minAttemptRunes {
+			limit = minAttemptRunes
+		}
+	}
+}
+
+// runScore runs a single input through the loaded pipelines under the run
+// lock.
+func runScore(text string) (Scores, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	var scores Scores
+	err := classify(toxicity, text, func(label string, score float32) {
+		switch label {
+		case "toxic":
+			scores.Toxicity = score
+		case "insult":
+			scores.Insult = score
+		}
+	})
+	if err != nil {
+		return Scores{}, err
+	}
+
+	// The spam model labels its classes either by name or as LABEL_0 (ham)
+	// and LABEL_1 (spam), depending on the export.
+	err = classify(spam, text, func(label string, score float32) {
+		if label == "spam" || label == "label_1" {
+			scores.Spam = score
+		}
+	})
+	if err != nil {
+		return Scores{}, err
+	}
+	return scores, nil
+}
+
+// classify runs the text through a classifier, skipping one that did not
+// load.
+func classify(c *classifier, text string, collect func(label string, score float32)) error {
+	pipeline, err := c.load()
+	if err != nil {
+		return nil
+	}
+	out, err := pipeline.RunPipeline(context.Background(), []string{text})
+	if err != nil {
+		return err
+	}
+	if len(out.ClassificationOutputs) == 0 || len(out.ClassificationOutputs[0]) == 0 {
+		return errors.New("no classification returned")
+	}
+	for _, class := range out.ClassificationOutputs[0] {
+		collect(strings.ToLower(class.Label), class.Score)
+	}
+	return nil
+}
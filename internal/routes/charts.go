@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/util"
+	"github.com/stollenaar/statisticsbot/internal/util/charts"
+)
+
+// defaultChartPort is the port of the interactive chart server when
+// CHART_PORT is not set.
+const defaultChartPort = "8081"
+
+// serveInteractiveCharts serves the interactive /plot charts on CHART_PORT,
+// apart from the admin routes on :8080, so only this listener needs to be
+// reachable at PUBLIC_URL. Without PUBLIC_URL no links are handed out and the
+// server is not started.
+func serveInteractiveCharts() {
+	if util.ConfigFile.PUBLIC_URL == "" {
+		return
+	}
+	port := util.ConfigFile.CHART_PORT
+	if port == "" {
+		port = defaultChartPort
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /charts/{token}", getInteractiveChart)
+
+	slog.Info("starting chart server", slog.String("port", port))
+	if err := http.ListenAndServe(":"+port, withMiddleware(mux)); err != nil {
+		slog.Error("Chart server failed", slog.Any("err", err))
+	}
+}
+
+// getInteractiveChart serves the interactive page of a /plot chart behind
+// the signed, expiring link the plot response carries.
+func getInteractiveChart(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	query := r.URL.Query()
+	if !charts.VerifyLink(token, query.Get("expires"), query.Get("sig")) {
+		http.Error(w, "this link is invalid or has expired", http.StatusForbidden)
+		return
+	}
+
+	var page bytes.Buffer
+	err := charts.RenderInteractive(token, &page)
+	if errors.Is(err, database.ErrSessionExpired) {
+		http.Error(w, "this chart has expired, please run /plot again", http.StatusGone)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to render interactive chart", slog.Any("err", err))
+		http.Error(w, "failed to render the chart", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page.Bytes())
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/stollenaar/statisticsbot/internal/database"
+)
+
+func addFixConversationWindows(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /fixConversationWindows", refreshConversationWindows)
+}
+
+// refreshConversationWindows folds every message stored since the last run
+// into conversation windows, so /semantic can search whole conversations
+// without waiting for the background refresh.
+func refreshConversationWindows(w http.ResponseWriter, r *http.Request) {
+	if err := database.RefreshConversationWindows(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "conversation windows refreshed"})
+}
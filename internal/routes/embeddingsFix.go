@@ -3,42 +3,27 @@ package routes
 import (
 	"fmt"
 	"net/http"
-	"sync"
 
 	"github.com/stollenaar/statisticsbot/internal/database"
-	"github.com/stollenaar/statisticsbot/internal/util"
+	"github.com/stollenaar/statisticsbot/internal/embeddings"
 )
 
-// embeddingBackfillWorkers bounds how many embeddings are generated in parallel
-// so the backfill does not overwhelm the embedding endpoint.
-const embeddingBackfillWorkers = 4
-
 func addFixEmbeddings(mux *http.ServeMux) {
 	mux.HandleFunc("PUT /fixEmbeddings", addMissingEmbeddings)
 }
 
 // addMissingEmbeddings generates embeddings for every stored message that does
-// not have one yet, so semantic search can cover historical messages.
+// not have one from the configured model yet, so semantic search can cover
+// historical messages. The messages go through the embedding queue, which
+// batches them and bounds how many are embedded in parallel.
 func addMissingEmbeddings(w http.ResponseWriter, r *http.Request) {
-	messages, err := database.GetMessagesWithoutEmbeddings(0)
+	messages, err := database.GetMessagesWithoutEmbeddings(embeddings.ModelName(), 0)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
 	}
 
-	var waitGroup sync.WaitGroup
-	sem := make(chan struct{}, embeddingBackfillWorkers)
-
-	for _, m := range messages {
-		waitGroup.Add(1)
-		sem <- struct{}{}
-		go func(m util.MessageObject) {
-			defer waitGroup.Done()
-			defer func() { <-sem }()
-			database.EmbedMessage(m.MessageID, m.Content)
-		}(m)
-	}
-	waitGroup.Wait()
+	embedded, failed := database.EmbedMessages(embeddings.ModelName(), messages, nil)
 
-	writeJSON(w, http.StatusOK, map[string]string{"message": fmt.Sprintf("embedded %d messages", len(messages))})
+	writeJSON(w, http.StatusOK, map[string]string{"message": fmt.Sprintf("embedded %d messages, %d failed", embedded, failed)})
 }
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/stollenaar/statisticsbot/internal/database"
+)
+
+func addEmbeddingsMetrics(mux *http.ServeMux) {
+	mux.HandleFunc("GET /metrics/embeddings", getEmbeddingQueueStats)
+}
+
+// getEmbeddingQueueStats reports the embedding queue's depth and throughput.
+func getEmbeddingQueueStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, database.GetEmbeddingQueueStats())
+}
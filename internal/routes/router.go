@@ -28,8 +28,17 @@ func CreateRouter(c *bot.Client) {
 	addFixMessages(mux)
 	addFixEmojis(mux)
 	addFixEmbeddings(mux)
+	addEmbeddingsMetrics(mux)
+	addFixConversationWindows(mux)
+	addFixTopics(mux)
+	addFixUserProfiles(mux)
+	addFixSentiment(mux)
 	addBackup(mux)
 
+	// The interactive charts are the only public pages, so they get their own
+	// listener and the admin routes above never have to be exposed.
+	go serveInteractiveCharts()
+
 	slog.Info("starting server on :8080")
 	_ = http.ListenAndServe(":8080", withMiddleware(mux))
 }
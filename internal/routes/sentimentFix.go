@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/stollenaar/statisticsbot/internal/database"
+)
+
+func addFixSentiment(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /fixSentiment", addMissingSentiment)
+}
+
+// addMissingSentiment classifies every stored message that has no sentiment
+// yet, so the sentiment metrics of /plot cover historical messages. They are
+// classified one after another: the classifier only runs one input at a time.
+func addMissingSentiment(w http.ResponseWriter, r *http.Request) {
+	messages, err := database.GetMessagesWithoutSentiment(0)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	for _, m := range messages {
+		database.ClassifyMessage(m.MessageID, m.Content)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": fmt.Sprintf("classified %d messages", len(messages))})
+}
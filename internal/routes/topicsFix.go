@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/stollenaar/statisticsbot/internal/database"
+)
+
+func addFixTopics(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /fixTopics", refreshTopics)
+}
+
+// refreshTopics clusters the current week of every guild, and every past week
+// still missing, into topics without waiting for the background job.
+func refreshTopics(w http.ResponseWriter, r *http.Request) {
+	if err := database.RefreshTopics(client); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "topics refreshed"})
+}
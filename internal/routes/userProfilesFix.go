@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/stollenaar/statisticsbot/internal/database"
+)
+
+func addFixUserProfiles(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /fixUserProfiles", rebuildUserProfiles)
+}
+
+// rebuildUserProfiles recomputes every member's profile from their stored
+// message embeddings, e.g. after a lot of edits the incremental updates skip.
+func rebuildUserProfiles(w http.ResponseWriter, r *http.Request) {
+	if err := database.RebuildAllUserProfiles(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "user profiles rebuilt"})
+}
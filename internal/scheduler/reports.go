@@ -0,0 +1,292 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/stollenaar/statisticsbot/internal/commands/summarizecommand"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/util/charts"
+)
+
+const (
+	ReportSummary     = "summary"
+	ReportChart       = "chart"
+	ReportTrending    = "trending"
+	ReportLeaderboard = "leaderboard"
+
+	// maxSummaryPeriod matches the longest stretch /summarize digest accepts;
+	// longer windows do not fit in the model's context.
+	maxSummaryPeriod = 7 * 24 * time.Hour
+	reportListSize   = 10
+)
+
+var (
+	Reports = []string{ReportSummary, ReportChart, ReportTrending, ReportLeaderboard}
+
+	periodPattern = regexp.MustCompile(`^(\d+)([hdw])$`)
+)
+
+// Config is the per-job report configuration stored as JSON in
+// scheduled_jobs.config. Only the chart fields are report specific.
+type Config struct {
+	// Period is how far back each run reports on, e.g. "1d" or "2w".
+	Period string `json:"period"`
+	// SourceChannelID is the channel a summary is made of. It defaults to the
+	// channel the report is posted in.
+	SourceChannelID string `json:"source_channel_id,omitempty"`
+
+	ChartType string `json:"chart_type,omitempty"`
+	Metric    string `json:"metric,omitempty"`
+	GroupBy   string `json:"group_by,omitempty"`
+}
+
+type report struct {
+	message discord.MessageCreate
+	// onPosted is called with the id of the posted message, if set.
+	onPosted func(messageID string)
+}
+
+func ParseConfig(raw string) (Config, error) {
+	var config Config
+	if err := json.Unmarshal([]byte(raw), &config); err != nil {
+		return Config{}, fmt.Errorf("invalid job config: %w", err)
+	}
+	return config, nil
+}
+
+// Validate checks that the config can produce the given report, so mistakes
+// surface when the job is created rather than on its first run.
+func (c Config) Validate(reportType string) error {
+	period, err := ParsePeriod(c.Period)
+	if err != nil {
+		return err
+	}
+
+	switch reportType {
+	case ReportSummary:
+		if period > maxSummaryPeriod {
+			return fmt.Errorf("summary period cannot exceed %s", FormatPeriod(maxSummaryPeriod))
+		}
+	case ReportChart:
+		if charts.GetChartType(c.ChartType) == charts.InvalidChart {
+			return errors.New("chart reports need a chart type")
+		}
+		if !strings.Contains(c.Metric, ";") || !strings.Contains(c.GroupBy, ";") {
+			return errors.New("chart reports need both a metric and a group by")
+		}
+	case ReportTrending, ReportLeaderboard:
+	default:
+		return fmt.Errorf("unknown report %q", reportType)
+	}
+	return nil
+}
+
+// ParsePeriod parses a report period made of a number and an h (hours),
+// d (days) or w (weeks) unit.
+func ParsePeriod(in string) (time.Duration, error) {
+	matches := periodPattern.FindStringSubmatch(in)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid period %q, use e.g. 12h, 1d or 2w", in)
+	}
+	value, err := strconv.Atoi(matches[1])
+	if err != nil || value == 0 {
+		return 0, fmt.Errorf("invalid period %q", in)
+	}
+
+	switch matches[2] {
+	case "h":
+		return time.Duration(value) * time.Hour, nil
+	case "d":
+		return time.Duration(value) * 24 * time.Hour, nil
+	default:
+		return time.Duration(value) * 7 * 24 * time.Hour, nil
+	}
+}
+
+// FormatPeriod renders a duration in the same units ParsePeriod accepts.
+func FormatPeriod(d time.Duration) string {
+	switch {
+	case d%(7*24*time.Hour) == 0:
+		return fmt.Sprintf("%dw", d/(7*24*time.Hour))
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	default:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+}
+
+func buildReport(client *bot.Client, job database.ScheduledJob, config Config, now time.Time) (report, error) {
+	period, err := ParsePeriod(config.Period)
+	if err != nil {
+		return report{}, err
+	}
+	start := now.Add(-period)
+
+	switch job.Report {
+	case ReportSummary:
+		return summaryReport(client, job, config, start, now)
+	case ReportChart:
+		return chartReport(client, job, config, start, now)
+	case ReportTrending:
+		return trendingReport(job, config, start, now)
+	case ReportLeaderboard:
+		return leaderboardReport(client, job, config, start, now)
+	}
+	return report{}, fmt.Errorf("unknown report %q", job.Report)
+}
+
+func summaryReport(client *bot.Client, job database.ScheduledJob, config Config, start, end time.Time) (report, error) {
+	sourceID := config.SourceChannelID
+	if sourceID == "" {
+		sourceID = job.ChannelID
+	}
+	guildID, err := snowflake.Parse(job.GuildID)
+	if err != nil {
+		return report{}, err
+	}
+
+	messages, err := summarizecommand.ChannelMessages(client, guildID, sourceID, start, end)
+	if err != nil {
+		return report{}, err
+	}
+
+	title := fmt.Sprintf("Summary of the past %s", config.Period)
+	if len(messages) == 0 {
+		return report{message: discord.MessageCreate{
+			Embeds: []discord.Embed{{Title: title, Description: "Nothing was said in this period."}},
+		}}, nil
+	}
+
+	// Saved like a /summarize invocation so failures show up in the admin
+	// summary list and can be retried from there.
+	inv := summarizecommand.NewInvocation(job.GuildID, sourceID, config.Period, messages)
+	summaries, err := summarizecommand.GetSummary(inv, messages)
+	if err != nil {
+		return report{}, err
+	}
+
+	embed := discord.Embed{Title: title}
+	if sourceID != job.ChannelID {
+		if id, err := snowflake.Parse(sourceID); err == nil {
+			embed.Description = fmt.Sprintf("Messages in %s", discord.ChannelMention(id))
+		}
+	}
+	for _, summary := range summaries.Summaries {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name:  summary.Topic,
+			Value: summary.Summary,
+		})
+	}
+
+	return report{
+		message: discord.MessageCreate{
+			Embeds:          []discord.Embed{embed},
+			AllowedMentions: &discord.AllowedMentions{},
+		},
+		onPosted: func(messageID string) {
+			if saveErr := database.SetSummaryInvocationMessage(inv.ID, messageID); saveErr != nil {
+				slog.Warn("Failed to save summary message ID", slog.Any("err", saveErr))
+			}
+		},
+	}, nil
+}
+
+func chartReport(client *bot.Client, job database.ScheduledJob, config Config, start, end time.Time) (report, error) {
+	tracker := charts.ChartTracker{
+		GuildID:   job.GuildID,
+		ChartType: charts.GetChartType(config.ChartType),
+		Metric:    charts.GetMetricType(config.Metric),
+		GroupBy:   charts.GetMetricType(config.GroupBy),
+		DateRange: "custom",
+		CustomDateRange: charts.DateRange{
+			Start: &start,
+			End:   &end,
+		},
+	}
+
+	chart, err := tracker.GenerateChart(client)
+	if err != nil {
+		return report{}, err
+	}
+	return report{message: discord.MessageCreate{
+		Content: fmt.Sprintf("Chart for the past %s", config.Period),
+		Files:   []*discord.File{chart},
+	}}, nil
+}
+
+func trendingReport(job database.ScheduledJob, config Config, start, end time.Time) (report, error) {
+	words, err := database.TrendingWords(job.GuildID, start, end, reportListSize)
+	if err != nil {
+		return report{}, err
+	}
+
+	embed := discord.Embed{
+		Title: fmt.Sprintf("Trending over the past %s", config.Period),
+	}
+	if len(words) == 0 {
+		embed.Description = "Nothing stood out in this period."
+	}
+	var lines []string
+	for i, w := range words {
+		lines = append(lines, fmt.Sprintf("%d. **%s** — %d (previously %d)", i+1, w.Word, w.Count, w.Previous))
+	}
+	if len(lines) > 0 {
+		embed.Description = strings.Join(lines, "\n")
+	}
+	return report{message: discord.MessageCreate{Embeds: []discord.Embed{embed}}}, nil
+}
+
+func leaderboardReport(client *bot.Client, job database.ScheduledJob, config Config, start, end time.Time) (report, error) {
+	tracker := charts.ChartTracker{
+		GuildID:   job.GuildID,
+		Metric:    charts.MetricType{Category: "message", Metric: "count"},
+		GroupBy:   charts.MetricType{Category: "single", Metric: "user"},
+		DateRange: "custom",
+		CustomDateRange: charts.DateRange{
+			Start: &start,
+			End:   &end,
+		},
+	}
+	data, err := tracker.Data(client)
+	if err != nil {
+		return report{}, err
+	}
+
+	embed := discord.Embed{
+		Title: fmt.Sprintf("Most active over the past %s", config.Period),
+	}
+	var lines []string
+	for _, d := range data {
+		if len(lines) == reportListSize {
+			break
+		}
+		// The "Other" bucket is a sum, not a member.
+		if d.Xaxes == "other" {
+			continue
+		}
+		name := d.XLabel
+		if id, err := snowflake.Parse(d.Xaxes); err == nil {
+			name = discord.UserMention(id)
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s — %.0f messages", len(lines)+1, name, d.Value))
+	}
+	if len(lines) == 0 {
+		embed.Description = "Nobody said anything in this period."
+	} else {
+		embed.Description = strings.Join(lines, "\n")
+	}
+	return report{message: discord.MessageCreate{
+		Embeds:          []discord.Embed{embed},
+		AllowedMentions: &discord.AllowedMentions{},
+	}}, nil
+}
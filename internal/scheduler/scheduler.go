@@ -0,0 +1,144 @@
+// Package scheduler runs the recurring reports guild admins configure through
+// /schedule. Jobs live in the scheduled_jobs table and are polled in-process,
+// so nothing external (like the CronJobs hitting the HTTP routes) is needed.
+package scheduler
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"github.com/stollenaar/statisticsbot/internal/database"
+)
+
+const (
+	// pollInterval is how often due jobs are looked up. Cron expressions have
+	// minute granularity, so anything below a minute is enough.
+	pollInterval = 30 * time.Second
+	// maxCatchUp bounds how late a missed run may still be posted after a
+	// restart. Older slots are recorded as missed instead of posting a stale
+	// report hours after the fact.
+	maxCatchUp = 24 * time.Hour
+)
+
+// NextRun returns the first time after the given time the cron expression
+// fires. Expressions use the standard five fields and may be prefixed with
+// CRON_TZ=<zone> to run in a timezone other than the bot's.
+func NextRun(spec string, after time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron expression %q: %w", spec, err)
+	}
+	return schedule.Next(after), nil
+}
+
+// Start polls for due jobs until the process exits. Runs that were still in
+// flight when the bot last stopped are marked interrupted first; jobs whose
+// slot passed while the bot was down come up as due on the first poll.
+func Start(client *bot.Client) {
+	if err := database.FailInterruptedScheduledJobRuns(); err != nil {
+		slog.Warn("Failed to mark interrupted scheduled runs", slog.Any("err", err))
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		runDue(client, time.Now())
+		<-ticker.C
+	}
+}
+
+// runDue runs every due job one after the other. Reports can call the LLM, so
+// they are deliberately not run in parallel.
+func runDue(client *bot.Client, now time.Time) {
+	jobs, err := database.DueScheduledJobs(now)
+	if err != nil {
+		slog.Error("Failed to fetch due scheduled jobs", slog.Any("err", err))
+		return
+	}
+	for _, job := range jobs {
+		run(client, job, now)
+	}
+}
+
+func run(client *bot.Client, job database.ScheduledJob, now time.Time) {
+	next, err := NextRun(job.Cron, now)
+	if err != nil {
+		// Only possible if the row was edited by hand; stop retrying it.
+		slog.Error("Disabling scheduled job with invalid cron", slog.String("job", job.ID), slog.Any("err", err))
+		if _, err := database.SetScheduledJobEnabled(job.ID, job.GuildID, false, job.NextRunAt); err != nil {
+			slog.Error("Failed to disable scheduled job", slog.String("job", job.ID), slog.Any("err", err))
+		}
+		return
+	}
+
+	// Several slots may have passed while the bot was down. They would all
+	// post the same report, so they are coalesced into this single run.
+	if skipped, _ := NextRun(job.Cron, job.NextRunAt); skipped.Before(now) {
+		slog.Info("Coalescing missed scheduled runs", slog.String("job", job.ID), slog.Time("since", job.NextRunAt))
+	}
+
+	runID := uuid.New().String()
+	if err := database.SaveScheduledJobRun(database.ScheduledJobRun{
+		ID:           runID,
+		JobID:        job.ID,
+		ScheduledFor: job.NextRunAt,
+		StartedAt:    now,
+		Status:       "running",
+	}); err != nil {
+		slog.Warn("Failed to save scheduled run", slog.String("job", job.ID), slog.Any("err", err))
+	}
+
+	// Move the job on before running it, so a crash halfway through a report
+	// does not make it fire again on every restart.
+	if err := database.UpdateScheduledJobRun(job.ID, now, "running", next); err != nil {
+		slog.Error("Failed to advance scheduled job", slog.String("job", job.ID), slog.Any("err", err))
+		return
+	}
+
+	status, errMsg, messageID := execute(client, job, now)
+	if err := database.FinishScheduledJobRun(runID, status, errMsg, messageID); err != nil {
+		slog.Warn("Failed to record scheduled run", slog.String("job", job.ID), slog.Any("err", err))
+	}
+	if err := database.UpdateScheduledJobRun(job.ID, now, status, next); err != nil {
+		slog.Warn("Failed to update scheduled job", slog.String("job", job.ID), slog.Any("err", err))
+	}
+}
+
+// execute builds and posts the job's report, returning the run status, an
+// error message on failure and the id of the posted message on success.
+func execute(client *bot.Client, job database.ScheduledJob, now time.Time) (status, errMsg, messageID string) {
+	if now.Sub(job.NextRunAt) > maxCatchUp {
+		return "missed", fmt.Sprintf("slot at %s was more than %s ago", job.NextRunAt.Format(time.RFC3339), maxCatchUp), ""
+	}
+
+	config, err := ParseConfig(job.Config)
+	if err != nil {
+		return "failed", err.Error(), ""
+	}
+
+	report, err := buildReport(client, job, config, now)
+	if err != nil {
+		slog.Error("Failed to build scheduled report", slog.String("job", job.ID), slog.String("report", job.Report), slog.Any("err", err))
+		return "failed", err.Error(), ""
+	}
+
+	channelID, err := snowflake.Parse(job.ChannelID)
+	if err != nil {
+		return "failed", err.Error(), ""
+	}
+	message, err := client.Rest.CreateMessage(channelID, report.message)
+	if err != nil {
+		slog.Error("Failed to post scheduled report", slog.String("job", job.ID), slog.Any("err", err))
+		return "failed", err.Error(), ""
+	}
+	if report.onPosted != nil {
+		report.onPosted(message.ID.String())
+	}
+	return "success", "", message.ID.String()
+}
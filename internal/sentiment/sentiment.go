@@ -0,0 +1,150 @@
+// Package sentiment labels messages as positive, neutral or negative locally
+// using a hugot text-classification pipeline (default: the ONNX export of
+// cardiffnlp/twitter-roberta-base-sentiment-latest, which is trained on tweets
+// and so a reasonable fit for chat) on hugot's pure-Go backend, the same way the
+// embeddings package runs its model. The model is found, or downloaded from
+// HuggingFace on first use, by the localmodels package.
+package sentiment
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/knights-analytics/hugot"
+	"github.com/knights-analytics/hugot/pipelines"
+	"github.com/stollenaar/statisticsbot/internal/localmodels"
+)
+
+const (
+	MODEL_NAME = "Xenova/twitter-roberta-base-sentiment-latest"
+	// onnxFile is where the Xenova exports keep their ONNX model.
+	onnxFile = "onnx/model.onnx"
+
+	Positive = "positive"
+	Neutral  = "neutral"
+	Negative = "negative"
+
+	// The model has the same 512-token window as the embedding model, and the
+	// Go tokenizer does not truncate for us either. Sentiment is carried by
+	// the first few sentences anyway, so the cap is tighter than for
+	// embeddings.
+	firstAttemptRunes = 1000
+	minAttemptRunes   = 128
+)
+
+var (
+	Labels = []string{Positive, Neutral, Negative}
+
+	initOnce sync.Once
+	initErr  error
+
+	pipeline *pipelines.TextClassificationPipeline
+
+	runMu sync.Mutex
+)
+
+// Result is the classification of a single text.
+type Result struct {
+	// Label is the most likely class.
+	Label string
+	// Confidence is the probability of Label.
+	Confidence float32
+	// Score is P(positive) - P(negative), from -1 (clearly negative) to 1
+	// (clearly positive). Unlike the label it can be averaged.
+	Score float32
+}
+
+// ModelName returns the classifier model identifier, stored with every label
+// so a later model change can tell which rows to redo.
+func ModelName() string {
+	return MODEL_NAME
+}
+
+func initPipeline() {
+	session, err := localmodels.Session()
+	if err != nil {
+		initErr = err
+		return
+	}
+
+	modelPath, err := localmodels.Path(context.Background(), MODEL_NAME, onnxFile)
+	if err != nil {
+		initErr = err
+		return
+	}
+
+	config := hugot.TextClassificationConfig{
+		ModelPath: modelPath,
+		Name:      "message-sentiment",
+		// Every class probability is needed for Score, not only the winner.
+		Options: []hugot.TextClassificationOption{
+			pipelines.WithSoftmax(),
+			pipelines.WithMultiLabel(),
+		},
+	}
+
+	pipeline, initErr = hugot.NewPipeline(session, config)
+}
+
+// Classify returns the sentiment of a single text. The pipeline is
+// initialized (and the model downloaded) on first call. Text that overflows
+// the context window is shortened and retried, like embeddings.Embed does.
+func Classify(text string) (Result, error) {
+	initOnce.Do(initPipeline)
+	if initErr != nil {
+		return Result{}, initErr
+	}
+
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) == 0 {
+		return Result{}, errors.New("cannot classify empty text")
+	}
+
+	limit := min(len(runes), firstAttemptRunes)
+
+	var lastErr error
+	for {
+		result, err := runClassify(string(runes[:limit]))
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+		if limit <= minAttemptRunes {
+			return Result{}, lastErr
+		}
+		if limit /= 2; limit < minAttemptRunes {
+			limit = minAttemptRunes
+		}
+	}
+}
+
+// runClassify runs a single input through the pipeline under the run lock.
+func runClassify(text string) (Result, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	out, err := pipeline.RunPipeline(context.Background(), []string{text})
+	if err != nil {
+		return Result{}, err
+	}
+	if len(out.ClassificationOutputs) == 0 || len(out.ClassificationOutputs[0]) == 0 {
+		return Result{}, errors.New("no classification returned")
+	}
+
+	var result Result
+	for _, class := range out.ClassificationOutputs[0] {
+		label := strings.ToLower(class.Label)
+		switch label {
+		case Positive:
+			result.Score += class.Score
+		case Negative:
+			result.Score -= class.Score
+		}
+		if class.Score > result.Confidence {
+			result.Label, result.Confidence = label, class.Score
+		}
+	}
+	return result, nil
+}
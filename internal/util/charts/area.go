@@ -0,0 +1,107 @@
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/go-echarts/snapshot-chromedp/render"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// Series is a named row of values, one per label on the x-axis.
+type Series struct {
+	Name   string
+	Values []float64
+}
+
+// GenerateStackedAreaChart renders series stacked on top of each other as
+// filled areas, e.g. the share of each topic over time, as a PNG.
+func GenerateStackedAreaChart(title string, xAxis []string, series []Series) (*discord.File, error) {
+	if nativeRenderer() {
+		image, err := rasterLine(title, xAxis, series, lineOptions{stacked: true, max: 100, unit: "%"})
+		if err != nil {
+			return nil, err
+		}
+		return pngFile(image), nil
+	}
+
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithInitializationOpts(opts.Initialization{
+			BackgroundColor: "#FFFFFF",
+			Width:           "100%",
+		}),
+		// Don't forget disable the Animation
+		charts.WithAnimation(false),
+		charts.WithTitleOpts(opts.Title{
+			Title: title,
+			Right: "40%",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show:   opts.Bool(true),
+			Bottom: "0",
+		}),
+		charts.WithGridOpts(opts.Grid{Bottom: "20%"}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Type:        "category",
+			BoundaryGap: opts.Bool(false),
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Max:       100,
+			AxisLabel: &opts.AxisLabel{Formatter: "{value}%"},
+		}),
+	)
+
+	line.SetXAxis(xAxis)
+	for _, s := range series {
+		items := make([]opts.LineData, len(s.Values))
+		for i, v := range s.Values {
+			items[i] = opts.LineData{Value: v}
+		}
+		line.AddSeries(s.Name, items,
+			charts.WithLineChartOpts(opts.LineChart{
+				Stack:      "total",
+				ShowSymbol: opts.Bool(false),
+			}),
+			charts.WithAreaStyleOpts(opts.AreaStyle{Opacity: opts.Float(0.8)}),
+		)
+	}
+
+	return snapshot(line.RenderContent())
+}
+
+// snapshot renders a chart's HTML to a PNG in headless Chrome and loads it as
+// a Discord attachment.
+func snapshot(content []byte) (*discord.File, error) {
+	fileName := fmt.Sprintf("%d.png", time.Now().UnixNano())
+	if err := render.MakeChartSnapshot(content, fileName); err != nil {
+		return nil, err
+	}
+	defer os.Remove(fileName)
+
+	image, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return pngFile(image), nil
+}
+
+// nativeRenderer reports whether charts are drawn in Go rather than
+// snapshotted in headless Chrome, which CHART_RENDERER=native asks for.
+func nativeRenderer() bool {
+	return strings.EqualFold(util.ConfigFile.CHART_RENDERER, "native")
+}
+
+// pngFile wraps a PNG as a Discord attachment.
+func pngFile(image []byte) *discord.File {
+	return &discord.File{
+		Name:   fmt.Sprintf("%d.png", time.Now().UnixNano()),
+		Reader: bytes.NewReader(image),
+	}
+}
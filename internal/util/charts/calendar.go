@@ -0,0 +1,236 @@
+package charts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+)
+
+// calendarWeeks is how many week columns a calendar chart spans, enough for
+// any year.
+const calendarWeeks = 53
+
+// calendarColors are the GitHub contribution graph colours, from no activity
+// to the busiest days.
+var calendarColors = []string{"#ebedf0", "#9be9a8", "#40c463", "#30a14e", "#216e39"}
+
+// calendarDay is a day of a calendar chart. Days before the selected date
+// range are not part of it.
+type calendarDay struct {
+	date    time.Time
+	value   float64
+	inRange bool
+}
+
+// streak is a run of consecutive days with activity.
+type streak struct {
+	start, end time.Time
+	days       int
+}
+
+// calendarStats are the annotations written under a calendar chart.
+type calendarStats struct {
+	longest    streak
+	current    streak
+	total      float64
+	activeDays int
+}
+
+// calendarDays spreads the single;date data over the year ending at the end
+// of the date range, a day per entry. Dates are the UTC days the query
+// groups by.
+func (c *ChartTracker) calendarDays(chartData []*ChartData) ([]calendarDay, error) {
+	start, end, err := c.getDateRange()
+	if err != nil {
+		return nil, err
+	}
+	last := truncateDay(end)
+	first := last.AddDate(-1, 0, 1)
+	start = truncateDay(start)
+
+	values := make(map[string]float64)
+	for _, data := range chartData {
+		values[data.XLabel] += data.Value
+	}
+
+	var days []calendarDay
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		days = append(days, calendarDay{
+			date:    d,
+			value:   values[d.Format("2006-01-02")],
+			inRange: !d.Before(start),
+		})
+	}
+	return days, nil
+}
+
+// truncateDay returns the UTC midnight of t's day.
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// getCalendarStats finds the longest and the current streak of active days.
+// Like GitHub, a current streak is not broken by a last day that has no
+// activity yet.
+func getCalendarStats(days []calendarDay) (stats calendarStats) {
+	active := func(d calendarDay) bool { return d.inRange && d.value > 0 }
+
+	var run streak
+	for _, d := range days {
+		if !active(d) {
+			run = streak{}
+			continue
+		}
+		stats.total += d.value
+		stats.activeDays++
+		if run.days == 0 {
+			run.start = d.date
+		}
+		run.end = d.date
+		run.days++
+		if run.days > stats.longest.days {
+			stats.longest = run
+		}
+	}
+
+	i := len(days) - 1
+	if i > 0 && !active(days[i]) {
+		i--
+	}
+	for ; i >= 0 && active(days[i]); i-- {
+		if stats.current.days == 0 {
+			stats.current.end = days[i].date
+		}
+		stats.current.start = days[i].date
+		stats.current.days++
+	}
+	return
+}
+
+// summary writes the stats as a single line. Totals are left out for
+// metrics that cannot be summed.
+func (s calendarStats) summary(additive bool) string {
+	parts := []string{
+		fmt.Sprintf("Longest streak: %s", s.longest.describe()),
+		fmt.Sprintf("Current streak: %s", s.current.describe()),
+	}
+	if additive {
+		parts = append(parts, fmt.Sprintf("Total: %s", formatValue(s.total)))
+	}
+	parts = append(parts, fmt.Sprintf("Active days: %d", s.activeDays))
+	return strings.Join(parts, "  ·  ")
+}
+
+// describe writes the length and dates of a streak.
+func (s streak) describe() string {
+	switch s.days {
+	case 0:
+		return "none"
+	case 1:
+		return fmt.Sprintf("1 day (%s)", s.start.Format("Jan 2"))
+	}
+	return fmt.Sprintf("%d days (%s – %s)", s.days, s.start.Format("Jan 2"), s.end.Format("Jan 2"))
+}
+
+// calendarLevels returns the colour level, 0 to 4, of every day. Active days
+// are split into quartiles of their values, the way GitHub shades its graph.
+func calendarLevels(days []calendarDay) []int {
+	var active []float64
+	for _, d := range days {
+		if d.inRange && d.value > 0 {
+			active = append(active, d.value)
+		}
+	}
+	sort.Float64s(active)
+	quantile := func(q float64) float64 {
+		if len(active) == 0 {
+			return 0
+		}
+		return active[int(q*float64(len(active)-1))]
+	}
+	thresholds := []float64{quantile(0.25), quantile(0.5), quantile(0.75)}
+
+	levels := make([]int, len(days))
+	for i, d := range days {
+		if !d.inRange || d.value <= 0 {
+			continue
+		}
+		levels[i] = len(calendarColors) - 1
+		for j, t := range thresholds {
+			if d.value <= t {
+				levels[i] = j + 1
+				break
+			}
+		}
+	}
+	return levels
+}
+
+func (c *ChartTracker) generateCalendarChart(chartData []*ChartData, title string) (*charts.HeatMap, error) {
+	days, err := c.calendarDays(chartData)
+	if err != nil {
+		return nil, err
+	}
+	stats := getCalendarStats(days)
+
+	var data []opts.HeatMapData
+	top := 0.0
+	for _, d := range days {
+		if d.inRange {
+			data = append(data, opts.HeatMapData{Value: [2]interface{}{d.date.Format("2006-01-02"), d.value}})
+			top = max(top, d.value)
+		}
+	}
+
+	heatmap := charts.NewHeatMap()
+	heatmap.SetGlobalOptions(
+		charts.WithInitializationOpts(opts.Initialization{
+			BackgroundColor: "#FFFFFF",
+			Width:           "100%",
+		}),
+		// Don't forget disable the Animation
+		charts.WithAnimation(false),
+		charts.WithTitleOpts(opts.Title{
+			Title:    title,
+			Subtitle: stats.summary(c.Metric.Additive()),
+			Right:    "40%",
+		}),
+		charts.WithVisualMapOpts(opts.VisualMap{
+			Show:   opts.Bool(true),
+			Min:    0,
+			Max:    float32(max(top, 1)),
+			Orient: "horizontal",
+			Right:  "40",
+			Bottom: "20",
+			InRange: &opts.VisualMapInRange{
+				Color: calendarColors,
+			},
+		}),
+		charts.WithLegendOpts(opts.Legend{Show: opts.Bool(false)}),
+	)
+	heatmap.AddCalendar(&opts.Calendar{
+		Top:      "120",
+		Left:     "60",
+		Right:    "40",
+		Height:   "140",
+		CellSize: "auto",
+		Range:    []string{days[0].date.Format("2006-01-02"), days[len(days)-1].date.Format("2006-01-02")},
+		ItemStyle: &opts.ItemStyle{
+			BorderWidth: 3,
+			BorderColor: "#FFFFFF",
+		},
+		SplitLine: &opts.SplitLine{Show: opts.Bool(false)},
+		DayLabel:  &opts.CalendarLabel{FirstDay: 1},
+		YearLabel: &opts.CalendarLabel{Show: opts.Bool(false)},
+	})
+	heatmap.AddSeries(c.Metric.ToString(), data,
+		charts.WithCoordinateSystem("calendar"),
+		charts.WithCalendarIndex(0),
+	)
+	return heatmap, nil
+}
@@ -0,0 +1,90 @@
+package charts
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// calendarRun returns a day per value starting at Jan 1 2026, in range from
+// the index inRangeFrom on.
+func calendarRun(inRangeFrom int, values ...float64) []calendarDay {
+	first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	days := make([]calendarDay, len(values))
+	for i, v := range values {
+		days[i] = calendarDay{date: first.AddDate(0, 0, i), value: v, inRange: i >= inRangeFrom}
+	}
+	return days
+}
+
+func TestGetCalendarStats(t *testing.T) {
+	jan := func(day int) time.Time { return time.Date(2026, 1, day, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name            string
+		days            []calendarDay
+		longest         streak
+		current         streak
+		total           float64
+		activeDays      int
+		summaryContains string
+	}{
+		{
+			name:            "no days",
+			days:            nil,
+			summaryContains: "Longest streak: none",
+		},
+		{
+			// A quiet last day does not break the current streak yet.
+			name:            "quiet today",
+			days:            calendarRun(0, 2, 1, 0, 4, 1, 3, 0),
+			longest:         streak{start: jan(4), end: jan(6), days: 3},
+			current:         streak{start: jan(4), end: jan(6), days: 3},
+			total:           11,
+			activeDays:      5,
+			summaryContains: "3 days (Jan 4 – Jan 6)",
+		},
+		{
+			name:            "streak broken",
+			days:            calendarRun(0, 1, 1, 1, 0, 5, 0, 0),
+			longest:         streak{start: jan(1), end: jan(3), days: 3},
+			total:           8,
+			activeDays:      4,
+			summaryContains: "Current streak: none",
+		},
+		{
+			// Days before the date range are drawn but never counted.
+			name:            "before the range",
+			days:            calendarRun(3, 9, 9, 9, 1, 0, 2),
+			longest:         streak{start: jan(4), end: jan(4), days: 1},
+			current:         streak{start: jan(6), end: jan(6), days: 1},
+			total:           3,
+			activeDays:      2,
+			summaryContains: "Current streak: 1 day (Jan 6)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := getCalendarStats(tt.days)
+			if stats.longest != tt.longest {
+				t.Errorf("longest = %+v, want %+v", stats.longest, tt.longest)
+			}
+			if stats.current != tt.current {
+				t.Errorf("current = %+v, want %+v", stats.current, tt.current)
+			}
+			if stats.total != tt.total || stats.activeDays != tt.activeDays {
+				t.Errorf("total %v over %d days, want %v over %d", stats.total, stats.activeDays, tt.total, tt.activeDays)
+			}
+			if summary := stats.summary(true); !strings.Contains(summary, tt.summaryContains) {
+				t.Errorf("summary %q does not contain %q", summary, tt.summaryContains)
+			}
+		})
+	}
+}
+
+func TestCalendarStatsSummaryAverages(t *testing.T) {
+	stats := getCalendarStats(calendarRun(0, 0.5, 0.5))
+	if summary := stats.summary(false); strings.Contains(summary, "Total") {
+		t.Errorf("summary of an averaged metric has a total: %q", summary)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,6 +14,13 @@ import (
 	"github.com/stollenaar/statisticsbot/internal/util"
 )
 
+// Data returns the aggregated rows a chart would be drawn from, with user and
+// channel ids resolved to names, so other commands can reuse the chart queries
+// without rendering an image.
+func (c *ChartTracker) Data(client *bot.Client) ([]*ChartData, error) {
+	return c.getData(client)
+}
+
 func (c *ChartTracker) getData(client *bot.Client) (data []*ChartData, err error) {
 	// Start tracking execution time
 	startTime := time.Now()
@@ -49,7 +57,7 @@ func (c *ChartTracker) getData(client *bot.Client) (data []*ChartData, err error
 	usernames, channels := make(map[string]string), make(map[string]string) // Cache for user and channel IDs
 
 	// Pre-fetch users if grouping by "user"
-	if c.GroupBy.Metric == "user" || c.GroupBy.Metric == "bot" {
+	if c.GroupBy.Metric == "user" || c.GroupBy.Metric == "bot" || c.GroupBy.Category == ReplyNetwork.Category {
 
 		members := slices.Collect(client.Caches.Members(snowflake.MustParse(c.GuildID))) // Fetch up to 1000 at a time
 
@@ -107,8 +115,16 @@ func (c *ChartTracker) getData(client *bot.Client) (data []*ChartData, err error
 			} else {
 				xLabel = xaxes
 			}
-		case c.GroupBy.Metric == "date":
+		case c.GroupBy.Chronological():
 			xLabel = xaxes
+		case c.GroupBy == HourWeekday:
+			// isodow numbers Monday 1 through Sunday 7.
+			if hour, err := strconv.Atoi(xaxes); err == nil && hour >= 0 && hour < len(hourLabels) {
+				xLabel = hourLabels[hour]
+			}
+			if day, err := strconv.Atoi(yaxes); err == nil && day >= 1 && day <= len(weekdayLabels) {
+				yLabel = weekdayLabels[len(weekdayLabels)-day]
+			}
 		case c.GroupBy.Category == "channel" && c.GroupBy.Metric == "user" && c.GroupBy.MultiAxes:
 			if name, found := usernames[xaxes]; found {
 				xLabel = name
@@ -120,6 +136,31 @@ func (c *ChartTracker) getData(client *bot.Client) (data []*ChartData, err error
 			} else {
 				yLabel = yaxes
 			}
+		case c.GroupBy == DateUser:
+			xLabel = xaxes
+			if name, found := usernames[yaxes]; found {
+				yLabel = name
+			} else {
+				yLabel = yaxes
+			}
+		case c.GroupBy == DateChannel:
+			xLabel = xaxes
+			if name, found := channels[yaxes]; found {
+				yLabel = name
+			} else {
+				yLabel = yaxes
+			}
+		case c.GroupBy.Category == ReplyNetwork.Category:
+			if name, found := usernames[xaxes]; found {
+				xLabel = name
+			} else {
+				xLabel = xaxes
+			}
+			if name, found := usernames[yaxes]; found {
+				yLabel = name
+			} else {
+				yLabel = yaxes
+			}
 		case c.GroupBy.Category == "reaction" && c.GroupBy.Metric == "user" && c.GroupBy.MultiAxes:
 			if name, found := usernames[xaxes]; found {
 				xLabel = name
@@ -160,20 +201,8 @@ func (c *ChartTracker) getData(client *bot.Client) (data []*ChartData, err error
 
 	// Process top 14 and "Other" category
 	if !c.GroupBy.MultiAxes &&
-		c.GroupBy.Metric != "date" && len(allData) > 14 {
-		topData := allData[:14]
-		otherValue := 0.0
-		for _, d := range allData[14:] {
-			otherValue += d.Value
-		}
-		topData = append(topData, &ChartData{
-			Xaxes:  "other",
-			XLabel: "Other",
-			Yaxes:  "other",
-			YLabel: "Other",
-			Value:  otherValue,
-		})
-		data = topData
+		!c.GroupBy.Chronological() && len(allData) > 14 {
+		data = topWithOther(allData, c.Metric.Category != "sentiment")
 	} else {
 		data = allData
 	}
@@ -197,3 +226,24 @@ func (c *ChartTracker) getData(client *bot.Client) (data []*ChartData, err error
 
 	return
 }
+
+// topWithOther keeps the 14 largest groups and, when withOther is set, folds
+// the rest into an "Other" group holding their sum. The sentiment metrics
+// leave it out: a sum of averages and shares would dwarf every real group.
+func topWithOther(all []*ChartData, withOther bool) []*ChartData {
+	top := all[:14]
+	if !withOther {
+		return top
+	}
+	otherValue := 0.0
+	for _, d := range all[14:] {
+		otherValue += d.Value
+	}
+	return append(top, &ChartData{
+		Xaxes:  "other",
+		XLabel: "Other",
+		Yaxes:  "other",
+		YLabel: "Other",
+		Value:  otherValue,
+	})
+}
@@ -74,18 +74,8 @@ func (c *ChartTracker) getDebugData() (data []*ChartData, err error) {
 
 	// Process top 14 and "Other" category
 	if !c.GroupBy.MultiAxes &&
-		c.GroupBy.Metric != "date" && len(allData) > 14 {
-		topData := allData[:14]
-		otherValue := 0.0
-		for _, d := range allData[14:] {
-			otherValue += d.Value
-		}
-		topData = append(topData, &ChartData{
-			Xaxes:  "other",
-			XLabel: "Other",
-			Value:  otherValue,
-		})
-		data = topData
+		!c.GroupBy.Chronological() && len(allData) > 14 {
+		data = topWithOther(allData, c.Metric.Category != "sentiment")
 	} else {
 		data = allData
 	}
@@ -0,0 +1,54 @@
+package charts
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopWithOther(t *testing.T) {
+	var all []*ChartData
+	for i := range 20 {
+		all = append(all, &ChartData{Xaxes: fmt.Sprint(i), Value: float64(20 - i)})
+	}
+
+	data := topWithOther(all, true)
+	if len(data) != 15 {
+		t.Fatalf("got %d groups, want 14 and Other", len(data))
+	}
+	// The six smallest groups are 6+5+4+3+2+1.
+	if other := data[14]; other.XLabel != "Other" || other.Value != 21 {
+		t.Errorf("Other = %q %v, want the sum 21", other.XLabel, other.Value)
+	}
+
+	data = topWithOther(all, false)
+	if len(data) != 14 {
+		t.Fatalf("got %d groups without Other, want only the top 14", len(data))
+	}
+	for _, d := range data {
+		if d.Xaxes == "other" {
+			t.Errorf("got an Other group: %v", d.Value)
+		}
+	}
+}
+
+// The grouping expressions are passed to Sprintf as arguments, not as part of
+// the format, so their strftime patterns must not be escaped.
+func TestBuildQueryMonth(t *testing.T) {
+	c := &ChartTracker{
+		Metric:  MetricType{Category: "message", Metric: "count"},
+		GroupBy: MetricType{Category: "single", Metric: "month"},
+	}
+	end := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	query, err := c.buildQuery(end.AddDate(0, -3, 0), end)
+	if err != nil {
+		t.Fatalf("buildQuery: %v", err)
+	}
+	if strings.Contains(query, "%%") {
+		t.Errorf("query has an escaped strftime pattern:\n%s", query)
+	}
+	if !strings.Contains(query, "ORDER BY strftime('%Y-%m', date) ASC") {
+		t.Errorf("months are not grouped and ordered by strftime('%%Y-%%m', date):\n%s", query)
+	}
+}
@@ -85,6 +85,16 @@ func (c *ChartTracker) BuildComponents(errors map[string][]discord.LayoutCompone
 							Value:   "heatmap",
 							Default: c.ChartType == HeatmapChart,
 						},
+						{
+							Label:   "Calendar",
+							Value:   "calendar",
+							Default: c.ChartType == CalendarChart,
+						},
+						{
+							Label:   "Network",
+							Value:   "network",
+							Default: c.ChartType == NetworkChart,
+						},
 					},
 				},
 			},
@@ -125,6 +135,18 @@ func (c *ChartTracker) BuildComponents(errors map[string][]discord.LayoutCompone
 							Description: "How many times a bot has been interacted with",
 							Default:     c.Metric == MetricType{Category: "interaction", Metric: "count"},
 						},
+						{
+							Label:       "Avg. Sentiment",
+							Value:       "sentiment;avg",
+							Description: "Average sentiment, from -1 (negative) to 1 (positive)",
+							Default:     c.Metric == MetricType{Category: "sentiment", Metric: "avg"},
+						},
+						{
+							Label:       "Positive Share",
+							Value:       "sentiment;positive_share",
+							Description: "Share of messages labelled positive",
+							Default:     c.Metric == MetricType{Category: "sentiment", Metric: "positive_share"},
+						},
 						// {Label: "Mentions Received", Value: "mentions", Description: "Times the user was mentioned"},
 						// {Label: "Reactions Received", Value: "reactions", Description: "Reactions per user (if available)"},
 					},
@@ -145,6 +167,7 @@ func (c *ChartTracker) BuildComponents(errors map[string][]discord.LayoutCompone
 		components = append(components, c.getCustomDate()...)
 	}
 
+	components = append(components, c.getLineMode()...)
 	components = append(components, c.getOptionalSettings()...)
 
 	components = append(components, discord.ActionRowComponent{
@@ -267,12 +290,15 @@ func (c *ChartTracker) getGroupBy() *discord.ActionRowComponent {
 	case PieChart:
 		fallthrough
 	case BarChart:
-		fallthrough
-	case LineChart:
-		if c.GroupBy == (MetricType{Category: "channel", Metric: "user", MultiAxes: true}) {
+		if c.GroupBy.MultiAxes {
 			c.GroupBy = MetricType{}
 		}
 		options = c.getSingleGroupBy()
+	case LineChart:
+		options = append(c.getSingleGroupBy(), c.getSeriesGroupBy()...)
+		if c.GroupBy.MultiAxes && !isOption(c.GroupBy, options) {
+			c.GroupBy = MetricType{}
+		}
 	case SunburstChart:
 		fallthrough
 	case HeatmapChart:
@@ -280,6 +306,13 @@ func (c *ChartTracker) getGroupBy() *discord.ActionRowComponent {
 		if !isOption(c.GroupBy, options) {
 			c.GroupBy = MetricType{}
 		}
+	case CalendarChart:
+		options = c.getCalendarGroupBy()
+	case NetworkChart:
+		options = c.getNetworkGroupBy()
+		if !isOption(c.GroupBy, options) {
+			c.GroupBy = MetricType{}
+		}
 	}
 	if len(options) == 0 {
 		return nil
@@ -313,7 +346,7 @@ func (c *ChartTracker) getSingleGroupBy() []discord.StringSelectMenuOption {
 				Default:     c.GroupBy == MetricType{Category: "interaction", Metric: "bot"},
 			},
 		}
-	case "message":
+	case "message", "sentiment":
 		fallthrough
 	case "reaction":
 		return []discord.StringSelectMenuOption{
@@ -329,6 +362,12 @@ func (c *ChartTracker) getSingleGroupBy() []discord.StringSelectMenuOption {
 				Description: "Group results by individual day",
 				Default:     c.GroupBy == MetricType{Category: "single", Metric: "date"},
 			},
+			{
+				Label:       "Month",
+				Value:       "single;month",
+				Description: "Group results by month",
+				Default:     c.GroupBy == MetricType{Category: "single", Metric: "month"},
+			},
 			{
 				Label:       "Channel",
 				Value:       "single;channel",
@@ -341,17 +380,20 @@ func (c *ChartTracker) getSingleGroupBy() []discord.StringSelectMenuOption {
 	}
 }
 
-func (c *ChartTracker) getMultiGroupBy() 	[]discord.StringSelectMenuOption {
+func (c *ChartTracker) getMultiGroupBy() []discord.StringSelectMenuOption {
 	switch c.Metric.Category {
-	case "message":
-		c.GroupBy = MetricType{Category: "channel", Metric: "user", MultiAxes: true}
+	case "message", "sentiment":
+		if c.GroupBy != HourWeekday {
+			c.GroupBy = MetricType{Category: "channel", Metric: "user", MultiAxes: true}
+		}
 		return []discord.StringSelectMenuOption{
 			{
 				Label:       "Channel & User",
 				Value:       "channel;user;true",
 				Description: "Group results by channel and user (author)",
-				Default:     true,
+				Default:     c.GroupBy == MetricType{Category: "channel", Metric: "user", MultiAxes: true},
 			},
+			hourWeekdayOption(c.GroupBy),
 		}
 	case "reaction":
 		return []discord.StringSelectMenuOption{
@@ -367,6 +409,7 @@ func (c *ChartTracker) getMultiGroupBy() 	[]discord.StringSelectMenuOption {
 				Description: "Group results by emoji and channel",
 				Default:     c.GroupBy == MetricType{Category: "reaction", Metric: "channel", MultiAxes: true},
 			},
+			hourWeekdayOption(c.GroupBy),
 		}
 	case "interaction":
 		c.GroupBy = MetricType{Category: "interaction", Metric: "user", MultiAxes: true}
@@ -383,6 +426,82 @@ func (c *ChartTracker) getMultiGroupBy() 	[]discord.StringSelectMenuOption {
 	}
 }
 
+// getSeriesGroupBy offers the groupings that draw a line per user or
+// channel.
+func (c *ChartTracker) getSeriesGroupBy() []discord.StringSelectMenuOption {
+	switch c.Metric.Category {
+	case "message", "sentiment", "reaction":
+		return []discord.StringSelectMenuOption{
+			{
+				Label:       "Date & User",
+				Value:       DateUser.ToString(),
+				Description: "A line per user (author), by day",
+				Default:     c.GroupBy == DateUser,
+			},
+			{
+				Label:       "Date & Channel",
+				Value:       DateChannel.ToString(),
+				Description: "A line per channel, by day",
+				Default:     c.GroupBy == DateChannel,
+			},
+		}
+	default:
+		return []discord.StringSelectMenuOption{}
+	}
+}
+
+// getCalendarGroupBy only offers the daily grouping a calendar is drawn
+// from, for the metrics that have one.
+func (c *ChartTracker) getCalendarGroupBy() []discord.StringSelectMenuOption {
+	switch c.Metric.Category {
+	case "message", "sentiment", "reaction":
+		c.GroupBy = MetricType{Category: "single", Metric: "date"}
+		return []discord.StringSelectMenuOption{
+			{
+				Label:       "Date",
+				Value:       "single;date",
+				Description: "Group results by individual day",
+				Default:     true,
+			},
+		}
+	default:
+		c.GroupBy = MetricType{}
+		return []discord.StringSelectMenuOption{}
+	}
+}
+
+// getNetworkGroupBy offers the reply network, with or without communities.
+// Its edges are counted, so only the message count applies.
+func (c *ChartTracker) getNetworkGroupBy() []discord.StringSelectMenuOption {
+	if c.Metric != (MetricType{Category: "message", Metric: "count"}) {
+		return []discord.StringSelectMenuOption{}
+	}
+	return []discord.StringSelectMenuOption{
+		{
+			Label:       "Replies & Mentions",
+			Value:       ReplyNetwork.ToString(),
+			Description: "Connect users who reply to or mention each other",
+			Default:     c.GroupBy == ReplyNetwork,
+		},
+		{
+			Label:       "Replies & Mentions, by Community",
+			Value:       ReplyCommunities.ToString(),
+			Description: "Also colour users by the group they talk with most",
+			Default:     c.GroupBy == ReplyCommunities,
+		},
+	}
+}
+
+// hourWeekdayOption is the group-by option of the HourWeekday punch card.
+func hourWeekdayOption(selected MetricType) discord.StringSelectMenuOption {
+	return discord.StringSelectMenuOption{
+		Label:       "Hour & Weekday",
+		Value:       HourWeekday.ToString(),
+		Description: fmt.Sprintf("Group results by hour of the day and day of the week (%s)", chartTimezone()),
+		Default:     selected == HourWeekday,
+	}
+}
+
 func isOption(selected MetricType, options []discord.StringSelectMenuOption) bool {
 	for _, option := range options {
 		if option.Value == selected.ToString() {
@@ -0,0 +1,182 @@
+package charts
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/go-echarts/go-echarts/v2/render"
+	"github.com/google/uuid"
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// interactiveTTL is how long the link to a chart's interactive page works.
+const interactiveTTL = 24 * time.Hour
+
+// interactiveSession is the command name interactive charts are stored under
+// in the session store.
+const interactiveSession = "plot"
+
+var (
+	// generatedSecret signs links when CHART_LINK_SECRET is not set, so they
+	// stop working when the bot restarts.
+	generatedSecret     []byte
+	generatedSecretOnce sync.Once
+)
+
+// interactiveChart is what a link's session holds to draw its chart again.
+type interactiveChart struct {
+	Tracker ChartTracker `json:"tracker"`
+	Data    []*ChartData `json:"data"`
+}
+
+// InteractiveLink stores the chart of already fetched data and returns a
+// signed link to its interactive page, which expires after interactiveTTL.
+// It returns an empty link when PUBLIC_URL, where the chart server on
+// CHART_PORT is reachable, is not set.
+func (c *ChartTracker) InteractiveLink(data []*ChartData) (string, error) {
+	base := strings.TrimRight(util.ConfigFile.PUBLIC_URL, "/")
+	if base == "" {
+		return "", nil
+	}
+
+	token := uuid.New().String()
+	if err := database.SaveSession(token, interactiveSession, interactiveChart{Tracker: *c, Data: data}, interactiveTTL); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(interactiveTTL).Unix(), 10)
+	return fmt.Sprintf("%s/charts/%s?expires=%s&sig=%s", base, url.PathEscape(token), expires, signLink(token, expires)), nil
+}
+
+// VerifyLink reports whether an interactive chart link was signed by this
+// bot and has not expired yet.
+func VerifyLink(token, expires, sig string) bool {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	want, _ := hex.DecodeString(signLink(token, expires))
+	return hmac.Equal(got, want)
+}
+
+// RenderInteractive writes the interactive page of the chart stored under a
+// link's token, or returns database.ErrSessionExpired.
+func RenderInteractive(token string, w io.Writer) error {
+	var chart interactiveChart
+	if err := database.LoadSession(token, interactiveSession, &chart); err != nil {
+		return err
+	}
+	c := chart.Tracker
+	title := caser.String(strings.ReplaceAll(fmt.Sprintf("%s by %s", c.Metric.Title(), c.GroupBy.Title()), "_", " "))
+
+	page := []charts.GlobalOpts{
+		charts.WithInitializationOpts(opts.Initialization{
+			PageTitle:       title,
+			BackgroundColor: "#FFFFFF",
+			Width:           "100%",
+			Height:          "90vh",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: opts.Bool(true)}),
+	}
+	// Dense category axes can be zoomed with the wheel or the slider.
+	zoom := charts.WithDataZoomOpts(opts.DataZoom{Type: "inside"}, opts.DataZoom{Type: "slider"})
+
+	switch c.ChartType {
+	case BarChart:
+		bar := c.generateBarChart(chart.Data, title)
+		bar.SetGlobalOptions(append(page, zoom)...)
+		return renderEscaped(w, bar.Validate, &bar.BaseConfiguration)
+	case PieChart:
+		pie := c.generatePieChart(chart.Data, title)
+		pie.SetGlobalOptions(page...)
+		return renderEscaped(w, pie.Validate, &pie.BaseConfiguration)
+	case LineChart:
+		line := c.generateLineChart(chart.Data, title)
+		line.SetGlobalOptions(append(page, zoom)...)
+		return renderEscaped(w, line.Validate, &line.BaseConfiguration)
+	case HeatmapChart:
+		heatmap := c.generateHeatMapChart(chart.Data, title)
+		heatmap.SetGlobalOptions(append(page, zoom)...)
+		return renderEscaped(w, heatmap.Validate, &heatmap.BaseConfiguration)
+	case SunburstChart:
+		sunburst := c.generateSunBurstChart(chart.Data, title)
+		sunburst.SetGlobalOptions(page...)
+		return renderEscaped(w, sunburst.Validate, &sunburst.BaseConfiguration)
+	case CalendarChart:
+		calendar, err := c.generateCalendarChart(chart.Data, title)
+		if err != nil {
+			return err
+		}
+		calendar.SetGlobalOptions(page...)
+		return renderEscaped(w, calendar.Validate, &calendar.BaseConfiguration)
+	case NetworkChart:
+		network := c.generateNetworkChart(chart.Data, title)
+		network.SetGlobalOptions(page...)
+		return renderEscaped(w, network.Validate, &network.BaseConfiguration)
+	}
+	return fmt.Errorf("chart type %q cannot be rendered", c.ChartType)
+}
+
+// escapedOptions is a chart whose options are written into the page with
+// HTML escaping. go-echarts writes them into a <script> unescaped, and the
+// labels are member nicknames, channel and reaction names anyone can pick,
+// so a "</script>" in one would run in the browser of whoever opens the link.
+type escapedOptions struct {
+	*charts.BaseConfiguration
+}
+
+// JSONNotEscaped shadows the method the page template writes the options
+// with; json.Marshal escapes <, > and & as \u003c, \u003e and \u0026.
+func (c escapedOptions) JSONNotEscaped() template.HTML {
+	options, err := json.Marshal(c.JSON())
+	if err != nil {
+		slog.Error("failed to marshal the chart options", slog.Any("err", err))
+		return "{}"
+	}
+	return template.HTML(options)
+}
+
+// renderEscaped validates a chart and writes its page with escapedOptions.
+func renderEscaped(w io.Writer, validate func(), chart *charts.BaseConfiguration) error {
+	validate()
+	return render.NewChartRender(escapedOptions{chart}).Render(w)
+}
+
+// signLink returns the hex HMAC of a link's token and expiry.
+func signLink(token, expires string) string {
+	mac := hmac.New(sha256.New, linkSecret())
+	mac.Write([]byte(token + "|" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// linkSecret returns the key links are signed with: CHART_LINK_SECRET, or
+// a random one for this process.
+func linkSecret() []byte {
+	if util.ConfigFile.CHART_LINK_SECRET != "" {
+		return []byte(util.ConfigFile.CHART_LINK_SECRET)
+	}
+	generatedSecretOnce.Do(func() {
+		generatedSecret = make([]byte, 32)
+		rand.Read(generatedSecret)
+		slog.Warn("CHART_LINK_SECRET is not set, interactive chart links will stop working on restart")
+	})
+	return generatedSecret
+}
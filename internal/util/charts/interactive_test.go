@@ -0,0 +1,113 @@
+package charts
+
+import (
+	"bytes"
+	"net/url"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/database"
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// useLinkSecret signs links with the given secret for a test.
+func useLinkSecret(t *testing.T, secret string) {
+	t.Helper()
+	old := util.ConfigFile.CHART_LINK_SECRET
+	util.ConfigFile.CHART_LINK_SECRET = secret
+	t.Cleanup(func() { util.ConfigFile.CHART_LINK_SECRET = old })
+}
+
+func TestVerifyLink(t *testing.T) {
+	useLinkSecret(t, "test-secret")
+	expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	sig := signLink("token", expires)
+
+	tests := []struct {
+		name                string
+		token, expires, sig string
+		want                bool
+	}{
+		{"valid", "token", expires, sig, true},
+		{"other token", "other", expires, sig, false},
+		{"extended expiry", "token", strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10), sig, false},
+		{"expired", "token", expired, signLink("token", expired), false},
+		{"bad expiry", "token", "soon", signLink("token", "soon"), false},
+		{"not hex", "token", expires, "zz" + sig[2:], false},
+		{"truncated", "token", expires, sig[:len(sig)-2], false},
+		{"empty", "token", expires, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyLink(tt.token, tt.expires, tt.sig); got != tt.want {
+				t.Errorf("VerifyLink = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A link signed with another secret, e.g. before it was rotated.
+	useLinkSecret(t, "rotated")
+	if VerifyLink("token", expires, sig) {
+		t.Error("link signed with the old secret still verifies")
+	}
+}
+
+func TestInteractiveLinkVerifies(t *testing.T) {
+	useLinkSecret(t, "test-secret")
+	old := util.ConfigFile.PUBLIC_URL
+	util.ConfigFile.PUBLIC_URL = "https://stats.example.com/"
+	t.Cleanup(func() { util.ConfigFile.PUBLIC_URL = old })
+
+	c := &ChartTracker{ChartType: BarChart}
+	link, err := c.InteractiveLink([]*ChartData{{Xaxes: "a", XLabel: "a", Value: 1}})
+	if err != nil {
+		t.Fatalf("InteractiveLink: %v", err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("link %q does not parse: %v", link, err)
+	}
+	if u.Host != "stats.example.com" || path.Dir(u.Path) != "/charts" {
+		t.Errorf("link %q is not under PUBLIC_URL/charts", link)
+	}
+	q := u.Query()
+	if !VerifyLink(path.Base(u.Path), q.Get("expires"), q.Get("sig")) {
+		t.Errorf("link %q does not verify", link)
+	}
+}
+
+// Labels are member nicknames, channel and reaction names, and must not be
+// able to close the page's <script>.
+func TestRenderInteractiveEscapesLabels(t *testing.T) {
+	const label = "</script><script>alert(1)</script>"
+	for _, chartType := range []ChartType{BarChart, PieChart, SunburstChart} {
+		t.Run(string(chartType), func(t *testing.T) {
+			token := "escape-" + string(chartType)
+			chart := interactiveChart{
+				Tracker: ChartTracker{
+					ChartType: chartType,
+					Metric:    MetricType{Category: "message", Metric: "count"},
+					GroupBy:   MetricType{Category: "single", Metric: "user"},
+				},
+				Data: []*ChartData{{Xaxes: "1", XLabel: label, Yaxes: "1", YLabel: label, Value: 3}},
+			}
+			if err := database.SaveSession(token, interactiveSession, chart, time.Hour); err != nil {
+				t.Fatalf("SaveSession: %v", err)
+			}
+
+			var page bytes.Buffer
+			if err := RenderInteractive(token, &page); err != nil {
+				t.Fatalf("RenderInteractive: %v", err)
+			}
+			if bytes.Contains(page.Bytes(), []byte("<script>alert")) {
+				t.Errorf("label was written into the page unescaped:\n%s", page.String())
+			}
+			if !bytes.Contains(page.Bytes(), []byte(`\u003c/script\u003e\u003cscript\u003ealert(1)`)) {
+				t.Errorf("escaped label missing from the page:\n%s", page.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package charts
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+)
+
+const (
+	// lineMaxSeries is how many users or channels a trend line chart
+	// compares; the rest are summed into "Other" when the metric allows it.
+	lineMaxSeries = 8
+)
+
+// lineModes are the ways a line chart can show its values over time, by the
+// value of their option.
+var lineModes = []struct {
+	value, label, description string
+	smoothing                 int
+	cumulative                bool
+}{
+	{value: "daily", label: "Daily values", description: "Plot the value of every day"},
+	{value: "avg7", label: "7-day moving average", description: "Smooth every day over the week up to it", smoothing: 7},
+	{value: "avg30", label: "30-day moving average", description: "Smooth every day over the 30 days up to it", smoothing: 30},
+	{value: "cumulative", label: "Cumulative total", description: "Add every value to the ones before it", cumulative: true},
+}
+
+// SetLineMode applies the line mode of the chosen option.
+func (c *ChartTracker) SetLineMode(value string) {
+	c.Smoothing, c.Cumulative = 0, false
+	for _, mode := range lineModes {
+		if mode.value == value {
+			c.Smoothing, c.Cumulative = mode.smoothing, mode.cumulative
+		}
+	}
+}
+
+// dailyLine reports whether a line chart has a point per day, which moving
+// averages are taken over.
+func (c *ChartTracker) dailyLine() bool {
+	return c.GroupBy == MetricType{Category: "single", Metric: "date"} || c.GroupBy.Category == DateUser.Category
+}
+
+// getLineMode is the select of the line modes that apply to the chart:
+// moving averages for daily lines and totals for metrics that can be summed.
+func (c *ChartTracker) getLineMode() []discord.LayoutComponent {
+	if c.ChartType != LineChart || !(c.dailyLine() || c.GroupBy.Chronological()) {
+		return nil
+	}
+	var options []discord.StringSelectMenuOption
+	for _, mode := range lineModes {
+		if (mode.smoothing > 0 && !c.dailyLine()) || (mode.cumulative && !c.Metric.Additive()) {
+			continue
+		}
+		options = append(options, discord.StringSelectMenuOption{
+			Label:       mode.label,
+			Value:       mode.value,
+			Description: mode.description,
+			Default:     mode.smoothing == c.Smoothing && mode.cumulative == c.Cumulative,
+		})
+	}
+	if len(options) < 2 {
+		return nil
+	}
+	return []discord.LayoutComponent{
+		discord.ActionRowComponent{
+			Components: []discord.InteractiveComponent{
+				discord.StringSelectMenuComponent{
+					CustomID:    "line_mode",
+					Placeholder: "Show values as...",
+					Options:     options,
+				},
+			},
+		},
+	}
+}
+
+// lineTitle is the chart title with the line mode in use.
+func (c *ChartTracker) lineTitle(title string) string {
+	switch {
+	case c.Cumulative && c.Metric.Additive():
+		return title + " (Cumulative)"
+	case c.Smoothing > 0 && c.dailyLine():
+		return fmt.Sprintf("%s (%d-Day Average)", title, c.Smoothing)
+	}
+	return title
+}
+
+// lineSeries returns the x-axis and series of a line chart. Multi-axis
+// groupings get a series per user or channel over every day between the
+// first and the last one with data; days a series has no value are 0 for
+// metrics that can be summed and NaN, a gap, for the others. Moving averages
+// and totals are applied last.
+func (c *ChartTracker) lineSeries(chartData []*ChartData) ([]string, []Series) {
+	var labels []string
+	var series []Series
+	if !c.GroupBy.MultiAxes {
+		labels = toXaxes(chartData)
+		series = []Series{{Name: c.Metric.ToString(), Values: chartValues(chartData)}}
+		if c.Smoothing > 0 && c.dailyLine() {
+			labels, series = fillDays(labels, series, c.Metric.Additive())
+		}
+	} else {
+		labels, series = c.groupSeries(chartData)
+	}
+
+	for i := range series {
+		switch {
+		case c.Cumulative && c.Metric.Additive():
+			series[i].Values = cumulative(series[i].Values)
+		case c.Smoothing > 0 && c.dailyLine():
+			series[i].Values = movingAverage(series[i].Values, c.Smoothing)
+		}
+	}
+	return labels, series
+}
+
+// groupSeries splits multi-axis data into a series per YLabel, the busiest
+// first, over every day in between.
+func (c *ChartTracker) groupSeries(chartData []*ChartData) ([]string, []Series) {
+	totals := make(map[string]float64)
+	values := make(map[string]map[string]float64)
+	var days []string
+	for _, data := range chartData {
+		totals[data.YLabel] += math.Abs(data.Value)
+		if values[data.YLabel] == nil {
+			values[data.YLabel] = make(map[string]float64)
+		}
+		values[data.YLabel][data.XLabel] = data.Value
+		days = append(days, data.XLabel)
+	}
+	slices.Sort(days)
+	days = slices.Compact(days)
+
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		switch {
+		case totals[a] > totals[b]:
+			return -1
+		case totals[a] < totals[b]:
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	var series []Series
+	for i, name := range names {
+		if i == lineMaxSeries {
+			if !c.Metric.Additive() {
+				break
+			}
+			// Sum the rest into one series, like the other charts do.
+			other := Series{Name: "Other", Values: make([]float64, len(days))}
+			for _, rest := range names[i:] {
+				for j, day := range days {
+					other.Values[j] += values[rest][day]
+				}
+			}
+			series = append(series, other)
+			break
+		}
+		s := Series{Name: name, Values: make([]float64, len(days))}
+		for j, day := range days {
+			v, ok := values[name][day]
+			if !ok && !c.Metric.Additive() {
+				v = math.NaN()
+			}
+			s.Values[j] = v
+		}
+		series = append(series, s)
+	}
+	return fillDays(days, series, c.Metric.Additive())
+}
+
+// fillDays adds the days missing between the first and the last label, all
+// formatted 2006-01-02, with 0 or NaN values.
+func fillDays(labels []string, series []Series, additive bool) ([]string, []Series) {
+	if len(labels) == 0 {
+		return labels, series
+	}
+	first, err := time.Parse("2006-01-02", labels[0])
+	if err != nil {
+		return labels, series
+	}
+	last, err := time.Parse("2006-01-02", labels[len(labels)-1])
+	if err != nil {
+		return labels, series
+	}
+	missing := 0.0
+	if !additive {
+		missing = math.NaN()
+	}
+
+	index := make(map[string]int, len(labels))
+	for i, l := range labels {
+		index[l] = i
+	}
+	var days []string
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format("2006-01-02"))
+	}
+	filled := make([]Series, len(series))
+	for i, s := range series {
+		filled[i] = Series{Name: s.Name, Values: make([]float64, len(days))}
+		for j, day := range days {
+			filled[i].Values[j] = missing
+			if k, ok := index[day]; ok && k < len(s.Values) {
+				filled[i].Values[j] = s.Values[k]
+			}
+		}
+	}
+	return days, filled
+}
+
+// movingAverage averages every value with the ones before it in a window of
+// days, leaving out gaps.
+func movingAverage(values []float64, window int) []float64 {
+	out := make([]float64, len(values))
+	for i := range values {
+		sum, n := 0.0, 0
+		for _, v := range values[max(0, i-window+1) : i+1] {
+			if !math.IsNaN(v) {
+				sum += v
+				n++
+			}
+		}
+		out[i] = math.NaN()
+		if n > 0 {
+			out[i] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+// cumulative returns the running total of the values.
+func cumulative(values []float64) []float64 {
+	out := make([]float64, len(values))
+	total := 0.0
+	for i, v := range values {
+		if !math.IsNaN(v) {
+			total += v
+		}
+		out[i] = total
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package charts
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// sameValues compares float slices, NaN matching NaN.
+func sameValues(a, b []float64) bool {
+	return slices.EqualFunc(a, b, func(x, y float64) bool {
+		return x == y || (math.IsNaN(x) && math.IsNaN(y))
+	})
+}
+
+func TestFillDays(t *testing.T) {
+	labels := []string{"2026-02-27", "2026-03-01", "2026-03-02"}
+	series := []Series{
+		{Name: "a", Values: []float64{1, 2, 3}},
+		{Name: "b", Values: []float64{4, 5}},
+	}
+
+	days, filled := fillDays(labels, series, true)
+	if want := []string{"2026-02-27", "2026-02-28", "2026-03-01", "2026-03-02"}; !slices.Equal(days, want) {
+		t.Fatalf("days = %v, want %v", days, want)
+	}
+	if want := []float64{1, 0, 2, 3}; !sameValues(filled[0].Values, want) {
+		t.Errorf("a = %v, want %v", filled[0].Values, want)
+	}
+	// A series shorter than the labels is padded like a missing day.
+	if want := []float64{4, 0, 5, 0}; !sameValues(filled[1].Values, want) {
+		t.Errorf("b = %v, want %v", filled[1].Values, want)
+	}
+	if filled[1].Name != "b" {
+		t.Errorf("series name %q was not kept", filled[1].Name)
+	}
+
+	// Averages have no value on a quiet day rather than a zero.
+	_, filled = fillDays(labels, series[:1], false)
+	if want := []float64{1, math.NaN(), 2, 3}; !sameValues(filled[0].Values, want) {
+		t.Errorf("averaged a = %v, want %v", filled[0].Values, want)
+	}
+
+	// Labels that are not days are left alone.
+	months := []string{"2026-01", "2026-03"}
+	if days, _ := fillDays(months, series[:1], true); !slices.Equal(days, months) {
+		t.Errorf("month labels changed to %v", days)
+	}
+}
+
+func TestMovingAverage(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name   string
+		values []float64
+		window int
+		want   []float64
+	}{
+		{"window of one", []float64{1, 2, 3}, 1, []float64{1, 2, 3}},
+		{"window of three", []float64{3, 6, 9, 0}, 3, []float64{3, 4.5, 6, 5}},
+		{"gaps left out", []float64{2, nan, 4, nan}, 2, []float64{2, 2, 4, 4}},
+		{"only gaps", []float64{nan, nan, 1}, 2, []float64{nan, nan, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := movingAverage(tt.values, tt.window); !sameValues(got, tt.want) {
+				t.Errorf("movingAverage = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
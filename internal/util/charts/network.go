@@ -0,0 +1,393 @@
+package charts
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+)
+
+const (
+	// networkMaxNodes is how many of the most connected users a network
+	// shows; more turn it into a hairball.
+	networkMaxNodes = 30
+	// networkTopConnections is how many rows the top connections table has.
+	networkTopConnections = 10
+	// networkIterations is how many steps the force layout is simulated for.
+	networkIterations = 300
+	// communityIterations bounds the label propagation rounds.
+	communityIterations = 20
+)
+
+// network is an undirected, weighted graph of users who reply to and
+// mention each other, laid out in the unit square.
+type network struct {
+	names []string
+	// weights is the total weight of every user's connections.
+	weights []float64
+	links   []networkLink
+	// communities numbers the community of every user, largest first, when
+	// they were detected.
+	communities []int
+	x, y        []float64
+}
+
+// networkLink is a connection between two users; forward counts from source
+// to target and backward the other way round.
+type networkLink struct {
+	source, target    int
+	forward, backward float64
+}
+
+func (l networkLink) weight() float64 {
+	return l.forward + l.backward
+}
+
+// buildNetwork turns the directed user pairs of a reply grouping, Yaxes
+// replying to or mentioning Xaxes, into the network of the most connected
+// users.
+func (c *ChartTracker) buildNetwork(chartData []*ChartData) network {
+	labels := make(map[string]string)
+	type pair struct{ a, b string }
+	pairs := make(map[pair]*networkLink)
+	degree := make(map[string]float64)
+	for _, data := range chartData {
+		if data.Xaxes == data.Yaxes || data.Value <= 0 {
+			continue
+		}
+		labels[data.Yaxes], labels[data.Xaxes] = data.YLabel, data.XLabel
+		degree[data.Yaxes] += data.Value
+		degree[data.Xaxes] += data.Value
+
+		key := pair{data.Yaxes, data.Xaxes}
+		forward := true
+		if key.a > key.b {
+			key, forward = pair{key.b, key.a}, false
+		}
+		link, ok := pairs[key]
+		if !ok {
+			link = &networkLink{}
+			pairs[key] = link
+		}
+		if forward {
+			link.forward += data.Value
+		} else {
+			link.backward += data.Value
+		}
+	}
+
+	// The most connected users, ties by id so the layout does not change.
+	ids := make([]string, 0, len(degree))
+	for id := range degree {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if degree[ids[i]] != degree[ids[j]] {
+			return degree[ids[i]] > degree[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	ids = ids[:min(len(ids), networkMaxNodes)]
+	index := make(map[string]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+
+	var g network
+	connected := make([]bool, len(ids))
+	for key, link := range pairs {
+		a, okA := index[key.a]
+		b, okB := index[key.b]
+		if !okA || !okB {
+			continue
+		}
+		link.source, link.target = a, b
+		g.links = append(g.links, *link)
+		connected[a], connected[b] = true, true
+	}
+
+	// Renumber the users left with a connection among the shown ones.
+	renumber := make([]int, len(ids))
+	for i, id := range ids {
+		renumber[i] = -1
+		if connected[i] {
+			renumber[i] = len(g.names)
+			name := labels[id]
+			if name == "" {
+				name = id
+			}
+			g.names = append(g.names, name)
+			g.weights = append(g.weights, 0)
+		}
+	}
+	for i := range g.links {
+		l := &g.links[i]
+		l.source, l.target = renumber[l.source], renumber[l.target]
+		g.weights[l.source] += l.weight()
+		g.weights[l.target] += l.weight()
+	}
+	sort.Slice(g.links, func(i, j int) bool {
+		if g.links[i].weight() != g.links[j].weight() {
+			return g.links[i].weight() > g.links[j].weight()
+		}
+		return g.names[g.links[i].source]+g.names[g.links[i].target] < g.names[g.links[j].source]+g.names[g.links[j].target]
+	})
+
+	if c.GroupBy == ReplyCommunities {
+		g.communities = detectCommunities(len(g.names), g.links)
+	}
+	g.x, g.y = forceLayout(len(g.names), g.links)
+	return g
+}
+
+// forceLayout places the nodes with the Fruchterman-Reingold algorithm, so
+// connected users pull together and the rest push apart. It starts from a
+// circle rather than random spots, so the same data always gets the same
+// picture.
+func forceLayout(n int, links []networkLink) (x, y []float64) {
+	x, y = make([]float64, n), make([]float64, n)
+	for i := range n {
+		a := 2 * math.Pi * float64(i) / float64(max(n, 1))
+		x[i], y[i] = 0.5+0.4*math.Cos(a), 0.5+0.4*math.Sin(a)
+	}
+	if n < 2 {
+		return
+	}
+
+	heaviest := 0.0
+	for _, l := range links {
+		heaviest = max(heaviest, l.weight())
+	}
+	k := math.Sqrt(1 / float64(n))
+	temperature := 0.1
+	dx, dy := make([]float64, n), make([]float64, n)
+	for range networkIterations {
+		clear(dx)
+		clear(dy)
+		for i := range n {
+			for j := i + 1; j < n; j++ {
+				ddx, ddy := x[i]-x[j], y[i]-y[j]
+				d := max(math.Hypot(ddx, ddy), 1e-3)
+				f := k * k / d
+				dx[i] += ddx / d * f
+				dy[i] += ddy / d * f
+				dx[j] -= ddx / d * f
+				dy[j] -= ddy / d * f
+			}
+		}
+		for _, l := range links {
+			ddx, ddy := x[l.source]-x[l.target], y[l.source]-y[l.target]
+			d := max(math.Hypot(ddx, ddy), 1e-3)
+			// Heavier connections pull harder, up to twice as hard.
+			f := d * d / k * (1 + l.weight()/heaviest)
+			dx[l.source] -= ddx / d * f
+			dy[l.source] -= ddy / d * f
+			dx[l.target] += ddx / d * f
+			dy[l.target] += ddy / d * f
+		}
+		for i := range n {
+			// A weak pull to the centre keeps loose users from drifting off.
+			dx[i] -= (x[i] - 0.5) * k
+			dy[i] -= (y[i] - 0.5) * k
+			d := math.Hypot(dx[i], dy[i])
+			if d > 0 {
+				step := min(d, temperature)
+				x[i] += dx[i] / d * step
+				y[i] += dy[i] / d * step
+			}
+		}
+		temperature = max(temperature*0.98, 0.002)
+	}
+
+	// Stretch the layout over the unit square.
+	minX, maxX, minY, maxY := x[0], x[0], y[0], y[0]
+	for i := range n {
+		minX, maxX = min(minX, x[i]), max(maxX, x[i])
+		minY, maxY = min(minY, y[i]), max(maxY, y[i])
+	}
+	for i := range n {
+		x[i] = (x[i] - minX) / max(maxX-minX, 1e-9)
+		y[i] = (y[i] - minY) / max(maxY-minY, 1e-9)
+	}
+	return
+}
+
+// detectCommunities groups users with weighted label propagation: every user
+// repeatedly joins the community it is most strongly connected to, until
+// nothing changes. Communities are numbered by size, largest first.
+func detectCommunities(n int, links []networkLink) []int {
+	neighbours := make([]map[int]float64, n)
+	for i := range neighbours {
+		neighbours[i] = make(map[int]float64)
+	}
+	for _, l := range links {
+		neighbours[l.source][l.target] += l.weight()
+		neighbours[l.target][l.source] += l.weight()
+	}
+
+	label := make([]int, n)
+	for i := range label {
+		label[i] = i
+	}
+	for range communityIterations {
+		changed := false
+		for i := range n {
+			scores := make(map[int]float64)
+			for j, w := range neighbours[i] {
+				scores[label[j]] += w
+			}
+			best, bestScore := label[i], scores[label[i]]
+			for l, s := range scores {
+				// Ties go to the lowest label to stay deterministic.
+				if s > bestScore || (s == bestScore && l < best) {
+					best, bestScore = l, s
+				}
+			}
+			if best != label[i] {
+				label[i], changed = best, true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	size := make(map[int]int)
+	for _, l := range label {
+		size[l]++
+	}
+	order := make([]int, 0, len(size))
+	for l := range size {
+		order = append(order, l)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if size[order[i]] != size[order[j]] {
+			return size[order[i]] > size[order[j]]
+		}
+		return order[i] < order[j]
+	})
+	rank := make(map[int]int, len(order))
+	for i, l := range order {
+		rank[l] = i
+	}
+	communities := make([]int, n)
+	for i, l := range label {
+		communities[i] = rank[l]
+	}
+	return communities
+}
+
+// topConnections writes the rows of the top connections table: the pair
+// and how often each of them replied to or mentioned the other.
+func (g network) topConnections() (pairs, counts []string) {
+	for i, l := range g.links {
+		if i == networkTopConnections {
+			break
+		}
+		pairs = append(pairs, fmt.Sprintf("%s ↔ %s", truncateLabel(g.names[l.source], 16), truncateLabel(g.names[l.target], 16)))
+		counts = append(counts, fmt.Sprintf("%s (%s / %s)", formatValue(l.weight()), formatValue(l.forward), formatValue(l.backward)))
+	}
+	return
+}
+
+// nodeColor is a user's community colour, or the first series colour when
+// communities were not detected.
+func (g network) nodeColor(i int) int {
+	if g.communities == nil {
+		return 0
+	}
+	return g.communities[i] % len(palette)
+}
+
+func (c *ChartTracker) generateNetworkChart(chartData []*ChartData, title string) *charts.Graph {
+	g := c.buildNetwork(chartData)
+
+	heaviestNode, heaviestLink := 0.0, 0.0
+	for _, w := range g.weights {
+		heaviestNode = max(heaviestNode, w)
+	}
+	for _, l := range g.links {
+		heaviestLink = max(heaviestLink, l.weight())
+	}
+
+	var nodes []opts.GraphNode
+	for i, name := range g.names {
+		node := opts.GraphNode{
+			Name:       name,
+			X:          float32(g.x[i] * 1000),
+			Y:          float32(g.y[i] * 1000),
+			Value:      float32(g.weights[i]),
+			SymbolSize: 12 + 28*math.Sqrt(g.weights[i]/heaviestNode),
+		}
+		if g.communities != nil {
+			node.Category = g.communities[i]
+		}
+		nodes = append(nodes, node)
+	}
+	var links []opts.GraphLink
+	for _, l := range g.links {
+		links = append(links, opts.GraphLink{
+			Source:    g.names[l.source],
+			Target:    g.names[l.target],
+			Value:     float32(l.weight()),
+			LineStyle: &opts.LineStyle{Width: float32(1 + 5*l.weight()/heaviestLink), Opacity: opts.Float(0.6)},
+		})
+	}
+	var categories []*opts.GraphCategory
+	if g.communities != nil {
+		count := 0
+		for _, community := range g.communities {
+			count = max(count, community+1)
+		}
+		for i := range count {
+			categories = append(categories, &opts.GraphCategory{Name: fmt.Sprintf("Community %d", i+1)})
+		}
+	}
+
+	// echarts has no tables, so the top connections are listed under the
+	// title, left of the graph.
+	pairs, counts := g.topConnections()
+	table := []string{"Top connections"}
+	for i := range pairs {
+		table = append(table, fmt.Sprintf("%d. %s  %s", i+1, pairs[i], counts[i]))
+	}
+
+	graph := charts.NewGraph()
+	graph.SetGlobalOptions(
+		charts.WithInitializationOpts(opts.Initialization{
+			BackgroundColor: "#FFFFFF",
+			Width:           "100%",
+		}),
+		// Don't forget disable the Animation
+		charts.WithAnimation(false),
+		charts.WithTitleOpts(opts.Title{
+			Title:    title,
+			Subtitle: strings.Join(table, "\n"),
+			Left:     "20",
+			Top:      "20",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show:   opts.Bool(g.communities != nil),
+			Bottom: "0",
+		}),
+	)
+	graph.AddSeries(c.Metric.ToString(), nodes, links,
+		charts.WithGraphChartOpts(opts.GraphChart{
+			Layout:     "none",
+			Roam:       opts.Bool(true),
+			Categories: categories,
+		}),
+		charts.WithLabelOpts(opts.Label{
+			Show:     opts.Bool(true),
+			Position: "bottom",
+		}),
+		// Leave the left of the chart to the top connections.
+		func(s *charts.SingleSeries) {
+			s.Left, s.Right = "35%", "20"
+		},
+	)
+	return graph
+}
@@ -0,0 +1,51 @@
+package charts
+
+import "testing"
+
+func TestDetectCommunities(t *testing.T) {
+	// A group of four and a group of three, talking among themselves, with a
+	// single reply between them, and a user nobody talks to.
+	var links []networkLink
+	clique := func(members ...int) {
+		for i, a := range members {
+			for _, b := range members[i+1:] {
+				links = append(links, networkLink{source: a, target: b, forward: 3, backward: 2})
+			}
+		}
+	}
+	clique(0, 1, 2)
+	clique(3, 4, 5, 6)
+	links = append(links, networkLink{source: 2, target: 3, forward: 1})
+
+	communities := detectCommunities(8, links)
+	want := []int{1, 1, 1, 0, 0, 0, 0, 2}
+	for i, c := range communities {
+		if c != want[i] {
+			t.Fatalf("communities = %v, want %v", communities, want)
+		}
+	}
+
+	if got := detectCommunities(0, nil); len(got) != 0 {
+		t.Errorf("empty network has communities %v", got)
+	}
+}
+
+// Label propagation breaks ties on the lowest label, so the same network
+// always gets the same communities.
+func TestDetectCommunitiesDeterministic(t *testing.T) {
+	links := []networkLink{
+		{source: 0, target: 1, forward: 1},
+		{source: 1, target: 2, forward: 1},
+		{source: 2, target: 3, forward: 1},
+		{source: 3, target: 0, forward: 1},
+	}
+	first := detectCommunities(4, links)
+	for range 20 {
+		got := detectCommunities(4, links)
+		for i := range got {
+			if got[i] != first[i] {
+				t.Fatalf("communities changed between runs: %v then %v", first, got)
+			}
+		}
+	}
+}
@@ -2,10 +2,18 @@ package charts
 
 import (
 	"fmt"
+	"log/slog"
+	"regexp"
 	"strings"
 	"time"
+
+	"github.com/stollenaar/statisticsbot/internal/util"
 )
 
+// timezoneName matches IANA time zone names like Europe/Amsterdam, which are
+// inlined into queries.
+var timezoneName = regexp.MustCompile(`^[A-Za-z0-9_+\-]+(/[A-Za-z0-9_+\-]+)*$`)
+
 const (
 	MessageQuery = `
 	WITH latest_messages AS (
@@ -30,6 +38,36 @@ const (
 	AND date BETWEEN ? AND ?
 `
 
+	// SentimentMessages is the messages table with every message's sentiment
+	// joined on; unclassified messages are left out.
+	SentimentMessages = `(
+		SELECT m.*, s.label, s.score
+		FROM messages m
+		JOIN message_sentiment s ON s.id = m.id
+	)`
+
+	// ReplyEdges has a row per user a message replied to or mentioned, with
+	// that user as target_id. Mentions are parsed from the content of the
+	// latest version of a message, and a reply that also mentions the same
+	// user counts once.
+	ReplyEdges = `(
+		WITH latest AS (
+			SELECT *
+			FROM messages
+			QUALIFY ROW_NUMBER() OVER (PARTITION BY id ORDER BY version DESC) = 1
+		)
+		SELECT DISTINCT e.id || ':' || e.target_id AS id, 1 AS version, e.guild_id, e.channel_id, e.author_id, e.target_id, e.date
+		FROM (
+			SELECT m.id, m.guild_id, m.channel_id, m.author_id, m.date, p.author_id AS target_id
+			FROM latest m
+			JOIN latest p ON p.id = m.reply_message_id
+			UNION ALL
+			SELECT id, guild_id, channel_id, author_id, date, unnest(regexp_extract_all(content, '<@!?(\d+)>', 1)) AS target_id
+			FROM latest
+		) e
+		WHERE e.target_id <> e.author_id
+	)`
+
 	QueryCont = `
 	%s
 	GROUP BY %s
@@ -46,6 +84,10 @@ func (c *ChartTracker) buildQuery(start, end time.Time) (query string, err error
 		aggExpr = "COUNT(*)"
 	case "avg_length":
 		aggExpr = "AVG(LENGTH(content))"
+	case "avg":
+		aggExpr = "ROUND(AVG(score), 3)"
+	case "positive_share":
+		aggExpr = "ROUND(AVG(CASE WHEN label = 'positive' THEN 1.0 ELSE 0.0 END), 3)"
 	case "freq":
 		aggExpr = fmt.Sprintf(
 			"COUNT(*) * 1.0 / DATEDIFF('day', DATE '%s', DATE '%s')",
@@ -66,7 +108,7 @@ func (c *ChartTracker) buildQuery(start, end time.Time) (query string, err error
 	case MetricType{Category: "single", Metric: "date"}:
 		selectExpr, groupField = "strftime('%Y-%m-%d', date) AS xaxes", "strftime('%Y-%m-%d', date)"
 	case MetricType{Category: "single", Metric: "month"}:
-		selectExpr, groupField = "strftime('%%Y-%%m', date) AS xaxes", "strftime('%%Y-%%m', date)"
+		selectExpr, groupField = "strftime('%Y-%m', date) AS xaxes", "strftime('%Y-%m', date)"
 	case MetricType{Category: "single", Metric: "channel"}:
 		selectExpr, groupField = "channel_id AS xaxes", "channel_id"
 	case MetricType{Category: "channel", Metric: "user", MultiAxes: true}:
@@ -77,24 +119,44 @@ func (c *ChartTracker) buildQuery(start, end time.Time) (query string, err error
 		selectExpr, groupField = "reaction AS yaxes, channel_id AS xaxes", "reaction, channel_id"
 	case MetricType{Category: "interaction", Metric: "user", MultiAxes: true}:
 		selectExpr, groupField = "author_id AS yaxes, interaction_author_id AS xaxes", "author_id, interaction_author_id"
+	case ReplyNetwork, ReplyCommunities:
+		selectExpr, groupField = "author_id AS yaxes, target_id AS xaxes", "author_id, target_id"
+	case DateUser:
+		selectExpr, groupField = "author_id AS yaxes, strftime('%Y-%m-%d', date) AS xaxes", "author_id, strftime('%Y-%m-%d', date)"
+	case DateChannel:
+		selectExpr, groupField = "channel_id AS yaxes, strftime('%Y-%m-%d', date) AS xaxes", "channel_id, strftime('%Y-%m-%d', date)"
+	case HourWeekday:
+		// Dates are stored in UTC; shift them to the chart time zone first.
+		local := fmt.Sprintf("timezone('%s', timezone('UTC', date))", chartTimezone())
+		selectExpr = fmt.Sprintf("isodow(%[1]s)::VARCHAR AS yaxes, hour(%[1]s)::VARCHAR AS xaxes", local)
+		groupField = fmt.Sprintf("isodow(%[1]s), hour(%[1]s)", local)
 	default:
 		selectExpr, groupField = "author_id", "author_id" // fallback
 	}
 
 	orderByField := "value DESC"
-	if c.GroupBy.Metric == "date" {
+	if c.GroupBy.Chronological() {
 		orderByField = fmt.Sprintf("%s ASC", groupField)
 	}
+	if c.GroupBy.Category == DateUser.Category {
+		orderByField = "xaxes ASC, yaxes ASC"
+	}
 
 	switch c.Metric.Category {
 	case "reaction":
 		query = fmt.Sprintf(ReactionQuery, selectExpr, aggExpr)
 	case "interaction":
 		query = fmt.Sprintf(MessageQuery, "bot_messages", selectExpr, aggExpr)
+	case "sentiment":
+		query = fmt.Sprintf(MessageQuery, SentimentMessages, selectExpr, aggExpr)
 	case "message":
 		fallthrough
 	default:
-		query = fmt.Sprintf(MessageQuery, "messages", selectExpr, aggExpr)
+		table := "messages"
+		if c.GroupBy.Category == ReplyNetwork.Category {
+			table = ReplyEdges
+		}
+		query = fmt.Sprintf(MessageQuery, table, selectExpr, aggExpr)
 	}
 
 	var filters []string
@@ -116,3 +178,17 @@ func (c *ChartTracker) buildQuery(start, end time.Time) (query string, err error
 	query += fmt.Sprintf(QueryCont, whereClause, groupField, orderByField)
 	return
 }
+
+// chartTimezone returns the time zone CHART_TIMEZONE sets for time-of-day
+// groupings, UTC by default.
+func chartTimezone() string {
+	tz := util.ConfigFile.CHART_TIMEZONE
+	if tz == "" {
+		return "UTC"
+	}
+	if !timezoneName.MatchString(tz) {
+		slog.Warn("CHART_TIMEZONE is not a time zone name, using UTC", slog.String("timezone", tz))
+		return "UTC"
+	}
+	return tz
+}
@@ -0,0 +1,912 @@
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// The native renderer draws charts straight to a PNG in memory, without the
+// HTML page and headless Chrome the go-echarts renderer needs. It is picked
+// with CHART_RENDERER=native and loosely follows the look of the echarts
+// charts, so switching renderers does not change what users see much.
+
+const (
+	rasterWidth  = 1200
+	rasterHeight = 675
+	// rasterMargin is the gap between the image border and the plot area.
+	rasterMargin = 60
+	// rasterTitleHeight is the band at the top reserved for the title.
+	rasterTitleHeight = 50
+	// rasterTicks is roughly how many steps the value axis is divided in.
+	rasterTicks = 5
+	// rasterMaxPointLabels is the most points a line gets its values printed
+	// for; beyond it they only overlap.
+	rasterMaxPointLabels = 40
+)
+
+var (
+	// palette is the echarts default series palette.
+	palette = []color.RGBA{
+		{0x54, 0x70, 0xc6, 0xff},
+		{0x91, 0xcc, 0x75, 0xff},
+		{0xfa, 0xc8, 0x58, 0xff},
+		{0xee, 0x66, 0x66, 0xff},
+		{0x73, 0xc0, 0xde, 0xff},
+		{0x3b, 0xa2, 0x72, 0xff},
+		{0xfc, 0x84, 0x52, 0xff},
+		{0x9a, 0x60, 0xb4, 0xff},
+		{0xea, 0x7c, 0xcc, 0xff},
+	}
+	// heatmapRange is the colour scale of the heatmap, low to high.
+	heatmapRange = []color.RGBA{
+		{0x50, 0xa3, 0xba, 0xff},
+		{0xea, 0xc7, 0x36, 0xff},
+		{0xd9, 0x4e, 0x5d, 0xff},
+	}
+
+	textColor  = color.RGBA{0x46, 0x4a, 0x53, 0xff}
+	axisColor  = color.RGBA{0x6e, 0x70, 0x79, 0xff}
+	gridColor  = color.RGBA{0xe0, 0xe6, 0xf1, 0xff}
+	splitColor = color.RGBA{0xf6, 0xf7, 0xf9, 0xff}
+)
+
+// lineOptions tweak how rasterLine draws its series.
+type lineOptions struct {
+	// stacked fills each series as an area on top of the ones before it.
+	stacked bool
+	// max fixes the top of the value axis; 0 fits it to the data.
+	max float64
+	// unit is appended to the value-axis labels, e.g. "%".
+	unit string
+	// pointLabels prints the value of every point.
+	pointLabels bool
+}
+
+// canvas is an image being drawn on, with the faces to write on it.
+type canvas struct {
+	img   *image.RGBA
+	title font.Face
+	text  font.Face
+	small font.Face
+}
+
+// newCanvas returns a white canvas of the given size. Faces keep per-face
+// caches and are not safe to share, so every canvas gets its own.
+func newCanvas(width, height int) (*canvas, error) {
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	face := func(size float64) (font.Face, error) {
+		return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	}
+
+	cv := &canvas{img: image.NewRGBA(image.Rect(0, 0, width, height))}
+	if cv.title, err = face(18); err != nil {
+		return nil, err
+	}
+	if cv.text, err = face(12); err != nil {
+		return nil, err
+	}
+	if cv.small, err = face(10); err != nil {
+		return nil, err
+	}
+	draw.Draw(cv.img, cv.img.Bounds(), image.White, image.Point{}, draw.Src)
+	return cv, nil
+}
+
+// png encodes the canvas.
+func (cv *canvas) png() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, cv.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawTitle writes the chart title centred at the top.
+func (cv *canvas) drawTitle(title string) {
+	cv.drawText(cv.title, title, float64(cv.img.Bounds().Dx())/2, 32, 0.5, textColor)
+}
+
+// fillRect fills a rectangle with a colour.
+func (cv *canvas) fillRect(r image.Rectangle, c color.Color) {
+	draw.Draw(cv.img, r, image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+// fillPolygon fills the polygon through the given points, antialiased.
+func (cv *canvas) fillPolygon(points [][2]float64, c color.Color) {
+	if len(points) < 3 {
+		return
+	}
+	b := cv.img.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+	z.MoveTo(float32(points[0][0]), float32(points[0][1]))
+	for _, p := range points[1:] {
+		z.LineTo(float32(p[0]), float32(p[1]))
+	}
+	z.ClosePath()
+	z.Draw(cv.img, b, image.NewUniform(c), image.Point{})
+}
+
+// fillCircle fills a circle around (x, y).
+func (cv *canvas) fillCircle(x, y, r float64, c color.Color) {
+	cv.fillPolygon(arcPoints(x, y, r, 0, 2*math.Pi), c)
+}
+
+// strokeLine draws a polyline of the given width with round joins.
+func (cv *canvas) strokeLine(points [][2]float64, width float64, c color.Color) {
+	for i := 1; i < len(points); i++ {
+		p, q := points[i-1], points[i]
+		dx, dy := q[0]-p[0], q[1]-p[1]
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*width/2, dx/length*width/2
+		cv.fillPolygon([][2]float64{
+			{p[0] + nx, p[1] + ny},
+			{q[0] + nx, q[1] + ny},
+			{q[0] - nx, q[1] - ny},
+			{p[0] - nx, p[1] - ny},
+		}, c)
+	}
+	if width > 1 {
+		for _, p := range points {
+			cv.fillCircle(p[0], p[1], width/2, c)
+		}
+	}
+}
+
+// drawText writes s with its baseline at y. align places x along the text:
+// 0 is its start, 0.5 its middle and 1 its end.
+func (cv *canvas) drawText(face font.Face, s string, x, y, align float64, c color.Color) {
+	d := font.Drawer{Dst: cv.img, Src: image.NewUniform(c), Face: face}
+	width := float64(d.MeasureString(s)) / 64
+	d.Dot = fixed.P(int(math.Round(x-width*align)), int(math.Round(y)))
+	d.DrawString(s)
+}
+
+// drawRotatedText writes s turned angle radians counter-clockwise, ending at
+// (x, y) halfway up the text, the way echarts writes rotated axis labels.
+func (cv *canvas) drawRotatedText(face font.Face, s string, x, y, angle float64, c color.Color) {
+	metrics := face.Metrics()
+	ascent, height := metrics.Ascent.Ceil(), metrics.Height.Ceil()
+	width := font.MeasureString(face, s).Ceil()
+	if width == 0 {
+		return
+	}
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	d := font.Drawer{Dst: src, Src: image.NewUniform(c), Face: face, Dot: fixed.P(0, ascent)}
+	d.DrawString(s)
+
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	mid := float64(height) / 2
+	s2d := f64.Aff3{
+		cos, sin, x - (cos*float64(width) + sin*mid),
+		-sin, cos, y - (-sin*float64(width) + cos*mid),
+	}
+	draw.BiLinear.Transform(cv.img, s2d, src, src.Bounds(), draw.Over, nil)
+}
+
+// measure returns how wide s is in pixels.
+func measure(face font.Face, s string) float64 {
+	return float64(font.MeasureString(face, s)) / 64
+}
+
+// arcPoints returns the points along an arc around (x, y), angles measured
+// clockwise from twelve o'clock.
+func arcPoints(x, y, r, from, to float64) [][2]float64 {
+	steps := max(int(math.Ceil((to-from)/(math.Pi/90))), 1)
+	points := make([][2]float64, 0, steps+1)
+	for i := 0; i <= steps; i++ {
+		a := from + (to-from)*float64(i)/float64(steps)
+		points = append(points, [2]float64{x + r*math.Sin(a), y - r*math.Cos(a)})
+	}
+	return points
+}
+
+// plotArea returns the rectangle charts with axes draw their data in, leaving
+// room for the title and the value-axis labels, and bottom pixels below it
+// for the category labels and legend.
+func plotArea(cv *canvas, bottom int) image.Rectangle {
+	b := cv.img.Bounds()
+	return image.Rect(b.Min.X+rasterMargin+20, b.Min.Y+rasterTitleHeight+20, b.Max.X-rasterMargin, b.Max.Y-bottom)
+}
+
+// niceScale rounds max up to a round number split into about rasterTicks
+// steps of 1, 2 or 5 times a power of ten.
+func niceScale(max float64) (float64, float64) {
+	if max <= 0 {
+		return 1, 0.2
+	}
+	raw := max / rasterTicks
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := 10 * magnitude
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*magnitude {
+			step = m * magnitude
+			break
+		}
+	}
+	return math.Ceil(max/step) * step, step
+}
+
+// niceRange is niceScale for data that can go below zero: the axis runs from
+// the data minimum rounded down to a step, or from 0 when nothing is
+// negative, to the maximum rounded up.
+func niceRange(lo, hi float64) (bottom, top, step float64) {
+	if lo >= 0 {
+		top, step = niceScale(hi)
+		return 0, top, step
+	}
+	_, step = niceScale(max(hi, 0) - lo)
+	bottom = math.Floor(lo/step) * step
+	top = math.Ceil(max(hi, 0)/step) * step
+	return bottom, top, step
+}
+
+// formatValue prints a value without trailing zeros.
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strings.TrimRight(strings.TrimRight(strconv.FormatFloat(v, 'f', 2, 64), "0"), ".")
+}
+
+// drawValueAxis draws the horizontal grid lines and labels of a value axis
+// from bottom to top and returns the y of a value.
+func (cv *canvas) drawValueAxis(area image.Rectangle, bottom, top, step float64, unit string) func(float64) float64 {
+	y := func(v float64) float64 {
+		return float64(area.Max.Y) - (v-bottom)/(top-bottom)*float64(area.Dy())
+	}
+	for i := 0; bottom+float64(i)*step <= top+step/2; i++ {
+		v := bottom + float64(i)*step
+		ly := y(v)
+		cv.fillRect(image.Rect(area.Min.X, int(math.Round(ly)), area.Max.X, int(math.Round(ly))+1), gridColor)
+		cv.drawText(cv.text, formatValue(v)+unit, float64(area.Min.X-8), ly+4, 1, axisColor)
+	}
+	return y
+}
+
+// drawCategoryAxis draws the axis line and labels of a category axis and
+// returns the x of every label: the centre of a band per label, or with
+// edges, spread from one edge of the area to the other. Labels that would
+// overlap are written at 45 degrees, and only every so many when even that
+// is too dense.
+func (cv *canvas) drawCategoryAxis(area image.Rectangle, labels []string, edges bool) []float64 {
+	band := float64(area.Dx()) / float64(max(len(labels), 1))
+	centres := make([]float64, len(labels))
+	widest := 0.0
+	for i, l := range labels {
+		centres[i] = float64(area.Min.X) + band*(float64(i)+0.5)
+		if edges && len(labels) > 1 {
+			centres[i] = float64(area.Min.X) + float64(area.Dx())*float64(i)/float64(len(labels)-1)
+		}
+		widest = max(widest, measure(cv.small, l))
+	}
+	cv.fillRect(image.Rect(area.Min.X, area.Max.Y, area.Max.X, area.Max.Y+1), axisColor)
+
+	rotate := widest+6 > band
+	every := 1
+	if rotate {
+		// A label at 45 degrees needs about its line height of room.
+		height := float64(cv.small.Metrics().Height.Ceil())
+		every = int(math.Ceil(height * 1.5 / band))
+	}
+	for i, l := range labels {
+		if i%every != 0 {
+			continue
+		}
+		l = truncateLabel(l, 24)
+		if rotate {
+			cv.drawRotatedText(cv.small, l, centres[i], float64(area.Max.Y+8), math.Pi/4, axisColor)
+		} else {
+			cv.drawText(cv.small, l, centres[i], float64(area.Max.Y+16), 0.5, axisColor)
+		}
+	}
+	return centres
+}
+
+// axisLabelRoom is how much room below the plot area the labels of a
+// category axis need.
+func axisLabelRoom(cv *canvas, labels []string) int {
+	area := plotArea(cv, rasterMargin)
+	band := float64(area.Dx()) / float64(max(len(labels), 1))
+	widest := 0.0
+	for _, l := range labels {
+		widest = max(widest, measure(cv.small, truncateLabel(l, 24)))
+	}
+	if widest+6 <= band {
+		return 30
+	}
+	return int(widest*math.Sqrt2/2) + 24
+}
+
+// drawLegend writes a swatch and name per series, centred in rows along the
+// bottom.
+func (cv *canvas) drawLegend(names []string) {
+	b := cv.img.Bounds()
+	y := float64(b.Max.Y - 16)
+	var widths []float64
+	total := 0.0
+	for _, n := range names {
+		w := 18 + measure(cv.text, n) + 16
+		widths = append(widths, w)
+		total += w
+	}
+	x := (float64(b.Dx()) - total) / 2
+	for i, n := range names {
+		c := palette[i%len(palette)]
+		cv.fillRect(image.Rect(int(x), int(y)-9, int(x)+14, int(y)+1), c)
+		cv.drawText(cv.text, n, x+18, y, 0, textColor)
+		x += widths[i]
+	}
+}
+
+// truncateLabel shortens a label to n runes.
+func truncateLabel(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// rasterBar draws a bar per label.
+func rasterBar(title string, labels []string, values []float64) ([]byte, error) {
+	cv, err := newCanvas(rasterWidth, rasterHeight)
+	if err != nil {
+		return nil, err
+	}
+	cv.drawTitle(title)
+
+	lo, hi := 0.0, 0.0
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	bottom, top, step := niceRange(lo, hi)
+	area := plotArea(cv, axisLabelRoom(cv, labels))
+
+	// Alternate the bands like echarts' split area.
+	band := float64(area.Dx()) / float64(max(len(labels), 1))
+	for i := range labels {
+		if i%2 == 1 {
+			x0 := float64(area.Min.X) + band*float64(i)
+			cv.fillRect(image.Rect(int(x0), area.Min.Y, int(x0+band), area.Max.Y), splitColor)
+		}
+	}
+	y := cv.drawValueAxis(area, bottom, top, step, "")
+	centres := cv.drawCategoryAxis(area, labels, false)
+
+	// Bars grow from zero, downwards for negative values.
+	width := max(band*0.6, 1)
+	for i, v := range values {
+		cv.fillRect(image.Rect(int(math.Round(centres[i]-width/2)), int(math.Round(y(v))), int(math.Round(centres[i]+width/2)), int(math.Round(y(0)))), palette[0])
+	}
+	return cv.png()
+}
+
+// rasterLine draws every series as a line over the labels, or as stacked
+// areas. NaN values are gaps in a line.
+func rasterLine(title string, labels []string, series []Series, o lineOptions) ([]byte, error) {
+	cv, err := newCanvas(rasterWidth, rasterHeight)
+	if err != nil {
+		return nil, err
+	}
+	cv.drawTitle(title)
+
+	// With stacking, each series is drawn on top of the running total.
+	base := make([][]float64, len(series))
+	running := make([]float64, len(labels))
+	lo, top := 0.0, 0.0
+	for i, s := range series {
+		base[i] = make([]float64, len(labels))
+		for j := range labels {
+			v := 0.0
+			if j < len(s.Values) {
+				v = s.Values[j]
+			}
+			if o.stacked {
+				base[i][j] = running[j]
+				running[j] += v
+				top = max(top, running[j])
+			} else if !math.IsNaN(v) {
+				lo, top = min(lo, v), max(top, v)
+			}
+		}
+	}
+	floor, step := 0.0, 0.0
+	if o.max > 0 {
+		top, step = o.max, o.max/rasterTicks
+	} else {
+		floor, top, step = niceRange(lo, top)
+	}
+
+	bottom := axisLabelRoom(cv, labels)
+	if len(series) > 1 {
+		bottom += 30
+	}
+	area := plotArea(cv, bottom)
+	y := cv.drawValueAxis(area, floor, top, step, o.unit)
+	// Areas run edge to edge, like echarts without a boundary gap.
+	centres := cv.drawCategoryAxis(area, labels, o.stacked)
+
+	var names []string
+	for i, s := range series {
+		names = append(names, s.Name)
+		c := palette[i%len(palette)]
+
+		var line [][2]float64
+		for j := range labels {
+			v := 0.0
+			if j < len(s.Values) {
+				v = s.Values[j]
+			}
+			line = append(line, [2]float64{centres[j], y(base[i][j] + v)})
+		}
+
+		if o.stacked {
+			fill := append([][2]float64{}, line...)
+			for j := len(labels) - 1; j >= 0; j-- {
+				fill = append(fill, [2]float64{centres[j], y(base[i][j])})
+			}
+			cv.fillPolygon(fill, color.NRGBA{c.R, c.G, c.B, 0xcc})
+			cv.strokeLine(line, 1.5, c)
+			continue
+		}
+
+		// Split the line at its gaps.
+		var segment [][2]float64
+		for _, p := range append(line, [2]float64{math.NaN(), math.NaN()}) {
+			if !math.IsNaN(p[1]) {
+				segment = append(segment, p)
+				continue
+			}
+			cv.strokeLine(segment, 2, c)
+			segment = nil
+		}
+		// Points only fit when there are few of them.
+		if len(series) == 1 || len(labels) <= rasterMaxPointLabels {
+			for _, p := range line {
+				if !math.IsNaN(p[1]) {
+					cv.fillCircle(p[0], p[1], 4, c)
+					cv.fillCircle(p[0], p[1], 2.5, color.White)
+				}
+			}
+		}
+		if o.pointLabels && len(labels) <= rasterMaxPointLabels {
+			for j, p := range line {
+				if j < len(s.Values) && !math.IsNaN(p[1]) {
+					cv.drawText(cv.small, formatValue(s.Values[j]), p[0], p[1]-8, 0.5, textColor)
+				}
+			}
+		}
+	}
+	if len(series) > 1 {
+		cv.drawLegend(names)
+	}
+	return cv.png()
+}
+
+// rasterPie draws a slice per label, named from the outside.
+func rasterPie(title string, labels []string, values []float64) ([]byte, error) {
+	cv, err := newCanvas(rasterWidth, rasterHeight)
+	if err != nil {
+		return nil, err
+	}
+	cv.drawTitle(title)
+
+	total := 0.0
+	for _, v := range values {
+		total += max(v, 0)
+	}
+	if total == 0 {
+		return cv.png()
+	}
+
+	b := cv.img.Bounds()
+	cx, cy := float64(b.Dx())/2, float64(rasterTitleHeight+b.Dy())/2
+	r := float64(b.Dy()-rasterTitleHeight)/2 - 60
+	angle := 0.0
+	for i, v := range values {
+		if v <= 0 {
+			continue
+		}
+		sweep := v / total * 2 * math.Pi
+		points := append([][2]float64{{cx, cy}}, arcPoints(cx, cy, r, angle, angle+sweep)...)
+		cv.fillPolygon(points, palette[i%len(palette)])
+		// Slivers get no label, like echarts hides overlapping ones.
+		if v/total >= 0.01 {
+			cv.drawSliceLabel(labels[i], cx, cy, r, angle+sweep/2, palette[i%len(palette)])
+		}
+		angle += sweep
+	}
+	return cv.png()
+}
+
+// drawSliceLabel writes a slice's name just outside the pie with a leader
+// line to the slice.
+func (cv *canvas) drawSliceLabel(label string, cx, cy, r, angle float64, c color.Color) {
+	sin, cos := math.Sin(angle), math.Cos(angle)
+	from := [2]float64{cx + r*sin, cy - r*cos}
+	elbow := [2]float64{cx + (r+15)*sin, cy - (r+15)*cos}
+	end := [2]float64{elbow[0] + 15, elbow[1]}
+	align := 0.0
+	if sin < 0 {
+		end[0] = elbow[0] - 15
+		align = 1
+	}
+	cv.strokeLine([][2]float64{from, elbow, end}, 1, c)
+	offset := 4.0
+	if align == 1 {
+		offset = -4
+	}
+	cv.drawText(cv.text, truncateLabel(label, 30), end[0]+offset, end[1]+4, align, textColor)
+}
+
+// rasterSunburst draws the groups as an inner ring and their parts as an
+// outer ring around it.
+func rasterSunburst(title string, data []sunburstSlice) ([]byte, error) {
+	cv, err := newCanvas(rasterWidth, rasterHeight)
+	if err != nil {
+		return nil, err
+	}
+	cv.drawTitle(title)
+
+	total := 0.0
+	for _, d := range data {
+		total += max(d.value, 0)
+	}
+	if total == 0 {
+		return cv.png()
+	}
+
+	b := cv.img.Bounds()
+	cx, cy := float64(b.Dx())/2, float64(rasterTitleHeight+b.Dy())/2
+	r := float64(b.Dy()-rasterTitleHeight)/2 - 30
+	inner := r / 2
+	angle := 0.0
+	for i, d := range data {
+		if d.value <= 0 {
+			continue
+		}
+		c := palette[i%len(palette)]
+		sweep := d.value / total * 2 * math.Pi
+		cv.fillPolygon(append([][2]float64{{cx, cy}}, arcPoints(cx, cy, inner, angle, angle+sweep)...), c)
+
+		childAngle := angle
+		for j, child := range d.children {
+			if child.value <= 0 {
+				continue
+			}
+			childSweep := child.value / total * 2 * math.Pi
+			// Parts take lighter shades of their group's colour.
+			shade := lighten(c, 0.25+0.5*float64(j%3)/3)
+			ring := append(arcPoints(cx, cy, r, childAngle, childAngle+childSweep), reversed(arcPoints(cx, cy, inner+2, childAngle, childAngle+childSweep))...)
+			cv.fillPolygon(ring, shade)
+			if childSweep*r > measure(cv.small, child.name)+4 && childSweep > 0.15 {
+				mid := childAngle + childSweep/2
+				cv.drawText(cv.small, truncateLabel(child.name, 16), cx+(inner+r)/2*math.Sin(mid), cy-(inner+r)/2*math.Cos(mid)+4, 0.5, textColor)
+			}
+			childAngle += childSweep
+		}
+		if sweep > 0.25 {
+			mid := angle + sweep/2
+			cv.drawText(cv.text, truncateLabel(d.name, 16), cx+inner/2*math.Sin(mid), cy-inner/2*math.Cos(mid)+4, 0.5, color.White)
+		}
+		angle += sweep
+	}
+	return cv.png()
+}
+
+// sunburstSlice is a group of a sunburst with the parts it is made of.
+type sunburstSlice struct {
+	name     string
+	value    float64
+	children []sunburstSlice
+}
+
+// rasterHeatmap draws a cell per x and y label pair, coloured by its value
+// from 0 to 100.
+func rasterHeatmap(title string, xAxes, yAxes []string, cells []heatmapCell) ([]byte, error) {
+	cv, err := newCanvas(rasterWidth, rasterHeight)
+	if err != nil {
+		return nil, err
+	}
+	cv.drawTitle(title)
+
+	left := 0.0
+	for _, l := range yAxes {
+		left = max(left, measure(cv.text, truncateLabel(l, 24)))
+	}
+	area := plotArea(cv, axisLabelRoom(cv, xAxes))
+	area.Min.X = max(area.Min.X, int(left)+30)
+	centres := cv.drawCategoryAxis(area, xAxes, false)
+
+	cellWidth := float64(area.Dx()) / float64(max(len(xAxes), 1))
+	cellHeight := float64(area.Dy()) / float64(max(len(yAxes), 1))
+	for j, l := range yAxes {
+		// Like echarts, the first category is at the bottom.
+		y0 := float64(area.Max.Y) - cellHeight*float64(j+1)
+		if j%2 == 1 {
+			cv.fillRect(image.Rect(area.Min.X, int(y0), area.Max.X, int(y0+cellHeight)), splitColor)
+		}
+		cv.drawText(cv.text, truncateLabel(l, 24), float64(area.Min.X-8), y0+cellHeight/2+4, 1, axisColor)
+	}
+	for _, c := range cells {
+		if c.x < 0 || c.x >= len(xAxes) || c.y < 0 || c.y >= len(yAxes) {
+			continue
+		}
+		x0 := centres[c.x] - cellWidth/2
+		y0 := float64(area.Max.Y) - cellHeight*float64(c.y+1)
+		cv.fillRect(image.Rect(int(math.Round(x0)), int(math.Round(y0)), int(math.Round(x0+cellWidth)), int(math.Round(y0+cellHeight))), heatmapColor(c.value/100))
+	}
+	return cv.png()
+}
+
+// rasterCalendar draws a day per cell in a column per week, shaded by level
+// like a GitHub contribution graph, with the summary under the title and the
+// highlighted streak outlined.
+func rasterCalendar(title, summary string, days []calendarDay, levels []int, highlight streak) ([]byte, error) {
+	cv, err := newCanvas(rasterWidth, 300)
+	if err != nil {
+		return nil, err
+	}
+	cv.drawTitle(title)
+	cv.drawText(cv.text, summary, float64(rasterWidth)/2, 60, 0.5, textColor)
+	if len(days) == 0 {
+		return cv.png()
+	}
+
+	const step, size, top = 20, 16, 110
+	left := (rasterWidth-calendarWeeks*step)/2 + 20
+	// Weeks start on Monday, the first row.
+	offset := (int(days[0].date.Weekday()) + 6) % 7
+	cell := func(i int) image.Rectangle {
+		x := left + (offset+i)/7*step
+		y := top + (offset+i)%7*step
+		return image.Rect(x, y, x+size, y+size)
+	}
+
+	for row, l := range []string{"Mon", "", "Wed", "", "Fri", "", ""} {
+		cv.drawText(cv.small, l, float64(left-8), float64(top+row*step+size-4), 1, axisColor)
+	}
+	labelEnd := 0.0
+	for i, d := range days {
+		if i != 0 && d.date.Day() != 1 {
+			continue
+		}
+		label := d.date.Format("Jan")
+		if i == 0 || d.date.Month() == time.January {
+			label = d.date.Format("Jan 2006")
+		}
+		x := float64(cell(i).Min.X)
+		if x < labelEnd+8 {
+			continue
+		}
+		cv.drawText(cv.small, label, x, top-8, 0, axisColor)
+		labelEnd = x + measure(cv.small, label)
+	}
+
+	outline := palette[6]
+	for i, d := range days {
+		if highlight.days > 0 && !d.date.Before(highlight.start) && !d.date.After(highlight.end) {
+			cv.fillRect(cell(i).Inset(-2), outline)
+		}
+	}
+	for i, d := range days {
+		c := splitColor
+		if d.inRange {
+			c = hexColor(calendarColors[levels[i]])
+		}
+		cv.fillRect(cell(i), c)
+	}
+
+	// The legend: the streak outline on the left, the levels on the right.
+	y := top + 7*step + 20
+	cv.fillRect(image.Rect(left-2, y-2, left+size+2, y+size+2), outline)
+	cv.fillRect(image.Rect(left, y, left+size, y+size), hexColor(calendarColors[len(calendarColors)-1]))
+	cv.drawText(cv.small, "Longest streak", float64(left+size+8), float64(y+size-4), 0, axisColor)
+	right := left + calendarWeeks*step
+	x := right - len(calendarColors)*step - int(measure(cv.small, "More")) - 8
+	cv.drawText(cv.small, "Less", float64(x-8), float64(y+size-4), 1, axisColor)
+	for _, c := range calendarColors {
+		cv.fillRect(image.Rect(x, y, x+size, y+size), hexColor(c))
+		x += step
+	}
+	cv.drawText(cv.small, "More", float64(x+4), float64(y+size-4), 0, axisColor)
+	return cv.png()
+}
+
+// rasterNetwork draws the users as circles sized by how connected they are,
+// joined by lines as thick as their connection is heavy, next to the table
+// of the top connections.
+func rasterNetwork(title string, g network) ([]byte, error) {
+	cv, err := newCanvas(rasterWidth, rasterHeight)
+	if err != nil {
+		return nil, err
+	}
+	cv.drawTitle(title)
+	if len(g.names) == 0 {
+		return cv.png()
+	}
+
+	// The graph takes the left, the table the right.
+	b := cv.img.Bounds()
+	tableLeft := b.Max.X - 400
+	area := image.Rect(b.Min.X+rasterMargin, b.Min.Y+rasterTitleHeight+30, tableLeft-rasterMargin, b.Max.Y-rasterMargin)
+	if g.communities != nil {
+		area.Max.Y -= 20
+	}
+	px := func(i int) [2]float64 {
+		return [2]float64{
+			float64(area.Min.X) + g.x[i]*float64(area.Dx()),
+			float64(area.Min.Y) + g.y[i]*float64(area.Dy()),
+		}
+	}
+
+	heaviestNode, heaviestLink := 0.0, 0.0
+	for _, w := range g.weights {
+		heaviestNode = max(heaviestNode, w)
+	}
+	for _, l := range g.links {
+		heaviestLink = max(heaviestLink, l.weight())
+	}
+	// The lightest links first, so the heavy ones stay visible.
+	for i := len(g.links) - 1; i >= 0; i-- {
+		l := g.links[i]
+		cv.strokeLine([][2]float64{px(l.source), px(l.target)}, 1+5*l.weight()/heaviestLink, color.NRGBA{axisColor.R, axisColor.G, axisColor.B, 0x60})
+	}
+	radius := func(i int) float64 {
+		return 6 + 14*math.Sqrt(g.weights[i]/heaviestNode)
+	}
+	for i := range g.names {
+		p := px(i)
+		cv.fillCircle(p[0], p[1], radius(i)+1.5, color.White)
+		cv.fillCircle(p[0], p[1], radius(i), palette[g.nodeColor(i)])
+	}
+	// Names go on top of every circle.
+	for i, name := range g.names {
+		p := px(i)
+		cv.drawText(cv.small, truncateLabel(name, 20), p[0], p[1]+radius(i)+12, 0.5, textColor)
+	}
+
+	pairs, counts := g.topConnections()
+	y := float64(area.Min.Y)
+	cv.drawText(cv.text, "Top connections", float64(tableLeft), y, 0, textColor)
+	cv.drawText(cv.small, "total (→ / ←)", float64(b.Max.X-30), y, 1, axisColor)
+	cv.fillRect(image.Rect(tableLeft, int(y)+8, b.Max.X-30, int(y)+9), axisColor)
+	for i := range pairs {
+		top := y + 12 + float64(i)*26
+		if i%2 == 1 {
+			cv.fillRect(image.Rect(tableLeft, int(top), b.Max.X-30, int(top)+26), splitColor)
+		}
+		cv.drawText(cv.text, fmt.Sprintf("%d. %s", i+1, pairs[i]), float64(tableLeft+6), top+18, 0, textColor)
+		cv.drawText(cv.text, counts[i], float64(b.Max.X-36), top+18, 1, textColor)
+	}
+
+	if g.communities != nil {
+		count := 0
+		for _, community := range g.communities {
+			count = max(count, community+1)
+		}
+		var names []string
+		for i := range min(count, len(palette)) {
+			names = append(names, fmt.Sprintf("Community %d", i+1))
+		}
+		cv.drawLegend(names)
+	}
+	return cv.png()
+}
+
+// hexColor parses a #rrggbb colour.
+func hexColor(s string) color.RGBA {
+	var c color.RGBA
+	c.A = 0xff
+	fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	return c
+}
+
+// heatmapCell is a cell of a heatmap by axis index, valued 0 to 100.
+type heatmapCell struct {
+	x, y  int
+	value float64
+}
+
+// heatmapColor interpolates heatmapRange at t between 0 and 1.
+func heatmapColor(t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t))
+	scaled := t * float64(len(heatmapRange)-1)
+	i := min(int(scaled), len(heatmapRange)-2)
+	return mix(heatmapRange[i], heatmapRange[i+1], scaled-float64(i))
+}
+
+// mix blends a towards b by t.
+func mix(a, b color.RGBA, t float64) color.RGBA {
+	lerp := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 0xff}
+}
+
+// lighten blends a colour towards white by t.
+func lighten(c color.RGBA, t float64) color.RGBA {
+	return mix(c, color.RGBA{0xff, 0xff, 0xff, 0xff}, t)
+}
+
+// reversed returns the points in the opposite order.
+func reversed(points [][2]float64) [][2]float64 {
+	out := make([][2]float64, len(points))
+	for i, p := range points {
+		out[len(points)-1-i] = p
+	}
+	return out
+}
+
+// rasterize draws the tracker's chart with the native renderer.
+func (c *ChartTracker) rasterize(data []*ChartData, title string) ([]byte, error) {
+	switch c.ChartType {
+	case BarChart:
+		return rasterBar(title, toXaxes(data), chartValues(data))
+	case PieChart:
+		return rasterPie(title, toXaxes(data), chartValues(data))
+	case LineChart:
+		labels, series := c.lineSeries(data)
+		return rasterLine(c.lineTitle(title), labels, series, lineOptions{pointLabels: len(series) == 1})
+	case HeatmapChart:
+		heatMapData, xAxes, yAxes := c.genHeatMap(data)
+		var cells []heatmapCell
+		for _, d := range heatMapData {
+			v := d.Value.([3]interface{})
+			cells = append(cells, heatmapCell{x: v[0].(int), y: v[1].(int), value: v[2].(float64)})
+		}
+		return rasterHeatmap(title, xAxes, yAxes, cells)
+	case SunburstChart:
+		var slices []sunburstSlice
+		for _, d := range genSunburst(data) {
+			s := sunburstSlice{name: d.Name, value: d.Value}
+			for _, child := range d.Children {
+				s.children = append(s.children, sunburstSlice{name: child.Name, value: child.Value})
+			}
+			slices = append(slices, s)
+		}
+		// genSunburst builds its groups from a map; order them so the same
+		// data always draws the same chart.
+		sort.Slice(slices, func(i, j int) bool { return slices[i].value > slices[j].value })
+		return rasterSunburst(title, slices)
+	case NetworkChart:
+		return rasterNetwork(title, c.buildNetwork(data))
+	case CalendarChart:
+		days, err := c.calendarDays(data)
+		if err != nil {
+			return nil, err
+		}
+		stats := getCalendarStats(days)
+		return rasterCalendar(title, stats.summary(c.Metric.Additive()), days, calendarLevels(days), stats.longest)
+	}
+	return nil, fmt.Errorf("chart type %q cannot be rendered", c.ChartType)
+}
+
+func chartValues(chartData []*ChartData) (rs []float64) {
+	for _, data := range chartData {
+		rs = append(rs, data.Value)
+	}
+	return
+}
@@ -1,7 +1,6 @@
 package charts
 
 import (
-	"bytes"
 	"fmt"
 	"log/slog"
 	"math"
@@ -9,13 +8,11 @@ import (
 	"slices"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/disgoorg/disgo/bot"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/go-echarts/go-echarts/v2/charts"
 	"github.com/go-echarts/go-echarts/v2/opts"
-	"github.com/go-echarts/snapshot-chromedp/render"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -27,46 +24,45 @@ var (
 func (c *ChartTracker) GenerateChart(client *bot.Client) (*discord.File, error) {
 	data, err := c.getData(client)
 	slog.Debug("generating chart", slog.Any("data", data))
-	t := time.Now()
-	title := caser.String(strings.ReplaceAll(fmt.Sprintf("%s by %s", c.Metric.Title(), c.GroupBy.Title()), "_", " "))
-	fileName := fmt.Sprintf("%d.png", t.UnixNano())
 	if err != nil {
 		return nil, err
 	}
-	var image []byte
+	return c.Render(data)
+}
+
+// Render draws the chart of already fetched data as a PNG, with the renderer
+// CHART_RENDERER picks.
+func (c *ChartTracker) Render(data []*ChartData) (*discord.File, error) {
+	title := caser.String(strings.ReplaceAll(fmt.Sprintf("%s by %s", c.Metric.Title(), c.GroupBy.Title()), "_", " "))
+	if nativeRenderer() {
+		image, err := c.rasterize(data, title)
+		if err != nil {
+			return nil, err
+		}
+		return pngFile(image), nil
+	}
 
 	switch c.ChartType {
 	case BarChart:
-		barChart := c.generateBarChart(data, title)
-		err = render.MakeChartSnapshot(barChart.RenderContent(), fileName)
+		return snapshot(c.generateBarChart(data, title).RenderContent())
 	case PieChart:
-		pieChart := c.generatePieChart(data, title)
-		err = render.MakeChartSnapshot(pieChart.RenderContent(), fileName)
+		return snapshot(c.generatePieChart(data, title).RenderContent())
 	case LineChart:
-		lineChart := c.generateLineChart(data, title)
-		err = render.MakeChartSnapshot(lineChart.RenderContent(), fileName)
+		return snapshot(c.generateLineChart(data, title).RenderContent())
 	case HeatmapChart:
-		heatmapChart := c.generateHeatMapChart(data, title)
-		err = render.MakeChartSnapshot(heatmapChart.RenderContent(), fileName)
+		return snapshot(c.generateHeatMapChart(data, title).RenderContent())
 	case SunburstChart:
-		sunburstChart := c.generateSunBurstChart(data, title)
-		err = render.MakeChartSnapshot(sunburstChart.RenderContent(), fileName)
-	}
-
-	if err != nil {
-		return nil, err
-	}
-
-	image, err = os.ReadFile(fileName)
-	if err != nil {
-		return nil, err
+		return snapshot(c.generateSunBurstChart(data, title).RenderContent())
+	case CalendarChart:
+		calendar, err := c.generateCalendarChart(data, title)
+		if err != nil {
+			return nil, err
+		}
+		return snapshot(calendar.RenderContent())
+	case NetworkChart:
+		return snapshot(c.generateNetworkChart(data, title).RenderContent())
 	}
-	os.Remove(fileName)
-	imgReader := bytes.NewReader(image)
-	return &discord.File{
-		Name:        fileName,
-		Reader:      imgReader,
-	}, nil
+	return nil, fmt.Errorf("chart type %q cannot be rendered", c.ChartType)
 }
 
 func (c *ChartTracker) GenerateDebugChart() {
@@ -94,6 +90,16 @@ func (c *ChartTracker) GenerateDebugChart() {
 	case SunburstChart:
 		sunburstChart := c.generateSunBurstChart(data, title)
 		sunburstChart.Render(f)
+	case CalendarChart:
+		calendarChart, err := c.generateCalendarChart(data, title)
+		if err != nil {
+			slog.Error("error generating debug calendar chart", slog.Any("err", err))
+			return
+		}
+		calendarChart.Render(f)
+	case NetworkChart:
+		networkChart := c.generateNetworkChart(data, title)
+		networkChart.Render(f)
 	}
 }
 
@@ -153,6 +159,7 @@ func (c *ChartTracker) generatePieChart(chartData []*ChartData, title string) *c
 }
 
 func (c *ChartTracker) generateLineChart(chartData []*ChartData, title string) *charts.Line {
+	labels, series := c.lineSeries(chartData)
 	line := charts.NewLine()
 	line.SetGlobalOptions(
 		charts.WithInitializationOpts(opts.Initialization{
@@ -162,22 +169,28 @@ func (c *ChartTracker) generateLineChart(chartData []*ChartData, title string) *
 		// Don't forget disable the Animation
 		charts.WithAnimation(false),
 		charts.WithTitleOpts(opts.Title{
-			Title: title,
+			Title: c.lineTitle(title),
 			Right: "40%",
 		}),
-		charts.WithLegendOpts(opts.Legend{Show: opts.Bool(false)}),
+		charts.WithLegendOpts(opts.Legend{
+			Show:   opts.Bool(len(series) > 1),
+			Bottom: "0",
+		}),
 	)
 
-	line.SetXAxis(toXaxes(chartData)).
-		AddSeries(c.Metric.ToString(), genLineData(chartData)).
-		SetSeriesOptions(
-			charts.WithLineChartOpts(opts.LineChart{
-				ShowSymbol: opts.Bool(true),
-			}),
-			charts.WithLabelOpts(opts.Label{
-				Show: opts.Bool(true),
-			}),
-		)
+	line.SetXAxis(labels)
+	for _, s := range series {
+		line.AddSeries(s.Name, genLineData(s.Values))
+	}
+	line.SetSeriesOptions(
+		charts.WithLineChartOpts(opts.LineChart{
+			ShowSymbol: opts.Bool(len(series) == 1),
+		}),
+		// Labels on every point of several lines only overlap.
+		charts.WithLabelOpts(opts.Label{
+			Show: opts.Bool(len(series) == 1),
+		}),
+	)
 	return line
 }
 
@@ -204,7 +217,7 @@ func (c *ChartTracker) generateSunBurstChart(chartData []*ChartData, title strin
 
 func (c *ChartTracker) generateHeatMapChart(chartData []*ChartData, title string) *charts.HeatMap {
 	heatmap := charts.NewHeatMap()
-	heatMapData, xAxes, yAxes := genHeatMap(chartData)
+	heatMapData, xAxes, yAxes := c.genHeatMap(chartData)
 
 	heatmap.SetGlobalOptions(
 		charts.WithInitializationOpts(opts.Initialization{
@@ -288,26 +301,40 @@ func genPieData(chartData []*ChartData) (rs []opts.PieData) {
 	return
 }
 
-func genLineData(chartData []*ChartData) (rs []opts.LineData) {
-	for _, data := range chartData {
-		rs = append(rs, opts.LineData{Value: data.Value})
+// genLineData turns values into line points; NaN is a gap, which echarts
+// writes as "-".
+func genLineData(values []float64) (rs []opts.LineData) {
+	for _, v := range values {
+		if math.IsNaN(v) {
+			rs = append(rs, opts.LineData{Value: "-"})
+			continue
+		}
+		rs = append(rs, opts.LineData{Value: v})
 	}
 	return
 }
 
-func genHeatMap(chartData []*ChartData) (rs []opts.HeatMapData, xAxes []string, yAxes []string) {
-	xAxesTotals, yAxesTotals := make(map[string]float64), make(map[string]float64)
-	for _, data := range chartData {
-		xAxesTotals[data.XLabel] += data.Value
-		yAxesTotals[data.YLabel] += data.Value
+func (c *ChartTracker) genHeatMap(chartData []*ChartData) (rs []opts.HeatMapData, xAxes []string, yAxes []string) {
+	if c.GroupBy == HourWeekday {
+		// A punch card always shows every hour and day, in order.
+		xAxes, yAxes = hourLabels, weekdayLabels
+	} else {
+		xAxesTotals, yAxesTotals := make(map[string]float64), make(map[string]float64)
+		for _, data := range chartData {
+			xAxesTotals[data.XLabel] += data.Value
+			yAxesTotals[data.YLabel] += data.Value
+		}
+		xAxes, yAxes = topNKeys(xAxesTotals, 14), topNKeys(yAxesTotals, 10)
 	}
-	xAxes, yAxes = topNKeys(xAxesTotals, 14), topNKeys(yAxesTotals, 10)
 	var filteredChartData []*ChartData
 	for _, data := range chartData {
 		if slices.Contains(xAxes, data.XLabel) && slices.Contains(yAxes, data.YLabel) {
 			filteredChartData = append(filteredChartData, data)
 		}
 	}
+	if len(filteredChartData) == 0 {
+		return
+	}
 
 	// Find min & max values
 	var minVal, maxVal float64 = filteredChartData[0].Value, filteredChartData[0].Value
@@ -0,0 +1,148 @@
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/stollenaar/statisticsbot/internal/util"
+)
+
+// useNativeRenderer switches Render to the pure-Go renderer for a test.
+func useNativeRenderer(t *testing.T) {
+	t.Helper()
+	renderer := util.ConfigFile.CHART_RENDERER
+	util.ConfigFile.CHART_RENDERER = "native"
+	t.Cleanup(func() { util.ConfigFile.CHART_RENDERER = renderer })
+}
+
+func renderPNG(t *testing.T, c *ChartTracker, data []*ChartData) image.Image {
+	t.Helper()
+	file, err := c.Render(data)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	img, err := png.Decode(file.Reader)
+	if err != nil {
+		t.Fatalf("Render did not return a PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != rasterWidth || b.Dy() != rasterHeight {
+		t.Errorf("image is %dx%d, want %dx%d", b.Dx(), b.Dy(), rasterWidth, rasterHeight)
+	}
+	return img
+}
+
+func TestRenderNative(t *testing.T) {
+	useNativeRenderer(t)
+
+	users := []*ChartData{
+		{Xaxes: "1", XLabel: "alice", Value: 42},
+		{Xaxes: "2", XLabel: "bob", Value: 17},
+		{Xaxes: "3", XLabel: "carol", Value: 5},
+	}
+	var days []*ChartData
+	for i := range 10 {
+		day := fmt.Sprintf("2026-01-%02d", i+1)
+		days = append(days, &ChartData{Xaxes: day, XLabel: day, Value: float64(i * i)})
+	}
+	var cells []*ChartData
+	for _, user := range []string{"alice", "bob"} {
+		for _, channel := range []string{"general", "random", "memes"} {
+			cells = append(cells, &ChartData{XLabel: channel, YLabel: user, Value: float64(len(user) * len(channel))})
+		}
+	}
+	count := MetricType{Category: "message", Metric: "count"}
+
+	tests := []struct {
+		name    string
+		tracker ChartTracker
+		data    []*ChartData
+	}{
+		{"bar", ChartTracker{ChartType: BarChart, Metric: count, GroupBy: MetricType{Category: "single", Metric: "user"}}, users},
+		{"pie", ChartTracker{ChartType: PieChart, Metric: count, GroupBy: MetricType{Category: "single", Metric: "user"}}, users},
+		{"line", ChartTracker{ChartType: LineChart, Metric: count, GroupBy: MetricType{Category: "single", Metric: "date"}}, days},
+		{"smoothed line", ChartTracker{ChartType: LineChart, Metric: count, GroupBy: MetricType{Category: "single", Metric: "date"}, Smoothing: 7}, days},
+		{"heatmap", ChartTracker{ChartType: HeatmapChart, Metric: count, GroupBy: MetricType{Category: "user", Metric: "channel", MultiAxes: true}}, cells},
+		{"empty bar", ChartTracker{ChartType: BarChart, Metric: count, GroupBy: MetricType{Category: "single", Metric: "user"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderPNG(t, &tt.tracker, tt.data)
+		})
+	}
+}
+
+func TestRenderNativeRejectsUnknownChart(t *testing.T) {
+	useNativeRenderer(t)
+	c := ChartTracker{ChartType: InvalidChart}
+	if _, err := c.Render(nil); err == nil {
+		t.Error("rendering an invalid chart type succeeded")
+	}
+}
+
+func TestNiceRange(t *testing.T) {
+	tests := []struct {
+		lo, hi                  float64
+		bottom, top, stepAtMost float64
+	}{
+		{0, 0, 0, 1, 1},
+		{0, 42, 0, 50, 10},
+		{-5, 10, -5, 10, 5},
+		{-30, 0, -30, 0, 10},
+		{-0.3, 0.8, -0.5, 1, 0.5},
+	}
+	for _, tt := range tests {
+		bottom, top, step := niceRange(tt.lo, tt.hi)
+		if bottom > tt.lo || top < tt.hi {
+			t.Errorf("niceRange(%v, %v) = [%v, %v], which does not cover the data", tt.lo, tt.hi, bottom, top)
+		}
+		if math.Abs(bottom-tt.bottom) > 1e-9 || math.Abs(top-tt.top) > 1e-9 || step > tt.stepAtMost {
+			t.Errorf("niceRange(%v, %v) = %v, %v, %v, want %v, %v and a step of at most %v", tt.lo, tt.hi, bottom, top, step, tt.bottom, tt.top, tt.stepAtMost)
+		}
+		if n := (top - bottom) / step; math.Abs(n-math.Round(n)) > 1e-9 {
+			t.Errorf("niceRange(%v, %v) does not split into whole steps of %v", tt.lo, tt.hi, step)
+		}
+	}
+}
+
+// TestRasterBarNegative checks that negative bars stay inside the plot area
+// instead of running over the axis labels below it.
+func TestRasterBarNegative(t *testing.T) {
+	labels := []string{"up", "down"}
+	out, err := rasterBar("Sentiment", labels, []float64{10, -5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cv, err := newCanvas(rasterWidth, rasterHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	area := plotArea(cv, axisLabelRoom(cv, labels))
+
+	bar := palette[0]
+	found := 0
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			if uint8(r>>8) != bar.R || uint8(g>>8) != bar.G || uint8(bl>>8) != bar.B {
+				continue
+			}
+			found++
+			if y < area.Min.Y || y > area.Max.Y {
+				t.Fatalf("bar pixel at (%d, %d) is outside the plot area %v", x, y, area)
+			}
+		}
+	}
+	if found == 0 {
+		t.Fatal("no bars were drawn")
+	}
+}
@@ -17,7 +17,12 @@ const (
 	LineChart     ChartType = "line"
 	SunburstChart ChartType = "sunburst"
 	HeatmapChart  ChartType = "heatmap"
-	InvalidChart  ChartType = "invalid"
+	// CalendarChart is a year of daily values laid out like a GitHub
+	// contribution graph.
+	CalendarChart ChartType = "calendar"
+	// NetworkChart draws who replies to and mentions whom.
+	NetworkChart ChartType = "network"
+	InvalidChart ChartType = "invalid"
 )
 
 type MetricType struct {
@@ -26,6 +31,34 @@ type MetricType struct {
 	MultiAxes bool
 }
 
+// HourWeekday groups by the hour of the day and the day of the week in
+// CHART_TIMEZONE, the punch card of when a server is active.
+var HourWeekday = MetricType{Category: "weekday", Metric: "hour", MultiAxes: true}
+
+var (
+	// ReplyNetwork groups replies and mentions by who sent them and whom
+	// they were aimed at, the edges of a NetworkChart.
+	ReplyNetwork = MetricType{Category: "reply", Metric: "user", MultiAxes: true}
+	// ReplyCommunities is ReplyNetwork with the users coloured by the
+	// community they were found to belong to.
+	ReplyCommunities = MetricType{Category: "reply", Metric: "community", MultiAxes: true}
+)
+
+var (
+	// DateUser and DateChannel group by day and user or channel, a line per
+	// user or channel to compare their trends.
+	DateUser    = MetricType{Category: "date", Metric: "user", MultiAxes: true}
+	DateChannel = MetricType{Category: "date", Metric: "channel", MultiAxes: true}
+)
+
+var (
+	// hourLabels and weekdayLabels are the full axes of the HourWeekday
+	// punch card; weekdays are listed Sunday first so that Monday ends up on
+	// top, heatmaps drawing their first category at the bottom.
+	hourLabels    = []string{"00", "01", "02", "03", "04", "05", "06", "07", "08", "09", "10", "11", "12", "13", "14", "15", "16", "17", "18", "19", "20", "21", "22", "23"}
+	weekdayLabels = []string{"Sun", "Sat", "Fri", "Thu", "Wed", "Tue", "Mon"}
+)
+
 // ChartData Basic count group for the max command
 type ChartData struct {
 	Xaxes  string  `json:"xAxes"`
@@ -41,16 +74,21 @@ type DateRange struct {
 }
 
 type ChartTracker struct {
-	GuildID         string         `json:"guildID"`
-	InteractionID   string         `json:"interactionID"`
-	UserID          string         `json:"userID"`
-	ChartType       ChartType      `json:"chart"`
-	Metric          MetricType     `json:"metrics"`
-	Users           []string `json:"users"`
-	Channels        []string `json:"channels"`
-	DateRange       string         `json:"date"`
-	CustomDateRange DateRange      `json:"customDate"`
-	GroupBy         MetricType     `json:"groupBy"`
+	GuildID         string     `json:"guildID"`
+	InteractionID   string     `json:"interactionID"`
+	UserID          string     `json:"userID"`
+	ChartType       ChartType  `json:"chart"`
+	Metric          MetricType `json:"metrics"`
+	Users           []string   `json:"users"`
+	Channels        []string   `json:"channels"`
+	DateRange       string     `json:"date"`
+	CustomDateRange DateRange  `json:"customDate"`
+	GroupBy         MetricType `json:"groupBy"`
+	// Smoothing is the window in days of the moving average daily lines are
+	// smoothed with; 0 leaves them as they are.
+	Smoothing int `json:"smoothing"`
+	// Cumulative turns line charts into running totals.
+	Cumulative bool `json:"cumulative"`
 }
 
 func (c *ChartTracker) Marshal() string {
@@ -83,11 +121,27 @@ func GetChartType(in string) ChartType {
 		return SunburstChart
 	case "heatmap":
 		return HeatmapChart
+	case "calendar":
+		return CalendarChart
+	case "network":
+		return NetworkChart
 	default:
 		return InvalidChart
 	}
 }
 
+// Chronological reports whether the grouping is a time bucket, which is
+// plotted in order and never cut off.
+func (m MetricType) Chronological() bool {
+	return m.Metric == "date" || m.Metric == "month"
+}
+
+// Additive reports whether values of the metric can be summed, e.g. into
+// running totals. Averages and shares cannot.
+func (m MetricType) Additive() bool {
+	return m.Metric == "count" || m.Metric == "freq"
+}
+
 func (m *MetricType) ToString() string {
 	return fmt.Sprintf("%s;%s;%t", m.Category, m.Metric, m.MultiAxes)
 }
@@ -33,6 +33,30 @@ type Config struct {
 	OLLAMA_AUTH_TYPE string
 	OLLAMA_MODEL     string
 
+	LLM_PROVIDER       string
+	LLM_TIMEOUT        string
+	LLM_MAX_RETRIES    string
+	LLM_FAKE_RESPONSES string
+
+	EMBEDDING_MODEL        string
+	EMBEDDING_ONNX_PATH    string
+	EMBEDDING_MODEL_PATH   string
+	EMBEDDING_MODELS_DIR   string
+	EMBEDDING_OFFLINE      bool
+	EMBEDDING_WORKERS      string
+	EMBEDDING_BATCH_SIZE   string
+	EMBEDDING_QUEUE_SIZE   string
+	EMBEDDING_QUANTIZATION string
+
+	MODERATION_ENABLED   bool
+	MODERATION_THRESHOLD string
+
+	CHART_RENDERER    string
+	CHART_LINK_SECRET string
+	CHART_TIMEZONE    string
+	PUBLIC_URL        string
+	CHART_PORT        string
+
 	AWS_OLLAMA_AUTH_USERNAME string
 	OLLAMA_AUTH_USERNAME     string
 	AWS_OLLAMA_AUTH_PASSWORD string
@@ -79,6 +103,26 @@ func init() {
 		OLLAMA_URL:               os.Getenv("OLLAMA_URL"),
 		OLLAMA_MODEL:             os.Getenv("OLLAMA_MODEL"),
 		OLLAMA_AUTH_TYPE:         os.Getenv("OLLAMA_AUTH_TYPE"),
+		LLM_PROVIDER:             os.Getenv("LLM_PROVIDER"),
+		LLM_TIMEOUT:              os.Getenv("LLM_TIMEOUT"),
+		LLM_MAX_RETRIES:          os.Getenv("LLM_MAX_RETRIES"),
+		LLM_FAKE_RESPONSES:       os.Getenv("LLM_FAKE_RESPONSES"),
+		EMBEDDING_MODEL:          os.Getenv("EMBEDDING_MODEL"),
+		EMBEDDING_ONNX_PATH:      os.Getenv("EMBEDDING_ONNX_PATH"),
+		EMBEDDING_MODEL_PATH:     os.Getenv("EMBEDDING_MODEL_PATH"),
+		EMBEDDING_MODELS_DIR:     os.Getenv("EMBEDDING_MODELS_DIR"),
+		EMBEDDING_OFFLINE:        os.Getenv("EMBEDDING_OFFLINE") == "true",
+		EMBEDDING_WORKERS:        os.Getenv("EMBEDDING_WORKERS"),
+		EMBEDDING_BATCH_SIZE:     os.Getenv("EMBEDDING_BATCH_SIZE"),
+		EMBEDDING_QUEUE_SIZE:     os.Getenv("EMBEDDING_QUEUE_SIZE"),
+		EMBEDDING_QUANTIZATION:   os.Getenv("EMBEDDING_QUANTIZATION"),
+		MODERATION_ENABLED:       os.Getenv("MODERATION_ENABLED") == "true",
+		MODERATION_THRESHOLD:     os.Getenv("MODERATION_THRESHOLD"),
+		CHART_RENDERER:           os.Getenv("CHART_RENDERER"),
+		CHART_LINK_SECRET:        os.Getenv("CHART_LINK_SECRET"),
+		CHART_TIMEZONE:           os.Getenv("CHART_TIMEZONE"),
+		PUBLIC_URL:               os.Getenv("PUBLIC_URL"),
+		CHART_PORT:               os.Getenv("CHART_PORT"),
 		OLLAMA_AUTH_USERNAME:     os.Getenv("OLLAMA_AUTH_USERNAME"),
 		OLLAMA_AUTH_PASSWORD:     os.Getenv("OLLAMA_AUTH_PASSWORD"),
 		OLLAMA_API_KEY:           os.Getenv("OLLAMA_API_KEY"),
@@ -1,80 +0,0 @@
-package util
-
-import (
-	"bytes"
-	b64 "encoding/base64"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"log/slog"
-	"net/http"
-)
-
-func CreateOllamaGeneration(prompt OllamaGenerateRequest) (OllamaGenerateResponse, error) {
-
-	data, err := json.Marshal(prompt)
-	if err != nil {
-		return OllamaGenerateResponse{}, err
-	}
-	// os.WriteFile("req.json", data, 0644)
-
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s", ConfigFile.OLLAMA_URL), bytes.NewBuffer(data))
-
-	if err != nil {
-		slog.Error("ollama request error", slog.Any("err", err))
-		return OllamaGenerateResponse{}, err
-	}
-	req.Header.Add("Content-Type", "application/json")
-
-	if err := setOllamaAuth(req); err != nil {
-		slog.Error("ollama request error", slog.Any("err", err))
-		return OllamaGenerateResponse{}, err
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		slog.Error("ollama request error", slog.Any("err", err))
-		return OllamaGenerateResponse{}, err
-	}
-
-	var bodyData string
-	if resp != nil {
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(resp.Body)
-		bodyData = buf.String()
-	}
-	if resp.StatusCode != 200 {
-		slog.Error("ollama request returned non-200", slog.Int("status", resp.StatusCode), slog.String("body", bodyData))
-		return OllamaGenerateResponse{}, errors.New(bodyData)
-	}
-
-	var r OllamaGenerateResponse
-	json.Unmarshal([]byte(bodyData), &r)
-	return r, nil
-}
-
-// setOllamaAuth applies the configured auth scheme to an Ollama request.
-func setOllamaAuth(req *http.Request) error {
-	switch ConfigFile.OLLAMA_AUTH_TYPE {
-	case "basic":
-		username, err := GetOllamaUsername()
-		if err != nil {
-			return err
-		}
-		password, err := GetOllamaPassword()
-		if err != nil {
-			return err
-		}
-		token := b64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password)))
-		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", token))
-	case "api_key":
-		token, err := GetOllamaAPIKey()
-		if err != nil {
-			return err
-		}
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-	}
-	return nil
-}
-
@@ -0,0 +1,45 @@
+package util
+
+import (
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// CanViewChannel reports whether the member can read the channel, going by the
+// cached roles and permission overwrites. Threads are checked against their
+// parent channel, and channels missing from the cache are treated as hidden.
+func CanViewChannel(client *bot.Client, member discord.Member, channelID snowflake.ID) bool {
+	channel, ok := client.Caches.Channel(channelID)
+	if !ok {
+		return false
+	}
+	if thread, ok := channel.(discord.GuildThread); ok {
+		if channel, ok = client.Caches.Channel(*thread.ParentID()); !ok {
+			return false
+		}
+	}
+	return client.Caches.MemberPermissionsInChannel(channel, member).Has(discord.PermissionViewChannel)
+}
+
+// VisibleChannels returns the ids of the guild's message channels and threads
+// the member can read.
+func VisibleChannels(client *bot.Client, member discord.Member) (ids []string) {
+	for channel := range client.Caches.ChannelsForGuild(member.GuildID) {
+		if _, ok := channel.(discord.GuildMessageChannel); !ok {
+			continue
+		}
+		if CanViewChannel(client, member, channel.ID()) {
+			ids = append(ids, channel.ID().String())
+		}
+	}
+	return
+}
+
+// PublicChannels returns the ids of the guild's message channels and threads
+// every member can read, going by the @everyone role and overwrites alone.
+// Background jobs whose output any member may see only read these.
+func PublicChannels(client *bot.Client, guildID snowflake.ID) []string {
+	// A member without roles only gets what @everyone gets.
+	return VisibleChannels(client, discord.Member{GuildID: guildID})
+}
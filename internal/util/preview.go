@@ -0,0 +1,44 @@
+package util
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// topicFieldPattern finds the string fields in a partial JSON reply. The
+// closing quote is optional so the field still being generated is picked up
+// too.
+var topicFieldPattern = regexp.MustCompile(`"(\w+)"\s*:\s*"((?:[^"\\]|\\.)*)`)
+
+// PreviewTopics returns a StreamEditor render for replies that are a list of
+// objects with a "topic" field: it shows the status line, then every topic
+// generated so far in bold followed by its valueField. The reply is JSON,
+// which is not worth showing raw.
+func PreviewTopics(status, valueField string) func(partial string) string {
+	return func(partial string) string {
+		lines := []string{"-# " + status}
+		for _, match := range topicFieldPattern.FindAllStringSubmatch(partial, -1) {
+			value := MentionifyIDs(unquote(match[2]))
+			switch {
+			case match[1] == "topic":
+				lines = append(lines, "**"+value+"**")
+			case match[1] == valueField && len(lines) > 1:
+				lines[len(lines)-1] += " — " + value
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+}
+
+// unquote decodes the escapes of a JSON string body that may be cut off
+// mid-escape.
+func unquote(s string) string {
+	var out string
+	for ; s != ""; s = s[:len(s)-1] {
+		if json.Unmarshal([]byte(`"`+s+`"`), &out) == nil {
+			return out
+		}
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package util
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+)
+
+const (
+	// streamEditInterval throttles progressive edits. Interaction edits share
+	// the webhook rate limit of five requests per five seconds per token.
+	streamEditInterval = 1500 * time.Millisecond
+	// maxContentLength is Discord's limit on a message's plain content.
+	maxContentLength = 2000
+)
+
+// StreamEditor shows an LLM reply in the deferred interaction response while
+// it is being generated. Write is meant to be passed as the delta callback of
+// llm.ChatStream and only collects the reply; the response is edited from a
+// ticker once per streamEditInterval, so a slow edit never holds up the
+// stream. Close stops the ticker and shows the reply as it ended, and must be
+// called before the final response is sent so no late edit overwrites it.
+type StreamEditor struct {
+	event *events.ApplicationCommandInteractionCreate
+	// render turns the reply so far into the progress text. The raw text is
+	// shown when it is nil.
+	render func(partial string) string
+
+	mu      sync.Mutex
+	partial strings.Builder
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+	// shown is only touched by the edit loop, and by Close once the loop is
+	// done.
+	shown string
+}
+
+func NewStreamEditor(event *events.ApplicationCommandInteractionCreate, render func(partial string) string) *StreamEditor {
+	return &StreamEditor{
+		event:  event,
+		render: render,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Write adds a piece of the reply. The first call starts the edit loop.
+func (s *StreamEditor) Write(delta string) {
+	s.startOnce.Do(func() { go s.run() })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial.WriteString(delta)
+}
+
+// Reset drops the reply shown so far, for when the model is asked to start
+// over. The next tick shows the new reply.
+func (s *StreamEditor) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial.Reset()
+}
+
+// Close stops the edit loop and edits the response once more if the reply
+// changed since the last tick. It is safe to call more than once.
+func (s *StreamEditor) Close() {
+	s.closeOnce.Do(func() {
+		// Without a Write there is no loop to wait for.
+		s.startOnce.Do(func() { close(s.done) })
+		close(s.stop)
+		<-s.done
+		s.flush()
+	})
+}
+
+func (s *StreamEditor) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(streamEditInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// flush edits the response to the reply so far, unless that is already shown.
+// The lock is only held to copy the reply, not during the edit.
+func (s *StreamEditor) flush() {
+	s.mu.Lock()
+	content := s.partial.String()
+	s.mu.Unlock()
+
+	if s.render != nil {
+		content = s.render(content)
+	}
+	content = tail(content, maxContentLength)
+	if content == "" || content == s.shown {
+		return
+	}
+	s.shown = content
+
+	_, err := s.event.Client().Rest.UpdateInteractionResponse(s.event.ApplicationID(), s.event.Token(), discord.MessageUpdate{
+		Content:         &content,
+		AllowedMentions: &discord.AllowedMentions{},
+	})
+	if err != nil {
+		slog.Warn("Error editing the streamed response", slog.Any("err", err))
+	}
+}
+
+// tail keeps the last n runes of s, so the newest part of a long reply stays
+// visible.
+func tail(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return "…" + string(runes[len(runes)-n+1:])
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
 )
 
 const (
@@ -156,6 +157,37 @@ func UpdateInteractionResponse(event *events.ApplicationCommandInteractionCreate
 	}
 }
 
+// EditError replaces the deferred response with a plain error message.
+func EditError(event *events.ApplicationCommandInteractionCreate, msg string) {
+	_, err := event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
+		Content:         &msg,
+		AllowedMentions: &discord.AllowedMentions{},
+	})
+	if err != nil {
+		slog.Error("Error editing the response:", slog.Any("err", err))
+	}
+}
+
+// MemberMention mentions a member by id, falling back to the raw id when it is
+// not a snowflake. Replies using it are sent without allowed mentions, so
+// nobody is pinged; a member who left shows as an unknown user.
+func MemberMention(authorID string) string {
+	id, err := snowflake.Parse(authorID)
+	if err != nil {
+		return authorID
+	}
+	return discord.UserMention(id)
+}
+
+// Truncate shortens s to at most n runes, appending an ellipsis when cut.
+func Truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
+
 func UpdateComponentInteractionResponse(event *events.ComponentInteractionCreate, components []discord.LayoutComponent) {
 	_, err := event.Client().Rest.UpdateInteractionResponse(event.ApplicationID(), event.Token(), discord.MessageUpdate{
 		Flags:      Pointer(discord.MessageFlagIsComponentsV2),
@@ -0,0 +1,453 @@
+// Package vectorindex is an in-process approximate nearest-neighbour index
+// over embedding vectors, a Hierarchical Navigable Small World graph (Malkov &
+// Yashunin, 2016) compared by cosine similarity. It is used to narrow a
+// semantic search down to candidates before they are scored exactly, so some
+// recall is traded for not having to compare the query with every vector.
+package vectorindex
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// DefaultM is the number of neighbours kept per node on the upper layers;
+	// the bottom layer keeps twice as many.
+	DefaultM = 16
+	// DefaultEfConstruction is how many candidates are considered when linking
+	// a new node.
+	DefaultEfConstruction = 200
+)
+
+// Result is a key returned from a search with its cosine similarity to the
+// query.
+type Result struct {
+	Key   string
+	Score float32
+}
+
+// Graph is an HNSW graph. Vectors are normalised on the way in, so cosine
+// similarity is a dot product. Deleting a key only marks its node, which keeps
+// routing searches until the graph is rebuilt.
+//
+// A Graph is not safe for concurrent use.
+type Graph struct {
+	m              int
+	efConstruction int
+	levelFactor    float64
+	rng            *rand.Rand
+
+	nodes    []node
+	keys     map[string]int32
+	entry    int32
+	maxLevel int
+	dims     int
+
+	// visited marks the nodes seen by the running searchLayer: a node was
+	// visited when its entry equals visitMark, which is bumped per search so
+	// the slice never has to be cleared.
+	visited   []uint32
+	visitMark uint32
+}
+
+type node struct {
+	key     string
+	vec     []float32
+	friends [][]int32
+	deleted bool
+}
+
+// New returns an empty graph keeping m neighbours per node. Zero values
+// select DefaultM and DefaultEfConstruction.
+func New(m, efConstruction int) *Graph {
+	if m <= 0 {
+		m = DefaultM
+	}
+	if efConstruction <= 0 {
+		efConstruction = DefaultEfConstruction
+	}
+	return &Graph{
+		m:              m,
+		efConstruction: efConstruction,
+		levelFactor:    1 / math.Log(float64(m)),
+		rng:            rand.New(rand.NewSource(rand.Int63())),
+		keys:           make(map[string]int32),
+		entry:          -1,
+	}
+}
+
+// Len returns the number of keys in the graph, not counting deleted ones.
+func (g *Graph) Len() int {
+	return len(g.keys)
+}
+
+// Deleted returns the number of nodes left behind by deleted and replaced
+// keys. They still take up memory and search time.
+func (g *Graph) Deleted() int {
+	return len(g.nodes) - len(g.keys)
+}
+
+// Dims returns the dimension of the graph's vectors, or 0 while it is empty.
+func (g *Graph) Dims() int {
+	return g.dims
+}
+
+// Has reports whether a key is in the graph.
+func (g *Graph) Has(key string) bool {
+	_, ok := g.keys[key]
+	return ok
+}
+
+// Delete removes a key, reporting whether it was there.
+func (g *Graph) Delete(key string) bool {
+	id, ok := g.keys[key]
+	if !ok {
+		return false
+	}
+	g.nodes[id].deleted = true
+	delete(g.keys, key)
+	return true
+}
+
+// Add inserts a vector under a key, replacing the key's previous vector.
+func (g *Graph) Add(key string, vec []float32) error {
+	if g.dims != 0 && len(vec) != g.dims {
+		return fmt.Errorf("vector has %d dimensions, the graph has %d", len(vec), g.dims)
+	}
+	if len(vec) == 0 {
+		return errors.New("empty vector")
+	}
+	g.dims = len(vec)
+	g.Delete(key)
+
+	level := int(-math.Log(1-g.rng.Float64()) * g.levelFactor)
+	id := int32(len(g.nodes))
+	g.nodes = append(g.nodes, node{
+		key:     key,
+		vec:     normalize(vec),
+		friends: make([][]int32, level+1),
+	})
+	g.keys[key] = id
+
+	if g.entry < 0 {
+		g.entry, g.maxLevel = id, level
+		return nil
+	}
+
+	q := g.nodes[id].vec
+	entry := g.entry
+	for l := g.maxLevel; l > level; l-- {
+		entry = g.greedy(q, entry, l)
+	}
+	entries := []int32{entry}
+	for l := min(level, g.maxLevel); l >= 0; l-- {
+		candidates := g.searchLayer(q, entries, g.efConstruction, l)
+		neighbours := g.selectNeighbours(q, candidates, g.maxFriends(l))
+		g.nodes[id].friends[l] = neighbours
+		for _, n := range neighbours {
+			g.link(n, id, l)
+		}
+		entries = entries[:0]
+		for _, c := range candidates {
+			entries = append(entries, c.id)
+		}
+	}
+
+	if level > g.maxLevel {
+		g.entry, g.maxLevel = id, level
+	}
+	return nil
+}
+
+// Search returns up to k keys nearest to the query vector, most similar
+// first. ef is how many candidates are tracked on the bottom layer; it is
+// raised to k when lower.
+func (g *Graph) Search(vec []float32, k, ef int) []Result {
+	if g.entry < 0 || len(vec) != g.dims || k <= 0 {
+		return nil
+	}
+	q := normalize(vec)
+	entry := g.entry
+	for l := g.maxLevel; l > 0; l-- {
+		entry = g.greedy(q, entry, l)
+	}
+
+	var results []Result
+	for _, c := range g.searchLayer(q, []int32{entry}, max(ef, k), 0) {
+		if g.nodes[c.id].deleted {
+			continue
+		}
+		results = append(results, Result{Key: g.nodes[c.id].key, Score: 1 - c.dist})
+		if len(results) == k {
+			break
+		}
+	}
+	return results
+}
+
+func (g *Graph) maxFriends(level int) int {
+	if level == 0 {
+		return 2 * g.m
+	}
+	return g.m
+}
+
+func (g *Graph) distance(q []float32, id int32) float32 {
+	return 1 - dot(q, g.nodes[id].vec)
+}
+
+// greedy walks a layer towards the query, returning the closest node found.
+func (g *Graph) greedy(q []float32, entry int32, level int) int32 {
+	best, bestDist := entry, g.distance(q, entry)
+	for changed := true; changed; {
+		changed = false
+		for _, n := range g.nodes[best].friends[level] {
+			if d := g.distance(q, n); d < bestDist {
+				best, bestDist, changed = n, d, true
+			}
+		}
+	}
+	return best
+}
+
+// searchLayer returns the ef nodes of a layer closest to the query, nearest
+// first.
+func (g *Graph) searchLayer(q []float32, entries []int32, ef, level int) []candidate {
+	if len(g.visited) < len(g.nodes) {
+		g.visited = append(g.visited, make([]uint32, len(g.nodes)-len(g.visited))...)
+	}
+	g.visitMark++
+	if g.visitMark == 0 {
+		clear(g.visited)
+		g.visitMark = 1
+	}
+	visited, mark := g.visited, g.visitMark
+
+	var frontier minHeap
+	var found maxHeap
+	for _, e := range entries {
+		visited[e] = mark
+		c := candidate{id: e, dist: g.distance(q, e)}
+		heap.Push(&frontier, c)
+		heap.Push(&found, c)
+	}
+
+	for frontier.Len() > 0 {
+		c := heap.Pop(&frontier).(candidate)
+		if found.Len() >= ef && c.dist > found[0].dist {
+			break
+		}
+		for _, n := range g.nodes[c.id].friends[level] {
+			if visited[n] == mark {
+				continue
+			}
+			visited[n] = mark
+			d := g.distance(q, n)
+			if found.Len() < ef || d < found[0].dist {
+				heap.Push(&frontier, candidate{id: n, dist: d})
+				heap.Push(&found, candidate{id: n, dist: d})
+				if found.Len() > ef {
+					heap.Pop(&found)
+				}
+			}
+		}
+	}
+
+	out := make([]candidate, found.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(&found).(candidate)
+	}
+	return out
+}
+
+// selectNeighbours picks up to m of the candidates, sorted nearest first, as
+// neighbours. A candidate closer to an already picked neighbour than to the
+// query is skipped at first, which spreads links across clusters, and used to
+// fill up the remaining slots afterwards.
+func (g *Graph) selectNeighbours(q []float32, candidates []candidate, m int) []int32 {
+	selected := make([]int32, 0, m)
+	var skipped []int32
+	for _, c := range candidates {
+		if len(selected) == m {
+			break
+		}
+		keep := true
+		for _, s := range selected {
+			if g.distance(g.nodes[c.id].vec, s) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c.id)
+		} else {
+			skipped = append(skipped, c.id)
+		}
+	}
+	for _, s := range skipped {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, s)
+	}
+	return selected
+}
+
+// link adds a back link from a node to a new neighbour. When that leaves it
+// with too many, only the nearest are kept; rerunning selectNeighbours here
+// would be more thorough but makes every insert quadratic in M.
+func (g *Graph) link(from, to int32, level int) {
+	friends := append(g.nodes[from].friends[level], to)
+	if len(friends) <= g.maxFriends(level) {
+		g.nodes[from].friends[level] = friends
+		return
+	}
+
+	vec := g.nodes[from].vec
+	candidates := make([]candidate, len(friends))
+	for i, f := range friends {
+		candidates[i] = candidate{id: f, dist: g.distance(vec, f)}
+	}
+	sortCandidates(candidates)
+	for i := range friends[:g.maxFriends(level)] {
+		friends[i] = candidates[i].id
+	}
+	g.nodes[from].friends[level] = friends[:g.maxFriends(level)]
+}
+
+// Snapshot returns a copy of the graph that later changes to g do not touch,
+// so it can be written out while g keeps taking writes. Vectors are never
+// changed once added and are shared; only the neighbour lists are copied.
+func (g *Graph) Snapshot() *Graph {
+	snapshot := *g
+	snapshot.rng = rand.New(rand.NewSource(g.rng.Int63()))
+	snapshot.visited, snapshot.visitMark = nil, 0
+	snapshot.keys = make(map[string]int32, len(g.keys))
+	for key, id := range g.keys {
+		snapshot.keys[key] = id
+	}
+	snapshot.nodes = make([]node, len(g.nodes))
+	for i, n := range g.nodes {
+		friends := make([][]int32, len(n.friends))
+		for l, f := range n.friends {
+			friends[l] = append([]int32(nil), f...)
+		}
+		n.friends = friends
+		snapshot.nodes[i] = n
+	}
+	return &snapshot
+}
+
+// savedGraph is the on-disk form of a Graph.
+type savedGraph struct {
+	M              int
+	EfConstruction int
+	Entry          int32
+	MaxLevel       int
+	Dims           int
+	Keys           []string
+	Vecs           [][]float32
+	Friends        [][][]int32
+	Deleted        []bool
+}
+
+// Write encodes the graph.
+func (g *Graph) Write(w io.Writer) error {
+	saved := savedGraph{
+		M:              g.m,
+		EfConstruction: g.efConstruction,
+		Entry:          g.entry,
+		MaxLevel:       g.maxLevel,
+		Dims:           g.dims,
+		Keys:           make([]string, len(g.nodes)),
+		Vecs:           make([][]float32, len(g.nodes)),
+		Friends:        make([][][]int32, len(g.nodes)),
+		Deleted:        make([]bool, len(g.nodes)),
+	}
+	for i, n := range g.nodes {
+		saved.Keys[i], saved.Vecs[i], saved.Friends[i], saved.Deleted[i] = n.key, n.vec, n.friends, n.deleted
+	}
+	return gob.NewEncoder(w).Encode(saved)
+}
+
+// Read decodes a graph written by Write.
+func Read(r io.Reader) (*Graph, error) {
+	var saved savedGraph
+	if err := gob.NewDecoder(r).Decode(&saved); err != nil {
+		return nil, err
+	}
+	if len(saved.Vecs) != len(saved.Keys) || len(saved.Friends) != len(saved.Keys) || len(saved.Deleted) != len(saved.Keys) {
+		return nil, errors.New("corrupt vector index")
+	}
+
+	g := New(saved.M, saved.EfConstruction)
+	g.entry, g.maxLevel, g.dims = saved.Entry, saved.MaxLevel, saved.Dims
+	g.nodes = make([]node, len(saved.Keys))
+	for i := range saved.Keys {
+		g.nodes[i] = node{key: saved.Keys[i], vec: saved.Vecs[i], friends: saved.Friends[i], deleted: saved.Deleted[i]}
+		if !saved.Deleted[i] {
+			g.keys[saved.Keys[i]] = int32(i)
+		}
+	}
+	return g, nil
+}
+
+// Save writes the graph to a file, replacing it only once the new contents
+// are complete.
+func (g *Graph) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := g.Write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads a graph saved with Save.
+func Load(path string) (*Graph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func normalize(vec []float32) []float32 {
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	out := make([]float32, len(vec))
+	if norm == 0 {
+		return out
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i, v := range vec {
+		out[i] = v * scale
+	}
+	return out
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,231 @@
+package vectorindex
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func randomVectors(rng *rand.Rand, n, dims int) [][]float32 {
+	vecs := make([][]float32, n)
+	for i := range vecs {
+		vecs[i] = make([]float32, dims)
+		for j := range vecs[i] {
+			vecs[i][j] = float32(rng.NormFloat64())
+		}
+	}
+	return vecs
+}
+
+func buildGraph(t *testing.T, vecs [][]float32) *Graph {
+	t.Helper()
+	g := New(0, 0)
+	for i, vec := range vecs {
+		if err := g.Add(fmt.Sprint(i), vec); err != nil {
+			t.Fatalf("Add(%d): %v", i, err)
+		}
+	}
+	return g
+}
+
+// bruteForce returns the keys of the k vectors most similar to q.
+func bruteForce(vecs [][]float32, q []float32, k int) []string {
+	type scored struct {
+		key   string
+		score float32
+	}
+	nq := normalize(q)
+	all := make([]scored, len(vecs))
+	for i, vec := range vecs {
+		all[i] = scored{key: fmt.Sprint(i), score: dot(nq, normalize(vec))}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+	keys := make([]string, k)
+	for i := range keys {
+		keys[i] = all[i].key
+	}
+	return keys
+}
+
+func TestSearchRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vecs := randomVectors(rng, 2000, 32)
+	g := buildGraph(t, vecs)
+
+	const k, queries = 10, 50
+	found := 0
+	for _, q := range randomVectors(rng, queries, 32) {
+		want := make(map[string]bool)
+		for _, key := range bruteForce(vecs, q, k) {
+			want[key] = true
+		}
+		results := g.Search(q, k, 100)
+		if len(results) != k {
+			t.Fatalf("Search returned %d results, want %d", len(results), k)
+		}
+		for i, r := range results {
+			if want[r.Key] {
+				found++
+			}
+			if i > 0 && r.Score > results[i-1].Score {
+				t.Errorf("results are not sorted by score: %v", results)
+			}
+		}
+	}
+
+	if recall := float64(found) / (k * queries); recall < 0.9 {
+		t.Errorf("recall@%d = %.2f, want at least 0.9", k, recall)
+	}
+}
+
+func TestSearchFindsExactVector(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	vecs := randomVectors(rng, 500, 16)
+	g := buildGraph(t, vecs)
+
+	for _, i := range []int{0, 123, 499} {
+		results := g.Search(vecs[i], 1, 50)
+		if len(results) != 1 || results[0].Key != fmt.Sprint(i) {
+			t.Errorf("Search(vecs[%d]) = %v, want key %d", i, results, i)
+		}
+		if results[0].Score < 0.999 {
+			t.Errorf("Search(vecs[%d]) scored %f, want 1", i, results[0].Score)
+		}
+	}
+}
+
+func TestDeleteAndReplace(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	vecs := randomVectors(rng, 300, 16)
+	g := buildGraph(t, vecs)
+
+	if !g.Delete("7") {
+		t.Fatal("Delete(7) = false, want true")
+	}
+	if g.Delete("7") {
+		t.Error("deleting twice reported the key as present")
+	}
+	if g.Has("7") || g.Len() != 299 || g.Deleted() != 1 {
+		t.Errorf("after Delete: Has = %v, Len = %d, Deleted = %d", g.Has("7"), g.Len(), g.Deleted())
+	}
+	for _, r := range g.Search(vecs[7], 20, 100) {
+		if r.Key == "7" {
+			t.Error("a deleted key was returned by Search")
+		}
+	}
+
+	// Replacing a key moves it to its new vector.
+	if err := g.Add("8", vecs[9]); err != nil {
+		t.Fatal(err)
+	}
+	if g.Len() != 299 || g.Deleted() != 2 {
+		t.Errorf("after replace: Len = %d, Deleted = %d", g.Len(), g.Deleted())
+	}
+	results := g.Search(vecs[9], 2, 50)
+	keys := map[string]bool{}
+	for _, r := range results {
+		keys[r.Key] = true
+	}
+	if !keys["8"] || !keys["9"] {
+		t.Errorf("Search(vecs[9]) = %v, want keys 8 and 9", results)
+	}
+	for _, r := range g.Search(vecs[8], 5, 50) {
+		if r.Key == "8" && r.Score > 0.999 {
+			t.Error("the replaced vector of key 8 is still found")
+		}
+	}
+}
+
+func TestAddRejectsOtherDimensions(t *testing.T) {
+	g := New(0, 0)
+	if err := g.Add("a", []float32{1, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Add("b", []float32{1, 0}); err == nil {
+		t.Error("adding a vector of another dimension succeeded")
+	}
+	if err := g.Add("c", nil); err == nil {
+		t.Error("adding an empty vector succeeded")
+	}
+	if g.Dims() != 3 || g.Len() != 1 {
+		t.Errorf("Dims = %d, Len = %d, want 3 and 1", g.Dims(), g.Len())
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	vecs := randomVectors(rng, 300, 16)
+	g := buildGraph(t, vecs)
+	g.Delete("5")
+
+	path := filepath.Join(t.TempDir(), "index.hnsw")
+	if err := g.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Len() != g.Len() || loaded.Deleted() != g.Deleted() || loaded.Dims() != g.Dims() {
+		t.Fatalf("loaded Len/Deleted/Dims = %d/%d/%d, want %d/%d/%d",
+			loaded.Len(), loaded.Deleted(), loaded.Dims(), g.Len(), g.Deleted(), g.Dims())
+	}
+	if loaded.Has("5") {
+		t.Error("a deleted key came back after loading")
+	}
+	for _, q := range randomVectors(rng, 10, 16) {
+		want, got := g.Search(q, 10, 50), loaded.Search(q, 10, 50)
+		if fmt.Sprint(want) != fmt.Sprint(got) {
+			t.Errorf("loaded graph searched differently:\n got %v\nwant %v", got, want)
+		}
+	}
+
+	// The loaded graph keeps taking writes.
+	if err := loaded.Add("new", vecs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Has("new") {
+		t.Error("key added after loading is missing")
+	}
+}
+
+func TestReadRejectsGarbage(t *testing.T) {
+	if _, err := Read(bytes.NewReader([]byte("not a graph"))); err == nil {
+		t.Error("Read of garbage succeeded")
+	}
+}
+
+func TestSnapshotIsIndependent(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	vecs := randomVectors(rng, 200, 16)
+	g := buildGraph(t, vecs[:100])
+
+	snapshot := g.Snapshot()
+	var before bytes.Buffer
+	if err := snapshot.Write(&before); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding nodes rewrites the neighbour lists of existing ones.
+	for i, vec := range vecs[100:] {
+		if err := g.Add(fmt.Sprint(100+i), vec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.Delete("0")
+
+	var after bytes.Buffer
+	if err := snapshot.Write(&after); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Error("the snapshot changed with the graph it was taken from")
+	}
+	if snapshot.Len() != 100 || !snapshot.Has("0") {
+		t.Errorf("snapshot Len = %d, Has(0) = %v, want 100 and true", snapshot.Len(), snapshot.Has("0"))
+	}
+}